@@ -731,6 +731,27 @@ var _ = Describe("Services API Application Endpoints, Mutations", func() {
 					Equal("Service 'bogus' does not exist"))
 			})
 
+			It("binds nothing when some of the services do not exist", func() {
+				response, err := Curl("POST",
+					fmt.Sprintf("%s/api/v1/orgs/%s/applications/%s/servicebindings",
+						serverURL, org, app),
+					strings.NewReader(fmt.Sprintf(`{ "names": ["%s", "bogus"] }`, service)))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response).ToNot(BeNil())
+
+				defer response.Body.Close()
+				bodyBytes, err := ioutil.ReadAll(response.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.StatusCode).To(Equal(http.StatusNotFound), string(bodyBytes))
+				var responseBody map[string][]apiv1.APIError
+				json.Unmarshal(bodyBytes, &responseBody)
+				Expect(responseBody["errors"]).To(HaveLen(1))
+				Expect(responseBody["errors"][0].Title).To(
+					Equal("Service 'bogus' does not exist"))
+
+				verifyAppServiceNotbound(app, service, org, 1)
+			})
+
 			Context("and already bound", func() {
 				BeforeEach(func() {
 					bindAppService(app, service, org)
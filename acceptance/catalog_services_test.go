@@ -2,6 +2,7 @@ package acceptance_test
 
 import (
 	"fmt"
+	"regexp"
 
 	"github.com/epinio/epinio/helpers"
 	. "github.com/onsi/ginkgo"
@@ -86,6 +87,41 @@ var _ = Describe("Catalog Services", func() {
 		})
 	})
 
+	Describe("service update", func() {
+		BeforeEach(func() {
+			makeCatalogService(serviceName)
+		})
+
+		AfterEach(func() {
+			cleanupService(serviceName)
+		})
+
+		It("replaces the parameters of a catalog based service", func() {
+			out, err := Epinio(fmt.Sprintf(`service update %s --dont-wait --data '{ "db": { "name": "other" }}'`, serviceName), "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("to watch when it is updated"))
+
+			serviceInstanceName := fmt.Sprintf("service.org-%s.svc-%s", org, serviceName)
+			out, err = helpers.Kubectl(
+				fmt.Sprintf("get serviceinstance -n %s %s -o=jsonpath='{.spec.parameters.db.name}'",
+					org, serviceInstanceName))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(out).To(Equal("other"))
+		})
+
+		It("rejects unknown plans", func() {
+			out, err := Epinio(fmt.Sprintf("service update %s --plan bogus", serviceName), "")
+			Expect(err).To(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Service plan 'bogus' does not exist"))
+		})
+
+		It("rejects --set for catalog services", func() {
+			out, err := Epinio(fmt.Sprintf("service update %s --set a=b", serviceName), "")
+			Expect(err).To(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Only custom services can be updated"))
+		})
+	})
+
 	Describe("service bind", func() {
 		var appName string
 		BeforeEach(func() {
@@ -125,6 +161,78 @@ var _ = Describe("Catalog Services", func() {
 		})
 	})
 
+	Describe("service rotate-credentials", func() {
+		var appName string
+		BeforeEach(func() {
+			appName = newAppName()
+
+			makeCatalogService(serviceName)
+			makeApp(appName, 1, true)
+			bindAppService(appName, serviceName, org)
+		})
+
+		AfterEach(func() {
+			cleanupApp(appName)
+			cleanupService(serviceName)
+		})
+
+		It("replaces the binding of the application", func() {
+			out, err := Epinio(fmt.Sprintf("service rotate-credentials %s %s", serviceName, appName), "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Credentials Rotated"))
+
+			out, err = helpers.Kubectl(fmt.Sprintf("get deployment -n %s %s -o=jsonpath='{.spec.template.spec.volumes[0].secret.secretName}'", org, appName))
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp(`\.rotated`))
+
+			Eventually(func() string {
+				out, _ := helpers.Kubectl(fmt.Sprintf("get servicebindings -n %s -o name", org))
+				return out
+			}, "2m").ShouldNot(MatchRegexp(`(?m)app-` + appName + `$`))
+
+			unbindAppService(appName, serviceName, org)
+		})
+	})
+
+	Describe("service backup", func() {
+		BeforeEach(func() {
+			makeCatalogService(serviceName)
+		})
+
+		AfterEach(func() {
+			cleanupService(serviceName)
+		})
+
+		It("backs up and restores a service", func() {
+			out, err := Epinio(fmt.Sprintf("service backup create %s --wait", serviceName), "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Backup Done"))
+
+			out, err = Epinio(fmt.Sprintf("service backup list %s", serviceName), "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			m := regexp.MustCompile(`\|\s*([0-9a-f]+)\s*\|.*\|\s*succeeded\s*\|`).FindStringSubmatch(out)
+			Expect(m).ToNot(BeNil(), out)
+
+			out, err = Epinio(fmt.Sprintf("service backup restore %s %s --wait", serviceName, m[1]), "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Backup Restored"))
+		})
+
+		It("schedules backups of a service", func() {
+			out, err := Epinio(fmt.Sprintf("service backup schedule %s 24h", serviceName), "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Backups Scheduled"))
+
+			out, err = Epinio(fmt.Sprintf("service backup list %s", serviceName), "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp(`Every: 24h0m0s`))
+
+			out, err = Epinio(fmt.Sprintf("service backup schedule %s 0", serviceName), "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Backup Schedule Removed"))
+		})
+	})
+
 	Describe("service show", func() {
 		BeforeEach(func() {
 			makeCatalogService(serviceName)
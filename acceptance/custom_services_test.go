@@ -1,6 +1,10 @@
 package acceptance_test
 
 import (
+	"fmt"
+	"strings"
+
+	"github.com/epinio/epinio/helpers"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -71,6 +75,43 @@ var _ = Describe("Custom Services", func() {
 		})
 	})
 
+	Describe("service update", func() {
+		var appName string
+		BeforeEach(func() {
+			appName = newAppName()
+
+			makeCustomService(serviceName)
+			makeApp(appName, 1, true)
+			bindAppService(appName, serviceName, org)
+		})
+
+		AfterEach(func() {
+			cleanupApp(appName)
+			cleanupService(serviceName)
+		})
+
+		It("changes the data and restarts the bound applications", func() {
+			out, err := Epinio("service update "+serviceName+" --set username=epinio-admin --set port=5432", "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Service Updated"))
+			Expect(out).To(MatchRegexp(`Restarted Applications: ` + appName))
+
+			out, err = Epinio("service show "+serviceName, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp(`username .*\|.* epinio-admin`))
+			Expect(out).To(MatchRegexp(`port .*\|.* 5432`))
+
+			out, err = Epinio("service update "+serviceName+" --unset port --no-restart", "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Service Updated"))
+			Expect(out).ToNot(MatchRegexp("Restarted Applications"))
+
+			out, err = Epinio("service show "+serviceName, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).ToNot(MatchRegexp(`port .*\|.* 5432`))
+		})
+	})
+
 	Describe("service bind", func() {
 		var appName string
 		BeforeEach(func() {
@@ -88,6 +129,26 @@ var _ = Describe("Custom Services", func() {
 		It("binds a service to the application deployment", func() {
 			bindAppService(appName, serviceName, org)
 		})
+
+		It("binds a service as environment variables", func() {
+			out, err := Epinio(fmt.Sprintf("service bind %s %s --as env", serviceName, appName), "")
+			Expect(err).ToNot(HaveOccurred(), out)
+
+			out, err = helpers.Kubectl(fmt.Sprintf("get deployment -n %s %s -o=jsonpath='{.spec.template.spec.containers[0].envFrom[0].prefix}'", org, appName))
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(ContainSubstring(strings.ToUpper(strings.ReplaceAll(serviceName, "-", "_")) + "_"))
+
+			out, err = helpers.Kubectl(fmt.Sprintf("get deployment -n %s %s -o=jsonpath='{.spec.template.spec.containers[0].env}'", org, appName))
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(ContainSubstring("EPINIO_SERVICES"))
+			Expect(out).To(ContainSubstring(serviceName))
+
+			out, err = Epinio("app show "+appName, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp(serviceName))
+
+			unbindAppService(appName, serviceName, org)
+		})
 	})
 
 	Describe("service unbind", func() {
@@ -127,4 +188,101 @@ var _ = Describe("Custom Services", func() {
 			cleanupService(serviceName)
 		})
 	})
+
+	Describe("service key", func() {
+		BeforeEach(func() {
+			makeCustomService(serviceName)
+		})
+
+		AfterEach(func() {
+			cleanupService(serviceName)
+		})
+
+		It("creates, lists, shows and deletes keys of the service", func() {
+			out, err := Epinio("service key create "+serviceName+" reporting", "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Service key created"))
+
+			out, err = Epinio("service key create "+serviceName+" reporting", "")
+			Expect(err).To(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Key 'reporting' of service '" + serviceName + "' already exists"))
+
+			out, err = Epinio("service key list "+serviceName, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp(`\| reporting`))
+
+			out, err = Epinio("service key show "+serviceName+" reporting", "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp(`username .*\|.* epinio-user`))
+
+			out, err = Epinio("service key delete "+serviceName+" reporting", "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Service key deleted"))
+
+			out, err = Epinio("service key list "+serviceName, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("No keys found"))
+		})
+	})
+
+	Describe("service share", func() {
+		var otherOrg string
+		BeforeEach(func() {
+			otherOrg = newOrgName()
+
+			makeCustomService(serviceName)
+
+			out, err := Epinio("org create "+otherOrg, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+		})
+
+		AfterEach(func() {
+			out, err := Epinio("target "+org, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+
+			out, err = Epinio("service delete --unbind --force "+serviceName, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+		})
+
+		It("makes the service bindable in the other org", func() {
+			out, err := Epinio("service share "+serviceName+" --to-org "+otherOrg, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Service shared"))
+
+			out, err = Epinio("service show "+serviceName, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp(`Shared With .*\|.* ` + otherOrg))
+
+			out, err = Epinio("service delete "+serviceName, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Unable to delete service. It is shared with"))
+			Expect(out).To(MatchRegexp(otherOrg))
+
+			out, err = Epinio("target "+otherOrg, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+
+			out, err = Epinio("service show "+serviceName, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp(`Shared From .*\|.* ` + org))
+			Expect(out).To(MatchRegexp(`username .*\|.* epinio-user`))
+
+			appName := newAppName()
+			makeApp(appName, 1, true)
+			bindAppService(appName, serviceName, otherOrg)
+
+			out, err = Epinio("target "+org, "")
+			Expect(err).ToNot(HaveOccurred(), out)
+
+			out, err = Epinio("service unshare "+serviceName+" --from-org "+otherOrg, "")
+			Expect(err).To(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("bound applications exist"))
+
+			out, err = Epinio("service unshare "+serviceName+" --from-org "+otherOrg+" --unbind", "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("PREVIOUSLY BOUND TO"))
+			Expect(out).To(MatchRegexp("Service unshared"))
+
+			verifyAppServiceNotbound(appName, serviceName, otherOrg, 1)
+		})
+	})
 })
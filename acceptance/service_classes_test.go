@@ -29,5 +29,11 @@ var _ = Describe("Service Classes, and Plans", func() {
 			Expect(out).To(MatchRegexp("10-3-22"))
 			Expect(out).To(MatchRegexp("MariaDB Server is intended"))
 		})
+
+		It("shows the parameter schemas of the plans", func() {
+			out, err := Epinio("service list-plans mariadb --details", "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp("Plan 10-3-22"))
+		})
 	})
 })
@@ -29,6 +29,13 @@ var _ = Describe("Services", func() {
 			Expect(out).To(MatchRegexp(serviceCatalogName))
 		})
 
+		It("shows the type, class, plan and status of the services", func() {
+			out, err := Epinio("service list", "")
+			Expect(err).ToNot(HaveOccurred(), out)
+			Expect(out).To(MatchRegexp(serviceCustomName + `\s*\|\s*custom\s*\|\s*\|\s*\|\s*Provisioned`))
+			Expect(out).To(MatchRegexp(serviceCatalogName + `\s*\|\s*catalog\s*\|\s*mariadb\s*\|\s*10-3-22\s*\|\s*Provisioned`))
+		})
+
 		AfterEach(func() {
 			cleanupService(serviceCatalogName)
 			cleanupService(serviceCustomName)
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,6 +21,7 @@ import (
 	"github.com/kyokomi/emoji"
 	"github.com/pkg/errors"
 	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -129,7 +131,36 @@ func (k Epinio) apply(ctx context.Context, c *kubernetes.Cluster, ui *termui.UI,
 		Username: apiUser.Value.(string),
 		Password: apiPassword.Value.(string),
 	}
-	if out, err := k.applyEpinioConfigYaml(ctx, c, ui, authAPI); err != nil {
+	stagingConcurrency, err := options.GetInt("staging_concurrency", "")
+	if err != nil {
+		return err
+	}
+
+	maxUploadSize, err := options.GetString("max_upload_size", "")
+	if err != nil {
+		return err
+	}
+
+	settings := map[string]string{
+		"staging_concurrency": strconv.Itoa(stagingConcurrency),
+		"max_upload_size":     maxUploadSize,
+	}
+	for _, name := range []string{"source_store", "s3_endpoint", "s3_bucket", "s3_region", "backup_store", "backup_claim_size", "backup_s3_bucket"} {
+		settings[name], err = options.GetString(name, "")
+		if err != nil {
+			return err
+		}
+	}
+	// The credentials go into a secret, base64 encoded
+	for _, name := range []string{"s3_access_key_id", "s3_secret_access_key"} {
+		value, err := options.GetString(name, "")
+		if err != nil {
+			return err
+		}
+		settings[name] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	if out, err := k.applyEpinioConfigYaml(ctx, c, ui, authAPI, settings); err != nil {
 		return errors.Wrap(err, out)
 	}
 
@@ -241,8 +272,10 @@ func (k Epinio) Upgrade(ctx context.Context, c *kubernetes.Cluster, ui *termui.U
 	return k.apply(ctx, c, ui, options, true)
 }
 
-// Replaces ##current_epinio_version## with version.Version and applies the embedded yaml
-func (k Epinio) applyEpinioConfigYaml(ctx context.Context, c *kubernetes.Cluster, ui *termui.UI, auth auth.PasswordAuth) (string, error) {
+// Replaces ##current_epinio_version## with version.Version, and the
+// ##setting## placeholders with the values of the settings, and applies the
+// embedded yaml
+func (k Epinio) applyEpinioConfigYaml(ctx context.Context, c *kubernetes.Cluster, ui *termui.UI, auth auth.PasswordAuth, settings map[string]string) (string, error) {
 	// (xxx) Apply traefik v2 middleware. This will fail for a
 	// traefik v1 controller.  Ignore error if it was due due to a
 	// missing Middleware CRD. That indicates presence of the
@@ -291,6 +324,11 @@ func (k Epinio) applyEpinioConfigYaml(ctx context.Context, c *kubernetes.Cluster
 	re = regexp.MustCompile(`##api_password##`)
 	renderedFileContents = re.ReplaceAll(renderedFileContents, []byte(encodedPass))
 
+	for name, value := range settings {
+		re = regexp.MustCompile(`##` + name + `##`)
+		renderedFileContents = re.ReplaceAll(renderedFileContents, []byte(value))
+	}
+
 	tmpFilePath, err := helpers.CreateTmpFile(string(renderedFileContents))
 	if err != nil {
 		return "", err
@@ -316,56 +354,82 @@ func (k Epinio) applyEpinioConfigYaml(ctx context.Context, c *kubernetes.Cluster
 }
 
 func (k *Epinio) createIngress(ctx context.Context, c *kubernetes.Cluster, subdomain string) error {
+	err := createServerIngress(ctx, c, "epinio", subdomain, "/", map[string]string{
+		// Traefik v1 annotations for ingress with basic auth.
+		// See `assets/embedded-files/epinio/server.yaml` for
+		// the definition of the secret.
+		"ingress.kubernetes.io/auth-type":   "basic",
+		"ingress.kubernetes.io/auth-secret": "epinio-api-auth-secret",
+		// Traefik v2 annotation for ingress with basic auth.
+		// The name of the middleware is `(namespace)-(object)@kubernetescrd`.
+		"traefik.ingress.kubernetes.io/router.middlewares": EpinioDeploymentID + "-epinio-api-auth@kubernetescrd",
+	})
+	if err != nil {
+		return err
+	}
+
+	// The git providers can not authenticate against the basic auth of
+	// the API. The webhooks are authenticated by their secret instead.
+	// Traefik prefers the longer path over the "/" of the API.
+	return createServerIngress(ctx, c, "epinio-webhooks", subdomain, "/api/v1/webhooks/", map[string]string{})
+}
+
+// createServerIngress creates an ingress of the epinio server for the path,
+// with TLS and the extra annotations. The ingress of an earlier installation
+// is updated, so that an upgrade adds the ingresses of newer versions.
+func createServerIngress(ctx context.Context, c *kubernetes.Cluster, name, subdomain, path string, annotations map[string]string) error {
+	annotations["kubernetes.io/ingress.class"] = "traefik"
+	// Traefik v1/v2 tls annotations.
+	annotations["traefik.ingress.kubernetes.io/router.entrypoints"] = "websecure"
+	annotations["traefik.ingress.kubernetes.io/router.tls"] = "true"
+
 	pathTypePrefix := networkingv1.PathTypeImplementationSpecific
-	_, err := c.Kubectl.NetworkingV1().Ingresses(EpinioDeploymentID).Create(
-		ctx,
-		&networkingv1.Ingress{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "epinio",
-				Namespace: EpinioDeploymentID,
-				Annotations: map[string]string{
-					"kubernetes.io/ingress.class": "traefik",
-					// Traefik v1 annotations for ingress with basic auth.
-					// See `assets/embedded-files/epinio/server.yaml` for
-					// the definition of the secret.
-					"ingress.kubernetes.io/auth-type":   "basic",
-					"ingress.kubernetes.io/auth-secret": "epinio-api-auth-secret",
-					// Traefik v2 annotation for ingress with basic auth.
-					// The name of the middleware is `(namespace)-(object)@kubernetescrd`.
-					"traefik.ingress.kubernetes.io/router.middlewares": EpinioDeploymentID + "-epinio-api-auth@kubernetescrd",
-					// Traefik v1/v2 tls annotations.
-					"traefik.ingress.kubernetes.io/router.entrypoints": "websecure",
-					"traefik.ingress.kubernetes.io/router.tls":         "true",
-				},
-				Labels: map[string]string{
-					"app.kubernetes.io/name": "epinio",
-				},
+	client := c.Kubectl.NetworkingV1().Ingresses(EpinioDeploymentID)
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   EpinioDeploymentID,
+			Annotations: annotations,
+			Labels: map[string]string{
+				"app.kubernetes.io/name": "epinio",
 			},
-			Spec: networkingv1.IngressSpec{
-				Rules: []networkingv1.IngressRule{
-					{
-						Host: subdomain,
-						IngressRuleValue: networkingv1.IngressRuleValue{
-							HTTP: &networkingv1.HTTPIngressRuleValue{
-								Paths: []networkingv1.HTTPIngressPath{
-									{
-										Path:     "/",
-										PathType: &pathTypePrefix,
-										Backend: networkingv1.IngressBackend{
-											Service: &networkingv1.IngressServiceBackend{
-												Name: "epinio-server",
-												Port: networkingv1.ServiceBackendPort{
-													Number: 80,
-												},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: subdomain,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     path,
+									PathType: &pathTypePrefix,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "epinio-server",
+											Port: networkingv1.ServiceBackendPort{
+												Number: 80,
 											},
-										}}}}}}},
-				TLS: []networkingv1.IngressTLS{{
-					Hosts:      []string{subdomain},
-					SecretName: "epinio-tls",
-				}},
+										},
+									}}}}}}},
+			TLS: []networkingv1.IngressTLS{{
+				Hosts:      []string{subdomain},
+				SecretName: "epinio-tls",
 			}},
-		metav1.CreateOptions{},
-	)
+		}}
+
+	_, err := client.Create(ctx, ingress, metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
 
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	existing.Annotations = ingress.Annotations
+	existing.Labels = ingress.Labels
+	existing.Spec = ingress.Spec
+	_, err = client.Update(ctx, existing, metav1.UpdateOptions{})
 	return err
 }
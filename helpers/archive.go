@@ -0,0 +1,158 @@
+package helpers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/mholt/archiver/v3"
+)
+
+// Archive formats, as detected by SniffArchive
+const (
+	ArchiveTar     = "tar"
+	ArchiveTarGz   = "tar.gz"
+	ArchiveTarZstd = "tar.zst"
+	ArchiveZip     = "zip"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+	// Empty zip archives consist of the end of central directory record
+	zipEmptyMagic = []byte("PK\x05\x06")
+	tarMagic      = []byte("ustar")
+)
+
+// tarMagicOffset is the position of the magic in a tar header
+const tarMagicOffset = 257
+
+// SniffArchive determines the format of the archive from its content,
+// regardless of its name. Jar and war files are zip archives.
+func SniffArchive(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return ArchiveTarGz, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		return ArchiveTarZstd, nil
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, zipEmptyMagic):
+		return ArchiveZip, nil
+	case len(header) > tarMagicOffset+len(tarMagic) &&
+		bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return ArchiveTar, nil
+	case len(header) == 512 && bytes.Count(header, []byte{0}) == 512:
+		// An empty tarball is only the zero filled end marker
+		return ArchiveTar, nil
+	}
+
+	return "", fmt.Errorf("'%s' is not a tar, tar.gz, tar.zst or zip archive", name)
+}
+
+// ArchiveLimits bound what UnpackArchive extracts. A zero field means no
+// limit.
+type ArchiveLimits struct {
+	// Size is the total size of the unpacked files, in bytes
+	Size int64
+	// Entries is the number of files, directories and links
+	Entries int
+}
+
+// ErrArchiveLimits is returned by UnpackArchive for an archive exceeding
+// the limits
+var ErrArchiveLimits = errors.New("archive exceeds the limits of unpacked size or entries")
+
+// UnpackArchive extracts the archive into dir. The format is determined by
+// SniffArchive. With limits, the archive is read through before anything
+// is extracted, counting the entries and the bytes of their content, and
+// rejected with ErrArchiveLimits as soon as it exceeds them.
+func UnpackArchive(name, dir string, limits ArchiveLimits) error {
+	format, err := SniffArchive(name)
+	if err != nil {
+		return err
+	}
+
+	var unarchiver interface {
+		archiver.Unarchiver
+		archiver.Walker
+	}
+	switch format {
+	case ArchiveTar:
+		unarchiver = archiver.NewTar()
+	case ArchiveTarGz:
+		unarchiver = archiver.NewTarGz()
+	case ArchiveTarZstd:
+		unarchiver = archiver.NewTarZstd()
+	case ArchiveZip:
+		unarchiver = archiver.NewZip()
+	}
+
+	if limits.Size > 0 || limits.Entries > 0 {
+		err = checkArchive(unarchiver, name, limits)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return unarchiver.Unarchive(name, dir)
+}
+
+// checkArchive reads the archive through and fails when it exceeds the
+// limits. The content is counted as read, sizes claimed by the headers are
+// not trusted.
+func checkArchive(walker archiver.Walker, name string, limits ArchiveLimits) error {
+	var (
+		size     int64
+		entries  int
+		exceeded bool
+	)
+
+	err := walker.Walk(name, func(f archiver.File) error {
+		entries++
+		if limits.Entries > 0 && entries > limits.Entries {
+			exceeded = true
+			return ErrArchiveLimits
+		}
+		if f.IsDir() {
+			return nil
+		}
+
+		var content io.Reader = f
+		if limits.Size > 0 {
+			// One byte more than allowed tells that the limit is exceeded
+			content = io.LimitReader(f, limits.Size-size+1)
+		}
+		n, err := io.Copy(ioutil.Discard, content)
+		size += n
+		if limits.Size > 0 && size > limits.Size {
+			exceeded = true
+			return ErrArchiveLimits
+		}
+		return err
+	})
+	if exceeded {
+		return ErrArchiveLimits
+	}
+	return err
+}
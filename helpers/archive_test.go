@@ -0,0 +1,114 @@
+package helpers_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/epinio/epinio/helpers"
+	"github.com/mholt/archiver/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Archives", func() {
+	var (
+		tmpDir string
+		src    string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "epinio-archive-test")
+		Expect(err).ToNot(HaveOccurred())
+
+		src = filepath.Join(tmpDir, "src")
+		Expect(os.MkdirAll(src, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(src, "main.go"), []byte("package main"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	// archive writes the sources in the format, under a name which does
+	// not tell the format
+	archive := func(a archiver.Archiver, ext string) string {
+		name := filepath.Join(tmpDir, "archive")
+		Expect(a.Archive([]string{filepath.Join(src, "main.go")}, name+ext)).To(Succeed())
+		Expect(os.Rename(name+ext, name)).To(Succeed())
+		return name
+	}
+
+	for _, c := range []struct {
+		format   string
+		ext      string
+		archiver archiver.Archiver
+	}{
+		{ArchiveTar, ".tar", archiver.NewTar()},
+		{ArchiveTarGz, ".tar.gz", archiver.NewTarGz()},
+		{ArchiveTarZstd, ".tar.zst", archiver.NewTarZstd()},
+		{ArchiveZip, ".zip", archiver.NewZip()},
+	} {
+		c := c
+		It("detects "+c.format+" by content and unpacks it", func() {
+			name := archive(c.archiver, c.ext)
+
+			Expect(SniffArchive(name)).To(Equal(c.format))
+
+			out := filepath.Join(tmpDir, "out")
+			Expect(UnpackArchive(name, out, ArchiveLimits{})).To(Succeed())
+			content, err := ioutil.ReadFile(filepath.Join(out, "main.go"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("package main"))
+		})
+	}
+
+	It("accepts an empty tarball", func() {
+		name := filepath.Join(tmpDir, "empty.tar")
+		Expect(ioutil.WriteFile(name, make([]byte, 1024), 0644)).To(Succeed())
+
+		Expect(SniffArchive(name)).To(Equal(ArchiveTar))
+		Expect(UnpackArchive(name, filepath.Join(tmpDir, "out"), ArchiveLimits{})).To(Succeed())
+	})
+
+	It("unpacks archives within the limits", func() {
+		name := archive(archiver.NewTarGz(), ".tar.gz")
+
+		out := filepath.Join(tmpDir, "out")
+		Expect(UnpackArchive(name, out, ArchiveLimits{Size: 12, Entries: 1})).To(Succeed())
+		Expect(filepath.Join(out, "main.go")).To(BeARegularFile())
+	})
+
+	It("rejects archives unpacking to more than the size limit", func() {
+		// A megabyte of zeros compresses to about a kilobyte
+		Expect(ioutil.WriteFile(filepath.Join(src, "zeros"), make([]byte, 1<<20), 0644)).To(Succeed())
+		name := filepath.Join(tmpDir, "bomb.tar.gz")
+		Expect(archiver.NewTarGz().Archive([]string{filepath.Join(src, "zeros")}, name)).To(Succeed())
+
+		out := filepath.Join(tmpDir, "out")
+		err := UnpackArchive(name, out, ArchiveLimits{Size: 1 << 19})
+		Expect(err).To(MatchError(ErrArchiveLimits))
+		Expect(out).ToNot(BeADirectory())
+	})
+
+	It("rejects archives with more than the entry limit", func() {
+		Expect(ioutil.WriteFile(filepath.Join(src, "other.go"), []byte("package main"), 0644)).To(Succeed())
+		name := filepath.Join(tmpDir, "many.zip")
+		Expect(archiver.NewZip().Archive([]string{
+			filepath.Join(src, "main.go"),
+			filepath.Join(src, "other.go"),
+		}, name)).To(Succeed())
+
+		err := UnpackArchive(name, filepath.Join(tmpDir, "out"), ArchiveLimits{Entries: 1})
+		Expect(err).To(MatchError(ErrArchiveLimits))
+	})
+
+	It("rejects other files", func() {
+		name := filepath.Join(tmpDir, "main.go")
+		Expect(ioutil.WriteFile(name, []byte("package main"), 0644)).To(Succeed())
+
+		_, err := SniffArchive(name)
+		Expect(err).To(MatchError(ContainSubstring("is not a tar, tar.gz, tar.zst or zip archive")))
+	})
+})
@@ -0,0 +1,158 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is a single pattern of an ignore file
+type ignoreRule struct {
+	base    string // directory of the ignore file, relative to the root
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// IgnoreMatcher decides which paths are excluded by the ignore files found in
+// a directory tree. The patterns use the syntax of .gitignore. Paths are
+// slash separated and relative to the root of the tree.
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+// NewIgnoreMatcher returns a matcher without any rules
+func NewIgnoreMatcher() *IgnoreMatcher {
+	return &IgnoreMatcher{}
+}
+
+// Add reads the patterns of the ignore file found in directory dir, relative
+// to the root. The files of deeper directories have to be added after those
+// of their parents, so that their patterns take precedence.
+func (m *IgnoreMatcher) Add(dir string, content []byte) {
+	if dir == "." {
+		dir = ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		rule, ok := parseIgnoreRule(scanner.Text())
+		if !ok {
+			continue
+		}
+		rule.base = dir
+		m.rules = append(m.rules, rule)
+	}
+}
+
+// Ignored returns true if the path is excluded. As for git, the last
+// matching pattern decides, and a negated pattern includes the path again.
+// The caller is expected to not descend into ignored directories.
+func (m *IgnoreMatcher) Ignored(name string, isDir bool) bool {
+	ignored := false
+	for _, rule := range m.rules {
+		rel := name
+		if rule.base != "" {
+			if !strings.HasPrefix(name, rule.base+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(name, rule.base+"/")
+		}
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// parseIgnoreRule converts a line of an ignore file into a rule. Blank lines
+// and comments yield no rule.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	rule := ignoreRule{}
+
+	// Trailing spaces are ignored, unless escaped
+	trimmed := strings.TrimRight(line, " \t\r")
+	if strings.HasSuffix(trimmed, "\\") && len(trimmed) < len(line) {
+		trimmed += " "
+	}
+	line = trimmed
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+
+	// A pattern with a slash is relative to the directory of the ignore
+	// file, any other matches at all depths below it.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := "^"
+	if !anchored {
+		expr += "(?:.*/)?"
+	}
+	expr += ignorePatternExpr(line) + "$"
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return rule, false
+	}
+	rule.re = re
+
+	return rule, true
+}
+
+// ignorePatternExpr translates the glob of a pattern into a regular
+// expression
+func ignorePatternExpr(pattern string) string {
+	var expr strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case pattern[i:] == "**" && i > 0 && pattern[i-1] == '/':
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return expr.String()
+}
@@ -0,0 +1,92 @@
+package helpers_test
+
+import (
+	. "github.com/epinio/epinio/helpers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IgnoreMatcher", func() {
+	var matcher *IgnoreMatcher
+
+	BeforeEach(func() {
+		matcher = NewIgnoreMatcher()
+	})
+
+	It("ignores nothing without rules", func() {
+		Expect(matcher.Ignored("main.go", false)).To(BeFalse())
+	})
+
+	It("matches patterns without slash at any depth", func() {
+		matcher.Add(".", []byte("# comment\n\n*.log\n"))
+
+		Expect(matcher.Ignored("debug.log", false)).To(BeTrue())
+		Expect(matcher.Ignored("tmp/debug.log", false)).To(BeTrue())
+		Expect(matcher.Ignored("debug.log.txt", false)).To(BeFalse())
+		Expect(matcher.Ignored("# comment", false)).To(BeFalse())
+	})
+
+	It("anchors patterns with slash to the directory of the ignore file", func() {
+		matcher.Add(".", []byte("/build\ndocs/*.pdf\n"))
+
+		Expect(matcher.Ignored("build", true)).To(BeTrue())
+		Expect(matcher.Ignored("src/build", true)).To(BeFalse())
+		Expect(matcher.Ignored("docs/manual.pdf", false)).To(BeTrue())
+		Expect(matcher.Ignored("docs/en/manual.pdf", false)).To(BeFalse())
+	})
+
+	It("applies directory patterns to directories only", func() {
+		matcher.Add(".", []byte("cache/\n"))
+
+		Expect(matcher.Ignored("cache", true)).To(BeTrue())
+		Expect(matcher.Ignored("lib/cache", true)).To(BeTrue())
+		Expect(matcher.Ignored("cache", false)).To(BeFalse())
+	})
+
+	It("supports double asterisks", func() {
+		matcher.Add(".", []byte("**/fixtures\nlogs/**\na/**/z\n"))
+
+		Expect(matcher.Ignored("fixtures", true)).To(BeTrue())
+		Expect(matcher.Ignored("test/unit/fixtures", true)).To(BeTrue())
+		Expect(matcher.Ignored("logs/today/app.log", false)).To(BeTrue())
+		Expect(matcher.Ignored("logs", true)).To(BeFalse())
+		Expect(matcher.Ignored("a/z", false)).To(BeTrue())
+		Expect(matcher.Ignored("a/b/c/z", false)).To(BeTrue())
+	})
+
+	It("supports wildcards and character classes", func() {
+		matcher.Add(".", []byte("file?.txt\n*.[oa]\nv[!0-9]\n"))
+
+		Expect(matcher.Ignored("file1.txt", false)).To(BeTrue())
+		Expect(matcher.Ignored("file10.txt", false)).To(BeFalse())
+		Expect(matcher.Ignored("main.o", false)).To(BeTrue())
+		Expect(matcher.Ignored("lib.a", false)).To(BeTrue())
+		Expect(matcher.Ignored("main.c", false)).To(BeFalse())
+		Expect(matcher.Ignored("vx", false)).To(BeTrue())
+		Expect(matcher.Ignored("v1", false)).To(BeFalse())
+	})
+
+	It("lets the last matching pattern decide", func() {
+		matcher.Add(".", []byte("*.env\n!example.env\n"))
+
+		Expect(matcher.Ignored("prod.env", false)).To(BeTrue())
+		Expect(matcher.Ignored("example.env", false)).To(BeFalse())
+	})
+
+	It("gives the ignore files of subdirectories precedence", func() {
+		matcher.Add(".", []byte("*.json\n"))
+		matcher.Add("config", []byte("!*.json\n/local.json\n"))
+
+		Expect(matcher.Ignored("package.json", false)).To(BeTrue())
+		Expect(matcher.Ignored("config/app.json", false)).To(BeFalse())
+		Expect(matcher.Ignored("config/local.json", false)).To(BeTrue())
+		Expect(matcher.Ignored("other/app.json", false)).To(BeTrue())
+	})
+
+	It("honors escaped characters", func() {
+		matcher.Add(".", []byte("\\#notes\n\\!important\n"))
+
+		Expect(matcher.Ignored("#notes", false)).To(BeTrue())
+		Expect(matcher.Ignored("!important", false)).To(BeTrue())
+	})
+})
@@ -55,7 +55,7 @@ var (
 var clusterMemo *Cluster
 
 type Platform interface {
-	Detect(context.Context, *kubernetes.Clientset) bool
+	Detect(context.Context, kubernetes.Interface) bool
 	Describe() string
 	String() string
 	Load(context.Context, *kubernetes.Clientset) error
@@ -72,7 +72,7 @@ var SupportedPlatforms []Platform = []Platform{
 type Cluster struct {
 	//	InternalIPs []string
 	//	Ingress     bool
-	Kubectl    *kubernetes.Clientset
+	Kubectl    kubernetes.Interface
 	RestConfig *restclient.Config
 	platform   Platform
 }
@@ -600,7 +600,7 @@ func (c *Cluster) LabelSecret(ctx context.Context, namespace, name string, label
 
 // GetVersion get the kube server version
 func (c *Cluster) GetVersion() (string, error) {
-	v, err := c.Kubectl.ServerVersion()
+	v, err := c.Kubectl.Discovery().ServerVersion()
 	if err != nil {
 		return "", errors.Wrap(err, "failed to get kube server version")
 	}
@@ -18,7 +18,7 @@ func (k *Generic) Describe() string {
 
 func (k *Generic) String() string { return "generic" }
 
-func (k *Generic) Detect(ctx context.Context, kube *kubernetes.Clientset) bool {
+func (k *Generic) Detect(ctx context.Context, kube kubernetes.Interface) bool {
 	return false
 }
 
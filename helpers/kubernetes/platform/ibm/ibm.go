@@ -20,7 +20,7 @@ func (k *ibm) Describe() string {
 
 func (k *ibm) String() string { return "ibm" }
 
-func (k *ibm) Detect(ctx context.Context, kube *kubernetes.Clientset) bool {
+func (k *ibm) Detect(ctx context.Context, kube kubernetes.Interface) bool {
 	nodes, err := kube.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return false
@@ -21,7 +21,7 @@ func (k *k3s) Describe() string {
 
 func (k *k3s) String() string { return "k3s" }
 
-func (k *k3s) Detect(ctx context.Context, kube *kubernetes.Clientset) bool {
+func (k *k3s) Detect(ctx context.Context, kube kubernetes.Interface) bool {
 	nodes, err := kube.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return false
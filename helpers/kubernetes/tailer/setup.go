@@ -30,6 +30,7 @@ type Config struct {
 	LabelSelector         labels.Selector
 	TailLines             *int64
 	Template              *template.Template // Template to apply to log entries for formatting
+	Redact                []string           // Values replaced by a placeholder in all log entries
 }
 
 // ContainerLogLine is an object that represents a line from the logs of a container.
@@ -70,6 +71,7 @@ func FetchLogs(ctx context.Context, logChan chan ContainerLogLine, wg *sync.Wait
 				Include:      config.Include,
 				Namespace:    config.AllNamespaces,
 				TailLines:    config.TailLines,
+				Redact:       config.Redact,
 			})
 	}
 
@@ -147,6 +149,7 @@ func StreamLogs(ctx context.Context, logChan chan ContainerLogLine, wg *sync.Wai
 					Include:      config.Include,
 					Namespace:    config.AllNamespaces,
 					TailLines:    config.TailLines,
+					Redact:       config.Redact,
 				})
 			tails[id] = tail
 
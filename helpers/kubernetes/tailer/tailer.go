@@ -19,7 +19,7 @@ type Tail struct {
 	ContainerName string
 	Options       *TailOptions
 	logger        logr.Logger
-	clientSet     *kubernetes.Clientset
+	clientSet     kubernetes.Interface
 }
 
 type TailOptions struct {
@@ -30,11 +30,15 @@ type TailOptions struct {
 	Include      []*regexp.Regexp
 	Namespace    bool
 	TailLines    *int64
+	Redact       []string
 	Logger       logr.Logger
 }
 
+// RedactedPlaceholder replaces the redacted values in log entries
+const RedactedPlaceholder = "[REDACTED]"
+
 // NewTail returns a new tail for a Kubernetes container inside a pod
-func NewTail(namespace, podName, containerName string, logger logr.Logger, clientSet *kubernetes.Clientset, options *TailOptions) *Tail {
+func NewTail(namespace, podName, containerName string, logger logr.Logger, clientSet kubernetes.Interface, options *TailOptions) *Tail {
 	return &Tail{
 		Namespace:     namespace,
 		PodName:       podName,
@@ -74,6 +78,15 @@ func (t *Tail) Start(ctx context.Context, logChan chan ContainerLogLine, follow
 
 	reader := bufio.NewReader(stream)
 
+	var redactor *strings.Replacer
+	if len(t.Options.Redact) > 0 {
+		pairs := []string{}
+		for _, value := range t.Options.Redact {
+			pairs = append(pairs, value, RedactedPlaceholder)
+		}
+		redactor = strings.NewReplacer(pairs...)
+	}
+
 OUTER:
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -87,6 +100,9 @@ OUTER:
 		}
 
 		str := strings.TrimRight(string(line), "\r\n\t ")
+		if redactor != nil {
+			str = redactor.Replace(str)
+		}
 
 		for _, rex := range t.Options.Exclude {
 			if rex.MatchString(str) {
@@ -0,0 +1,108 @@
+package termui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// This file implements the BarProgress form of the Progress
+// interface. It shows how much of a known amount of work is done,
+// e.g. the bytes of an upload.
+
+type BarProgress struct {
+	ui      *UI
+	mu      *sync.Mutex
+	message string
+	total   int64
+	current int64
+	active  bool
+}
+
+// Standard width of the bar, in characters
+const barWidth = 30
+
+// NewBarProgress creates and starts a progress bar for total units of
+// work
+func NewBarProgress(ui *UI, message string, total int64) *BarProgress {
+	p := &BarProgress{
+		ui:      ui,
+		mu:      &sync.Mutex{},
+		message: message,
+		total:   total,
+	}
+	p.Start()
+	return p
+}
+
+// ProgressBar creates, configures, and returns an active progress
+// bar for total units of work
+func (u *UI) ProgressBar(message string, total int64) *BarProgress {
+	return NewBarProgress(u, message, total)
+}
+
+func (p *BarProgress) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active {
+		return
+	}
+	p.active = true
+	p.ui.Normal().KeepLine().Msg(p.line())
+}
+
+func (p *BarProgress) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active {
+		p.active = false
+		p.ui.Normal().Compact().Msg("")
+	}
+}
+
+// ChangeMessagef extends the bar-based progress with the ability to
+// change the message mid-flight
+func (p *BarProgress) ChangeMessagef(message string, a ...interface{}) {
+	p.ChangeMessage(fmt.Sprintf(message, a...))
+}
+
+// ChangeMessage extends the bar-based progress with the ability to
+// change the message mid-flight
+func (p *BarProgress) ChangeMessage(message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.message = message
+	p.render()
+}
+
+// Set moves the bar to the given amount of work done
+func (p *BarProgress) Set(current int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if current > p.total {
+		current = p.total
+	}
+	p.current = current
+	p.render()
+}
+
+// render redraws the bar in place. Must be called with the lock held.
+func (p *BarProgress) render() {
+	if !p.active {
+		return
+	}
+	p.ui.Normal().Compact().KeepLine().Msg("\r" + p.line())
+}
+
+func (p *BarProgress) line() string {
+	percent := int64(100)
+	if p.total > 0 {
+		percent = p.current * 100 / p.total
+	}
+	done := int(percent * barWidth / 100)
+	bar := strings.Repeat("=", done) + strings.Repeat(" ", barWidth-done)
+
+	return fmt.Sprintf("%s [%s] %3d%%", p.message, color.MagentaString(bar), percent)
+}
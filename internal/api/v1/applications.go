@@ -13,8 +13,8 @@ import (
 	"github.com/epinio/epinio/helpers/tracelog"
 	"github.com/epinio/epinio/internal/api/v1/models"
 	"github.com/epinio/epinio/internal/application"
-	"github.com/epinio/epinio/internal/cli/clients/gitea"
 	"github.com/epinio/epinio/internal/organizations"
+	"github.com/epinio/epinio/internal/sources"
 	"github.com/gorilla/websocket"
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
@@ -139,6 +139,11 @@ func (hc ApplicationsController) Show(w http.ResponseWriter, r *http.Request) AP
 		return AppIsNotKnown(appName)
 	}
 
+	app.History, err = application.History(ctx, cluster, app.AppRef())
+	if err != nil {
+		return InternalError(err)
+	}
+
 	js, err := json.Marshal(app)
 	if err != nil {
 		return InternalError(err)
@@ -354,7 +359,7 @@ func (hc ApplicationsController) Delete(w http.ResponseWriter, r *http.Request)
 	org := params.ByName("org")
 	appName := params.ByName("app")
 
-	gitea, err := gitea.New(ctx)
+	store, err := sources.New(ctx)
 	if err != nil {
 		return InternalError(err)
 	}
@@ -392,7 +397,12 @@ func (hc ApplicationsController) Delete(w http.ResponseWriter, r *http.Request)
 		response.UnboundServices = app.BoundServices
 	}
 
-	err = application.Delete(ctx, cluster, gitea, appRef)
+	err = queue.drop(ctx, cluster, appRef)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = application.Delete(ctx, cluster, store, appRef)
 	if err != nil {
 		return InternalError(err)
 	}
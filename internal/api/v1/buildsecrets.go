@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/application"
+	"github.com/julienschmidt/httprouter"
+)
+
+type BuildSecretsController struct {
+}
+
+// Index lists the names of the application's build secrets
+func (bc BuildSecretsController) Index(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	appName := params.ByName("app")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	apiErr := appExists(ctx, cluster, org, appName)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	keys, err := application.BuildSecretKeys(ctx, cluster, models.NewAppRef(appName, org))
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = jsonResponse(w, models.BuildSecretsResponse{Keys: keys})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// Set creates or replaces a build secret of the application. It is used by
+// the next staging.
+func (bc BuildSecretsController) Set(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	appName := params.ByName("app")
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var setRequest models.BuildSecretSetRequest
+	err = json.Unmarshal(bodyBytes, &setRequest)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if !application.ValidBuildSecretKey(setRequest.Key) {
+		return NewBadRequest(fmt.Sprintf("Build secret name '%s' is not a valid environment variable name", setRequest.Key))
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	apiErr := appExists(ctx, cluster, org, appName)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	err = application.SetBuildSecret(ctx, cluster, models.NewAppRef(appName, org), setRequest.Key, setRequest.Value)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// Unset removes a build secret of the application
+func (bc BuildSecretsController) Unset(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	appName := params.ByName("app")
+	key := params.ByName("key")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	apiErr := appExists(ctx, cluster, org, appName)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	err = application.UnsetBuildSecret(ctx, cluster, models.NewAppRef(appName, org), key)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package v1
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/tracelog"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/sources"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Source returns the sources of the app as gzipped tarball. The `revision`
+// query parameter selects a revision, the latest is returned without.
+func (hc ApplicationsController) Source(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	app := models.NewAppRef(params.ByName("app"), params.ByName("org"))
+	revision := r.URL.Query().Get("revision")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	apiErr := appExists(ctx, cluster, app.Org, app.Name)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	store, err := sources.New(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	// The archive is assembled on disk first, so that failures are still
+	// reported as errors, not as a truncated download
+	tmp, err := ioutil.TempFile("", "epinio-download-*.tar.gz")
+	if err != nil {
+		return InternalError(err, "can't create temp file")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = store.Download(ctx, app, revision, tmp)
+	if err == sources.ErrNotFound {
+		return SourceIsNotKnown(app.Name, revision)
+	}
+	if err != nil {
+		return InternalError(err, "failed to fetch sources")
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return InternalError(err)
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	tracelog.Logger(ctx).Info("download sources", "org", app.Org, "app", app.Name,
+		"revision", revision, "size", info.Size())
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar.gz"`, app.Name))
+	_, err = io.Copy(w, tmp)
+	if err != nil {
+		tracelog.Logger(ctx).Error(err, "failed to send sources")
+	}
+
+	return nil
+}
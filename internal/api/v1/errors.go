@@ -151,6 +151,76 @@ func ServiceAlreadyKnown(service string) APIError {
 		http.StatusConflict)
 }
 
+func ServiceAlreadyKnownInOrg(service, org string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Service '%s' already exists in organization '%s'", service, org),
+		"",
+		http.StatusConflict)
+}
+
+func ServiceIsNotShared(service, org string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Service '%s' is not shared with organization '%s'", service, org),
+		"",
+		http.StatusNotFound)
+}
+
+func ServiceKeyIsNotKnown(key, service string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Key '%s' of service '%s' does not exist", key, service),
+		"",
+		http.StatusNotFound)
+}
+
+func ServiceBackupIsNotKnown(backup, service string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Backup '%s' of service '%s' does not exist", backup, service),
+		"",
+		http.StatusNotFound)
+}
+
+func ServiceKeyAlreadyKnown(key, service string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Key '%s' of service '%s' already exists", key, service),
+		"",
+		http.StatusConflict)
+}
+
+func OperationIsNotKnown(id string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Operation '%s' does not exist", id),
+		"",
+		http.StatusNotFound)
+}
+
+func BrokerIsNotKnown(broker string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Service broker '%s' does not exist", broker),
+		"",
+		http.StatusNotFound)
+}
+
+func BrokerAlreadyKnown(broker string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Service broker '%s' already exists", broker),
+		"",
+		http.StatusConflict)
+}
+
+func ChartIsNotKnown(chart string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Service chart '%s' does not exist", chart),
+		"",
+		http.StatusNotFound)
+}
+
+func ChartAlreadyKnown(chart string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Service chart '%s' already exists", chart),
+		"",
+		http.StatusConflict)
+}
+
 func ServiceAlreadyBound(service string) APIError {
 	return NewAPIError(
 		fmt.Sprintf("Service '%s' already bound", service),
@@ -158,9 +228,50 @@ func ServiceAlreadyBound(service string) APIError {
 		http.StatusConflict)
 }
 
+func ServiceBindingFailed(service string, reason error) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Service '%s' could not be bound", service),
+		reason.Error(),
+		http.StatusBadRequest)
+}
+
 func ServiceIsNotBound(service string) APIError {
 	return NewAPIError(
 		fmt.Sprintf("Service '%s' is not bound", service),
 		"",
 		http.StatusBadRequest)
 }
+
+func WebhookIsNotEnabled(app string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Application '%s' has no webhook enabled", app),
+		"",
+		http.StatusNotFound)
+}
+
+func StageIsNotKnown(stageID string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Staging run '%s' does not exist", stageID),
+		"",
+		http.StatusNotFound)
+}
+
+func SourceIsNotKnown(app, revision string) APIError {
+	if revision == "" {
+		return NewAPIError(
+			fmt.Sprintf("Application '%s' has no sources", app),
+			"",
+			http.StatusNotFound)
+	}
+	return NewAPIError(
+		fmt.Sprintf("Revision '%s' of application '%s' does not exist", revision, app),
+		"",
+		http.StatusNotFound)
+}
+
+func UploadIsNotKnown(id string) APIError {
+	return NewAPIError(
+		fmt.Sprintf("Upload '%s' does not exist", id),
+		"",
+		http.StatusNotFound)
+}
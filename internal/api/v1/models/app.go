@@ -1,18 +1,26 @@
 package models
 
+import "time"
+
 const (
 	EpinioStageIDLabel = "epinio.suse.org/stage-id"
+
+	// StagingTriggerPush marks stagings requested by `epinio push`
+	StagingTriggerPush = "push"
+	// StagingTriggerWebhook marks stagings requested by a git webhook
+	StagingTriggerWebhook = "webhook"
 )
 
 // App has all the app properties, like the routes and stage ID.
 // It is used in the CLI and  API responses.
 type App struct {
-	StageID       string   `json:"stage_id,omitempty"`
-	Name          string   `json:"name,omitempty"`
-	Organization  string   `json:"organization,omitempty"`
-	Status        string   `json:"status,omitempty"`
-	Routes        []string `json:"routes,omitempty"`
-	BoundServices []string `json:"bound_services,omitempty"`
+	StageID       string          `json:"stage_id,omitempty"`
+	Name          string          `json:"name,omitempty"`
+	Organization  string          `json:"organization,omitempty"`
+	Status        string          `json:"status,omitempty"`
+	Routes        []string        `json:"routes,omitempty"`
+	BoundServices []string        `json:"bound_services,omitempty"`
+	History       []StagingRecord `json:"history,omitempty"`
 }
 
 // NewApp returns a new app for name and org
@@ -83,3 +91,49 @@ type GitRef struct {
 	Revision string `json:"revision"`
 	URL      string `json:"url"`
 }
+
+// ArchiveRef describes a tarball of application sources in an object store.
+// The digest is the SHA-256 of the tarball, and serves as its revision.
+type ArchiveRef struct {
+	Key    string `json:"key"`
+	Digest string `json:"digest"`
+}
+
+// StagingRecord describes a single staging of an app, as kept in the app's
+// history
+type StagingRecord struct {
+	ID       string    `json:"id"`
+	Revision string    `json:"revision"`
+	Trigger  string    `json:"trigger"`
+	Time     time.Time `json:"time"`
+}
+
+// Staging failure reasons, as determined by inspecting the failed
+// PipelineRun
+const (
+	StagingFailureNoBuildpack  = "no-buildpack"
+	StagingFailureImagePush    = "image-push"
+	StagingFailureRegistryAuth = "registry-auth"
+	StagingFailureOutOfDisk    = "out-of-disk"
+	StagingFailureTimeout      = "timeout"
+	StagingFailureUnknown      = "unknown"
+)
+
+// Staging states
+const (
+	StagingQueued    = "queued"
+	StagingRunning   = "running"
+	StagingSucceeded = "succeeded"
+	StagingFailed    = "failed"
+)
+
+// StagingFailure describes why a staging run failed: the failing task and
+// step, its exit code and the tail of its log
+type StagingFailure struct {
+	Reason   string   `json:"reason"`
+	Message  string   `json:"message,omitempty"`
+	Task     string   `json:"task,omitempty"`
+	Step     string   `json:"step,omitempty"`
+	ExitCode int32    `json:"exit_code,omitempty"`
+	Logs     []string `json:"logs,omitempty"`
+}
@@ -2,19 +2,35 @@
 // response data used by the communication between cli and api server.
 package models
 
+import "time"
+
+// ServiceResponse is the overview of a service in the service listing.
+// LastError is the error of the last operation on the service, if that
+// failed.
 type ServiceResponse struct {
-	Name      string   `json:"name"`
-	BoundApps []string `json:"boundapps"`
+	Name      string    `json:"name"`
+	BoundApps []string  `json:"boundapps"`
+	Type      string    `json:"type"`
+	Class     string    `json:"class,omitempty"`
+	Plan      string    `json:"plan,omitempty"`
+	Status    string    `json:"status"`
+	Created   time.Time `json:"created"`
+	LastError string    `json:"lasterror,omitempty"`
 }
 
 type ServiceResponseList []ServiceResponse
 
 type CatalogCreateRequest struct {
-	Name             string `json:"name"`
-	Class            string `json:"class"`
-	Plan             string `json:"plan"`
-	Data             string `json:"data"`
-	WaitForProvision bool   `json:"waitforprovision"`
+	Name  string `json:"name"`
+	Class string `json:"class"`
+	Plan  string `json:"plan"`
+	Data  string `json:"data"`
+}
+
+// ServiceOperationResponse names the operation completing a change of a
+// service in the background, see the Operation route
+type ServiceOperationResponse struct {
+	Operation string `json:"operation"`
 }
 
 type CustomCreateRequest struct {
@@ -22,16 +38,140 @@ type CustomCreateRequest struct {
 	Data map[string]string `json:"data"`
 }
 
+// CustomUpdateRequest changes the data of a custom service. Set adds or
+// replaces keys, Unset removes them. Bound applications are restarted to
+// pick up the new data, unless NoRestart is set.
+type CustomUpdateRequest struct {
+	Set       map[string]string `json:"set,omitempty"`
+	Unset     []string          `json:"unset,omitempty"`
+	NoRestart bool              `json:"norestart,omitempty"`
+}
+
+// CustomUpdateResponse lists the applications which were restarted, and
+// why others were not. Applications of the orgs the service is shared with
+// are named ORG/APP.
+type CustomUpdateResponse struct {
+	RestartedApps []string `json:"restartedapps"`
+	RestartErrors []string `json:"restarterrors,omitempty"`
+}
+
+// BrokerCreateRequest registers a service broker with Epinio
+type BrokerCreateRequest struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// BrokerResponse describes a registered service broker. The credentials
+// are never returned.
+type BrokerResponse struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type BrokersResponse []BrokerResponse
+
+// ServiceUpdateRequest changes the plan and the parameters of a catalog
+// service. Empty fields are left unchanged. Data is a JSON object replacing
+// the parameters of the service.
+type ServiceUpdateRequest struct {
+	Plan string `json:"plan,omitempty"`
+	Data string `json:"data,omitempty"`
+}
+
+// DeleteRequest deletes a service. Unbind unbinds the applications bound to
+// it. Force additionally removes the shares of the service with other orgs,
+// unbinding the applications of these orgs.
 type DeleteRequest struct {
 	Unbind bool `json:"unbind"`
+	Force  bool `json:"force,omitempty"`
 }
 
 type DeleteResponse struct {
 	BoundApps []string `json:"boundapps"`
+	Operation string   `json:"operation,omitempty"`
+}
+
+// ServiceKeyCreateRequest creates a named key of a service, for consumers
+// outside of Epinio
+type ServiceKeyCreateRequest struct {
+	Name string `json:"name"`
+}
+
+// ServiceKeyResponse describes a key of a service, with its credentials
+type ServiceKeyResponse struct {
+	Name    string            `json:"name"`
+	Service string            `json:"service"`
+	Data    map[string]string `json:"data"`
+}
+
+// ServiceKeysResponse lists the names of the keys of a service
+type ServiceKeysResponse []string
+
+// ServiceShareRequest shares a service with another org
+type ServiceShareRequest struct {
+	Org string `json:"org"`
+}
+
+// ServiceUnshareRequest removes the share of a service with another org.
+// Unbind unbinds the applications of that org bound to the service.
+type ServiceUnshareRequest struct {
+	Unbind bool `json:"unbind"`
+}
+
+// ServiceUnshareResponse names the applications of the other org which were
+// unbound from the service
+type ServiceUnshareResponse struct {
+	BoundApps []string `json:"boundapps"`
+}
+
+// ServiceBackup describes a backup of a service. Scheduled backups are
+// removed once newer ones replaced them.
+type ServiceBackup struct {
+	ID        string    `json:"id"`
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	Created   time.Time `json:"created"`
+	Scheduled bool      `json:"scheduled,omitempty"`
 }
 
+// ServiceBackupSchedule is the interval of the automatic backups of a
+// service, the time of the next one, and how many of them are kept
+type ServiceBackupSchedule struct {
+	Every string    `json:"every"`
+	Next  time.Time `json:"next"`
+	Keep  int       `json:"keep"`
+}
+
+// ServiceBackupsResponse lists the backups of a service, oldest first, and
+// its schedule, if it has one
+type ServiceBackupsResponse struct {
+	Backups  []ServiceBackup        `json:"backups"`
+	Schedule *ServiceBackupSchedule `json:"schedule,omitempty"`
+}
+
+// ServiceBackupResponse names a new backup of a service, and the operation
+// taking it
+type ServiceBackupResponse struct {
+	ID        string `json:"id"`
+	Operation string `json:"operation"`
+}
+
+// ServiceBackupScheduleRequest sets the interval of the automatic backups of
+// a service, e.g. 24h, and how many of them are kept. Zero removes the
+// schedule. Without Keep the server's default is kept.
+type ServiceBackupScheduleRequest struct {
+	Every string `json:"every"`
+	Keep  int    `json:"keep,omitempty"`
+}
+
+// BindRequest binds services to an application. As selects how the
+// application sees the binding data: as files, env, or both. Files is the
+// default.
 type BindRequest struct {
 	Names []string `json:"names"`
+	As    string   `json:"as,omitempty"`
 }
 
 type BindResponse struct {
@@ -50,21 +190,104 @@ type UpdateAppRequest struct {
 
 // UploadRequest is a multipart form
 
+// UploadResponse refers to the stored sources, by git commit or by archive,
+// depending on the source store of the server
 type UploadResponse struct {
-	Git *GitRef `json:"git,omitempty"`
+	Git     *GitRef     `json:"git,omitempty"`
+	Archive *ArchiveRef `json:"archive,omitempty"`
+}
+
+// UploadStartRequest announces a chunked upload of the application sources
+type UploadStartRequest struct {
+	Size int64 `json:"size"`
+}
+
+// UploadStatusResponse reports the progress of a chunked upload. Offset is
+// the amount of data received, and where the next chunk has to start.
+type UploadStatusResponse struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// UploadFinishRequest completes a chunked upload. SHA256 is the hex encoded
+// checksum of the whole tarball. With a manifest the tarball only holds the
+// files the server was missing, the others are taken from the previous
+// revision of the app.
+type UploadFinishRequest struct {
+	SHA256   string       `json:"sha256"`
+	Manifest []SourceFile `json:"manifest,omitempty"`
+}
+
+// SourceFile is a regular file of the application sources, identified by
+// its content
+type SourceFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Mode   uint32 `json:"mode"`
+	Size   int64  `json:"size"`
+}
+
+// SourceManifestRequest lists the files of the sources to push
+type SourceManifestRequest struct {
+	Files []SourceFile `json:"files"`
+}
+
+// SourceManifestResponse lists the files of the manifest which are not in
+// the previous revision of the app, and have to be uploaded
+type SourceManifestResponse struct {
+	Missing []string `json:"missing"`
 }
 
 type StageRequest struct {
-	App       AppRef  `json:"app,omitempty"`
-	Instances *int32  `json:"instances,omitempty"`
-	Git       *GitRef `json:"git,omitempty"`
-	Route     string  `json:"route,omitempty"`
+	App       AppRef      `json:"app,omitempty"`
+	Instances *int32      `json:"instances,omitempty"`
+	Git       *GitRef     `json:"git,omitempty"`
+	Archive   *ArchiveRef `json:"archive,omitempty"`
+	Route     string      `json:"route,omitempty"`
 }
 
 type StageResponse struct {
 	Stage StageRef `json:"stage,omitempty"`
 }
 
+// StageStatusResponse reports the state of a staging run. Position is only
+// set for queued runs, Failure only for failed runs.
+type StageStatusResponse struct {
+	Stage    StageRef        `json:"stage,omitempty"`
+	Status   string          `json:"status"`
+	Position int             `json:"position,omitempty"`
+	Failure  *StagingFailure `json:"failure,omitempty"`
+}
+
 type ApplicationDeleteResponse struct {
 	UnboundServices []string `json:"unboundservices"`
 }
+
+type WebhookEnableRequest struct {
+	Branch string `json:"branch"`
+}
+
+type WebhookResponse struct {
+	Branch string `json:"branch"`
+	Secret string `json:"secret"`
+}
+
+// BuildSecretSetRequest sets a build secret of an application
+type BuildSecretSetRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// BuildSecretsResponse lists the names of the build secrets of an
+// application. The values are never returned.
+type BuildSecretsResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// WebhookTriggerResponse is returned to the git provider. Stage is empty when
+// the event was ignored, Reason says why.
+type WebhookTriggerResponse struct {
+	Stage  StageRef `json:"stage,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+}
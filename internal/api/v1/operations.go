@@ -0,0 +1,43 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/operations"
+	"github.com/julienschmidt/httprouter"
+)
+
+// OperationsController reports the state of the long running operations of
+// the server, like the provisioning of services
+type OperationsController struct {
+}
+
+// Show returns the state of the operation. Operations of other orgs are
+// not known.
+func (oc OperationsController) Show(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	id := params.ByName("id")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	op, err := operations.Lookup(ctx, cluster, id)
+	if err != nil {
+		return InternalError(err)
+	}
+	if op == nil || op.Org != org {
+		return OperationIsNotKnown(id)
+	}
+
+	err = jsonResponse(w, op)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
@@ -11,9 +11,9 @@ import (
 	"github.com/epinio/epinio/helpers/kubernetes"
 	"github.com/epinio/epinio/internal/api/v1/models"
 	"github.com/epinio/epinio/internal/application"
-	"github.com/epinio/epinio/internal/cli/clients/gitea"
 	"github.com/epinio/epinio/internal/organizations"
 	"github.com/epinio/epinio/internal/services"
+	"github.com/epinio/epinio/internal/sources"
 	"github.com/julienschmidt/httprouter"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
@@ -56,7 +56,7 @@ func (oc OrganizationsController) Index(w http.ResponseWriter, r *http.Request)
 
 func (oc OrganizationsController) Create(w http.ResponseWriter, r *http.Request) APIErrors {
 	ctx := r.Context()
-	gitea, err := gitea.New(ctx)
+	store, err := sources.New(ctx)
 	if err != nil {
 		return InternalError(err)
 	}
@@ -93,7 +93,7 @@ func (oc OrganizationsController) Create(w http.ResponseWriter, r *http.Request)
 		return OrgAlreadyKnown(org)
 	}
 
-	err = organizations.Create(r.Context(), cluster, gitea, org)
+	err = organizations.Create(r.Context(), cluster, store, org)
 	if err != nil {
 		return InternalError(err)
 	}
@@ -109,7 +109,7 @@ func (oc OrganizationsController) Delete(w http.ResponseWriter, r *http.Request)
 	params := httprouter.ParamsFromContext(r.Context())
 	org := params.ByName("org")
 
-	gitea, err := gitea.New(ctx)
+	store, err := sources.New(ctx)
 	if err != nil {
 		return InternalError(err)
 	}
@@ -127,7 +127,7 @@ func (oc OrganizationsController) Delete(w http.ResponseWriter, r *http.Request)
 		return OrgIsNotKnown(org)
 	}
 
-	err = deleteApps(ctx, cluster, gitea, org)
+	err = deleteApps(ctx, cluster, store, org)
 	if err != nil {
 		return InternalError(err)
 	}
@@ -145,7 +145,7 @@ func (oc OrganizationsController) Delete(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Deleting the namespace here. That will automatically delete the application resources.
-	err = organizations.Delete(ctx, cluster, gitea, org)
+	err = organizations.Delete(ctx, cluster, store, org)
 	if err != nil {
 		return InternalError(err)
 	}
@@ -161,7 +161,7 @@ func (oc OrganizationsController) Delete(w http.ResponseWriter, r *http.Request)
 }
 
 // deleteApps removes the application and its resources
-func deleteApps(ctx context.Context, cluster *kubernetes.Cluster, gitea *gitea.Client, org string) error {
+func deleteApps(ctx context.Context, cluster *kubernetes.Cluster, store sources.Store, org string) error {
 	appRefs, err := application.ListAppRefs(ctx, cluster, org)
 	if err != nil {
 		return err
@@ -227,7 +227,7 @@ loop:
 			defer func() {
 				<-buffer // 2b
 			}()
-			err := application.Delete(ctx, cluster, gitea, appRef)
+			err := application.Delete(ctx, cluster, store, appRef)
 			if err != nil {
 				errChan <- err // x
 			}
@@ -0,0 +1,232 @@
+package v1
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/tracelog"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/application"
+	"github.com/epinio/epinio/internal/duration"
+	v1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// stagingJob is a staging waiting for its PipelineRun to be created
+type stagingJob struct {
+	app models.AppRef
+	run *v1beta1.PipelineRun
+}
+
+// failedRetention is how long the error of a staging which failed to start
+// is remembered
+const failedRetention = time.Hour
+
+// stagingFailure is the error which prevented a staging from starting
+type stagingFailure struct {
+	message string
+	at      time.Time
+}
+
+// stagingQueue holds the stagings waiting for their turn. An app is staged by
+// one PipelineRun at a time, and at most `limit` PipelineRuns run across the
+// cluster. The queue lives in the memory of the API server, pending stagings
+// are lost on restart. This assumes a single replica of the server: the
+// queues of several replicas do not see each other, so the limit may be
+// exceeded and a staging is only known to the replica it was pushed to.
+type stagingQueue struct {
+	// dispatching serializes the dispatches and drops, which talk to the
+	// cluster without holding mu
+	dispatching sync.Mutex
+
+	mu      sync.Mutex
+	jobs    []stagingJob
+	failed  map[string]stagingFailure
+	limit   int
+	started bool
+}
+
+var queue = &stagingQueue{
+	failed: map[string]stagingFailure{},
+}
+
+// SetStagingConcurrency sets the number of stagings allowed to run at the
+// same time, across all apps. Zero means no limit.
+func SetStagingConcurrency(limit int) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.limit = limit
+}
+
+// enqueue adds the staging to the queue and immediately starts whatever the
+// limits allow. A failure to dispatch leaves the staging queued, the
+// dispatcher retries.
+func (q *stagingQueue) enqueue(ctx context.Context, cluster *kubernetes.Cluster, job stagingJob) error {
+	q.mu.Lock()
+	q.jobs = append(q.jobs, job)
+	if !q.started {
+		q.started = true
+		go q.run()
+	}
+	q.mu.Unlock()
+
+	return q.dispatch(ctx, cluster)
+}
+
+// position returns the 1-based position of the app's staging in the queue,
+// and false if it is not queued
+func (q *stagingQueue) position(app models.AppRef, uid string) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, job := range q.jobs {
+		if job.run.Name == uid && job.app == app {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// failure returns the error which prevented the staging from starting
+func (q *stagingQueue) failure(uid string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	f, ok := q.failed[uid]
+	return f.message, ok
+}
+
+// drop removes the queued stagings of the app, with their copies of the
+// build secrets
+func (q *stagingQueue) drop(ctx context.Context, cluster *kubernetes.Cluster, app models.AppRef) error {
+	q.dispatching.Lock()
+	defer q.dispatching.Unlock()
+
+	q.mu.Lock()
+	jobs := []stagingJob{}
+	dropped := []stagingJob{}
+	for _, job := range q.jobs {
+		if job.app != app {
+			jobs = append(jobs, job)
+			continue
+		}
+		dropped = append(dropped, job)
+	}
+	q.jobs = jobs
+	q.mu.Unlock()
+
+	for _, job := range dropped {
+		err := application.DeleteStagingSecret(ctx, cluster, job.run.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// run dispatches the queue whenever running stagings may have finished
+func (q *stagingQueue) run() {
+	ticker := time.NewTicker(duration.PollInterval())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		q.mu.Lock()
+		pending := len(q.jobs) > 0
+		q.mu.Unlock()
+
+		if pending {
+			ctx := context.Background()
+			cluster, err := kubernetes.GetCluster(ctx)
+			if err == nil {
+				_ = q.dispatch(ctx, cluster)
+			}
+		}
+	}
+}
+
+// dispatch creates the PipelineRuns of the queued stagings, in order, as long
+// as the cluster-wide limit allows and their app has no staging running. A
+// staging which fails to start is taken out of the queue and remembered as
+// failed. The queue is only locked to take a snapshot of it, and to remove
+// the stagings handled, stagings enqueued in between stay queued.
+func (q *stagingQueue) dispatch(ctx context.Context, cluster *kubernetes.Cluster) error {
+	q.dispatching.Lock()
+	defer q.dispatching.Unlock()
+
+	q.mu.Lock()
+	jobs := append([]stagingJob{}, q.jobs...)
+	limit := q.limit
+	q.mu.Unlock()
+
+	cs, err := versioned.NewForConfig(cluster.RestConfig)
+	if err != nil {
+		return err
+	}
+	client := cs.TektonV1beta1().PipelineRuns(deployments.TektonStagingNamespace)
+
+	l, err := client.List(ctx, metav1.ListOptions{LabelSelector: "app.kubernetes.io/managed-by=epinio"})
+	if err != nil {
+		return err
+	}
+
+	running := 0
+	busy := map[models.AppRef]bool{}
+	// assume that completed pipelineruns have a CompletionTime
+	for _, pr := range l.Items {
+		if pr.Status.CompletionTime == nil {
+			running++
+			busy[models.NewAppRef(pr.Labels["app.kubernetes.io/name"], pr.Labels["app.kubernetes.io/part-of"])] = true
+		}
+	}
+
+	handled := map[string]bool{}
+	failed := map[string]stagingFailure{}
+	for _, job := range jobs {
+		if busy[job.app] || (limit > 0 && running >= limit) {
+			continue
+		}
+
+		handled[job.run.Name] = true
+		run, err := client.Create(ctx, job.run, metav1.CreateOptions{})
+		if err != nil {
+			failed[job.run.Name] = stagingFailure{message: err.Error(), at: time.Now()}
+			_ = application.DeleteStagingSecret(ctx, cluster, job.run.Name)
+			continue
+		}
+		// The secret is owned by no one until adopted. Should the
+		// adoption fail, the garbage collector removes the secret once
+		// it is stale.
+		err = application.AdoptStagingSecret(ctx, cluster, run)
+		if err != nil {
+			tracelog.Logger(ctx).Error(err, "build secrets not adopted by the staging run", "run", run.Name)
+		}
+		running++
+		busy[job.app] = true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiting := []stagingJob{}
+	for _, job := range q.jobs {
+		if !handled[job.run.Name] {
+			waiting = append(waiting, job)
+		}
+	}
+	q.jobs = waiting
+
+	// Failures are kept for their retention
+	for uid, f := range q.failed {
+		if _, ok := failed[uid]; !ok && time.Since(f.at) <= failedRetention {
+			failed[uid] = f
+		}
+	}
+	q.failed = failed
+
+	return nil
+}
@@ -60,6 +60,10 @@ func delete(path string, h http.HandlerFunc) routes.Route {
 	return routes.NewRoute("DELETE", v+path, h)
 }
 
+func put(path string, h http.HandlerFunc) routes.Route {
+	return routes.NewRoute("PUT", v+path, h)
+}
+
 func patch(path string, h http.HandlerFunc) routes.Route {
 	return routes.NewRoute("PATCH", v+path, h)
 }
@@ -76,27 +80,93 @@ var Routes = routes.NamedRoutes{
 	"AppStage":    post("/orgs/:org/applications/:app/stage", errorHandler(ApplicationsController{}.Stage)),
 	"AppUpdate":   patch("/orgs/:org/applications/:app", errorHandler(ApplicationsController{}.Update)),
 
+	// Chunked, resumable upload of application sources. The manifest route
+	// tells which files the server lacks, for incremental uploads.
+	"AppUploadStart": post("/orgs/:org/applications/:app/uploads",
+		errorHandler(ApplicationsController{}.UploadStart)),
+	"AppUploadStatus": get("/orgs/:org/applications/:app/uploads/:upload_id",
+		errorHandler(ApplicationsController{}.UploadStatus)),
+	"AppUploadChunk": put("/orgs/:org/applications/:app/uploads/:upload_id",
+		errorHandler(ApplicationsController{}.UploadChunk)),
+	"AppSourceManifest": post("/orgs/:org/applications/:app/manifest",
+		errorHandler(ApplicationsController{}.SourceManifest)),
+	"AppUploadFinish": post("/orgs/:org/applications/:app/uploads/:upload_id/finish",
+		errorHandler(ApplicationsController{}.UploadFinish)),
+
+	// Download of the stored sources, of the latest or a given revision
+	"AppSource": get("/orgs/:org/applications/:app/source",
+		errorHandler(ApplicationsController{}.Source)),
+
+	// State of a staging run, with the failure details of failed runs
+	"AppStageStatus": get("/orgs/:org/applications/:app/stage/:stage_id",
+		errorHandler(ApplicationsController{}.StageStatus)),
+
+	// Push-to-deploy. The webhook is called by the git provider, the
+	// webhookconfig routes manage its secret and branch.
+	"AppWebhook":        post("/webhooks/:org/:app", errorHandler(WebhooksController{}.Receive)),
+	"AppWebhookShow":    get("/orgs/:org/applications/:app/webhookconfig", errorHandler(WebhooksController{}.Show)),
+	"AppWebhookEnable":  post("/orgs/:org/applications/:app/webhookconfig", errorHandler(WebhooksController{}.Enable)),
+	"AppWebhookDisable": delete("/orgs/:org/applications/:app/webhookconfig", errorHandler(WebhooksController{}.Disable)),
+
+	// Secrets available to the stagings of an application only
+	"AppBuildSecrets":     get("/orgs/:org/applications/:app/buildsecrets", errorHandler(BuildSecretsController{}.Index)),
+	"AppBuildSecretSet":   post("/orgs/:org/applications/:app/buildsecrets", errorHandler(BuildSecretsController{}.Set)),
+	"AppBuildSecretUnset": delete("/orgs/:org/applications/:app/buildsecrets/:key", errorHandler(BuildSecretsController{}.Unset)),
+
 	// Bind and unbind services to/from applications, by means of servicebindings in applications
 	"ServiceBindingCreate": post("/orgs/:org/applications/:app/servicebindings",
 		errorHandler(ServicebindingsController{}.Create)),
 	"ServiceBindingDelete": delete("/orgs/:org/applications/:app/servicebindings/:service",
 		errorHandler(ServicebindingsController{}.Delete)),
+	"ServiceBindingRotate": post("/orgs/:org/applications/:app/servicebindings/:service/rotate",
+		errorHandler(ServicebindingsController{}.Rotate)),
 
 	// List, create, show and delete organizations
 	"Orgs":      get("/orgs", errorHandler(OrganizationsController{}.Index)),
 	"OrgCreate": post("/orgs", errorHandler(OrganizationsController{}.Create)),
 	"OrgDelete": delete("/orgs/:org", errorHandler(OrganizationsController{}.Delete)),
 
-	// List, show, create and delete services, catalog and custom
+	// List, show, create, update and delete services, catalog and custom
 	"Services":            get("/orgs/:org/services", errorHandler(ServicesController{}.Index)),
 	"ServiceShow":         get("/orgs/:org/services/:service", errorHandler(ServicesController{}.Show)),
 	"ServiceCreate":       post("/orgs/:org/services", errorHandler(ServicesController{}.Create)),
 	"ServiceCreateCustom": post("/orgs/:org/custom-services", errorHandler(ServicesController{}.CreateCustom)),
+	"ServiceUpdateCustom": patch("/orgs/:org/custom-services/:service", errorHandler(ServicesController{}.UpdateCustom)),
+	"ServiceUpdate":       patch("/orgs/:org/services/:service", errorHandler(ServicesController{}.Update)),
 	"ServiceDelete":       delete("/orgs/:org/services/:service", errorHandler(ServicesController{}.Delete)),
 
+	// State of the operations completing service changes in the background
+	"Operation": get("/orgs/:org/operations/:id", errorHandler(OperationsController{}.Show)),
+
+	// List, show, create and delete the keys of services, for consumers outside of Epinio
+	"ServiceKeys":      get("/orgs/:org/services/:service/keys", errorHandler(ServiceKeysController{}.Index)),
+	"ServiceKeyShow":   get("/orgs/:org/services/:service/keys/:key", errorHandler(ServiceKeysController{}.Show)),
+	"ServiceKeyCreate": post("/orgs/:org/services/:service/keys", errorHandler(ServiceKeysController{}.Create)),
+	"ServiceKeyDelete": delete("/orgs/:org/services/:service/keys/:key", errorHandler(ServiceKeysController{}.Delete)),
+
+	// Share services with other orgs, and remove such shares
+	"ServiceShare":   post("/orgs/:org/services/:service/shares", errorHandler(ServiceSharesController{}.Create)),
+	"ServiceUnshare": delete("/orgs/:org/services/:service/shares/:target", errorHandler(ServiceSharesController{}.Delete)),
+
+	// Back up services, restore the backups, and schedule backups
+	"ServiceBackups":        get("/orgs/:org/services/:service/backups", errorHandler(ServiceBackupsController{}.Index)),
+	"ServiceBackupCreate":   post("/orgs/:org/services/:service/backups", errorHandler(ServiceBackupsController{}.Create)),
+	"ServiceBackupRestore":  post("/orgs/:org/services/:service/backups/:backup/restore", errorHandler(ServiceBackupsController{}.Restore)),
+	"ServiceBackupSchedule": put("/orgs/:org/services/:service/backupschedule", errorHandler(ServiceBackupsController{}.Schedule)),
+
 	// list service classes and plans (of catalog services)
 	"ServiceClasses": get("/serviceclasses", errorHandler(ServiceClassesController{}.Index)),
 	"ServicePlans":   get("/serviceclasses/:serviceclass/serviceplans", errorHandler(ServicePlansController{}.Index)),
+
+	// list, register and remove service brokers
+	"ServiceBrokers":      get("/servicebrokers", errorHandler(ServiceBrokersController{}.Index)),
+	"ServiceBrokerCreate": post("/servicebrokers", errorHandler(ServiceBrokersController{}.Create)),
+	"ServiceBrokerDelete": delete("/servicebrokers/:broker", errorHandler(ServiceBrokersController{}.Delete)),
+
+	// list, register and remove helm charts offered as service classes
+	"ServiceCharts":      get("/servicecharts", errorHandler(ServiceChartsController{}.Index)),
+	"ServiceChartCreate": post("/servicecharts", errorHandler(ServiceChartsController{}.Create)),
+	"ServiceChartDelete": delete("/servicecharts/:chart", errorHandler(ServiceChartsController{}.Delete)),
 }
 
 func Router() *httprouter.Router {
@@ -0,0 +1,210 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/backups"
+	"github.com/julienschmidt/httprouter"
+)
+
+// ServiceBackupsController takes backups of services, restores them, and
+// schedules automatic backups. Backups and restores run in the background.
+type ServiceBackupsController struct {
+}
+
+func (sbc ServiceBackupsController) Index(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	service, apierr := lookupOrgService(ctx, cluster, org, serviceName)
+	if apierr != nil {
+		return apierr
+	}
+
+	serviceBackups, err := backups.List(ctx, cluster, service)
+	if err != nil {
+		return InternalError(err)
+	}
+	schedule, err := backups.LookupSchedule(ctx, cluster, service)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	response := models.ServiceBackupsResponse{Backups: []models.ServiceBackup{}}
+	for _, b := range serviceBackups {
+		response.Backups = append(response.Backups, models.ServiceBackup{
+			ID:        b.ID,
+			State:     b.State,
+			Error:     b.Error,
+			Created:   b.Created,
+			Scheduled: b.Scheduled,
+		})
+	}
+	if schedule != nil {
+		response.Schedule = &models.ServiceBackupSchedule{
+			Every: schedule.Every.String(),
+			Next:  schedule.Next,
+			Keep:  schedule.Keep,
+		}
+	}
+
+	err = jsonResponse(w, response)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (sbc ServiceBackupsController) Create(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	service, apierr := lookupOrgService(ctx, cluster, org, serviceName)
+	if apierr != nil {
+		return apierr
+	}
+
+	b, op, err := backups.Start(ctx, cluster, service)
+	if err == backups.ErrNoHook || err == backups.ErrShared {
+		return BadRequest(err)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	js, err := json.Marshal(models.ServiceBackupResponse{ID: b.ID, Operation: op.ID})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(js)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (sbc ServiceBackupsController) Restore(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+	backupID := params.ByName("backup")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	service, apierr := lookupOrgService(ctx, cluster, org, serviceName)
+	if apierr != nil {
+		return apierr
+	}
+
+	b, err := backups.Lookup(ctx, cluster, service, backupID)
+	if err != nil {
+		return InternalError(err)
+	}
+	if b == nil {
+		return ServiceBackupIsNotKnown(backupID, serviceName)
+	}
+
+	op, err := backups.Restore(ctx, cluster, service, b)
+	if err == backups.ErrNoHook || err == backups.ErrShared || err == backups.ErrNotRestorable {
+		return BadRequest(err)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = jsonResponse(w, models.ServiceOperationResponse{Operation: op.ID})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (sbc ServiceBackupsController) Schedule(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var scheduleRequest models.ServiceBackupScheduleRequest
+	err = json.Unmarshal(bodyBytes, &scheduleRequest)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	every, err := time.ParseDuration(scheduleRequest.Every)
+	if err != nil {
+		return BadRequest(err)
+	}
+	if every < 0 {
+		return NewBadRequest("Backup interval must not be negative")
+	}
+	if every > 0 && every < backups.MinInterval {
+		return NewBadRequest(fmt.Sprintf("Backup interval must be at least %s", backups.MinInterval))
+	}
+	if scheduleRequest.Keep < 0 {
+		return NewBadRequest("Number of kept backups must not be negative")
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	service, apierr := lookupOrgService(ctx, cluster, org, serviceName)
+	if apierr != nil {
+		return apierr
+	}
+
+	err = backups.SetSchedule(ctx, cluster, service, every, scheduleRequest.Keep)
+	if err == backups.ErrNoHook || err == backups.ErrShared {
+		return BadRequest(err)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
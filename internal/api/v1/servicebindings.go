@@ -53,6 +53,13 @@ func (hc ServicebindingsController) Create(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	if bindRequest.As == "" {
+		bindRequest.As = application.BindAsFiles
+	}
+	if !application.ValidBindMode(bindRequest.As) {
+		return NewBadRequest("Unknown binding mode", bindRequest.As)
+	}
+
 	cluster, err := kubernetes.GetCluster(ctx)
 	if err != nil {
 		return InternalError(err)
@@ -76,10 +83,8 @@ func (hc ServicebindingsController) Create(w http.ResponseWriter, r *http.Reques
 
 	wl := application.NewWorkload(cluster, app.AppRef())
 
-	// From here on out we collect errors and warnings per
-	// service, to report as much as possible while also applying
-	// as much as possible. IOW even when errors are reported it
-	// is possible for some of the services to be properly bound.
+	// Binding is all or nothing. All services are looked up first, and
+	// reported together when some are not known. Nothing is bound then.
 
 	var theServices interfaces.ServiceList
 	var theIssues []APIError
@@ -99,24 +104,27 @@ func (hc ServicebindingsController) Create(w http.ResponseWriter, r *http.Reques
 		theServices = append(theServices, service)
 	}
 
-	resp := models.BindResponse{}
+	if len(theIssues) > 0 {
+		return MultiError{theIssues}
+	}
 
-	for _, service := range theServices {
-		err = wl.Bind(ctx, service)
-		if err != nil {
-			if err.Error() == "service already bound" {
-				resp.WasBound = append(resp.WasBound, service.Name())
-				continue
-			}
+	// A single update of the application binds all services, restarting
+	// it once
 
-			theIssues = append([]APIError{InternalError(err)}, theIssues...)
-			return MultiError{theIssues}
+	wasBound, err := wl.Bind(ctx, theServices, bindRequest.As)
+	if failure, ok := err.(*application.BindingFailure); ok {
+		for _, serviceName := range bindRequest.Names {
+			if reason, failed := failure.Failed[serviceName]; failed {
+				theIssues = append(theIssues, ServiceBindingFailed(serviceName, reason))
+			}
 		}
-	}
-
-	if len(theIssues) > 0 {
 		return MultiError{theIssues}
 	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	resp := models.BindResponse{WasBound: wasBound}
 
 	err = jsonResponse(w, resp)
 	if err != nil {
@@ -180,3 +188,63 @@ func (hc ServicebindingsController) Delete(w http.ResponseWriter, r *http.Reques
 
 	return nil
 }
+
+// Rotate replaces the credentials of the service's binding to the
+// application, restarting the application
+func (hc ServicebindingsController) Rotate(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	appName := params.ByName("app")
+	serviceName := params.ByName("service")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	exists, err := organizations.Exists(ctx, cluster, org)
+	if err != nil {
+		return InternalError(err)
+	}
+	if !exists {
+		return OrgIsNotKnown(org)
+	}
+
+	app, err := application.Lookup(ctx, cluster, org, appName)
+	if err != nil {
+		return InternalError(err)
+	}
+	if app == nil {
+		return AppIsNotKnown(appName)
+	}
+
+	wl := application.NewWorkload(cluster, app.AppRef())
+
+	service, err := services.Lookup(ctx, cluster, org, serviceName)
+	if err != nil && err.Error() == "service not found" {
+		return ServiceIsNotKnown(serviceName)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = wl.RotateCredentials(ctx, service)
+	if err != nil && err.Error() == "service is not bound to the application" {
+		return ServiceIsNotBound(serviceName)
+	}
+	if err == application.ErrNoCredentials {
+		return BadRequest(err)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
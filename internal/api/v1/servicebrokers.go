@@ -0,0 +1,134 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/services"
+	"github.com/julienschmidt/httprouter"
+)
+
+// ServiceBrokersController manages the service brokers registered with
+// Epinio. The services of these brokers are offered as service classes.
+type ServiceBrokersController struct {
+}
+
+func (sbc ServiceBrokersController) Index(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	brokers, err := services.ListBrokers(ctx, cluster)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	response := models.BrokersResponse{}
+	for _, broker := range brokers {
+		response = append(response, models.BrokerResponse{
+			Name: broker.Name,
+			URL:  broker.URL,
+		})
+	}
+
+	err = jsonResponse(w, response)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (sbc ServiceBrokersController) Create(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var createRequest models.BrokerCreateRequest
+	err = json.Unmarshal(bodyBytes, &createRequest)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if createRequest.Name == "" {
+		return NewBadRequest("Cannot register service broker without a name")
+	}
+	if createRequest.URL == "" {
+		return NewBadRequest("Cannot register service broker without a url")
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = services.AddBroker(ctx, cluster, services.Broker{
+		Name:     createRequest.Name,
+		URL:      createRequest.URL,
+		Username: createRequest.Username,
+		Password: createRequest.Password,
+	})
+	if err == services.ErrBrokerExists {
+		return BrokerAlreadyKnown(createRequest.Name)
+	}
+	if errors.Is(err, services.ErrBrokerCatalog) {
+		return BadRequest(err)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (sbc ServiceBrokersController) Delete(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	brokerName := params.ByName("broker")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	broker, err := services.LookupBroker(ctx, cluster, brokerName)
+	if err != nil {
+		return InternalError(err)
+	}
+	if broker == nil {
+		return BrokerIsNotKnown(brokerName)
+	}
+
+	err = services.DeleteBroker(ctx, cluster, brokerName)
+	if errors.Is(err, services.ErrBrokerInUse) {
+		return BadRequest(err)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
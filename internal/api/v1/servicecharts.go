@@ -0,0 +1,115 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/services"
+	"github.com/julienschmidt/httprouter"
+)
+
+// ServiceChartsController manages the Helm charts registered with Epinio.
+// The charts are offered as service classes.
+type ServiceChartsController struct {
+}
+
+func (scc ServiceChartsController) Index(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	charts, err := services.ListCharts(ctx, cluster)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = jsonResponse(w, charts)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (scc ServiceChartsController) Create(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var chart services.Chart
+	err = json.Unmarshal(bodyBytes, &chart)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	err = chart.Validate()
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = services.AddChart(ctx, cluster, chart)
+	if err == services.ErrChartExists {
+		return ChartAlreadyKnown(chart.Name)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (scc ServiceChartsController) Delete(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	chartName := params.ByName("chart")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	chart, err := services.LookupChart(ctx, cluster, chartName)
+	if err != nil {
+		return InternalError(err)
+	}
+	if chart == nil {
+		return ChartIsNotKnown(chartName)
+	}
+
+	err = services.DeleteChart(ctx, cluster, chartName)
+	if errors.Is(err, services.ErrChartInUse) {
+		return BadRequest(err)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
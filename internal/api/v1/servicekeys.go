@@ -0,0 +1,201 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/interfaces"
+	"github.com/epinio/epinio/internal/organizations"
+	"github.com/epinio/epinio/internal/services"
+	"github.com/julienschmidt/httprouter"
+)
+
+// ServiceKeysController manages the keys of services. A key is a named
+// binding not attached to an application, for consumers outside of Epinio.
+type ServiceKeysController struct {
+}
+
+func (skc ServiceKeysController) Index(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	service, apierr := lookupOrgService(ctx, cluster, org, serviceName)
+	if apierr != nil {
+		return apierr
+	}
+
+	keys, err := services.ListKeys(ctx, cluster, service)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = jsonResponse(w, models.ServiceKeysResponse(keys))
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (skc ServiceKeysController) Show(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+	keyName := params.ByName("key")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	service, apierr := lookupOrgService(ctx, cluster, org, serviceName)
+	if apierr != nil {
+		return apierr
+	}
+
+	key, err := services.LookupKey(ctx, cluster, service, keyName)
+	if err != nil {
+		return InternalError(err)
+	}
+	if key == nil {
+		return ServiceKeyIsNotKnown(keyName, serviceName)
+	}
+
+	err = jsonResponse(w, models.ServiceKeyResponse{
+		Name:    key.Name,
+		Service: key.Service,
+		Data:    key.Data,
+	})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (skc ServiceKeysController) Create(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var createRequest models.ServiceKeyCreateRequest
+	err = json.Unmarshal(bodyBytes, &createRequest)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if createRequest.Name == "" {
+		return NewBadRequest("Cannot create service key without a name")
+	}
+	err = services.ValidateKeyName(createRequest.Name)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	service, apierr := lookupOrgService(ctx, cluster, org, serviceName)
+	if apierr != nil {
+		return apierr
+	}
+
+	_, err = services.CreateKey(ctx, cluster, service, createRequest.Name)
+	if err == services.ErrKeyExists {
+		return ServiceKeyAlreadyKnown(createRequest.Name, serviceName)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (skc ServiceKeysController) Delete(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+	keyName := params.ByName("key")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	service, apierr := lookupOrgService(ctx, cluster, org, serviceName)
+	if apierr != nil {
+		return apierr
+	}
+
+	key, err := services.LookupKey(ctx, cluster, service, keyName)
+	if err != nil {
+		return InternalError(err)
+	}
+	if key == nil {
+		return ServiceKeyIsNotKnown(keyName, serviceName)
+	}
+
+	err = services.DeleteKey(ctx, cluster, service, keyName)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// lookupOrgService returns the named service of the org, or the error
+// reporting the missing org or service
+func lookupOrgService(ctx context.Context, cluster *kubernetes.Cluster, org, serviceName string) (interfaces.Service, APIErrors) {
+	exists, err := organizations.Exists(ctx, cluster, org)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+	if !exists {
+		return nil, OrgIsNotKnown(org)
+	}
+
+	service, err := services.Lookup(ctx, cluster, org, serviceName)
+	if err != nil && err.Error() == "service not found" {
+		return nil, ServiceIsNotKnown(serviceName)
+	}
+	if err != nil {
+		return nil, InternalError(err)
+	}
+
+	return service, nil
+}
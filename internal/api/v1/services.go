@@ -3,18 +3,29 @@ package v1
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/epinio/epinio/helpers/kubernetes"
 	"github.com/epinio/epinio/internal/api/v1/models"
 	"github.com/epinio/epinio/internal/application"
+	"github.com/epinio/epinio/internal/backups"
+	"github.com/epinio/epinio/internal/interfaces"
+	"github.com/epinio/epinio/internal/operations"
 	"github.com/epinio/epinio/internal/organizations"
 	"github.com/epinio/epinio/internal/services"
 	"github.com/julienschmidt/httprouter"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// serviceStatusConcurrency is the number of services whose status is
+// determined at the same time, when listing them
+const serviceStatusConcurrency = 8
+
 type ServicesController struct {
 }
 
@@ -59,6 +70,13 @@ func (sc ServicesController) Show(w http.ResponseWriter, r *http.Request) APIErr
 	responseData := map[string]string{
 		"Status": status,
 	}
+	sharedWith, err := services.Shares(ctx, cluster, service)
+	if err != nil {
+		return InternalError(err)
+	}
+	if len(sharedWith) > 0 {
+		responseData["Shared With"] = strings.Join(sharedWith, ", ")
+	}
 	for key, value := range serviceDetails {
 		responseData[key] = value
 	}
@@ -104,19 +122,54 @@ func (sc ServicesController) Index(w http.ResponseWriter, r *http.Request) APIEr
 		return InternalError(err)
 	}
 
-	var responseData models.ServiceResponseList
-
-	for _, service := range orgServices {
-		var appNames []string
+	latest, err := operations.Latest(ctx, cluster, org)
+	if err != nil {
+		return InternalError(err)
+	}
 
+	// The status of catalog and broker services is asked of their
+	// brokers. The services are looked at in parallel, a few at a time.
+	responseData := make(models.ServiceResponseList, len(orgServices))
+	limit := make(chan struct{}, serviceStatusConcurrency)
+	var wg sync.WaitGroup
+	for i, service := range orgServices {
+		appNames := []string{}
 		for _, app := range appsOf[service.Name()] {
 			appNames = append(appNames, app.Name)
 		}
-		responseData = append(responseData, models.ServiceResponse{
-			Name:      service.Name(),
-			BoundApps: appNames,
-		})
+
+		lastError := ""
+		if op, ok := latest[service.Name()]; ok && op.State == operations.StateFailed {
+			lastError = op.Error
+		}
+
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(i int, service interfaces.Service) {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			// A service whose state can not be determined is listed
+			// nonetheless, so that it does not hide the others
+			status, err := service.Status(ctx)
+			if err != nil {
+				status = fmt.Sprintf("Unknown: %s", err.Error())
+			}
+
+			overview := services.OverviewOf(ctx, service)
+			responseData[i] = models.ServiceResponse{
+				Name:      service.Name(),
+				BoundApps: appNames,
+				Type:      overview.Type,
+				Class:     overview.Class,
+				Plan:      overview.Plan,
+				Status:    status,
+				Created:   overview.Created,
+				LastError: lastError,
+			}
+		}(i, service)
 	}
+	wg.Wait()
 
 	js, err := json.Marshal(responseData)
 	if err != nil {
@@ -196,6 +249,243 @@ func (sc ServicesController) CreateCustom(w http.ResponseWriter, r *http.Request
 	return nil
 }
 
+// UpdateCustom changes the data of a custom service, copies it into the orgs
+// the service is shared with, and restarts the applications bound to it.
+// Failed restarts do not fail the update, they are listed in the response.
+func (sc ServicesController) UpdateCustom(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var updateRequest models.CustomUpdateRequest
+	err = json.Unmarshal(bodyBytes, &updateRequest)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if len(updateRequest.Set) < 1 && len(updateRequest.Unset) < 1 {
+		return NewBadRequest("Cannot update custom service without changes")
+	}
+	for key := range updateRequest.Set {
+		if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+			return NewBadRequest(fmt.Sprintf("Bad custom service key '%s': %s", key, strings.Join(errs, ", ")))
+		}
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	exists, err := organizations.Exists(ctx, cluster, org)
+	if err != nil {
+		return InternalError(err)
+	}
+	if !exists {
+		return OrgIsNotKnown(org)
+	}
+
+	service, err := services.Lookup(ctx, cluster, org, serviceName)
+	if err != nil && err.Error() == "service not found" {
+		return ServiceIsNotKnown(serviceName)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+	customService, ok := service.(*services.CustomService)
+	if !ok {
+		return NewBadRequest("Only custom services can be updated", serviceName)
+	}
+
+	err = customService.Update(ctx, updateRequest.Set, updateRequest.Unset)
+	if err == services.ErrNoData {
+		return NewBadRequest("Cannot remove all data of a custom service")
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = services.RefreshShares(ctx, cluster, service)
+	if err != nil {
+		return InternalError(err, "failed to copy the data into the orgs the service is shared with")
+	}
+	shares, err := services.Shares(ctx, cluster, service)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	// The update is applied, failed restarts are reported along with it.
+	// Applications of the orgs the service is shared with are named with
+	// their org.
+	response := models.CustomUpdateResponse{RestartedApps: []string{}}
+	if !updateRequest.NoRestart {
+		for _, appOrg := range append([]string{org}, shares...) {
+			appsOf, err := servicesToApps(ctx, cluster, appOrg)
+			if err != nil {
+				response.RestartErrors = append(response.RestartErrors,
+					fmt.Sprintf("failed to find the bound applications of org '%s': %s", appOrg, err))
+			}
+			for _, app := range appsOf[service.Name()] {
+				name := app.Name
+				if appOrg != org {
+					name = fmt.Sprintf("%s/%s", appOrg, app.Name)
+				}
+				err = application.NewWorkload(cluster, app.AppRef()).Restart(ctx)
+				if err != nil {
+					response.RestartErrors = append(response.RestartErrors,
+						fmt.Sprintf("failed to restart application '%s': %s", name, err))
+					continue
+				}
+				response.RestartedApps = append(response.RestartedApps, name)
+			}
+		}
+	}
+
+	err = jsonResponse(w, response)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// Update changes the plan and the parameters of a catalog service, waiting
+// for the service catalog to complete, if requested
+func (sc ServicesController) Update(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var updateRequest models.ServiceUpdateRequest
+	err = json.Unmarshal(bodyBytes, &updateRequest)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if updateRequest.Plan == "" && updateRequest.Data == "" {
+		return NewBadRequest("Cannot update service without changes")
+	}
+
+	var parameters map[string]interface{}
+	if updateRequest.Data != "" {
+		err = json.Unmarshal([]byte(updateRequest.Data), &parameters)
+		if err != nil {
+			return BadRequest(err, updateRequest.Data)
+		}
+		if parameters == nil {
+			parameters = map[string]interface{}{}
+		}
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	exists, err := organizations.Exists(ctx, cluster, org)
+	if err != nil {
+		return InternalError(err)
+	}
+	if !exists {
+		return OrgIsNotKnown(org)
+	}
+
+	service, err := services.Lookup(ctx, cluster, org, serviceName)
+	if err != nil && err.Error() == "service not found" {
+		return ServiceIsNotKnown(serviceName)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+	catalogService, ok := service.(*services.CatalogService)
+	if !ok {
+		return NewBadRequest("Only catalog services can change plan and parameters", serviceName)
+	}
+
+	// The plan is the new one, if changed. The parameters replace the
+	// current ones, they have to match the schema of the plan as a whole.
+	planName := updateRequest.Plan
+	if planName == "" {
+		planName = catalogService.Plan
+	}
+	serviceClass, err := services.ClassLookup(ctx, cluster, catalogService.Class)
+	if err != nil {
+		return InternalError(err)
+	}
+	if serviceClass == nil {
+		return ServiceClassIsNotKnown(catalogService.Class)
+	}
+	servicePlan, err := serviceClass.LookupPlan(ctx, planName)
+	if err != nil {
+		return InternalError(err)
+	}
+	if servicePlan == nil {
+		return ServicePlanIsNotKnown(planName, catalogService.Class)
+	}
+
+	if parameters != nil {
+		violations, err := services.ValidateParameters(servicePlan.UpdateParameterSchema(), parameters)
+		if err != nil {
+			return InternalError(err)
+		}
+		if len(violations) > 0 {
+			return parametersInvalid(violations)
+		}
+	}
+
+	generation, err := catalogService.Update(ctx, updateRequest.Plan, parameters)
+	if err == services.ErrPlanNotUpdatable {
+		return NewBadRequest("Service class does not allow plan changes", catalogService.Class)
+	}
+	if apierrors.IsInvalid(err) || apierrors.IsForbidden(err) {
+		return BadRequest(err)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	// The broker completes the update in the background
+	op, err := operations.Start(ctx, cluster, "service-update", org, serviceName,
+		func(ctx context.Context, progress operations.Progress) error {
+			progress("Updating")
+			err := catalogService.WaitForUpdate(ctx, generation)
+			if err != nil {
+				return err
+			}
+			progress("Refreshing shares")
+			err = services.RefreshShares(ctx, cluster, catalogService)
+			if err != nil {
+				return err
+			}
+			progress("Updated")
+			return nil
+		})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = jsonResponse(w, models.ServiceOperationResponse{Operation: op.ID})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
 func (sc ServicesController) Create(w http.ResponseWriter, r *http.Request) APIErrors {
 	ctx := r.Context()
 	params := httprouter.ParamsFromContext(ctx)
@@ -279,23 +569,55 @@ func (sc ServicesController) Create(w http.ResponseWriter, r *http.Request) APIE
 		return BadRequest(err, data)
 	}
 
+	// Verify that the parameters match the schema of the plan
+	violations, err := services.ValidateParameters(servicePlan.Schema, dataObj)
+	if err != nil {
+		return InternalError(err)
+	}
+	if len(violations) > 0 {
+		return parametersInvalid(violations)
+	}
+
 	// Create the new service. At last.
-	service, err := services.CreateCatalogService(ctx, cluster, createRequest.Name, org,
-		createRequest.Class, createRequest.Plan, data)
+	var service interfaces.Service
+	switch {
+	case serviceClass.IsChart():
+		service, err = services.CreateChartService(ctx, cluster, createRequest.Name, org,
+			serviceClass, servicePlan, dataObj)
+	case serviceClass.IsBroker():
+		service, err = services.CreateBrokerService(ctx, cluster, createRequest.Name, org,
+			serviceClass, servicePlan, dataObj)
+	default:
+		service, err = services.CreateCatalogService(ctx, cluster, createRequest.Name, org,
+			createRequest.Class, createRequest.Plan, dataObj)
+	}
 	if err != nil {
 		return InternalError(err)
 	}
 
-	// Wait for service to be fully provisioned, if requested
-	if createRequest.WaitForProvision {
-		err := service.WaitForProvision(ctx)
-		if err != nil {
-			return InternalError(err)
-		}
+	// Provisioning completes in the background
+	op, err := operations.Start(ctx, cluster, "service-create", org, createRequest.Name,
+		func(ctx context.Context, progress operations.Progress) error {
+			progress("Provisioning")
+			err := service.WaitForProvision(ctx)
+			if err != nil {
+				return err
+			}
+			progress("Provisioned")
+			return nil
+		})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	js, err := json.Marshal(models.ServiceOperationResponse{Operation: op.ID})
+	if err != nil {
+		return InternalError(err)
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	_, err = w.Write([]byte{})
+	_, err = w.Write(js)
 	if err != nil {
 		return InternalError(err)
 	}
@@ -342,6 +664,21 @@ func (sc ServicesController) Delete(w http.ResponseWriter, r *http.Request) APIE
 		return InternalError(err)
 	}
 
+	// All checks are done before anything is changed, so that a
+	// rejected deletion leaves the service as it was.
+
+	// Verify that the service is not shared with other orgs. If it is,
+	// and deletion is forced, remove the shares, unbinding the
+	// applications of these orgs.
+
+	sharedWith, err := services.Shares(ctx, cluster, service)
+	if err != nil {
+		return InternalError(err)
+	}
+	if len(sharedWith) > 0 && !deleteRequest.Force {
+		return NewBadRequest("service is shared with other organizations", strings.Join(sharedWith, ","))
+	}
+
 	// Verify that the service is unbound. IOW not bound to any application.
 	// If it is, and automatic unbind was requested, do that.
 	// Without automatic unbind such applications are reported as error.
@@ -351,32 +688,78 @@ func (sc ServicesController) Delete(w http.ResponseWriter, r *http.Request) APIE
 	if err != nil {
 		return InternalError(err)
 	}
-	if boundApps, found := appsOf[service.Name()]; found {
-		for _, app := range boundApps {
-			boundAppNames = append(boundAppNames, app.Name)
-		}
+	boundApps := appsOf[service.Name()]
+	for _, app := range boundApps {
+		boundAppNames = append(boundAppNames, app.Name)
+	}
+	if len(boundApps) > 0 && !deleteRequest.Unbind {
+		return NewBadRequest("bound applications exist", strings.Join(boundAppNames, ","))
+	}
+
+	// Everything looks to be ok. Delete, in the background. Brokers
+	// may take their time to deprovision. The shares, bindings and keys
+	// are removed first, a service is not deprovisioned while in use.
+	// The records and the schedule of the backups are forgotten once
+	// the service is gone. Their archives stay in the store.
+
+	op, err := operations.Start(ctx, cluster, "service-delete", org, serviceName,
+		func(ctx context.Context, progress operations.Progress) error {
+			for _, target := range sharedWith {
+				progress(fmt.Sprintf("Unsharing from %s", target))
+				shared, err := services.SharedServiceLookup(ctx, cluster, target, serviceName)
+				if err != nil {
+					return err
+				}
+				if shared == nil {
+					continue
+				}
+				_, apierr := unshare(ctx, cluster, shared, true)
+				if apierr != nil {
+					return apierr.Errors()[0]
+				}
+			}
 
-		if !deleteRequest.Unbind {
-			return NewBadRequest("bound applications exist", strings.Join(boundAppNames, ","))
-		}
+			for _, app := range boundApps {
+				progress(fmt.Sprintf("Unbinding from %s", app.Name))
+				wl := application.NewWorkload(cluster, app.AppRef())
+				err := wl.Unbind(ctx, service)
+				if err != nil {
+					return err
+				}
+			}
 
-		for _, app := range boundApps {
-			wl := application.NewWorkload(cluster, app.AppRef())
-			err = wl.Unbind(ctx, service)
+			// The keys of the service are bindings without
+			// application. They go away with the service.
+			keys, err := services.ListKeys(ctx, cluster, service)
 			if err != nil {
-				return InternalError(err)
+				return err
+			}
+			for _, key := range keys {
+				progress(fmt.Sprintf("Deleting key %s", key))
+				err = services.DeleteKey(ctx, cluster, service, key)
+				if err != nil {
+					return err
+				}
 			}
-		}
-	}
 
-	// Everything looks to be ok. Delete.
+			progress("Deleting")
+			err = service.Delete(ctx)
+			if err != nil {
+				return err
+			}
 
-	err = service.Delete(ctx)
+			err = backups.Forget(ctx, cluster, service)
+			if err != nil {
+				return err
+			}
+			progress("Deleted")
+			return nil
+		})
 	if err != nil {
 		return InternalError(err)
 	}
 
-	js, err := json.Marshal(models.DeleteResponse{BoundApps: boundAppNames})
+	js, err := json.Marshal(models.DeleteResponse{BoundApps: boundAppNames, Operation: op.ID})
 	if err != nil {
 		return InternalError(err)
 	}
@@ -420,3 +803,16 @@ func servicesToApps(ctx context.Context, cluster *kubernetes.Cluster, org string
 
 	return appsOf, nil
 }
+
+// parametersInvalid returns an error per parameter violating the schema of
+// the service plan
+func parametersInvalid(violations []services.ParameterError) APIErrors {
+	errs := []APIError{}
+	for _, violation := range violations {
+		errs = append(errs, NewAPIError(
+			fmt.Sprintf("Invalid service parameter '%s': %s", violation.Field, violation.Description),
+			violation.Field,
+			http.StatusBadRequest))
+	}
+	return MultiError{errs}
+}
@@ -0,0 +1,175 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/application"
+	"github.com/epinio/epinio/internal/interfaces"
+	"github.com/epinio/epinio/internal/organizations"
+	"github.com/epinio/epinio/internal/services"
+	"github.com/julienschmidt/httprouter"
+)
+
+// ServiceSharesController manages the shares of services with other orgs.
+// A shared service is bindable by the applications of the other org.
+type ServiceSharesController struct {
+}
+
+func (ssc ServiceSharesController) Create(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var shareRequest models.ServiceShareRequest
+	err = json.Unmarshal(bodyBytes, &shareRequest)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if shareRequest.Org == "" {
+		return NewBadRequest("Cannot share service without an organization")
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	for _, o := range []string{org, shareRequest.Org} {
+		exists, err := organizations.Exists(ctx, cluster, o)
+		if err != nil {
+			return InternalError(err)
+		}
+		if !exists {
+			return OrgIsNotKnown(o)
+		}
+	}
+
+	service, err := services.Lookup(ctx, cluster, org, serviceName)
+	if err != nil && err.Error() == "service not found" {
+		return ServiceIsNotKnown(serviceName)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	_, err = services.Share(ctx, cluster, service, shareRequest.Org)
+	if err == services.ErrShareExists {
+		return ServiceAlreadyKnownInOrg(serviceName, shareRequest.Org)
+	}
+	if err == services.ErrShareOfShare || err == services.ErrShareToSelf {
+		return BadRequest(err)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func (ssc ServiceSharesController) Delete(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	serviceName := params.ByName("service")
+	target := params.ByName("target")
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var unshareRequest models.ServiceUnshareRequest
+	err = json.Unmarshal(bodyBytes, &unshareRequest)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	exists, err := organizations.Exists(ctx, cluster, org)
+	if err != nil {
+		return InternalError(err)
+	}
+	if !exists {
+		return OrgIsNotKnown(org)
+	}
+
+	shared, err := services.SharedServiceLookup(ctx, cluster, target, serviceName)
+	if err != nil {
+		return InternalError(err)
+	}
+	if shared == nil || shared.(*services.SharedService).SourceOrg != org {
+		return ServiceIsNotShared(serviceName, target)
+	}
+
+	boundAppNames, apierr := unshare(ctx, cluster, shared, unshareRequest.Unbind)
+	if apierr != nil {
+		return apierr
+	}
+
+	err = jsonResponse(w, models.ServiceUnshareResponse{BoundApps: boundAppNames})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// unshare removes the share of a service with another org. Applications of
+// that org bound to the service are an error, unless unbind is requested.
+// Returns the names of the bound applications.
+func unshare(ctx context.Context, cluster *kubernetes.Cluster, shared interfaces.Service, unbind bool) ([]string, APIErrors) {
+	boundAppNames := []string{}
+	appsOf, err := servicesToApps(ctx, cluster, shared.Org())
+	if err != nil {
+		return nil, InternalError(err)
+	}
+	if boundApps, found := appsOf[shared.Name()]; found {
+		for _, app := range boundApps {
+			boundAppNames = append(boundAppNames, app.Name)
+		}
+
+		if !unbind {
+			return nil, NewBadRequest("bound applications exist", strings.Join(boundAppNames, ","))
+		}
+
+		for _, app := range boundApps {
+			wl := application.NewWorkload(cluster, app.AppRef())
+			err = wl.Unbind(ctx, shared)
+			if err != nil {
+				return nil, InternalError(err)
+			}
+		}
+	}
+
+	err = shared.Delete(ctx)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+
+	return boundAppNames, nil
+}
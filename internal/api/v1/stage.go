@@ -8,14 +8,14 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/epinio/epinio/internal/application"
 	"github.com/epinio/epinio/internal/cli/clients/gitea"
 	"github.com/epinio/epinio/internal/domain"
+	"github.com/epinio/epinio/internal/sources"
 	"github.com/julienschmidt/httprouter"
 	v1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
-	"github.com/tektoncd/pipeline/pkg/apis/resource/v1alpha1"
-	"github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -34,35 +34,32 @@ const (
 	DefaultInstances = int32(1)
 )
 
+// stageParam holds what the staging pipeline needs. The SourceURL is the git
+// repository or archive to stage from. The GitRevision is checked out of a
+// repository, it is empty for an archive.
 type stageParam struct {
 	models.AppRef
-	Image     models.ImageRef
-	Git       *models.GitRef
-	Route     string
-	Stage     models.StageRef
-	Instances int32
-	Owner     metav1.OwnerReference
+	Image       models.ImageRef
+	SourceURL   string
+	GitRevision string
+	Revision    string
+	Route       string
+	Stage       models.StageRef
+	Instances   int32
+	Owner       metav1.OwnerReference
 }
 
-// GitURL returns the git URL by combining the server with the org and name
-func (app *stageParam) GitURL(server string) string {
-	return fmt.Sprintf("%s/%s/%s", server, app.Org, app.Name)
-}
-
-// ImageURL returns the URL of the image, using the ImageID. The ImageURL is
-// later used in app.yml.  Since the final commit is not known when the app.yml
-// is written, we cannot use Repo.Revision
+// ImageURL returns the URL of the image, using the revision of the sources.
+// The ImageURL is later used in app.yml.
 func (app *stageParam) ImageURL(server string) string {
-	return fmt.Sprintf("%s/%s-%s", server, app.Name, app.Git.Revision)
+	return fmt.Sprintf("%s/%s-%s", server, app.Name, app.Revision)
 }
 
 // Stage will create a Tekton PipelineRun resource to stage and start the app
 func (hc ApplicationsController) Stage(w http.ResponseWriter, r *http.Request) APIErrors {
 	ctx := r.Context()
-	log := tracelog.Logger(ctx)
 
 	p := httprouter.ParamsFromContext(ctx)
-	org := p.ByName("org")
 	name := p.ByName("app")
 
 	defer r.Body.Close()
@@ -80,49 +77,52 @@ func (hc ApplicationsController) Stage(w http.ResponseWriter, r *http.Request) A
 		return NewBadRequest("name parameter from URL does not match name param in body")
 	}
 
+	resp, apiErr := hc.stage(ctx, req, models.StagingTriggerPush)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	err = jsonResponse(w, resp)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// stage creates the Tekton PipelineRun for the request and records it in the
+// app's staging history. It is shared by the Stage handler and the webhook
+// receiver, which differ only in how they obtain the request.
+func (hc ApplicationsController) stage(ctx context.Context, req models.StageRequest, trigger string) (*models.StageResponse, APIErrors) {
+	log := tracelog.Logger(ctx)
+
 	if req.Instances != nil && *req.Instances < 0 {
-		return NewBadRequest("instances param should be integer equal or greater than zero")
+		return nil, NewBadRequest("instances param should be integer equal or greater than zero")
+	}
+
+	if req.Git == nil && req.Archive == nil {
+		return nil, NewBadRequest("cannot stage app without git or archive reference")
 	}
 
 	cluster, err := kubernetes.GetCluster(ctx)
 	if err != nil {
-		return InternalError(err, "failed to get access to a kube client")
+		return nil, InternalError(err, "failed to get access to a kube client")
 	}
 
 	// check application resource
 	app, err := application.Get(ctx, cluster, req.App)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
-			return AppIsNotKnown("cannot stage app, application resource is missing")
+			return nil, AppIsNotKnown("cannot stage app, application resource is missing")
 		}
-		return InternalError(err, "failed to get the application resource")
+		return nil, InternalError(err, "failed to get the application resource")
 	}
 
-	log.Info("staging app", "org", org, "app", req)
-
-	cs, err := versioned.NewForConfig(cluster.RestConfig)
-	if err != nil {
-		return InternalError(err, "failed to get access to a tekton client")
-	}
-	client := cs.TektonV1beta1().PipelineRuns(deployments.TektonStagingNamespace)
+	log.Info("staging app", "org", req.App.Org, "app", req)
 
 	uid, err := randstr.Hex16()
 	if err != nil {
-		return InternalError(err, "failed to generate a uid")
-	}
-
-	l, err := client.List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("app.kubernetes.io/name=%s,app.kubernetes.io/part-of=%s", req.App.Name, req.App.Org),
-	})
-	if err != nil {
-		return InternalError(err)
-	}
-
-	// assume that completed pipelineruns are from the past and have a CompletionTime
-	for _, pr := range l.Items {
-		if pr.Status.CompletionTime == nil {
-			return NewBadRequest("pipelinerun for image ID still running")
-		}
+		return nil, InternalError(err, "failed to generate a uid")
 	}
 
 	// find out the instances
@@ -132,7 +132,7 @@ func (hc ApplicationsController) Stage(w http.ResponseWriter, r *http.Request) A
 	} else {
 		instances, err = existingReplica(ctx, cluster.Kubectl, req.App)
 		if err != nil {
-			return InternalError(err)
+			return nil, InternalError(err)
 		}
 	}
 
@@ -144,15 +144,38 @@ func (hc ApplicationsController) Stage(w http.ResponseWriter, r *http.Request) A
 	}
 	params := stageParam{
 		AppRef:    req.App,
-		Git:       req.Git,
 		Route:     req.Route,
 		Instances: instances,
 		Owner:     owner,
 	}
 
+	// Git sources are cloned at the revision, archives are downloaded
+	// from the source store and named by their digest
+	if req.Git != nil {
+		if !application.ValidGitURL(req.Git.URL) {
+			return nil, NewBadRequest("bad git url")
+		}
+		if !application.ValidGitRevision(req.Git.Revision) {
+			return nil, NewBadRequest("bad git revision, expected a commit hash or ref name")
+		}
+		params.SourceURL = req.Git.URL
+		params.GitRevision = req.Git.Revision
+		params.Revision = req.Git.Revision
+	} else {
+		store, err := sources.New(ctx)
+		if err != nil {
+			return nil, InternalError(err)
+		}
+		params.SourceURL, err = store.ArchiveURL(ctx, req.App, *req.Archive)
+		if err != nil {
+			return nil, BadRequest(err, "bad archive reference")
+		}
+		params.Revision = req.Archive.Digest
+	}
+
 	mainDomain, err := domain.MainDomain(ctx)
 	if err != nil {
-		return InternalError(err)
+		return nil, InternalError(err)
 	}
 	var deploymentImageURL string
 	registryURL := fmt.Sprintf("%s.%s/%s", deployments.RegistryDeploymentID, mainDomain, "apps")
@@ -164,21 +187,120 @@ func (hc ApplicationsController) Stage(w http.ResponseWriter, r *http.Request) A
 		deploymentImageURL = gitea.LocalRegistry
 	}
 
+	buildSecrets, err := application.BuildSecrets(ctx, cluster, req.App)
+	if err != nil {
+		return nil, InternalError(err, "failed to read build secrets")
+	}
+	err = application.CreateStagingSecret(ctx, cluster, req.App, uid, buildSecrets)
+	if err != nil {
+		return nil, InternalError(err, "failed to copy build secrets")
+	}
+
+	// The PipelineRun is created by the queue, as soon as no other
+	// staging of the app runs and the cluster-wide limit allows.
 	pr := newPipelineRun(uid, params, mainDomain, registryURL, deploymentImageURL)
-	o, err := client.Create(ctx, pr, metav1.CreateOptions{})
+	err = queue.enqueue(ctx, cluster, stagingJob{app: req.App, run: pr})
 	if err != nil {
-		return InternalError(err, fmt.Sprintf("failed to create pipeline run: %#v", o))
+		log.Error(err, "failed to dispatch staging queue", "uid", uid)
 	}
 
 	err = auth.CreateCertificate(ctx, cluster, params.Name, params.Org, mainDomain, &owner)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+
+	err = application.RecordStaging(ctx, cluster, req.App, models.StagingRecord{
+		ID:       uid,
+		Revision: params.Revision,
+		Trigger:  trigger,
+		Time:     time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, InternalError(err, "failed to record staging in app history")
+	}
+
+	// The staging is queued, failing to remove old archives does not
+	// fail it. The next staging tries again.
+	if req.Archive != nil {
+		err = retainSources(ctx, cluster, req.App)
+		if err != nil {
+			log.Error(err, "failed to remove old sources", "org", req.App.Org, "app", req.App.Name)
+		}
+	}
+
+	log.Info("staged app", "org", req.App.Org, "app", params.AppRef, "uid", uid)
+
+	return &models.StageResponse{Stage: models.NewStage(uid)}, nil
+}
+
+// retainSources removes the archives of the app which no staging in its
+// history refers to
+func retainSources(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef) error {
+	history, err := application.History(ctx, cluster, appRef)
+	if err != nil {
+		return err
+	}
+	revisions := []string{}
+	for _, record := range history {
+		revisions = append(revisions, record.Revision)
+	}
+
+	store, err := sources.New(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Retain(ctx, appRef, revisions)
+}
+
+// StageStatus reports the state of a staging run. For queued runs it includes
+// the position in the queue, for failed runs the failing task and step, the
+// classified reason and the tail of the log.
+func (hc ApplicationsController) StageStatus(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	appName := params.ByName("app")
+	stageID := params.ByName("stage_id")
+
+	cluster, err := kubernetes.GetCluster(ctx)
 	if err != nil {
 		return InternalError(err)
 	}
 
-	log.Info("staged app", "org", org, "app", params.AppRef, "uid", uid)
+	apiErr := appExists(ctx, cluster, org, appName)
+	if apiErr != nil {
+		return apiErr
+	}
 
-	resp := models.StageResponse{Stage: models.NewStage(uid)}
-	err = jsonResponse(w, resp)
+	appRef := models.NewAppRef(appName, org)
+	var status *models.StageStatusResponse
+
+	if position, ok := queue.position(appRef, stageID); ok {
+		status = &models.StageStatusResponse{
+			Stage:    models.NewStage(stageID),
+			Status:   models.StagingQueued,
+			Position: position,
+		}
+	} else if message, ok := queue.failure(stageID); ok {
+		status = &models.StageStatusResponse{
+			Stage:  models.NewStage(stageID),
+			Status: models.StagingFailed,
+			Failure: &models.StagingFailure{
+				Reason:  models.StagingFailureUnknown,
+				Message: message,
+			},
+		}
+	} else {
+		status, err = application.StagingStatus(ctx, cluster, appRef, stageID)
+	}
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return StageIsNotKnown(stageID)
+		}
+		return InternalError(err)
+	}
+
+	err = jsonResponse(w, status)
 	if err != nil {
 		return InternalError(err)
 	}
@@ -186,7 +308,7 @@ func (hc ApplicationsController) Stage(w http.ResponseWriter, r *http.Request) A
 	return nil
 }
 
-func existingReplica(ctx context.Context, client *k8s.Clientset, app models.AppRef) (int32, error) {
+func existingReplica(ctx context.Context, client k8s.Interface, app models.AppRef) (int32, error) {
 	// if a deployment exists, use that deployment's replica count
 	result, err := client.AppsV1().Deployments(app.Org).Get(ctx, app.Name, metav1.GetOptions{})
 	if err != nil {
@@ -215,6 +337,8 @@ func newPipelineRun(uid string, app stageParam, mainDomain, registryURL, deploym
 			ServiceAccountName: "staging-triggers-admin",
 			PipelineRef:        &v1beta1.PipelineRef{Name: "staging-pipeline"},
 			Params: []v1beta1.Param{
+				{Name: "SOURCE_URL", Value: *str(app.SourceURL)},
+				{Name: "SOURCE_REVISION", Value: *str(app.GitRevision)},
 				{Name: "APP_NAME", Value: *str(app.Name)},
 				{Name: "ORG", Value: *str(app.Org)},
 				{Name: "ROUTE", Value: *str(app.Route)},
@@ -229,6 +353,10 @@ func newPipelineRun(uid string, app stageParam, mainDomain, registryURL, deploym
 				{Name: "OWNER_UID", Value: *str(string(app.Owner.UID))},
 			},
 			Workspaces: []v1beta1.WorkspaceBinding{
+				{
+					Name:   "build-secrets",
+					Secret: &corev1.SecretVolumeSource{SecretName: application.StagingSecretName(uid)},
+				},
 				{
 					Name: "source",
 					VolumeClaimTemplate: &corev1.PersistentVolumeClaim{
@@ -241,18 +369,6 @@ func newPipelineRun(uid string, app stageParam, mainDomain, registryURL, deploym
 					},
 				},
 			},
-			Resources: []v1beta1.PipelineResourceBinding{
-				{
-					Name: "source-repo",
-					ResourceSpec: &v1alpha1.PipelineResourceSpec{
-						Type: v1alpha1.PipelineResourceTypeGit,
-						Params: []v1alpha1.ResourceParam{
-							{Name: "revision", Value: app.Git.Revision},
-							{Name: "url", Value: app.Git.URL},
-						},
-					},
-				},
-			},
 		},
 	}
 }
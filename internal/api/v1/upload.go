@@ -1,6 +1,8 @@
 package v1
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,15 +10,43 @@ import (
 	"os"
 	"path"
 
+	"github.com/epinio/epinio/helpers"
 	"github.com/epinio/epinio/helpers/tracelog"
 	"github.com/epinio/epinio/internal/api/v1/models"
-	"github.com/epinio/epinio/internal/cli/clients/gitea"
+	"github.com/epinio/epinio/internal/sources"
 	"github.com/julienschmidt/httprouter"
-	"github.com/mholt/archiver/v3"
 )
 
-// Upload receives the application data, as tarball, and creates the gitea as
-// well as k8s resources to trigger staging
+// maxUploadSize is the largest request body accepted by Upload, in bytes.
+// Zero means no limit.
+var maxUploadSize int64
+
+// unpackedSizeFactor bounds the unpacked application sources to a multiple
+// of the maximum upload size. Compressed uploads are limited by what they
+// unpack to, not only by the bytes sent.
+const unpackedSizeFactor = 10
+
+// maxArchiveEntries is the largest number of files, directories and links
+// of uploaded application sources
+const maxArchiveEntries = 100000
+
+// SetMaxUploadSize sets the largest application upload accepted, in bytes.
+// Zero means no limit.
+func SetMaxUploadSize(size int64) {
+	maxUploadSize = size
+}
+
+// UnpackLimits returns the limits of unpacking uploaded application
+// sources, derived from the maximum upload size
+func UnpackLimits() helpers.ArchiveLimits {
+	return helpers.ArchiveLimits{
+		Size:    maxUploadSize * unpackedSizeFactor,
+		Entries: maxArchiveEntries,
+	}
+}
+
+// Upload receives the application data, as tarball, and keeps it in the
+// source store for staging
 func (hc ApplicationsController) Upload(w http.ResponseWriter, r *http.Request) APIErrors {
 	ctx := r.Context()
 	log := tracelog.Logger(ctx)
@@ -27,15 +57,27 @@ func (hc ApplicationsController) Upload(w http.ResponseWriter, r *http.Request)
 
 	log.Info("processing upload", "org", org, "app", name)
 
-	client, err := gitea.New(ctx)
+	store, err := sources.New(ctx)
 	if err != nil {
 		return InternalError(err)
 	}
 
+	var body *limitedBody
+	if maxUploadSize > 0 {
+		if r.ContentLength > maxUploadSize {
+			return uploadTooLarge()
+		}
+		body = &limitedBody{ReadCloser: r.Body, remaining: maxUploadSize}
+		r.Body = body
+	}
+
 	log.V(2).Info("parsing multipart form")
 
 	file, _, err := r.FormFile("file")
 	if err != nil {
+		if body != nil && body.exceeded {
+			return uploadTooLarge()
+		}
 		return BadRequest(err, "can't read multipart file input")
 	}
 	defer file.Close()
@@ -58,30 +100,97 @@ func (hc ApplicationsController) Upload(w http.ResponseWriter, r *http.Request)
 		return InternalError(err, "failed to copy app sources to temp location")
 	}
 
+	resp, apierr := storeSources(ctx, store, models.NewAppRef(name, org), tmpDir, blob, nil)
+	if apierr != nil {
+		return apierr
+	}
+
+	err = jsonResponse(w, resp)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// storeSources unpacks the tarball of application sources into tmpDir and
+// keeps them in the source store. With a manifest the tarball is the delta
+// to the previous revision.
+func storeSources(ctx context.Context, store sources.Store, app models.AppRef, tmpDir, blob string, manifest []models.SourceFile) (*models.UploadResponse, APIErrors) {
+	log := tracelog.Logger(ctx)
+
+	// The format is detected from the content, clients may compress
 	log.V(2).Info("unpacking temp dir")
 	appDir := path.Join(tmpDir, "app")
-	err = archiver.Unarchive(blob, appDir)
+	err := helpers.UnpackArchive(blob, appDir, UnpackLimits())
+	if errors.Is(err, helpers.ErrArchiveLimits) {
+		return nil, unpackedTooLarge()
+	}
 	if err != nil {
-		return InternalError(err, "failed to unpack app sources to temp location")
+		return nil, BadRequest(err, "failed to unpack app sources")
 	}
 
-	log.V(2).Info("create gitea app repo")
-	app := models.NewAppRef(name, org)
-	g, err := client.Upload(app, appDir)
+	var resp *models.UploadResponse
+	if manifest != nil {
+		log.V(2).Info("assemble app sources from delta", "files", len(manifest))
+		resp, err = store.UploadDelta(ctx, app, manifest, appDir)
+	} else {
+		log.V(2).Info("store app sources")
+		resp, err = store.Upload(ctx, app, appDir)
+	}
+	if errors.Is(err, helpers.ErrArchiveLimits) {
+		return nil, unpackedTooLarge()
+	}
 	if err != nil {
-		return InternalError(err)
+		return nil, InternalError(err)
 	}
 
-	log.Info("uploaded app", "org", org, "app", name)
+	log.Info("uploaded app", "org", app.Org, "app", app.Name)
 
-	// Extend url to contain the full repo path
-	g.URL = fmt.Sprintf("%s/%s/%s", g.URL, org, name)
+	return resp, nil
+}
 
-	resp := models.UploadResponse{Git: &g}
-	err = jsonResponse(w, resp)
-	if err != nil {
-		return InternalError(err)
+func uploadTooLarge() APIErrors {
+	return NewAPIError(
+		fmt.Sprintf("application sources exceed the maximum upload size of %d bytes", maxUploadSize),
+		"",
+		http.StatusRequestEntityTooLarge,
+	)
+}
+
+func unpackedTooLarge() APIErrors {
+	limits := UnpackLimits()
+	return NewAPIError(
+		fmt.Sprintf("application sources exceed %d bytes or %d files when unpacked", limits.Size, limits.Entries),
+		"",
+		http.StatusRequestEntityTooLarge,
+	)
+}
+
+// limitedBody reads a request body of unknown length, up to a limit. Unlike
+// http.MaxBytesReader it records that the limit was exceeded, the multipart
+// reader does not keep the identity of the read errors.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errors.New("request body too large")
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
 	}
 
-	return nil
+	b.exceeded = true
+	n = int(b.remaining)
+	b.remaining = 0
+	return n, errors.New("request body too large")
 }
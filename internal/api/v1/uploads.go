@@ -0,0 +1,408 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/epinio/epinio/helpers"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/randstr"
+	"github.com/epinio/epinio/helpers/tracelog"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/sources"
+	"github.com/julienschmidt/httprouter"
+)
+
+// uploadSessionTimeout is the time after which an upload without activity is
+// abandoned and its data removed
+const uploadSessionTimeout = time.Hour
+
+// uploadSession is a chunked upload of application sources in progress. The
+// data received so far is in the blob file, its size is the offset at which
+// the next chunk is expected.
+type uploadSession struct {
+	mu      sync.Mutex
+	app     models.AppRef
+	size    int64
+	dir     string
+	blob    string
+	touched time.Time
+}
+
+// uploadSessions holds the chunked uploads in progress. They live in the
+// memory of the API server, an upload interrupted by a restart has to start
+// over.
+type uploadSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*uploadSession
+}
+
+var uploads = &uploadSessions{
+	sessions: map[string]*uploadSession{},
+}
+
+// create starts a new session, after removing the abandoned ones
+func (u *uploadSessions) create(app models.AppRef, size int64) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	active := map[string]*uploadSession{}
+	for id, session := range u.sessions {
+		if time.Since(session.touched) > uploadSessionTimeout {
+			os.RemoveAll(session.dir)
+			continue
+		}
+		active[id] = session
+	}
+	u.sessions = active
+
+	id, err := randstr.Hex16()
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := ioutil.TempDir("", "epinio-upload")
+	if err != nil {
+		return "", err
+	}
+	blob := path.Join(dir, "blob.tar")
+	f, err := os.Create(blob)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	f.Close()
+
+	u.sessions[id] = &uploadSession{
+		app:     app,
+		size:    size,
+		dir:     dir,
+		blob:    blob,
+		touched: time.Now(),
+	}
+
+	return id, nil
+}
+
+// get returns the session of the app, or nil if there is none
+func (u *uploadSessions) get(app models.AppRef, id string) *uploadSession {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	session, ok := u.sessions[id]
+	if !ok || session.app != app {
+		return nil
+	}
+	session.touched = time.Now()
+	return session
+}
+
+// remove ends the session and removes its data
+func (u *uploadSessions) remove(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	session, ok := u.sessions[id]
+	if !ok {
+		return
+	}
+	os.RemoveAll(session.dir)
+
+	active := map[string]*uploadSession{}
+	for other, session := range u.sessions {
+		if other != id {
+			active[other] = session
+		}
+	}
+	u.sessions = active
+}
+
+// offset returns the amount of data received so far
+func (s *uploadSession) offset() (int64, error) {
+	info, err := os.Stat(s.blob)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// UploadStart begins a chunked upload of the application sources. The
+// request announces the size of the tarball, the response carries the id of
+// the upload for the chunks to refer to.
+func (hc ApplicationsController) UploadStart(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	app := models.NewAppRef(params.ByName("app"), params.ByName("org"))
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var req models.UploadStartRequest
+	err = json.Unmarshal(bodyBytes, &req)
+	if err != nil {
+		return BadRequest(err)
+	}
+	if req.Size <= 0 {
+		return NewBadRequest("the size of the upload is missing")
+	}
+	if maxUploadSize > 0 && req.Size > maxUploadSize {
+		return uploadTooLarge()
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+	apiErr := appExists(ctx, cluster, app.Org, app.Name)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	id, err := uploads.create(app, req.Size)
+	if err != nil {
+		return InternalError(err, "can't create upload")
+	}
+
+	tracelog.Logger(ctx).Info("started upload", "org", app.Org, "app", app.Name, "id", id, "size", req.Size)
+
+	err = jsonResponse(w, models.UploadStatusResponse{ID: id, Size: req.Size})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// UploadStatus reports how much of the upload the server has. An upload
+// interrupted mid-chunk resumes from here.
+func (hc ApplicationsController) UploadStatus(w http.ResponseWriter, r *http.Request) APIErrors {
+	params := httprouter.ParamsFromContext(r.Context())
+	app := models.NewAppRef(params.ByName("app"), params.ByName("org"))
+	id := params.ByName("upload_id")
+
+	session := uploads.get(app, id)
+	if session == nil {
+		return UploadIsNotKnown(id)
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	offset, err := session.offset()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = jsonResponse(w, models.UploadStatusResponse{ID: id, Offset: offset, Size: session.size})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// UploadChunk appends the request body to the upload. The `offset` query
+// parameter has to match the amount of data received so far, else the chunk
+// is rejected with a conflict.
+func (hc ApplicationsController) UploadChunk(w http.ResponseWriter, r *http.Request) APIErrors {
+	params := httprouter.ParamsFromContext(r.Context())
+	app := models.NewAppRef(params.ByName("app"), params.ByName("org"))
+	id := params.ByName("upload_id")
+
+	start, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		return BadRequest(err, "bad offset")
+	}
+
+	session := uploads.get(app, id)
+	if session == nil {
+		return UploadIsNotKnown(id)
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	offset, err := session.offset()
+	if err != nil {
+		return InternalError(err)
+	}
+	if start != offset {
+		return NewAPIError(fmt.Sprintf("upload '%s' expects data at offset %d", id, offset), "", http.StatusConflict)
+	}
+
+	f, err := os.OpenFile(session.blob, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return InternalError(err)
+	}
+	defer f.Close()
+
+	// Data beyond the announced size is an error, reading one byte more
+	// detects it.
+	n, err := io.Copy(f, io.LimitReader(r.Body, session.size-offset+1))
+	if err != nil {
+		// Keep what arrived, the client resumes from there
+		return InternalError(err, "failed to receive chunk")
+	}
+	if offset+n > session.size {
+		uploads.remove(id)
+		return NewBadRequest(fmt.Sprintf("upload '%s' exceeds its size of %d bytes", id, session.size))
+	}
+
+	err = jsonResponse(w, models.UploadStatusResponse{ID: id, Offset: offset + n, Size: session.size})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// UploadFinish verifies the checksum of the completed upload and pushes the
+// sources to gitea, like Upload does. A checksum mismatch discards the
+// upload.
+func (hc ApplicationsController) UploadFinish(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	log := tracelog.Logger(ctx)
+	params := httprouter.ParamsFromContext(ctx)
+	app := models.NewAppRef(params.ByName("app"), params.ByName("org"))
+	id := params.ByName("upload_id")
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var req models.UploadFinishRequest
+	err = json.Unmarshal(bodyBytes, &req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	session := uploads.get(app, id)
+	if session == nil {
+		return UploadIsNotKnown(id)
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	offset, err := session.offset()
+	if err != nil {
+		return InternalError(err)
+	}
+	if offset != session.size {
+		return NewAPIError(fmt.Sprintf("upload '%s' is incomplete, %d of %d bytes received", id, offset, session.size), "", http.StatusConflict)
+	}
+
+	apierr := validManifest(req.Manifest)
+	if apierr != nil {
+		return apierr
+	}
+
+	sum, err := helpers.FileSHA256(session.blob)
+	if err != nil {
+		return InternalError(err, "failed to checksum upload")
+	}
+	if !strings.EqualFold(sum, req.SHA256) {
+		uploads.remove(id)
+		return NewBadRequest(fmt.Sprintf("checksum mismatch for upload '%s', the upload is discarded", id))
+	}
+
+	log.Info("completed upload", "org", app.Org, "app", app.Name, "id", id)
+
+	store, err := sources.New(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	resp, apierr := storeSources(ctx, store, app, session.dir, session.blob, req.Manifest)
+	uploads.remove(id)
+	if apierr != nil {
+		return apierr
+	}
+
+	err = jsonResponse(w, resp)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// SourceManifest compares the manifest of the sources to push with the latest
+// revision of the app, and returns the files which have to be uploaded. The
+// upload is then completed with the same manifest.
+func (hc ApplicationsController) SourceManifest(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	app := models.NewAppRef(params.ByName("app"), params.ByName("org"))
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var req models.SourceManifestRequest
+	err = json.Unmarshal(bodyBytes, &req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	apierr := validManifest(req.Files)
+	if apierr != nil {
+		return apierr
+	}
+
+	store, err := sources.New(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	hashes, err := store.SourceHashes(ctx, app)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	missing := []string{}
+	for _, file := range req.Files {
+		if hashes[file.Path] != file.SHA256 {
+			missing = append(missing, file.Path)
+		}
+	}
+
+	tracelog.Logger(ctx).Info("compared sources", "org", app.Org, "app", app.Name,
+		"files", len(req.Files), "missing", len(missing))
+
+	err = jsonResponse(w, models.SourceManifestResponse{Missing: missing})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// validManifest checks that the paths of the manifest stay inside the
+// sources, and away from the git metadata
+func validManifest(manifest []models.SourceFile) APIErrors {
+	for _, file := range manifest {
+		clean := path.Clean(file.Path)
+		if clean != file.Path || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") ||
+			clean == ".git" || strings.HasPrefix(clean, ".git/") {
+			return NewBadRequest(fmt.Sprintf("bad path '%s' in source manifest", file.Path))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/tracelog"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/application"
+	"github.com/epinio/epinio/internal/domain"
+	"github.com/epinio/epinio/internal/organizations"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+type WebhooksController struct {
+}
+
+// Show returns the webhook configuration of the application
+func (wc WebhooksController) Show(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	appName := params.ByName("app")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	apiErr := appExists(ctx, cluster, org, appName)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	webhook, err := application.LookupWebhook(ctx, cluster, models.NewAppRef(appName, org))
+	if err != nil {
+		return InternalError(err)
+	}
+	if webhook == nil {
+		return WebhookIsNotEnabled(appName)
+	}
+
+	err = jsonResponse(w, models.WebhookResponse{Branch: webhook.Branch, Secret: webhook.Secret})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// Enable generates a new webhook secret for the application, and sets the
+// branch to deploy from
+func (wc WebhooksController) Enable(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	appName := params.ByName("app")
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var enableRequest models.WebhookEnableRequest
+	err = json.Unmarshal(bodyBytes, &enableRequest)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	apiErr := appExists(ctx, cluster, org, appName)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	webhook, err := application.EnableWebhook(ctx, cluster, models.NewAppRef(appName, org), enableRequest.Branch)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = jsonResponse(w, models.WebhookResponse{Branch: webhook.Branch, Secret: webhook.Secret})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// Disable removes the webhook configuration of the application
+func (wc WebhooksController) Disable(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	appName := params.ByName("app")
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	apiErr := appExists(ctx, cluster, org, appName)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	err = application.DisableWebhook(ctx, cluster, models.NewAppRef(appName, org))
+	if err != nil {
+		return InternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write([]byte{})
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+// Receive handles push notifications from GitHub, GitLab and Gitea. A push to
+// the configured branch stages the pushed revision. The caller is not
+// authenticated until the payload is verified against the secret of the
+// webhook. Before that, unknown orgs, apps and webhooks fail like a bad
+// signature, not to tell which exist.
+func (wc WebhooksController) Receive(w http.ResponseWriter, r *http.Request) APIErrors {
+	ctx := r.Context()
+	log := tracelog.Logger(ctx)
+	params := httprouter.ParamsFromContext(ctx)
+	org := params.ByName("org")
+	appName := params.ByName("app")
+
+	defer r.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	// The webhook secret lives in the org namespace and is owned by the
+	// app, it is not found for unknown orgs and apps
+	appRef := models.NewAppRef(appName, org)
+	webhook, err := application.LookupWebhook(ctx, cluster, appRef)
+	if err != nil {
+		return InternalError(err)
+	}
+	if webhook == nil {
+		return webhookUnauthorized()
+	}
+
+	event, err := application.ParsePushEvent(r.Header, bodyBytes, webhook.Secret)
+	if err == application.ErrWebhookSignature {
+		return webhookUnauthorized()
+	}
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	apiErr := appExists(ctx, cluster, org, appName)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	resp := models.WebhookTriggerResponse{}
+	switch {
+	case event == nil:
+		resp.Reason = "not a push event"
+	case event.Ignored != "":
+		resp.Reason = event.Ignored
+	case event.Branch != webhook.Branch:
+		resp.Reason = fmt.Sprintf("push to branch '%s' ignored, deploying from '%s'", event.Branch, webhook.Branch)
+	default:
+		log.Info("webhook push", "org", org, "app", appName, "revision", event.Revision)
+
+		route, err := appRoute(ctx, cluster, appRef)
+		if err != nil {
+			return InternalError(err)
+		}
+
+		stage, apiErr := ApplicationsController{}.stage(ctx, models.StageRequest{
+			App:   appRef,
+			Git:   &models.GitRef{URL: event.URL, Revision: event.Revision},
+			Route: route,
+		}, models.StagingTriggerWebhook)
+		if apiErr != nil {
+			return apiErr
+		}
+		resp.Stage = stage.Stage
+	}
+
+	err = jsonResponse(w, resp)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
+func webhookUnauthorized() APIErrors {
+	return NewAPIError(application.ErrWebhookSignature.Error(), "", http.StatusUnauthorized)
+}
+
+// appRoute returns the route the app was pushed with, from its ingress. An
+// app which was never deployed gets the default route.
+func appRoute(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef) (string, error) {
+	routes, err := cluster.ListIngressRoutes(ctx, appRef.Org, appRef.Name)
+	if err != nil && !apierrors.IsNotFound(errors.Cause(err)) {
+		return "", err
+	}
+	if len(routes) > 0 {
+		return routes[0], nil
+	}
+
+	mainDomain, err := domain.MainDomain(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%s", appRef.Name, mainDomain), nil
+}
+
+// appExists checks that both org and application are known
+func appExists(ctx context.Context, cluster *kubernetes.Cluster, org, appName string) APIErrors {
+	exists, err := organizations.Exists(ctx, cluster, org)
+	if err != nil {
+		return InternalError(err)
+	}
+	if !exists {
+		return OrgIsNotKnown(org)
+	}
+
+	found, err := application.Exists(ctx, cluster, models.NewAppRef(appName, org))
+	if err != nil {
+		return InternalError(err)
+	}
+	if !found {
+		return AppIsNotKnown(appName)
+	}
+
+	return nil
+}
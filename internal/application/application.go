@@ -254,6 +254,16 @@ func Logs(ctx context.Context, logChan chan tailer.ContainerLogLine, wg *sync.Wa
 		selector = selector.Add(*req)
 	}
 
+	// Build secrets must not leak through the staging logs
+	var redact []string
+	if stageID != "" {
+		var err error
+		redact, err = stagingSecretValues(ctx, cluster, stageID)
+		if err != nil {
+			return err
+		}
+	}
+
 	config := &tailer.Config{
 		ContainerQuery:        regexp.MustCompile(".*"),
 		ExcludeContainerQuery: regexp.MustCompile("linkerd-(proxy|init)"),
@@ -267,6 +277,7 @@ func Logs(ctx context.Context, logChan chan tailer.ContainerLogLine, wg *sync.Wa
 		TailLines:             nil,
 		Namespace:             "",
 		PodQuery:              regexp.MustCompile(".*"),
+		Redact:                redact,
 	}
 
 	if follow {
@@ -0,0 +1,346 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The ways a service can be bound to an application. Files mounts the
+// binding secret below /services/NAME, env injects its keys as environment
+// variables with the prefix of the service.
+const (
+	BindAsFiles = "files"
+	BindAsEnv   = "env"
+	BindAsBoth  = "both"
+)
+
+// BindModes lists the valid ways to bind a service
+var BindModes = []string{BindAsFiles, BindAsEnv, BindAsBoth}
+
+// ServicesEnv is the environment variable of the application which
+// describes all its bindings, as JSON list of Binding
+const ServicesEnv = "EPINIO_SERVICES"
+
+// Binding describes where an application finds the data of a bound service.
+// Path is set for bindings as files, EnvPrefix for bindings as environment
+// variables.
+type Binding struct {
+	Service   string `json:"service"`
+	Path      string `json:"path,omitempty"`
+	EnvPrefix string `json:"envprefix,omitempty"`
+}
+
+// ValidBindMode returns true for the known ways to bind a service
+func ValidBindMode(as string) bool {
+	for _, mode := range BindModes {
+		if as == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// BindingEnvPrefix returns the prefix of the environment variables holding
+// the data of the service, e.g. MY_DB_ for the service my-db
+func BindingEnvPrefix(service string) string {
+	prefix := strings.Map(func(r rune) rune {
+		switch {
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, service)
+	if prefix == "" || ('0' <= prefix[0] && prefix[0] <= '9') {
+		prefix = "_" + prefix
+	}
+	return prefix + "_"
+}
+
+// Bindings returns the bindings of the pod spec, as described by its
+// ServicesEnv. Services mounted by older versions of Epinio, which did not
+// set the variable, are reported as bindings as files. These are recognized
+// by the name of their secret, other secret volumes are not bindings.
+func Bindings(spec *corev1.PodSpec) ([]Binding, error) {
+	bindings := []Binding{}
+	known := map[string]bool{}
+
+	// All containers carry the same description, the first one found is
+	// used
+	for _, container := range spec.Containers {
+		value, ok := servicesEnvOf(container)
+		if !ok {
+			continue
+		}
+		err := json.Unmarshal([]byte(value), &bindings)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad %s", ServicesEnv)
+		}
+		break
+	}
+	for _, binding := range bindings {
+		known[binding.Service] = true
+	}
+
+	for _, volume := range spec.Volumes {
+		if volume.Secret == nil || known[volume.Name] || !legacyBindingSecret(volume.Secret.SecretName, volume.Name) {
+			continue
+		}
+		bindings = append(bindings, Binding{
+			Service: volume.Name,
+			Path:    bindingPath(volume.Name),
+		})
+	}
+
+	return bindings, nil
+}
+
+// legacyBindingSecret returns true for the names of the secrets older
+// versions of Epinio mounted for the service: the secret of a custom
+// service, service.org-ORG.svc-NAME, or the binding secret of a catalog
+// service, service.org-ORG.svc-NAME.app-APP
+func legacyBindingSecret(secretName, service string) bool {
+	if !strings.HasPrefix(secretName, "service.org-") {
+		return false
+	}
+	return strings.HasSuffix(secretName, ".svc-"+service) ||
+		strings.Contains(secretName, ".svc-"+service+".app-")
+}
+
+// AddBinding binds the service with the binding secret to all containers of
+// the pod spec, as files, environment variables or both
+func AddBinding(spec *corev1.PodSpec, service, secretName, as string) error {
+	if !ValidBindMode(as) {
+		return fmt.Errorf("unknown binding mode '%s', expected one of %s", as, strings.Join(BindModes, ", "))
+	}
+	if len(spec.Containers) < 1 {
+		return errors.New("application has no containers")
+	}
+
+	bindings, err := Bindings(spec)
+	if err != nil {
+		return err
+	}
+
+	binding := Binding{Service: service}
+	prefix := BindingEnvPrefix(service)
+	for _, other := range bindings {
+		if other.Service == service {
+			return errors.New("service already bound")
+		}
+	}
+	if as == BindAsEnv || as == BindAsBoth {
+		for _, other := range bindings {
+			if other.EnvPrefix == prefix {
+				return fmt.Errorf("environment prefix '%s' is already used by service '%s'",
+					prefix, other.Service)
+			}
+		}
+	}
+
+	if as == BindAsFiles || as == BindAsBoth {
+		binding.Path = bindingPath(service)
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: service,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secretName,
+				},
+			},
+		})
+	}
+	if as == BindAsEnv || as == BindAsBoth {
+		binding.EnvPrefix = prefix
+	}
+
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+
+		if binding.Path != "" {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      service,
+				ReadOnly:  true,
+				MountPath: binding.Path,
+			})
+		}
+		if binding.EnvPrefix != "" {
+			container.EnvFrom = append(container.EnvFrom, corev1.EnvFromSource{
+				Prefix: binding.EnvPrefix,
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				},
+			})
+		}
+	}
+
+	return setBindings(spec, append(bindings, binding))
+}
+
+// RemoveBinding dissolves the binding of the service from all containers of
+// the pod spec. It returns false when the service is not bound.
+func RemoveBinding(spec *corev1.PodSpec, service string) (bool, error) {
+	bindings, err := Bindings(spec)
+	if err != nil {
+		return false, err
+	}
+
+	var removed *Binding
+	remaining := []Binding{}
+	for i, binding := range bindings {
+		if binding.Service == service {
+			removed = &bindings[i]
+		} else {
+			remaining = append(remaining, binding)
+		}
+	}
+	if removed == nil {
+		return false, nil
+	}
+
+	volumes := []corev1.Volume{}
+	for _, volume := range spec.Volumes {
+		if volume.Name != service {
+			volumes = append(volumes, volume)
+		}
+	}
+	spec.Volumes = volumes
+
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+
+		mounts := []corev1.VolumeMount{}
+		for _, mount := range container.VolumeMounts {
+			if mount.Name != service {
+				mounts = append(mounts, mount)
+			}
+		}
+		container.VolumeMounts = mounts
+
+		if removed.EnvPrefix != "" {
+			envFrom := []corev1.EnvFromSource{}
+			for _, source := range container.EnvFrom {
+				if source.Prefix != removed.EnvPrefix {
+					envFrom = append(envFrom, source)
+				}
+			}
+			container.EnvFrom = envFrom
+		}
+	}
+
+	return true, setBindings(spec, remaining)
+}
+
+// BindingSecret returns the name of the secret of the service's binding
+func BindingSecret(spec *corev1.PodSpec, service string) (string, error) {
+	binding, err := findBinding(spec, service)
+	if err != nil {
+		return "", err
+	}
+
+	if binding.Path != "" {
+		for _, volume := range spec.Volumes {
+			if volume.Name == service && volume.Secret != nil {
+				return volume.Secret.SecretName, nil
+			}
+		}
+	}
+	for _, container := range spec.Containers {
+		for _, source := range container.EnvFrom {
+			if source.Prefix == binding.EnvPrefix && source.SecretRef != nil {
+				return source.SecretRef.Name, nil
+			}
+		}
+	}
+
+	return "", errors.New("binding of the service has no secret")
+}
+
+// ReplaceBindingSecret changes the secret of the service's binding in all
+// containers of the pod spec, keeping the way it is bound
+func ReplaceBindingSecret(spec *corev1.PodSpec, service, secretName string) error {
+	binding, err := findBinding(spec, service)
+	if err != nil {
+		return err
+	}
+
+	for i := range spec.Volumes {
+		volume := &spec.Volumes[i]
+		if volume.Name == service && volume.Secret != nil {
+			volume.Secret.SecretName = secretName
+		}
+	}
+	if binding.EnvPrefix != "" {
+		for i := range spec.Containers {
+			container := &spec.Containers[i]
+			for j := range container.EnvFrom {
+				source := &container.EnvFrom[j]
+				if source.Prefix == binding.EnvPrefix && source.SecretRef != nil {
+					source.SecretRef.Name = secretName
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// findBinding returns the binding of the service, or an error when the
+// service is not bound
+func findBinding(spec *corev1.PodSpec, service string) (*Binding, error) {
+	bindings, err := Bindings(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range bindings {
+		if bindings[i].Service == service {
+			return &bindings[i], nil
+		}
+	}
+
+	return nil, errors.New("service is not bound to the application")
+}
+
+// setBindings stores the bindings in the ServicesEnv of all containers. The
+// variable is removed when there are no bindings.
+func setBindings(spec *corev1.PodSpec, bindings []Binding) error {
+	value, err := json.Marshal(bindings)
+	if err != nil {
+		return err
+	}
+
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+
+		env := []corev1.EnvVar{}
+		for _, e := range container.Env {
+			if e.Name != ServicesEnv {
+				env = append(env, e)
+			}
+		}
+		if len(bindings) > 0 {
+			env = append(env, corev1.EnvVar{Name: ServicesEnv, Value: string(value)})
+		}
+		container.Env = env
+	}
+
+	return nil
+}
+
+func servicesEnvOf(container corev1.Container) (string, bool) {
+	for _, env := range container.Env {
+		if env.Name == ServicesEnv {
+			return env.Value, true
+		}
+	}
+	return "", false
+}
+
+func bindingPath(service string) string {
+	return fmt.Sprintf("/services/%s", service)
+}
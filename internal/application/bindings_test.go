@@ -0,0 +1,174 @@
+package application_test
+
+import (
+	"errors"
+
+	. "github.com/epinio/epinio/internal/application"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Bindings", func() {
+	var spec *corev1.PodSpec
+
+	BeforeEach(func() {
+		spec = &corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "app",
+				Env:  []corev1.EnvVar{{Name: "PORT", Value: "8080"}},
+			}},
+		}
+	})
+
+	servicesEnv := func() string {
+		for _, env := range spec.Containers[0].Env {
+			if env.Name == ServicesEnv {
+				return env.Value
+			}
+		}
+		return ""
+	}
+
+	It("derives environment prefixes from service names", func() {
+		Expect(BindingEnvPrefix("my-db")).To(Equal("MY_DB_"))
+		Expect(BindingEnvPrefix("cache.v2")).To(Equal("CACHE_V2_"))
+		Expect(BindingEnvPrefix("1st")).To(Equal("_1ST_"))
+	})
+
+	It("binds as files", func() {
+		Expect(AddBinding(spec, "db", "db-secret", BindAsFiles)).To(Succeed())
+
+		Expect(spec.Volumes).To(HaveLen(1))
+		Expect(spec.Volumes[0].Secret.SecretName).To(Equal("db-secret"))
+		Expect(spec.Containers[0].VolumeMounts[0].MountPath).To(Equal("/services/db"))
+		Expect(spec.Containers[0].EnvFrom).To(BeEmpty())
+		Expect(servicesEnv()).To(MatchJSON(`[{"service":"db","path":"/services/db"}]`))
+	})
+
+	It("binds as environment variables", func() {
+		Expect(AddBinding(spec, "my-db", "db-secret", BindAsEnv)).To(Succeed())
+
+		Expect(spec.Volumes).To(BeEmpty())
+		Expect(spec.Containers[0].EnvFrom).To(HaveLen(1))
+		Expect(spec.Containers[0].EnvFrom[0].Prefix).To(Equal("MY_DB_"))
+		Expect(spec.Containers[0].EnvFrom[0].SecretRef.Name).To(Equal("db-secret"))
+		Expect(servicesEnv()).To(MatchJSON(`[{"service":"my-db","envprefix":"MY_DB_"}]`))
+	})
+
+	It("keeps the description in sync on bind and unbind", func() {
+		Expect(AddBinding(spec, "db", "db-secret", BindAsBoth)).To(Succeed())
+		Expect(AddBinding(spec, "cache", "cache-secret", BindAsEnv)).To(Succeed())
+
+		bindings, err := Bindings(spec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bindings).To(Equal([]Binding{
+			{Service: "db", Path: "/services/db", EnvPrefix: "DB_"},
+			{Service: "cache", EnvPrefix: "CACHE_"},
+		}))
+
+		found, err := RemoveBinding(spec, "db")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(spec.Volumes).To(BeEmpty())
+		Expect(spec.Containers[0].VolumeMounts).To(BeEmpty())
+		Expect(spec.Containers[0].EnvFrom).To(HaveLen(1))
+		Expect(servicesEnv()).To(MatchJSON(`[{"service":"cache","envprefix":"CACHE_"}]`))
+
+		found, err = RemoveBinding(spec, "cache")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(spec.Containers[0].Env).To(Equal([]corev1.EnvVar{{Name: "PORT", Value: "8080"}}))
+
+		found, err = RemoveBinding(spec, "cache")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+
+	It("binds and unbinds all containers", func() {
+		spec.Containers = append(spec.Containers, corev1.Container{Name: "worker"})
+
+		Expect(AddBinding(spec, "db", "db-secret", BindAsBoth)).To(Succeed())
+
+		Expect(spec.Volumes).To(HaveLen(1))
+		for _, container := range spec.Containers {
+			Expect(container.VolumeMounts).To(HaveLen(1), container.Name)
+			Expect(container.EnvFrom).To(HaveLen(1), container.Name)
+			Expect(container.Env).To(ContainElement(corev1.EnvVar{
+				Name:  ServicesEnv,
+				Value: `[{"service":"db","path":"/services/db","envprefix":"DB_"}]`,
+			}), container.Name)
+		}
+
+		found, err := RemoveBinding(spec, "db")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(spec.Volumes).To(BeEmpty())
+		for _, container := range spec.Containers {
+			Expect(container.VolumeMounts).To(BeEmpty(), container.Name)
+			Expect(container.EnvFrom).To(BeEmpty(), container.Name)
+			Expect(container.Env).ToNot(ContainElement(WithTransform(func(e corev1.EnvVar) string {
+				return e.Name
+			}, Equal(ServicesEnv))), container.Name)
+		}
+	})
+
+	It("rejects double bindings and clashing prefixes", func() {
+		Expect(AddBinding(spec, "my-db", "db-secret", BindAsEnv)).To(Succeed())
+
+		Expect(AddBinding(spec, "my-db", "db-secret", BindAsFiles)).To(MatchError("service already bound"))
+		Expect(AddBinding(spec, "my_db", "other-secret", BindAsEnv)).To(
+			MatchError(ContainSubstring("already used by service 'my-db'")))
+	})
+
+	It("replaces the secret of a binding, keeping the way it is bound", func() {
+		Expect(AddBinding(spec, "db", "db-secret", BindAsBoth)).To(Succeed())
+		Expect(AddBinding(spec, "queue", "queue-secret", BindAsFiles)).To(Succeed())
+
+		Expect(BindingSecret(spec, "db")).To(Equal("db-secret"))
+		Expect(ReplaceBindingSecret(spec, "db", "db-secret.rotated")).To(Succeed())
+		Expect(BindingSecret(spec, "db")).To(Equal("db-secret.rotated"))
+
+		Expect(spec.Volumes[0].Secret.SecretName).To(Equal("db-secret.rotated"))
+		Expect(spec.Volumes[1].Secret.SecretName).To(Equal("queue-secret"))
+		Expect(spec.Containers[0].EnvFrom[0].SecretRef.Name).To(Equal("db-secret.rotated"))
+		Expect(servicesEnv()).To(MatchJSON(`[{"service":"db","path":"/services/db","envprefix":"DB_"},
+			{"service":"queue","path":"/services/queue"}]`))
+
+		Expect(ReplaceBindingSecret(spec, "cache", "cache-secret")).To(
+			MatchError("service is not bound to the application"))
+	})
+
+	It("reports mounts made without description as bindings as files", func() {
+		secretVolume := func(name, secretName string) corev1.Volume {
+			return corev1.Volume{
+				Name: name,
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{SecretName: secretName},
+				},
+			}
+		}
+		spec.Volumes = []corev1.Volume{
+			secretVolume("old", "service.org-workspace.svc-old"),
+			secretVolume("db", "service.org-workspace.svc-db.app-myapp"),
+			secretVolume("tls", "myapp-tls"),
+			secretVolume("other", "service.org-workspace.svc-db"),
+		}
+
+		bindings, err := Bindings(spec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bindings).To(Equal([]Binding{
+			{Service: "old", Path: "/services/old"},
+			{Service: "db", Path: "/services/db"},
+		}))
+	})
+
+	It("lists the services which failed to bind", func() {
+		failure := &BindingFailure{Failed: map[string]error{
+			"queue": errors.New("not provisioned"),
+			"db":    errors.New("service already bound"),
+		}}
+		Expect(failure.Error()).To(Equal(
+			"failed to bind services: db: service already bound; queue: not provisioned"))
+	})
+})
@@ -0,0 +1,212 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	v1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// buildSecretKey is the form of valid build secret names. They become
+// environment variables of the build.
+var buildSecretKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func buildSecretsResourceName(app models.AppRef) string {
+	return fmt.Sprintf("buildsecrets.org-%s.app-%s", app.Org, app.Name)
+}
+
+// StagingSecretName returns the name of the secret holding the copy of the
+// build secrets made for the staging run
+func StagingSecretName(stageID string) string {
+	return fmt.Sprintf("build-secrets-%s", stageID)
+}
+
+// ValidBuildSecretKey checks that the key can be used as an environment
+// variable name
+func ValidBuildSecretKey(key string) bool {
+	return buildSecretKey.MatchString(key)
+}
+
+// BuildSecrets returns the build secrets of the application. The map is empty
+// if there are none.
+func BuildSecrets(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef) (map[string][]byte, error) {
+	secret, err := cluster.GetSecret(ctx, appRef.Org, buildSecretsResourceName(appRef))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return map[string][]byte{}, nil
+		}
+		return nil, err
+	}
+
+	if secret.Data == nil {
+		return map[string][]byte{}, nil
+	}
+	return secret.Data, nil
+}
+
+// BuildSecretKeys returns the sorted names of the application's build secrets
+func BuildSecretKeys(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef) ([]string, error) {
+	secrets, err := BuildSecrets(ctx, cluster, appRef)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for key := range secrets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// SetBuildSecret sets the build secret of the application, creating the
+// secret resource on first use. The resource is owned by the application.
+func SetBuildSecret(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef, key, value string) error {
+	client := cluster.Kubectl.CoreV1().Secrets(appRef.Org)
+
+	secret, err := client.Get(ctx, buildSecretsResourceName(appRef), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[key] = []byte(value)
+		_, err = client.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	}
+
+	app, err := Get(ctx, cluster, appRef)
+	if err != nil {
+		return err
+	}
+
+	return cluster.CreateSecret(ctx, appRef.Org, corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: buildSecretsResourceName(appRef),
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       appRef.Name,
+				"app.kubernetes.io/part-of":    appRef.Org,
+				"app.kubernetes.io/component":  "build-secrets",
+				"app.kubernetes.io/managed-by": "epinio",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: app.GetAPIVersion(),
+					Kind:       app.GetKind(),
+					Name:       app.GetName(),
+					UID:        app.GetUID(),
+				},
+			},
+		},
+		Data: map[string][]byte{key: []byte(value)},
+	})
+}
+
+// UnsetBuildSecret removes the build secret of the application. Removing an
+// unknown key is not an error.
+func UnsetBuildSecret(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef, key string) error {
+	client := cluster.Kubectl.CoreV1().Secrets(appRef.Org)
+
+	secret, err := client.Get(ctx, buildSecretsResourceName(appRef), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if _, ok := secret.Data[key]; !ok {
+		return nil
+	}
+
+	delete(secret.Data, key)
+	_, err = client.Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+// CreateStagingSecret copies the build secrets into the staging namespace,
+// for the buildpacks task of the staging run to mount. The copy exists before
+// the run, so that the staging logs are redacted from the start. It has no
+// owner until AdoptStagingSecret hands it to the run, the application lives
+// in another namespace.
+func CreateStagingSecret(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef, stageID string, data map[string][]byte) error {
+	return cluster.CreateSecret(ctx, deployments.TektonStagingNamespace, corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: StagingSecretName(stageID),
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       appRef.Name,
+				"app.kubernetes.io/part-of":    appRef.Org,
+				"app.kubernetes.io/component":  "build-secrets",
+				"app.kubernetes.io/managed-by": "epinio",
+				models.EpinioStageIDLabel:      stageID,
+			},
+		},
+		Data: data,
+	})
+}
+
+// AdoptStagingSecret makes the staging run the owner of its copy of the build
+// secrets, so that it goes away with the run.
+func AdoptStagingSecret(ctx context.Context, cluster *kubernetes.Cluster, run *v1beta1.PipelineRun) error {
+	client := cluster.Kubectl.CoreV1().Secrets(deployments.TektonStagingNamespace)
+
+	secret, err := client.Get(ctx, StagingSecretName(run.Name), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	secret.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+			Kind:       "PipelineRun",
+			Name:       run.Name,
+			UID:        run.UID,
+		},
+	}
+	_, err = client.Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+// DeleteStagingSecret removes the copy of the build secrets made for a
+// staging which did not start
+func DeleteStagingSecret(ctx context.Context, cluster *kubernetes.Cluster, stageID string) error {
+	err := cluster.Kubectl.CoreV1().Secrets(deployments.TektonStagingNamespace).Delete(ctx, StagingSecretName(stageID), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// stagingSecretValues returns the build secret values used by the staging
+// run, for redaction from its logs
+func stagingSecretValues(ctx context.Context, cluster *kubernetes.Cluster, stageID string) ([]string, error) {
+	secret, err := cluster.GetSecret(ctx, deployments.TektonStagingNamespace, StagingSecretName(stageID))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	values := []string{}
+	for _, value := range secret.Data {
+		if len(value) > 0 {
+			values = append(values, string(value))
+		}
+	}
+	// Longest first, a value containing another is redacted as a whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	return values, nil
+}
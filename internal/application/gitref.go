@@ -0,0 +1,51 @@
+package application
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// gitRefPattern are the characters allowed in the revision of a git
+// source. It is a subset of what git allows in ref names, and includes
+// commit hashes.
+var gitRefPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/+-]*$`)
+
+// gitURLSchemes are the schemes of git sources the staging clones from
+var gitURLSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+	"git":   true,
+	"ssh":   true,
+}
+
+// ValidGitRevision returns true if the revision is a commit hash or a valid
+// ref name. The revision ends up in the arguments of git in the staging
+// pod, anything else is rejected.
+func ValidGitRevision(revision string) bool {
+	if !gitRefPattern.MatchString(revision) {
+		return false
+	}
+	if strings.Contains(revision, "..") || strings.Contains(revision, "//") ||
+		strings.HasSuffix(revision, "/") || strings.HasSuffix(revision, ".") {
+		return false
+	}
+	for _, component := range strings.Split(revision, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidGitURL returns true if the source is the URL of a git repository
+// the staging can clone from.
+func ValidGitURL(source string) bool {
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+
+	return gitURLSchemes[u.Scheme] && u.Host != "" && !strings.HasPrefix(u.Host, "-")
+}
@@ -0,0 +1,46 @@
+package application_test
+
+import (
+	. "github.com/epinio/epinio/internal/application"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidGitRevision", func() {
+	It("accepts commit hashes and ref names", func() {
+		Expect(ValidGitRevision("3f2a9c1")).To(BeTrue())
+		Expect(ValidGitRevision("0123456789abcdef0123456789abcdef01234567")).To(BeTrue())
+		Expect(ValidGitRevision("main")).To(BeTrue())
+		Expect(ValidGitRevision("release/v1.2")).To(BeTrue())
+	})
+
+	It("rejects shell and option injection", func() {
+		Expect(ValidGitRevision("")).To(BeFalse())
+		Expect(ValidGitRevision("main; rm -rf /")).To(BeFalse())
+		Expect(ValidGitRevision("$(id)")).To(BeFalse())
+		Expect(ValidGitRevision("--upload-pack=sh")).To(BeFalse())
+	})
+
+	It("rejects invalid ref names", func() {
+		Expect(ValidGitRevision("a..b")).To(BeFalse())
+		Expect(ValidGitRevision("main@{1}")).To(BeFalse())
+		Expect(ValidGitRevision("release/")).To(BeFalse())
+		Expect(ValidGitRevision("release/.hidden")).To(BeFalse())
+		Expect(ValidGitRevision("main.lock")).To(BeFalse())
+	})
+})
+
+var _ = Describe("ValidGitURL", func() {
+	It("accepts repository URLs", func() {
+		Expect(ValidGitURL("https://github.com/epinio/epinio.git")).To(BeTrue())
+		Expect(ValidGitURL("http://gitea-http.gitea:10080")).To(BeTrue())
+		Expect(ValidGitURL("ssh://git@example.com/repo.git")).To(BeTrue())
+	})
+
+	It("rejects other sources", func() {
+		Expect(ValidGitURL("")).To(BeFalse())
+		Expect(ValidGitURL("--upload-pack=sh")).To(BeFalse())
+		Expect(ValidGitURL("file:///etc")).To(BeFalse())
+		Expect(ValidGitURL("https://x\"; id; \"")).To(BeFalse())
+	})
+})
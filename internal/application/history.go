@@ -0,0 +1,88 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	pkgerrors "github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// historyAnnotation is the annotation of the application resource
+	// holding the JSON encoded list of recent stagings
+	historyAnnotation = "epinio.suse.org/staging-history"
+
+	// historyLimit is the number of stagings kept in the history
+	historyLimit = 10
+)
+
+// History returns the recorded stagings of the application, most recent
+// first.
+func History(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef) ([]models.StagingRecord, error) {
+	app, err := Get(ctx, cluster, appRef)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeHistory(app.GetAnnotations()[historyAnnotation])
+}
+
+// RecordStaging adds the staging to the front of the application's history,
+// dropping the oldest entries beyond the limit.
+func RecordStaging(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef, record models.StagingRecord) error {
+	client, err := cluster.ClientApp()
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		app, err := Get(ctx, cluster, appRef)
+		if err != nil {
+			return err
+		}
+
+		annotations := app.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		history, err := decodeHistory(annotations[historyAnnotation])
+		if err != nil {
+			return err
+		}
+
+		history = append([]models.StagingRecord{record}, history...)
+		if len(history) > historyLimit {
+			history = history[:historyLimit]
+		}
+
+		js, err := json.Marshal(history)
+		if err != nil {
+			return err
+		}
+		annotations[historyAnnotation] = string(js)
+		app.SetAnnotations(annotations)
+
+		_, err = client.Namespace(appRef.Org).Update(ctx, app, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func decodeHistory(value string) ([]models.StagingRecord, error) {
+	history := []models.StagingRecord{}
+	if value == "" {
+		return history, nil
+	}
+
+	err := json.Unmarshal([]byte(value), &history)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to decode staging history")
+	}
+
+	return history, nil
+}
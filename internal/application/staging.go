@@ -0,0 +1,167 @@
+package application
+
+import (
+	"context"
+	"strings"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/kubernetes/tailer"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	v1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+// stagingFailureLogLines is the number of log lines of the failing step
+// reported with a staging failure
+const stagingFailureLogLines = int64(20)
+
+// StagingStatus returns the state of the application's staging run. For a
+// failed run the failing task and step are located and the failure is
+// classified. A NotFound error is returned if the run does not exist, or
+// belongs to another application.
+func StagingStatus(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef, stageID string) (*models.StageStatusResponse, error) {
+	cs, err := versioned.NewForConfig(cluster.RestConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client := cs.TektonV1beta1().PipelineRuns(deployments.TektonStagingNamespace)
+
+	pr, err := client.Get(ctx, stageID, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if pr.Labels["app.kubernetes.io/name"] != appRef.Name || pr.Labels["app.kubernetes.io/part-of"] != appRef.Org {
+		return nil, apierrors.NewNotFound(v1beta1.Resource("pipelineruns"), stageID)
+	}
+
+	status := &models.StageStatusResponse{Stage: models.NewStage(stageID)}
+
+	cond := pr.Status.GetCondition(apis.ConditionSucceeded)
+	switch {
+	case cond == nil || cond.IsUnknown():
+		status.Status = models.StagingRunning
+	case cond.IsTrue():
+		status.Status = models.StagingSucceeded
+	default:
+		status.Status = models.StagingFailed
+		status.Failure = StagingFailure(ctx, cluster, pr, cond)
+	}
+
+	return status, nil
+}
+
+// StagingFailure collects the details of the failed PipelineRun from its
+// failed TaskRun and the first step of it which exited with an error. The
+// build secrets of the run are redacted from the logs of the step.
+func StagingFailure(ctx context.Context, cluster *kubernetes.Cluster, pr *v1beta1.PipelineRun, cond *apis.Condition) *models.StagingFailure {
+	failure := &models.StagingFailure{Message: cond.Message}
+	reason := cond.Reason
+
+	for _, tr := range pr.Status.TaskRuns {
+		if tr.Status == nil {
+			continue
+		}
+		trCond := tr.Status.GetCondition(apis.ConditionSucceeded)
+		if trCond == nil || !trCond.IsFalse() {
+			continue
+		}
+
+		failure.Task = tr.PipelineTaskName
+		failure.Message = trCond.Message
+		if trCond.Reason == string(v1beta1.TaskRunReasonTimedOut) {
+			reason = trCond.Reason
+		}
+
+		for _, step := range tr.Status.Steps {
+			if step.Terminated == nil || step.Terminated.ExitCode == 0 {
+				continue
+			}
+			failure.Step = step.Name
+			failure.ExitCode = step.Terminated.ExitCode
+			failure.Logs = stepLogs(ctx, cluster, pr.Name, tr.Status.PodName, step.ContainerName)
+			break
+		}
+		break
+	}
+
+	failure.Reason = ClassifyStagingFailure(reason, failure.Message, failure.Logs)
+
+	return failure
+}
+
+// stepLogs returns the last lines of the step's log, with the build secrets
+// of the run redacted. The pod may be gone already, in which case there are
+// no logs to report. Without the secret values no logs are reported either,
+// rather than risking to show them.
+func stepLogs(ctx context.Context, cluster *kubernetes.Cluster, stageID, podName, container string) []string {
+	if podName == "" {
+		return nil
+	}
+
+	redact, err := stagingSecretValues(ctx, cluster, stageID)
+	if err != nil {
+		return nil
+	}
+
+	tail := stagingFailureLogLines
+	raw, err := cluster.Kubectl.CoreV1().Pods(deployments.TektonStagingNamespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container: container,
+		TailLines: &tail,
+	}).DoRaw(ctx)
+	if err != nil {
+		return nil
+	}
+
+	text := strings.TrimRight(string(raw), "\n")
+	if text == "" {
+		return nil
+	}
+	if len(redact) > 0 {
+		pairs := []string{}
+		for _, value := range redact {
+			pairs = append(pairs, value, tailer.RedactedPlaceholder)
+		}
+		text = strings.NewReplacer(pairs...).Replace(text)
+	}
+
+	return strings.Split(text, "\n")
+}
+
+// ClassifyStagingFailure maps the condition reason and message of a failed
+// staging run, and the log of the failing step, to one of the known failure
+// reasons.
+func ClassifyStagingFailure(reason, message string, logs []string) string {
+	if reason == string(v1beta1.PipelineRunReasonTimedOut) || reason == string(v1beta1.TaskRunReasonTimedOut) {
+		return models.StagingFailureTimeout
+	}
+
+	text := strings.ToLower(message + "\n" + strings.Join(logs, "\n"))
+	contains := func(needles ...string) bool {
+		for _, needle := range needles {
+			if strings.Contains(text, needle) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case contains("no space left on device", "ephemeral-storage"):
+		return models.StagingFailureOutOfDisk
+	case contains("no buildpack groups passed detection"):
+		return models.StagingFailureNoBuildpack
+	// Checked before image push, an auth failure fails the push too
+	case contains("unauthorized", "authentication required", "denied:"):
+		return models.StagingFailureRegistryAuth
+	case contains("failed to write image", "failed to export", "error pushing", "failed to push"):
+		return models.StagingFailureImagePush
+	}
+
+	return models.StagingFailureUnknown
+}
@@ -0,0 +1,107 @@
+package application_test
+
+import (
+	"context"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/kubernetes/tailer"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	. "github.com/epinio/epinio/internal/application"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"knative.dev/pkg/apis"
+)
+
+var _ = Describe("ClassifyStagingFailure", func() {
+	It("recognizes timeouts by the condition reason", func() {
+		Expect(ClassifyStagingFailure("PipelineRunTimeout", "", nil)).To(Equal(models.StagingFailureTimeout))
+		Expect(ClassifyStagingFailure("TaskRunTimeout", "", nil)).To(Equal(models.StagingFailureTimeout))
+	})
+
+	It("recognizes a failed buildpack detection", func() {
+		logs := []string{"======== Results ========", "ERROR: No buildpack groups passed detection."}
+		Expect(ClassifyStagingFailure("Failed", "", logs)).To(Equal(models.StagingFailureNoBuildpack))
+	})
+
+	It("prefers registry auth over image push", func() {
+		logs := []string{"ERROR: failed to export: failed to write image to the following tags: UNAUTHORIZED: authentication required"}
+		Expect(ClassifyStagingFailure("Failed", "", logs)).To(Equal(models.StagingFailureRegistryAuth))
+	})
+
+	It("recognizes image push failures", func() {
+		logs := []string{"ERROR: failed to export: failed to write image to the following tags: connection refused"}
+		Expect(ClassifyStagingFailure("Failed", "", logs)).To(Equal(models.StagingFailureImagePush))
+	})
+
+	It("recognizes an evicted pod", func() {
+		message := "The node was low on resource: ephemeral-storage."
+		Expect(ClassifyStagingFailure("Failed", message, nil)).To(Equal(models.StagingFailureOutOfDisk))
+	})
+
+	It("falls back to unknown", func() {
+		Expect(ClassifyStagingFailure("Failed", "exit status 1", []string{"boom"})).To(Equal(models.StagingFailureUnknown))
+	})
+})
+
+var _ = Describe("StagingFailure", func() {
+	failedRun := func() *v1beta1.PipelineRun {
+		tr := &v1beta1.TaskRunStatus{}
+		tr.SetCondition(&apis.Condition{
+			Type:    apis.ConditionSucceeded,
+			Status:  corev1.ConditionFalse,
+			Reason:  "Failed",
+			Message: "step build failed",
+		})
+		tr.PodName = "stage-pod"
+		tr.Steps = []v1beta1.StepState{
+			{
+				Name:          "build",
+				ContainerName: "step-build",
+				ContainerState: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+				},
+			},
+		}
+
+		return &v1beta1.PipelineRun{
+			ObjectMeta: metav1.ObjectMeta{Name: "stage-id"},
+			Status: v1beta1.PipelineRunStatus{
+				PipelineRunStatusFields: v1beta1.PipelineRunStatusFields{
+					TaskRuns: map[string]*v1beta1.PipelineRunTaskRunStatus{
+						"stage-id-stage": {PipelineTaskName: "stage", Status: tr},
+					},
+				},
+			},
+		}
+	}
+	cond := &apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionFalse, Reason: "Failed"}
+
+	It("redacts the build secrets from the logs of the failed step", func() {
+		// The fake clientset answers every log request with "fake logs"
+		cluster := &kubernetes.Cluster{Kubectl: fake.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      StagingSecretName("stage-id"),
+				Namespace: deployments.TektonStagingNamespace,
+			},
+			Data: map[string][]byte{"TOKEN": []byte("fake")},
+		})}
+
+		failure := StagingFailure(context.Background(), cluster, failedRun(), cond)
+		Expect(failure.Task).To(Equal("stage"))
+		Expect(failure.Step).To(Equal("build"))
+		Expect(failure.ExitCode).To(Equal(int32(1)))
+		Expect(failure.Logs).To(Equal([]string{tailer.RedactedPlaceholder + " logs"}))
+	})
+
+	It("reports the logs as they are without build secrets", func() {
+		cluster := &kubernetes.Cluster{Kubectl: fake.NewSimpleClientset()}
+
+		failure := StagingFailure(context.Background(), cluster, failedRun(), cond)
+		Expect(failure.Logs).To(Equal([]string{"fake logs"}))
+	})
+})
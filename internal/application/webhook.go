@@ -0,0 +1,212 @@
+package application
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/randstr"
+	"github.com/epinio/epinio/internal/api/v1/models"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultWebhookBranch is the branch used when enabling a webhook without
+// specifying one
+const DefaultWebhookBranch = "main"
+
+// Webhook is the push-to-deploy configuration of an application. It is
+// stored in a secret in the org namespace, owned by the application resource.
+type Webhook struct {
+	Secret string
+	Branch string
+}
+
+// PushEvent is the provider independent part of a git push notification.
+// Ignored is the reason a push is not deployed, for pushes of tags and
+// deletions of branches.
+type PushEvent struct {
+	Branch   string
+	Revision string
+	URL      string
+	Ignored  string
+}
+
+// ErrWebhookSignature is returned when a webhook payload fails verification
+var ErrWebhookSignature = errors.New("webhook signature verification failed")
+
+func webhookResourceName(app models.AppRef) string {
+	return fmt.Sprintf("webhook.org-%s.app-%s", app.Org, app.Name)
+}
+
+// LookupWebhook returns the webhook configuration of the application, or nil
+// if no webhook is enabled.
+func LookupWebhook(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef) (*Webhook, error) {
+	secret, err := cluster.GetSecret(ctx, appRef.Org, webhookResourceName(appRef))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &Webhook{
+		Secret: string(secret.Data["secret"]),
+		Branch: string(secret.Data["branch"]),
+	}, nil
+}
+
+// EnableWebhook generates a fresh secret for the application's webhook and
+// sets the branch to deploy from. An existing webhook is replaced.
+func EnableWebhook(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef, branch string) (*Webhook, error) {
+	app, err := Get(ctx, cluster, appRef)
+	if err != nil {
+		return nil, err
+	}
+
+	if branch == "" {
+		branch = DefaultWebhookBranch
+	}
+
+	token, err := randstr.Hex16()
+	if err != nil {
+		return nil, err
+	}
+
+	err = DisableWebhook(ctx, cluster, appRef)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cluster.CreateSecret(ctx, appRef.Org, corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: webhookResourceName(appRef),
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       appRef.Name,
+				"app.kubernetes.io/part-of":    appRef.Org,
+				"app.kubernetes.io/component":  "webhook",
+				"app.kubernetes.io/managed-by": "epinio",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: app.GetAPIVersion(),
+					Kind:       app.GetKind(),
+					Name:       app.GetName(),
+					UID:        app.GetUID(),
+				},
+			},
+		},
+		StringData: map[string]string{
+			"secret": token,
+			"branch": branch,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Webhook{Secret: token, Branch: branch}, nil
+}
+
+// DisableWebhook removes the webhook configuration of the application, if any.
+func DisableWebhook(ctx context.Context, cluster *kubernetes.Cluster, appRef models.AppRef) error {
+	err := cluster.Kubectl.CoreV1().Secrets(appRef.Org).Delete(ctx, webhookResourceName(appRef), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// ParsePushEvent verifies the payload against the webhook secret and extracts
+// the pushed branch and revision. GitHub, GitLab and Gitea payloads are
+// supported. A nil event without error is returned for events which are not
+// pushes, e.g. GitHub's initial ping. Pushes which are not deployed are
+// returned with the reason in Ignored.
+func ParsePushEvent(header http.Header, body []byte, secret string) (*PushEvent, error) {
+	var event string
+
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		// Gitea also sends the GitHub headers, check it first
+		event = header.Get("X-Gitea-Event")
+		if !validHMAC(body, secret, header.Get("X-Gitea-Signature")) {
+			return nil, ErrWebhookSignature
+		}
+	case header.Get("X-GitHub-Event") != "":
+		event = header.Get("X-GitHub-Event")
+		signature := strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !validHMAC(body, secret, signature) {
+			return nil, ErrWebhookSignature
+		}
+	case header.Get("X-Gitlab-Event") != "":
+		// GitLab does not sign the payload, it sends the secret token as is
+		event = header.Get("X-Gitlab-Event")
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return nil, ErrWebhookSignature
+		}
+	default:
+		return nil, errors.New("unknown webhook provider")
+	}
+
+	if event != "push" && event != "Push Hook" {
+		return nil, nil
+	}
+
+	var payload struct {
+		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Repository struct {
+			CloneURL   string `json:"clone_url"`
+			GitHTTPURL string `json:"git_http_url"`
+		} `json:"repository"`
+	}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(payload.Ref, "refs/heads/") {
+		return &PushEvent{Ignored: fmt.Sprintf("push of '%s' is not a branch push", payload.Ref)}, nil
+	}
+	branch := strings.TrimPrefix(payload.Ref, "refs/heads/")
+
+	// The new revision of a deleted branch is all zeros
+	if strings.Trim(payload.After, "0") == "" {
+		return &PushEvent{Branch: branch, Ignored: fmt.Sprintf("branch '%s' deleted", branch)}, nil
+	}
+
+	url := payload.Repository.CloneURL
+	if url == "" {
+		url = payload.Repository.GitHTTPURL
+	}
+
+	return &PushEvent{
+		Branch:   branch,
+		Revision: payload.After,
+		URL:      url,
+	}, nil
+}
+
+// validHMAC checks the hex encoded signature against the HMAC-SHA256 of the
+// body, keyed by the secret.
+func validHMAC(body []byte, secret, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
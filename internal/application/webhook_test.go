@@ -0,0 +1,103 @@
+package application_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	. "github.com/epinio/epinio/internal/application"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParsePushEvent", func() {
+	body := []byte(`{"ref":"refs/heads/main","after":"abc123","repository":{"clone_url":"https://example.com/repo.git"}}`)
+
+	sign := func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	It("accepts a signed GitHub push", func() {
+		header := http.Header{}
+		header.Set("X-GitHub-Event", "push")
+		header.Set("X-Hub-Signature-256", "sha256="+sign("s3cr3t"))
+
+		event, err := ParsePushEvent(header, body, "s3cr3t")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event).To(Equal(&PushEvent{
+			Branch:   "main",
+			Revision: "abc123",
+			URL:      "https://example.com/repo.git",
+		}))
+	})
+
+	It("rejects a GitHub push signed with another secret", func() {
+		header := http.Header{}
+		header.Set("X-GitHub-Event", "push")
+		header.Set("X-Hub-Signature-256", "sha256="+sign("other"))
+
+		_, err := ParsePushEvent(header, body, "s3cr3t")
+		Expect(err).To(Equal(ErrWebhookSignature))
+	})
+
+	It("accepts a signed Gitea push", func() {
+		header := http.Header{}
+		header.Set("X-Gitea-Event", "push")
+		header.Set("X-GitHub-Event", "push")
+		header.Set("X-Gitea-Signature", sign("s3cr3t"))
+
+		event, err := ParsePushEvent(header, body, "s3cr3t")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event.Revision).To(Equal("abc123"))
+	})
+
+	It("checks the GitLab token", func() {
+		header := http.Header{}
+		header.Set("X-Gitlab-Event", "Push Hook")
+		header.Set("X-Gitlab-Token", "wrong")
+
+		_, err := ParsePushEvent(header, body, "s3cr3t")
+		Expect(err).To(Equal(ErrWebhookSignature))
+
+		header.Set("X-Gitlab-Token", "s3cr3t")
+		event, err := ParsePushEvent(header, body, "s3cr3t")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event.Branch).To(Equal("main"))
+	})
+
+	It("ignores events which are not pushes", func() {
+		header := http.Header{}
+		header.Set("X-GitHub-Event", "ping")
+		header.Set("X-Hub-Signature-256", "sha256="+sign("s3cr3t"))
+
+		event, err := ParsePushEvent(header, body, "s3cr3t")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event).To(BeNil())
+	})
+})
+
+var _ = Describe("ParsePushEvent of pushes which are not deployed", func() {
+	parse := func(body string) (*PushEvent, error) {
+		header := http.Header{}
+		header.Set("X-Gitlab-Event", "Push Hook")
+		header.Set("X-Gitlab-Token", "s3cr3t")
+		return ParsePushEvent(header, []byte(body), "s3cr3t")
+	}
+
+	It("ignores tag pushes", func() {
+		event, err := parse(`{"ref":"refs/tags/v1.0","after":"abc123"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event.Ignored).To(ContainSubstring("not a branch push"))
+	})
+
+	It("ignores deleted branches", func() {
+		event, err := parse(`{"ref":"refs/heads/main","after":"0000000000000000000000000000000000000000"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event.Branch).To(Equal("main"))
+		Expect(event.Ignored).To(Equal("branch 'main' deleted"))
+		Expect(event.Revision).To(BeEmpty())
+	})
+})
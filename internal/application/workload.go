@@ -4,18 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/epinio/epinio/helpers/kubernetes"
 	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/duration"
 	"github.com/epinio/epinio/internal/interfaces"
 	"github.com/epinio/epinio/internal/services"
 
 	pkgerrors "github.com/pkg/errors"
 
 	appsv1 "k8s.io/api/apps/v1"
-	corev1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/util/retry"
 )
 
@@ -37,10 +40,15 @@ func (a *Workload) Services(ctx context.Context) (interfaces.ServiceList, error)
 		return nil, err
 	}
 
+	bindings, err := Bindings(&deployment.Spec.Template.Spec)
+	if err != nil {
+		return nil, err
+	}
+
 	var bound = interfaces.ServiceList{}
 
-	for _, volume := range deployment.Spec.Template.Spec.Volumes {
-		service, err := services.Lookup(ctx, a.cluster, a.app.Org, volume.Name)
+	for _, binding := range bindings {
+		service, err := services.Lookup(ctx, a.cluster, a.app.Org, binding.Service)
 		if err != nil {
 			return nil, err
 		}
@@ -70,6 +78,28 @@ func (a *Workload) Scale(ctx context.Context, instances int32) error {
 	})
 }
 
+// Restart rolls the pods of the application over, like `kubectl rollout
+// restart`. The pods see the current data of the bound services afterwards.
+func (a *Workload) Restart(ctx context.Context) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployment, err := a.deployment(ctx)
+		if err != nil {
+			return err
+		}
+
+		if deployment.Spec.Template.ObjectMeta.Annotations == nil {
+			deployment.Spec.Template.ObjectMeta.Annotations = map[string]string{}
+		}
+		deployment.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] =
+			time.Now().Format(time.RFC3339)
+
+		_, err = a.cluster.Kubectl.AppsV1().Deployments(a.app.Org).Update(
+			ctx, deployment, metav1.UpdateOptions{})
+
+		return err
+	})
+}
+
 // UnbindAll dissolves all bindings from the application.
 func (a *Workload) UnbindAll(ctx context.Context, cluster *kubernetes.Cluster, svcs []string) error {
 	for _, bonded := range svcs {
@@ -88,61 +118,92 @@ func (a *Workload) UnbindAll(ctx context.Context, cluster *kubernetes.Cluster, s
 
 // Unbind dissolves the binding of the service to the application.
 func (a *Workload) Unbind(ctx context.Context, service interfaces.Service) error {
-	for {
-		deployment, err := a.deployment(ctx)
+	err := a.updateDeployment(ctx, func(deployment *appsv1.Deployment) error {
+		found, err := RemoveBinding(&deployment.Spec.Template.Spec, service.Name())
 		if err != nil {
 			return err
 		}
-
-		volumes := deployment.Spec.Template.Spec.Volumes
-		newVolumes := []corev1.Volume{}
-		found := false
-		for _, volume := range volumes {
-			if volume.Name == service.Name() {
-				found = true
-			} else {
-				newVolumes = append(newVolumes, volume)
-			}
-		}
 		if !found {
 			return errors.New("service is not bound to the application")
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		// TODO: Iterate over containers and find the one matching the app name
-		volumeMounts := deployment.Spec.Template.Spec.Containers[0].VolumeMounts
-		newVolumeMounts := []corev1.VolumeMount{}
-		found = false
-		for _, mount := range volumeMounts {
-			if mount.Name == service.Name() {
-				found = true
-			} else {
-				newVolumeMounts = append(newVolumeMounts, mount)
-			}
-		}
-		if !found {
-			return errors.New("service is not bound to the application")
-		}
+	// delete binding - DeleteBinding(a.Name)
+	return service.DeleteBinding(ctx, a.app.Name, a.app.Org)
+}
 
-		deployment.Spec.Template.Spec.Volumes = newVolumes
-		deployment.Spec.Template.Spec.Containers[0].VolumeMounts = newVolumeMounts
+// ErrNoCredentials is returned by RotateCredentials for services which do
+// not issue credentials per application binding, like custom services
+var ErrNoCredentials = interfaces.ErrNoCredentials
+
+// RotateCredentials replaces the credentials of the service's binding to the
+// application. A new binding is created next to the current one, and the
+// application is rolled over to it. The old binding is removed after the
+// rollout completed, so that no instance is left with revoked credentials.
+func (a *Workload) RotateCredentials(ctx context.Context, service interfaces.Service) error {
+	rotator, ok := service.(interfaces.CredentialRotator)
+	if !ok {
+		return ErrNoCredentials
+	}
 
-		_, err = a.cluster.Kubectl.AppsV1().Deployments(a.app.Org).Update(
-			ctx,
-			deployment,
-			metav1.UpdateOptions{},
-		)
-		if err == nil {
-			break
-		}
-		if !apierrors.IsConflict(err) {
-			return err
+	deployment, err := a.deployment(ctx)
+	if err != nil {
+		return err
+	}
+	current, err := BindingSecret(&deployment.Spec.Template.Spec, service.Name())
+	if err != nil {
+		return err
+	}
+
+	secret, err := rotator.RotateBinding(ctx, a.app.Name, current)
+	if err != nil {
+		return err
+	}
+
+	var generation int64
+	err = a.updateDeployment(ctx, func(deployment *appsv1.Deployment) error {
+		generation = deployment.Generation + 1
+		return ReplaceBindingSecret(&deployment.Spec.Template.Spec, service.Name(), secret.Name)
+	})
+	if err != nil {
+		if err := rotator.DeleteRotatedBinding(ctx, a.app.Name, secret.Name); err != nil {
+			return pkgerrors.Wrap(err, "failed to remove new binding")
 		}
+		return err
+	}
 
-		// Found a conflict. Try again from the beginning.
+	err = a.waitForRollout(ctx, generation)
+	if err != nil {
+		return pkgerrors.Wrap(err, "application did not roll over to the new credentials, old binding kept")
 	}
 
-	// delete binding - DeleteBinding(a.Name)
-	return service.DeleteBinding(ctx, a.app.Name, a.app.Org)
+	return rotator.DeleteRotatedBinding(ctx, a.app.Name, current)
+}
+
+// waitForRollout waits until all instances of the application run the pod
+// template of the given generation of the deployment, or later
+func (a *Workload) waitForRollout(ctx context.Context, generation int64) error {
+	return wait.PollImmediate(time.Second, duration.ToDeployment(), func() (bool, error) {
+		deployment, err := a.deployment(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		replicas := int32(1)
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+		status := deployment.Status
+
+		return status.ObservedGeneration >= generation &&
+			status.UpdatedReplicas == replicas &&
+			status.Replicas == replicas &&
+			status.AvailableReplicas == replicas, nil
+	})
 }
 
 func (a *Workload) deployment(ctx context.Context) (*appsv1.Deployment, error) {
@@ -151,63 +212,140 @@ func (a *Workload) deployment(ctx context.Context) (*appsv1.Deployment, error) {
 	)
 }
 
-// Bind creates a binding of the service to the application.
-func (a *Workload) Bind(ctx context.Context, service interfaces.Service) error {
-	bindSecret, err := service.GetBinding(ctx, a.app.Name)
+// BindingFailure reports the services which could not be bound, with the
+// reasons. None of the requested services were bound.
+type BindingFailure struct {
+	Failed map[string]error
+}
+
+func (f *BindingFailure) Error() string {
+	names := make([]string, 0, len(f.Failed))
+	for name := range f.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reasons := []string{}
+	for _, name := range names {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", name, f.Failed[name].Error()))
+	}
+	return "failed to bind services: " + strings.Join(reasons, "; ")
+}
+
+// Bind creates bindings of the services to the application, in a single
+// update of the deployment, so that the application restarts once. The
+// binding data is mounted as files, injected as environment variables, or
+// both, as selected by one of the BindModes. Services which are bound
+// already are skipped, and returned. Either all other services are bound,
+// or none, with a BindingFailure listing the services which failed.
+func (a *Workload) Bind(ctx context.Context, svcs interfaces.ServiceList, as string) ([]string, error) {
+	if !ValidBindMode(as) {
+		return nil, fmt.Errorf("unknown binding mode '%s'", as)
+	}
+
+	deployment, err := a.deployment(ctx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	bindings, err := Bindings(&deployment.Spec.Template.Spec)
+	if err != nil {
+		return nil, err
+	}
+	bound := map[string]bool{}
+	for _, binding := range bindings {
+		bound[binding.Service] = true
 	}
 
-	for {
-		deployment, err := a.deployment(ctx)
-		if err != nil {
-			return err
+	var wasBound []string
+	toBind := interfaces.ServiceList{}
+	requested := map[string]bool{}
+	for _, service := range svcs {
+		switch {
+		case requested[service.Name()]:
+		case bound[service.Name()]:
+			wasBound = append(wasBound, service.Name())
+		default:
+			toBind = append(toBind, service)
 		}
+		requested[service.Name()] = true
+	}
+	if len(toBind) == 0 {
+		return wasBound, nil
+	}
 
-		volumes := deployment.Spec.Template.Spec.Volumes
-
-		for _, volume := range volumes {
-			if volume.Name == service.Name() {
-				return errors.New("service already bound")
-			}
+	// Catalog services create their binding secrets here. They are
+	// deleted again when the deployment can not be updated.
+	failure := &BindingFailure{Failed: map[string]error{}}
+	secrets := map[string]string{}
+	prepared := interfaces.ServiceList{}
+	for _, service := range toBind {
+		bindSecret, err := service.GetBinding(ctx, a.app.Name)
+		if err != nil {
+			failure.Failed[service.Name()] = err
+			continue
 		}
+		secrets[service.Name()] = bindSecret.Name
+		prepared = append(prepared, service)
+	}
 
-		volumes = append(volumes, corev1.Volume{
-			Name: service.Name(),
-			VolumeSource: corev1.VolumeSource{
-				Secret: &corev1.SecretVolumeSource{
-					SecretName: bindSecret.Name,
-				},
-			},
-		})
-		// TODO: Iterate over containers and find the one matching the app name
-		volumeMounts := deployment.Spec.Template.Spec.Containers[0].VolumeMounts
-		volumeMounts = append(volumeMounts, corev1.VolumeMount{
-			Name:      service.Name(),
-			ReadOnly:  true,
-			MountPath: fmt.Sprintf("/services/%s", service.Name()),
+	if len(failure.Failed) == 0 {
+		err = a.updateDeployment(ctx, func(deployment *appsv1.Deployment) error {
+			for _, service := range toBind {
+				err := AddBinding(&deployment.Spec.Template.Spec, service.Name(), secrets[service.Name()], as)
+				if err != nil {
+					failure.Failed[service.Name()] = err
+				}
+			}
+			if len(failure.Failed) > 0 {
+				return failure
+			}
+			return nil
 		})
+		if err == nil {
+			return wasBound, nil
+		}
+		if err != failure {
+			failure.Failed = map[string]error{}
+			for _, service := range toBind {
+				failure.Failed[service.Name()] = err
+			}
+		}
+	}
 
-		deployment.Spec.Template.Spec.Volumes = volumes
-		deployment.Spec.Template.Spec.Containers[0].VolumeMounts = volumeMounts
+	for _, service := range prepared {
+		err := service.DeleteBinding(ctx, a.app.Name, a.app.Org)
+		if err == nil {
+			continue
+		}
+		if reason, ok := failure.Failed[service.Name()]; ok {
+			failure.Failed[service.Name()] = pkgerrors.Wrapf(reason, "binding not removed: %s", err.Error())
+		} else {
+			failure.Failed[service.Name()] = pkgerrors.Wrap(err, "failed to remove binding")
+		}
+	}
 
-		_, err = a.cluster.Kubectl.AppsV1().Deployments(a.app.Org).Update(
-			ctx,
-			deployment,
-			metav1.UpdateOptions{},
-		)
+	return nil, failure
+}
 
-		if err == nil {
-			break
+// updateDeployment applies the change to the latest version of the
+// deployment, and retries on conflicts with other updates
+func (a *Workload) updateDeployment(ctx context.Context, change func(*appsv1.Deployment) error) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployment, err := a.deployment(ctx)
+		if err != nil {
+			return err
 		}
-		if !apierrors.IsConflict(err) {
+
+		err = change(deployment)
+		if err != nil {
 			return err
 		}
 
-		// Found a conflict. Try again from the beginning.
-	}
+		_, err = a.cluster.Kubectl.AppsV1().Deployments(a.app.Org).Update(
+			ctx, deployment, metav1.UpdateOptions{})
 
-	return nil
+		return err
+	})
 }
 
 // Complete fills all fields of a workload with values from the cluster
@@ -0,0 +1,446 @@
+// Package backups takes backups of the data of services, and restores them.
+// A backup runs the hook of the service class in a job, against the
+// credentials of a binding of the service. The archives are kept in a
+// persistent volume claim of the epinio namespace, or in an S3 compatible
+// bucket.
+package backups
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/randstr"
+	"github.com/epinio/epinio/internal/interfaces"
+	"github.com/epinio/epinio/internal/operations"
+	"github.com/epinio/epinio/internal/services"
+	"github.com/epinio/epinio/internal/sources"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// KindPVC keeps the archives in a persistent volume claim of the
+	// epinio namespace
+	KindPVC = "pvc"
+	// KindS3 keeps the archives in an S3 bucket
+	KindS3 = "s3"
+)
+
+// The states of a backup
+const (
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// consumer is the name backups are bound to services under, in place of an
+// application name. Application names can not contain dots, so it does not
+// clash with the bindings of applications.
+const consumer = "epinio.backup"
+
+var (
+	// ErrNoHook is returned for services whose class has no backup hook
+	ErrNoHook = errors.New("no backup hook for the class of the service")
+	// ErrShared is returned for services shared from another org
+	ErrShared = errors.New("shared services are backed up in the organization they are shared from")
+	// ErrNotRestorable is returned by Restore for a backup which did not
+	// succeed
+	ErrNotRestorable = errors.New("only succeeded backups can be restored")
+)
+
+// Config selects and configures the store of the archives
+type Config struct {
+	Kind string
+	// ClaimSize is the size of the claim created for the pvc store
+	ClaimSize string
+	S3        sources.S3Config
+}
+
+var (
+	configMu sync.Mutex
+	config   = Config{Kind: KindPVC, ClaimSize: "10Gi"}
+)
+
+// Configure sets the store used by new backups
+func Configure(c Config) error {
+	switch c.Kind {
+	case KindPVC:
+		if _, err := resource.ParseQuantity(c.ClaimSize); err != nil {
+			return fmt.Errorf("bad backup claim size '%s': %w", c.ClaimSize, err)
+		}
+	case KindS3:
+		if _, err := sources.NewS3Store(c.S3); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown backup store '%s', expected '%s' or '%s'", c.Kind, KindPVC, KindS3)
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+	return nil
+}
+
+func currentConfig() Config {
+	configMu.Lock()
+	defer configMu.Unlock()
+	return config
+}
+
+// Backup is the record of a backup of a service. It is kept in a config
+// map of the epinio namespace. The archive is the object of the key in the
+// store. Scheduled backups are pruned, see Expired.
+type Backup struct {
+	ID        string    `json:"id"`
+	Org       string    `json:"org"`
+	Service   string    `json:"service"`
+	Class     string    `json:"class"`
+	Store     string    `json:"store"`
+	Key       string    `json:"key"`
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	Created   time.Time `json:"created"`
+	Scheduled bool      `json:"scheduled,omitempty"`
+}
+
+// backupResourceName returns the name of the config map recording the
+// backup, and of the job taking it
+func backupResourceName(id string) string {
+	return fmt.Sprintf("epinio-backup-%s", id)
+}
+
+// restoreResourceName returns the name of the job of a restore. Each
+// restore has its own ID, failed jobs are kept for their logs.
+func restoreResourceName(id string) string {
+	return fmt.Sprintf("epinio-restore-%s", id)
+}
+
+// Start records a new backup of the service, and takes it in the
+// background. It returns the backup and the operation taking it.
+func Start(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service) (*Backup, *operations.Operation, error) {
+	return start(ctx, cluster, service, 0)
+}
+
+// start records and takes a new backup. A positive keep marks it as
+// scheduled. Once it succeeded, the scheduled backups of the service older
+// than the newest keep ones are removed, with their archives.
+func start(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service, keep int) (*Backup, *operations.Operation, error) {
+	hook, class, err := hookOf(ctx, cluster, service)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id, err := randstr.Hex16()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := currentConfig()
+	b := &Backup{
+		ID:        id,
+		Org:       service.Org(),
+		Service:   service.Name(),
+		Class:     class,
+		Store:     c.Kind,
+		Key:       fmt.Sprintf("%s/%s/%s.gz", service.Org(), service.Name(), id),
+		State:     StateRunning,
+		Created:   time.Now().UTC(),
+		Scheduled: keep > 0,
+	}
+
+	js, err := json.Marshal(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Create(ctx,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   backupResourceName(id),
+				Labels: labels(service),
+			},
+			Data: map[string]string{
+				"backup": string(js),
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	op, err := operations.Start(ctx, cluster, "service-backup", service.Org(), service.Name(),
+		func(ctx context.Context, progress operations.Progress) error {
+			err := run(ctx, cluster, c, service, hook, b, backupResourceName(id), false, progress)
+
+			_ = update(ctx, cluster, id, func(b *Backup) {
+				if err != nil {
+					b.State = StateFailed
+					b.Error = err.Error()
+				} else {
+					b.State = StateSucceeded
+				}
+			})
+			if err != nil || keep == 0 {
+				return err
+			}
+
+			progress("Pruning")
+			return prune(ctx, cluster, c, service, keep)
+		})
+	if err != nil {
+		_ = update(ctx, cluster, id, func(b *Backup) {
+			b.State = StateFailed
+			b.Error = err.Error()
+		})
+		return nil, nil, err
+	}
+
+	return b, op, nil
+}
+
+// Restore replaces the data of the service with the archive of the backup,
+// in the background. It returns the operation restoring it.
+func Restore(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service, b *Backup) (*operations.Operation, error) {
+	if b.State != StateSucceeded {
+		return nil, ErrNotRestorable
+	}
+
+	hook, _, err := hookOf(ctx, cluster, service)
+	if err != nil {
+		return nil, err
+	}
+
+	c := currentConfig()
+	if b.Store != c.Kind {
+		return nil, fmt.Errorf("backup '%s' is kept in the %s store, the server uses the %s store", b.ID, b.Store, c.Kind)
+	}
+
+	id, err := randstr.Hex16()
+	if err != nil {
+		return nil, err
+	}
+
+	return operations.Start(ctx, cluster, "service-restore", service.Org(), service.Name(),
+		func(ctx context.Context, progress operations.Progress) error {
+			return run(ctx, cluster, c, service, hook, b, restoreResourceName(id), true, progress)
+		})
+}
+
+// List returns the backups of the service, oldest first
+func List(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service) ([]*Backup, error) {
+	configMaps, err := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app.kubernetes.io/component=servicebackup, epinio.suse.org/organization=%s, epinio.suse.org/service=%s",
+			service.Org(), service.Name()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Backup{}
+	for _, configMap := range configMaps.Items {
+		b, err := backupFromConfigMap(configMap)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+
+	return result, nil
+}
+
+// Lookup returns the backup of the service with the ID, or nil if there is
+// none
+func Lookup(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service, id string) (*Backup, error) {
+	configMap, err := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Get(ctx,
+		backupResourceName(id), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	b, err := backupFromConfigMap(*configMap)
+	if err != nil {
+		return nil, err
+	}
+	if b.Org != service.Org() || b.Service != service.Name() {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// Expired returns the scheduled backups beyond the newest keep ones, of the
+// backups listed oldest first. Backups taken on request, and running ones,
+// are not expired.
+func Expired(backups []*Backup, keep int) []*Backup {
+	scheduled := []*Backup{}
+	for _, b := range backups {
+		if b.Scheduled && b.State != StateRunning {
+			scheduled = append(scheduled, b)
+		}
+	}
+	if len(scheduled) <= keep {
+		return nil
+	}
+	return scheduled[:len(scheduled)-keep]
+}
+
+// prune removes the expired backups of the service, their archives first.
+// Archives of another store than the configured one can not be removed,
+// their records are kept.
+func prune(ctx context.Context, cluster *kubernetes.Cluster, c Config, service interfaces.Service, keep int) error {
+	backups, err := List(ctx, cluster, service)
+	if err != nil {
+		return err
+	}
+
+	expired := []*Backup{}
+	keys := []string{}
+	for _, b := range Expired(backups, keep) {
+		if b.Store == c.Kind {
+			expired = append(expired, b)
+			keys = append(keys, b.Key)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	if c.Kind == KindS3 {
+		store, err := sources.NewS3Store(c.S3)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			err = store.DeleteObject(ctx, key)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		err = pruneClaim(ctx, cluster, service, keys)
+		if err != nil {
+			return err
+		}
+	}
+
+	client := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID)
+	for _, b := range expired {
+		err = client.Delete(ctx, backupResourceName(b.ID), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Forget removes the binding used by the backups of the service, their
+// records and the schedule of the service. The archives are left in the
+// store.
+func Forget(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service) error {
+	backups, err := List(ctx, cluster, service)
+	if err != nil {
+		return err
+	}
+
+	if len(backups) > 0 {
+		err = service.DeleteBinding(ctx, consumer, service.Org())
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	client := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID)
+	for _, b := range backups {
+		err = client.Delete(ctx, backupResourceName(b.ID), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return SetSchedule(ctx, cluster, service, 0, 0)
+}
+
+// hookOf returns the backup hook of the service, and its class
+func hookOf(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service) (*Hook, string, error) {
+	if _, ok := service.(*services.SharedService); ok {
+		return nil, "", ErrShared
+	}
+
+	class := services.OverviewOf(ctx, service).Class
+	if class == "" {
+		return nil, "", ErrNoHook
+	}
+
+	hook, err := LookupHook(ctx, cluster, class)
+	if err != nil {
+		return nil, "", err
+	}
+	if hook == nil {
+		return nil, "", ErrNoHook
+	}
+
+	return hook, class, nil
+}
+
+// labels returns the labels of the resources of the backups of the service
+func labels(service interfaces.Service) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "epinio",
+		"app.kubernetes.io/component":  "servicebackup",
+		"app.kubernetes.io/managed-by": "epinio",
+		"epinio.suse.org/organization": service.Org(),
+		"epinio.suse.org/service":      service.Name(),
+	}
+}
+
+// update applies the change to the recorded backup, and retries on
+// conflicts with other updates
+func update(ctx context.Context, cluster *kubernetes.Cluster, id string, change func(*Backup)) error {
+	client := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := client.Get(ctx, backupResourceName(id), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		b, err := backupFromConfigMap(*configMap)
+		if err != nil {
+			return err
+		}
+
+		change(b)
+
+		js, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		configMap.Data["backup"] = string(js)
+
+		_, err = client.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func backupFromConfigMap(configMap corev1.ConfigMap) (*Backup, error) {
+	var b Backup
+	err := json.Unmarshal([]byte(configMap.Data["backup"]), &b)
+	if err != nil {
+		return nil, fmt.Errorf("bad backup '%s': %w", configMap.Name, err)
+	}
+	return &b, nil
+}
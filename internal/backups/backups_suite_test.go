@@ -0,0 +1,19 @@
+package backups_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+func TestBackups(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Backups Suite")
+}
+
+var _ = BeforeSuite(func() {
+	// The timeouts are zero without the flags of the command line
+	viper.Set("timeout-multiplier", 1)
+})
@@ -0,0 +1,75 @@
+package backups_test
+
+import (
+	"time"
+
+	. "github.com/epinio/epinio/internal/backups"
+	"github.com/epinio/epinio/internal/sources"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Configure", func() {
+	AfterEach(func() {
+		Expect(Configure(Config{Kind: KindPVC, ClaimSize: "10Gi"})).To(Succeed())
+	})
+
+	It("accepts a pvc store with a claim size", func() {
+		Expect(Configure(Config{Kind: KindPVC, ClaimSize: "50Gi"})).To(Succeed())
+	})
+
+	It("rejects a bad claim size", func() {
+		Expect(Configure(Config{Kind: KindPVC, ClaimSize: "lots"})).To(MatchError(ContainSubstring("bad backup claim size 'lots'")))
+	})
+
+	It("accepts an s3 store with endpoint and bucket", func() {
+		Expect(Configure(Config{Kind: KindS3, S3: sources.S3Config{
+			Endpoint: "https://minio.example.com",
+			Bucket:   "epinio-backups",
+		}})).To(Succeed())
+	})
+
+	It("rejects an s3 store without bucket", func() {
+		Expect(Configure(Config{Kind: KindS3, S3: sources.S3Config{
+			Endpoint: "https://minio.example.com",
+		}})).ToNot(Succeed())
+	})
+
+	It("rejects unknown stores", func() {
+		Expect(Configure(Config{Kind: "tape"})).To(MatchError("unknown backup store 'tape', expected 'pvc' or 's3'"))
+	})
+})
+
+var _ = Describe("Expired", func() {
+	backup := func(id, state string, scheduled bool) *Backup {
+		return &Backup{ID: id, State: state, Scheduled: scheduled, Created: time.Now()}
+	}
+
+	ids := func(backups []*Backup) []string {
+		result := []string{}
+		for _, b := range backups {
+			result = append(result, b.ID)
+		}
+		return result
+	}
+
+	It("returns the scheduled backups beyond the newest ones", func() {
+		list := []*Backup{
+			backup("s1", StateSucceeded, true),
+			backup("m1", StateSucceeded, false),
+			backup("s2", StateFailed, true),
+			backup("s3", StateSucceeded, true),
+			backup("s4", StateSucceeded, true),
+		}
+		Expect(ids(Expired(list, 2))).To(Equal([]string{"s1", "s2"}))
+	})
+
+	It("keeps backups taken on request and running ones", func() {
+		list := []*Backup{
+			backup("m1", StateSucceeded, false),
+			backup("s1", StateSucceeded, true),
+			backup("s2", StateRunning, true),
+		}
+		Expect(Expired(list, 1)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,88 @@
+package backups
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Hook takes and restores the backups of the services of a class. The
+// scripts run with `sh -c` in a container of the image. The binding data of
+// the service is mounted as files in /binding, and the archive is the file
+// named by $ARCHIVE.
+type Hook struct {
+	Class   string
+	Image   string
+	Backup  string
+	Restore string
+}
+
+// mysqlEnv passes the password of the binding to the mysql clients. It is
+// not given as argument, where other processes could see it.
+const mysqlEnv = `export MYSQL_PWD="$(cat /binding/password)"`
+
+// mysqlArgs are the arguments connecting the mysql clients to the database
+// of the binding
+const mysqlArgs = `-h "$(cat /binding/host)" -P "$(cat /binding/port)" -u "$(cat /binding/username)"`
+
+// postgresEnv sets the environment connecting the postgres clients to the
+// database of the binding
+const postgresEnv = `export PGHOST="$(cat /binding/host)" PGPORT="$(cat /binding/port)" PGUSER="$(cat /binding/username)" PGPASSWORD="$(cat /binding/password)" PGDATABASE="$(cat /binding/database)"`
+
+// builtinHooks are the hooks for the classes of the in-cluster services
+var builtinHooks = map[string]Hook{
+	"mariadb": mysqlHook("mariadb"),
+	"mysql":   mysqlHook("mysql"),
+	"postgresql": {
+		Class:   "postgresql",
+		Image:   "postgres:13",
+		Backup:  postgresEnv + ` && pg_dump --clean --if-exists > /archive/dump.sql && gzip -c /archive/dump.sql > "$ARCHIVE"`,
+		Restore: postgresEnv + ` && gunzip -t "$ARCHIVE" && gunzip -c "$ARCHIVE" | psql -v ON_ERROR_STOP=1`,
+	},
+}
+
+func mysqlHook(class string) Hook {
+	return Hook{
+		Class:   class,
+		Image:   "mariadb:10.5",
+		Backup:  mysqlEnv + ` && mysqldump ` + mysqlArgs + ` --single-transaction "$(cat /binding/database)" > /archive/dump.sql && gzip -c /archive/dump.sql > "$ARCHIVE"`,
+		Restore: mysqlEnv + ` && gunzip -t "$ARCHIVE" && gunzip -c "$ARCHIVE" | mysql ` + mysqlArgs + ` "$(cat /binding/database)"`,
+	}
+}
+
+// LookupHook returns the backup hook of the class, or nil if there is none.
+// Hooks are registered by config maps of the epinio namespace, labeled
+// `app.kubernetes.io/component=backup-hook`, with the keys class, image,
+// backup and restore. They take precedence over the built-in hooks.
+func LookupHook(ctx context.Context, cluster *kubernetes.Cluster, class string) (*Hook, error) {
+	configMaps, err := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).List(ctx, metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/component=backup-hook",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, configMap := range configMaps.Items {
+		if configMap.Data["class"] != class {
+			continue
+		}
+		hook := &Hook{
+			Class:   class,
+			Image:   configMap.Data["image"],
+			Backup:  configMap.Data["backup"],
+			Restore: configMap.Data["restore"],
+		}
+		if hook.Image == "" || hook.Backup == "" || hook.Restore == "" {
+			return nil, fmt.Errorf("backup hook '%s' needs an image, a backup and a restore script", configMap.Name)
+		}
+		return hook, nil
+	}
+
+	if hook, ok := builtinHooks[class]; ok {
+		return &hook, nil
+	}
+	return nil, nil
+}
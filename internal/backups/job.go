@@ -0,0 +1,378 @@
+package backups
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/randstr"
+	"github.com/epinio/epinio/internal/duration"
+	"github.com/epinio/epinio/internal/interfaces"
+	"github.com/epinio/epinio/internal/operations"
+	"github.com/epinio/epinio/internal/sources"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// claimName is the persistent volume claim of the pvc store
+const claimName = "epinio-backups"
+
+// storeGroup owns the files of the pvc store. The pods mounting the claim
+// get it as fsGroup, which makes the claim writable for the unprivileged
+// user of the transfer image. Volumes which ignore fsGroup, like host
+// paths, have to be writable for all users.
+const storeGroup = int64(2000)
+
+// claimMu serializes the jobs mounting the claim of the pvc store. The
+// claim is ReadWriteOnce, jobs of a backup and a restore running at the same
+// time could be scheduled to different nodes, and one of them would not
+// start. The server is a single replica, the lock is enough.
+var claimMu sync.Mutex
+
+// jobTTL is how long finished jobs are kept, for the logs of failed ones
+const jobTTL = 24 * time.Hour
+
+// transferImage is the image of the container moving the archive between
+// the job and the store
+const transferImage = "curlimages/curl:7.78.0"
+
+// archivePath is where the hook finds the archive, in a volume shared with
+// the transfer container
+const archivePath = "/archive/backup.gz"
+
+// Transfer describes how the job moves the archive of the key in or out of
+// the store. The URL is the presigned URL of the object, for the s3 store.
+type Transfer struct {
+	Kind string
+	Key  string
+	URL  string
+}
+
+// script returns the shell script of the transfer container
+func (t Transfer) script(restore bool) string {
+	switch {
+	case t.Kind == KindS3 && restore:
+		return `curl -fsS -o "$ARCHIVE" "$URL"`
+	case t.Kind == KindS3:
+		return `curl -fsS -T "$ARCHIVE" "$URL"`
+	case restore:
+		return `cp "/store/$KEY" "$ARCHIVE"`
+	default:
+		return `mkdir -p "$(dirname "/store/$KEY")" && cp "$ARCHIVE" "/store/$KEY"`
+	}
+}
+
+// run takes the backup, or restores it, with a job of the name. The job
+// runs in the epinio namespace, with a copy of the binding data of the
+// service.
+func run(ctx context.Context, cluster *kubernetes.Cluster, c Config, service interfaces.Service, hook *Hook,
+	b *Backup, name string, restore bool, progress operations.Progress) error {
+
+	progress("Binding")
+	binding, err := service.GetBinding(ctx, consumer)
+	if err != nil {
+		return err
+	}
+
+	t := Transfer{Kind: c.Kind, Key: b.Key}
+	if c.Kind == KindS3 {
+		store, err := sources.NewS3Store(c.S3)
+		if err != nil {
+			return err
+		}
+		method := http.MethodPut
+		if restore {
+			method = http.MethodGet
+		}
+		t.URL = store.PresignedURL(method, b.Key, duration.ToServiceBackup())
+	} else {
+		claimMu.Lock()
+		defer claimMu.Unlock()
+
+		err = ensureClaim(ctx, cluster, c.ClaimSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The pod of the job mounts a copy of the binding data. It is made
+	// before the job, and removed once the job finished, failed or not.
+	secrets := cluster.Kubectl.CoreV1().Secrets(deployments.EpinioDeploymentID)
+	err = cluster.CreateSecret(ctx, deployments.EpinioDeploymentID, corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels(service),
+		},
+		Data: binding.Data,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = secrets.Delete(context.Background(), name, metav1.DeleteOptions{})
+	}()
+
+	jobs := cluster.Kubectl.BatchV1().Jobs(deployments.EpinioDeploymentID)
+	job, err := jobs.Create(ctx, NewJob(name, labels(service), hook, t, restore), metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	// Should the server stop before removing the copy, it goes away
+	// with the job
+	secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		secret.OwnerReferences = []metav1.OwnerReference{
+			{
+				APIVersion: "batch/v1",
+				Kind:       "Job",
+				Name:       job.Name,
+				UID:        job.UID,
+			},
+		}
+		_, _ = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+
+	progress(fmt.Sprintf("Running job %s", name))
+	err = awaitJob(ctx, cluster, name)
+	if err != nil {
+		return err
+	}
+
+	if restore {
+		progress("Restored")
+	} else {
+		progress(fmt.Sprintf("Stored as %s", b.Key))
+	}
+	return nil
+}
+
+// awaitJob waits for the job to succeed, and removes it. Failed jobs are
+// kept for their logs, until their TTL passed.
+func awaitJob(ctx context.Context, cluster *kubernetes.Cluster, name string) error {
+	jobs := cluster.Kubectl.BatchV1().Jobs(deployments.EpinioDeploymentID)
+
+	err := wait.PollImmediate(duration.PollInterval(), duration.ToServiceBackup(), func() (bool, error) {
+		job, err := jobs.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if job.Status.Failed > 0 {
+			return false, fmt.Errorf("job %s failed, see `kubectl logs -n %s job/%s`",
+				name, deployments.EpinioDeploymentID, name)
+		}
+		return job.Status.Succeeded > 0, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	return jobs.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+// NewJob returns the job running the hook. A backup runs the hook first,
+// then transfers the archive to the store. A restore transfers the archive
+// from the store first.
+func NewJob(name string, labels map[string]string, hook *Hook, t Transfer, restore bool) *batchv1.Job {
+	env := []corev1.EnvVar{
+		{Name: "ARCHIVE", Value: archivePath},
+		{Name: "KEY", Value: t.Key},
+	}
+	archiveMount := corev1.VolumeMount{Name: "archive", MountPath: "/archive"}
+
+	script := hook.Backup
+	if restore {
+		script = hook.Restore
+	}
+	hookContainer := corev1.Container{
+		Name:    "hook",
+		Image:   hook.Image,
+		Command: []string{"/bin/sh", "-c", script},
+		Env:     env,
+		VolumeMounts: []corev1.VolumeMount{
+			archiveMount,
+			{Name: "binding", MountPath: "/binding", ReadOnly: true},
+		},
+	}
+
+	transferContainer := corev1.Container{
+		Name:         "transfer",
+		Image:        transferImage,
+		Command:      []string{"/bin/sh", "-c", t.script(restore)},
+		Env:          append(env, corev1.EnvVar{Name: "URL", Value: t.URL}),
+		VolumeMounts: []corev1.VolumeMount{archiveMount},
+	}
+
+	volumes := []corev1.Volume{
+		{
+			Name:         "archive",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+		{
+			Name:         "binding",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: name}},
+		},
+	}
+	var securityContext *corev1.PodSecurityContext
+	if t.Kind == KindPVC {
+		securityContext = storeSecurityContext()
+		transferContainer.VolumeMounts = append(transferContainer.VolumeMounts,
+			corev1.VolumeMount{Name: "store", MountPath: "/store"})
+		volumes = append(volumes, corev1.Volume{
+			Name: "store",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+			},
+		})
+	}
+
+	first, second := hookContainer, transferContainer
+	if restore {
+		first, second = transferContainer, hookContainer
+	}
+
+	backoffLimit := int32(0)
+	deadline := int64(duration.ToServiceBackup().Seconds())
+	ttl := int32(jobTTL.Seconds())
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:   corev1.RestartPolicyNever,
+					SecurityContext: securityContext,
+					InitContainers:  []corev1.Container{first},
+					Containers:      []corev1.Container{second},
+					Volumes:         volumes,
+				},
+			},
+		},
+	}
+}
+
+// NewPruneJob returns the job removing the archives of the keys from the
+// pvc store
+func NewPruneJob(name string, labels map[string]string, keys []string) *batchv1.Job {
+	command := []string{"rm", "-f", "--"}
+	for _, key := range keys {
+		command = append(command, "/store/"+key)
+	}
+
+	backoffLimit := int32(0)
+	deadline := int64(duration.ToServiceBackup().Seconds())
+	ttl := int32(jobTTL.Seconds())
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:   corev1.RestartPolicyNever,
+					SecurityContext: storeSecurityContext(),
+					Containers: []corev1.Container{
+						{
+							Name:         "prune",
+							Image:        transferImage,
+							Command:      command,
+							VolumeMounts: []corev1.VolumeMount{{Name: "store", MountPath: "/store"}},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "store",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// pruneClaim removes the archives of the keys from the pvc store, with a job
+func pruneClaim(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service, keys []string) error {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+
+	id, err := randstr.Hex16()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("epinio-backup-prune-%s", id)
+	_, err = cluster.Kubectl.BatchV1().Jobs(deployments.EpinioDeploymentID).Create(ctx,
+		NewPruneJob(name, labels(service), keys), metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	return awaitJob(ctx, cluster, name)
+}
+
+// storeSecurityContext returns the security context of the pods mounting
+// the claim of the pvc store
+func storeSecurityContext() *corev1.PodSecurityContext {
+	group := storeGroup
+	return &corev1.PodSecurityContext{FSGroup: &group}
+}
+
+// ensureClaim creates the claim of the pvc store, if it does not exist
+func ensureClaim(ctx context.Context, cluster *kubernetes.Cluster, size string) error {
+	claims := cluster.Kubectl.CoreV1().PersistentVolumeClaims(deployments.EpinioDeploymentID)
+
+	_, err := claims.Get(ctx, claimName, metav1.GetOptions{})
+	if err == nil || !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return err
+	}
+	_, err = claims.Create(ctx, &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: claimName,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "epinio",
+				"app.kubernetes.io/component":  "servicebackup",
+				"app.kubernetes.io/managed-by": "epinio",
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: quantity},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package backups_test
+
+import (
+	"github.com/epinio/epinio/internal/backups"
+	"github.com/epinio/epinio/internal/duration"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewJob", func() {
+	hook := &backups.Hook{
+		Class:   "mysql",
+		Image:   "mysql:8",
+		Backup:  "backup",
+		Restore: "restore",
+	}
+	labels := map[string]string{"app.kubernetes.io/component": "backup"}
+
+	It("runs the hook before the transfer of a backup", func() {
+		job := backups.NewJob("job", labels, hook, backups.Transfer{Kind: backups.KindPVC, Key: "k"}, false)
+		spec := job.Spec.Template.Spec
+
+		Expect(spec.InitContainers).To(HaveLen(1))
+		Expect(spec.InitContainers[0].Name).To(Equal("hook"))
+		Expect(spec.InitContainers[0].Command).To(ContainElement("backup"))
+		Expect(spec.Containers).To(HaveLen(1))
+		Expect(spec.Containers[0].Name).To(Equal("transfer"))
+	})
+
+	It("runs the transfer before the hook of a restore", func() {
+		job := backups.NewJob("job", labels, hook, backups.Transfer{Kind: backups.KindPVC, Key: "k"}, true)
+		spec := job.Spec.Template.Spec
+
+		Expect(spec.InitContainers[0].Name).To(Equal("transfer"))
+		Expect(spec.Containers[0].Name).To(Equal("hook"))
+		Expect(spec.Containers[0].Command).To(ContainElement("restore"))
+	})
+
+	It("mounts the claim of the pvc store into the transfer container", func() {
+		job := backups.NewJob("job", labels, hook, backups.Transfer{Kind: backups.KindPVC, Key: "k"}, false)
+		spec := job.Spec.Template.Spec
+
+		mounts := map[string]string{}
+		for _, mount := range spec.Containers[0].VolumeMounts {
+			mounts[mount.Name] = mount.MountPath
+		}
+		Expect(mounts).To(HaveKeyWithValue("store", "/store"))
+
+		claims := []string{}
+		for _, volume := range spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				claims = append(claims, volume.PersistentVolumeClaim.ClaimName)
+			}
+		}
+		Expect(claims).To(Equal([]string{"epinio-backups"}))
+	})
+
+	It("makes the claim writable through the group, not root", func() {
+		job := backups.NewJob("job", labels, hook, backups.Transfer{Kind: backups.KindPVC, Key: "k"}, false)
+		spec := job.Spec.Template.Spec
+
+		Expect(spec.SecurityContext).ToNot(BeNil())
+		Expect(spec.SecurityContext.FSGroup).ToNot(BeNil())
+		Expect(spec.SecurityContext.RunAsUser).To(BeNil())
+		Expect(spec.Containers[0].SecurityContext).To(BeNil())
+	})
+
+	It("mounts no store for the s3 store", func() {
+		job := backups.NewJob("job", labels, hook, backups.Transfer{Kind: backups.KindS3, Key: "k", URL: "https://s3/k"}, false)
+		spec := job.Spec.Template.Spec
+
+		for _, volume := range spec.Volumes {
+			Expect(volume.Name).ToNot(Equal("store"))
+		}
+		for _, mount := range spec.Containers[0].VolumeMounts {
+			Expect(mount.Name).ToNot(Equal("store"))
+		}
+	})
+
+	It("bounds the job in time and removes it later", func() {
+		job := backups.NewJob("job", labels, hook, backups.Transfer{Kind: backups.KindPVC, Key: "k"}, false)
+
+		Expect(job.Spec.ActiveDeadlineSeconds).ToNot(BeNil())
+		Expect(*job.Spec.ActiveDeadlineSeconds).To(Equal(int64(duration.ToServiceBackup().Seconds())))
+		Expect(*job.Spec.ActiveDeadlineSeconds).To(BeNumerically(">", 0))
+		Expect(job.Spec.TTLSecondsAfterFinished).ToNot(BeNil())
+		Expect(*job.Spec.TTLSecondsAfterFinished).To(BeNumerically(">", 0))
+		Expect(*job.Spec.BackoffLimit).To(BeZero())
+	})
+})
+
+var _ = Describe("NewPruneJob", func() {
+	It("removes the archives of the keys from the claim", func() {
+		job := backups.NewPruneJob("job", map[string]string{}, []string{"org/db/a.gz", "org/db/b.gz"})
+		spec := job.Spec.Template.Spec
+
+		Expect(spec.Containers).To(HaveLen(1))
+		Expect(spec.Containers[0].Command).To(Equal([]string{"rm", "-f", "--", "/store/org/db/a.gz", "/store/org/db/b.gz"}))
+		Expect(spec.Volumes).To(HaveLen(1))
+		Expect(spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal("epinio-backups"))
+		Expect(spec.SecurityContext.FSGroup).ToNot(BeNil())
+		Expect(*job.Spec.BackoffLimit).To(BeZero())
+	})
+})
@@ -0,0 +1,226 @@
+package backups
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/interfaces"
+	"github.com/epinio/epinio/internal/services"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// MinInterval is the shortest interval of automatic backups. Each backup
+// runs a job and keeps an archive.
+const MinInterval = time.Hour
+
+// DefaultKeep is the number of scheduled backups kept of a service, if the
+// schedule does not say otherwise
+const DefaultKeep = 7
+
+// Schedule is the interval of the automatic backups of a service, the time
+// of the next one, and the number of scheduled backups kept
+type Schedule struct {
+	Org     string
+	Service string
+	Every   time.Duration
+	Next    time.Time
+	Keep    int
+}
+
+// scheduleResourceName returns the name of the config map recording the
+// schedule of the service
+func scheduleResourceName(org, service string) string {
+	return fmt.Sprintf("epinio-backup-schedule.org-%s.svc-%s", org, service)
+}
+
+// SetSchedule makes the server back up the service every interval, the
+// first time an interval from now, keeping the newest keep of these
+// backups. A zero interval removes the schedule, a zero keep is
+// DefaultKeep.
+func SetSchedule(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service, every time.Duration, keep int) error {
+	client := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID)
+	name := scheduleResourceName(service.Org(), service.Name())
+
+	if every <= 0 {
+		err := client.Delete(ctx, name, metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	_, _, err := hookOf(ctx, cluster, service)
+	if err != nil {
+		return err
+	}
+
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	data := map[string]string{
+		"every": every.String(),
+		"next":  time.Now().Add(every).UTC().Format(time.RFC3339),
+		"keep":  strconv.Itoa(keep),
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := client.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			labels := labels(service)
+			labels["app.kubernetes.io/component"] = "backup-schedule"
+			_, err = client.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					Labels: labels,
+				},
+				Data: data,
+			}, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		configMap.Data = data
+		_, err = client.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// LookupSchedule returns the schedule of the service, or nil if it has none
+func LookupSchedule(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service) (*Schedule, error) {
+	configMap, err := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Get(ctx,
+		scheduleResourceName(service.Org(), service.Name()), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return scheduleFromConfigMap(*configMap)
+}
+
+// Scheduler starts the backups of the services whose schedule is due
+type Scheduler struct {
+	cluster *kubernetes.Cluster
+	log     logr.Logger
+}
+
+// NewScheduler returns a scheduler of the backups
+func NewScheduler(cluster *kubernetes.Cluster, log logr.Logger) *Scheduler {
+	return &Scheduler{
+		cluster: cluster,
+		log:     log.WithName("backups"),
+	}
+}
+
+// Start checks the schedules every interval, until the context is done
+func (s *Scheduler) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			started, err := s.Check(ctx)
+			if err != nil {
+				s.log.Error(err, "schedule check failed")
+			} else if started > 0 {
+				s.log.Info("backups started", "count", started)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Check starts the backups which are due, and returns how many it started.
+// A due schedule is moved to its next time before the backup starts. Of
+// several servers checking at the same time, only the one updating the
+// schedule first starts the backup.
+func (s *Scheduler) Check(ctx context.Context) (int, error) {
+	client := s.cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID)
+
+	configMaps, err := client.List(ctx, metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/component=backup-schedule",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	started := 0
+	now := time.Now()
+	for _, configMap := range configMaps.Items {
+		schedule, err := scheduleFromConfigMap(configMap)
+		if err != nil {
+			s.log.Error(err, "bad schedule", "name", configMap.Name)
+			continue
+		}
+		if now.Before(schedule.Next) {
+			continue
+		}
+
+		configMap.Data["next"] = now.Add(schedule.Every).UTC().Format(time.RFC3339)
+		_, err = client.Update(ctx, &configMap, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return started, err
+		}
+
+		service, err := services.Lookup(ctx, s.cluster, schedule.Org, schedule.Service)
+		if err != nil {
+			s.log.Error(err, "service lookup failed", "org", schedule.Org, "service", schedule.Service)
+			continue
+		}
+		b, _, err := start(ctx, s.cluster, service, schedule.Keep)
+		if err != nil {
+			s.log.Error(err, "backup failed to start", "org", schedule.Org, "service", schedule.Service)
+			continue
+		}
+		s.log.Info("backup started", "org", schedule.Org, "service", schedule.Service, "id", b.ID)
+		started++
+	}
+
+	return started, nil
+}
+
+func scheduleFromConfigMap(configMap corev1.ConfigMap) (*Schedule, error) {
+	every, err := time.ParseDuration(configMap.Data["every"])
+	if err != nil {
+		return nil, fmt.Errorf("bad schedule '%s': %w", configMap.Name, err)
+	}
+	next, err := time.Parse(time.RFC3339, configMap.Data["next"])
+	if err != nil {
+		return nil, fmt.Errorf("bad schedule '%s': %w", configMap.Name, err)
+	}
+	// Schedules made before the retention existed keep the default
+	keep := DefaultKeep
+	if value, ok := configMap.Data["keep"]; ok {
+		keep, err = strconv.Atoi(value)
+		if err != nil || keep <= 0 {
+			return nil, fmt.Errorf("bad schedule '%s': bad keep '%s'", configMap.Name, value)
+		}
+	}
+
+	return &Schedule{
+		Org:     configMap.Labels["epinio.suse.org/organization"],
+		Service: configMap.Labels["epinio.suse.org/service"],
+		Every:   every,
+		Next:    next,
+		Keep:    keep,
+	}, nil
+}
@@ -0,0 +1,142 @@
+package backups_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/backups"
+	"github.com/epinio/epinio/internal/services"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+var _ = Describe("Schedules", func() {
+	var (
+		ctx     context.Context
+		cluster *kubernetes.Cluster
+	)
+
+	schedule := func(name, service, every string, next time.Time) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "epinio",
+				Labels: map[string]string{
+					"app.kubernetes.io/component":  "backup-schedule",
+					"epinio.suse.org/organization": "workspace",
+					"epinio.suse.org/service":      service,
+				},
+			},
+			Data: map[string]string{
+				"every": every,
+				"next":  next.UTC().Format(time.RFC3339),
+			},
+		}
+	}
+
+	create := func(configMap *corev1.ConfigMap) {
+		_, err := cluster.Kubectl.CoreV1().ConfigMaps("epinio").Create(ctx, configMap, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	next := func(name string) string {
+		configMap, err := cluster.Kubectl.CoreV1().ConfigMaps("epinio").Get(ctx, name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return configMap.Data["next"]
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		// The service lookups reach no cluster and fail
+		cluster = &kubernetes.Cluster{
+			Kubectl:    fake.NewSimpleClientset(),
+			RestConfig: &rest.Config{Host: "http://127.0.0.1:1"},
+		}
+	})
+
+	Describe("Check", func() {
+		It("moves a due schedule to its next time", func() {
+			due := schedule("due", "db", "1h", time.Now().Add(-time.Minute))
+			create(due)
+
+			started, err := backups.NewScheduler(cluster, logr.Discard()).Check(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(started).To(BeZero())
+
+			moved, err := time.Parse(time.RFC3339, next("due"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(moved).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+		})
+
+		It("leaves a schedule which is not due", func() {
+			later := schedule("later", "db", "1h", time.Now().Add(30*time.Minute))
+			create(later)
+
+			started, err := backups.NewScheduler(cluster, logr.Discard()).Check(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(started).To(BeZero())
+			Expect(next("later")).To(Equal(later.Data["next"]))
+		})
+
+		It("skips a bad schedule", func() {
+			bad := schedule("bad", "db", "often", time.Now().Add(-time.Minute))
+			create(bad)
+			due := schedule("due", "other", "2h", time.Now().Add(-time.Minute))
+			create(due)
+
+			_, err := backups.NewScheduler(cluster, logr.Discard()).Check(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(next("bad")).To(Equal(bad.Data["next"]))
+			Expect(next("due")).ToNot(Equal(due.Data["next"]))
+		})
+	})
+
+	Describe("LookupSchedule", func() {
+		service := &services.CustomService{OrgName: "workspace", Service: "db"}
+
+		It("returns the schedule of the service", func() {
+			at := time.Now().Add(time.Hour).Truncate(time.Second)
+			create(schedule("epinio-backup-schedule.org-workspace.svc-db", "db", "3h", at))
+
+			found, err := backups.LookupSchedule(ctx, cluster, service)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).ToNot(BeNil())
+			Expect(found.Org).To(Equal("workspace"))
+			Expect(found.Service).To(Equal("db"))
+			Expect(found.Every).To(Equal(3 * time.Hour))
+			Expect(found.Next.Equal(at)).To(BeTrue())
+			Expect(found.Keep).To(Equal(backups.DefaultKeep))
+		})
+
+		It("returns the number of kept backups", func() {
+			configMap := schedule("epinio-backup-schedule.org-workspace.svc-db", "db", "3h", time.Now())
+			configMap.Data["keep"] = "3"
+			create(configMap)
+
+			found, err := backups.LookupSchedule(ctx, cluster, service)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found.Keep).To(Equal(3))
+		})
+
+		It("rejects a bad number of kept backups", func() {
+			configMap := schedule("epinio-backup-schedule.org-workspace.svc-db", "db", "3h", time.Now())
+			configMap.Data["keep"] = "-1"
+			create(configMap)
+
+			_, err := backups.LookupSchedule(ctx, cluster, service)
+			Expect(err).To(MatchError(ContainSubstring("bad keep '-1'")))
+		})
+
+		It("returns nil for a service without schedule", func() {
+			found, err := backups.LookupSchedule(ctx, cluster, service)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(BeNil())
+		})
+	})
+})
@@ -2,6 +2,7 @@ package cli
 
 import (
 	v1 "github.com/epinio/epinio/internal/api/v1"
+	"github.com/epinio/epinio/internal/application"
 	"github.com/epinio/epinio/internal/cli/clients"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -39,6 +40,22 @@ func init() {
 	CmdApp.AddCommand(CmdPush)
 	CmdApp.AddCommand(CmdAppUpdate)
 	CmdApp.AddCommand(CmdAppLogs)
+	CmdApp.AddCommand(CmdAppWebhook)
+
+	downloadFlags := CmdAppDownload.Flags()
+	downloadFlags.String("revision", "", "The revision of the sources, the latest by default")
+	downloadFlags.StringP("output", "o", "", "The file to save the sources in, NAME.tar.gz by default")
+	CmdApp.AddCommand(CmdAppDownload)
+
+	CmdAppWebhookEnable.Flags().String("branch", application.DefaultWebhookBranch, "The git branch to deploy pushes from")
+	CmdAppWebhook.AddCommand(CmdAppWebhookEnable)
+	CmdAppWebhook.AddCommand(CmdAppWebhookDisable)
+	CmdAppWebhook.AddCommand(CmdAppWebhookShow)
+
+	CmdApp.AddCommand(CmdAppBuildSecret)
+	CmdAppBuildSecret.AddCommand(CmdAppBuildSecretSet)
+	CmdAppBuildSecret.AddCommand(CmdAppBuildSecretUnset)
+	CmdAppBuildSecret.AddCommand(CmdAppBuildSecretList)
 }
 
 // CmdAppList implements the epinio `apps list` command
@@ -224,3 +241,218 @@ var CmdAppUpdate = &cobra.Command{
 		return matches, cobra.ShellCompDirectiveNoFileComp
 	},
 }
+
+// CmdAppWebhook implements the epinio `apps webhook` command
+var CmdAppWebhook = &cobra.Command{
+	Use:           "webhook",
+	Short:         "Epinio application webhook features",
+	Long:          `Manage the git webhook redeploying the application on push`,
+	Args:          cobra.ExactArgs(0),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+// CmdAppWebhookEnable implements the epinio `apps webhook enable` command
+var CmdAppWebhookEnable = &cobra.Command{
+	Use:   "enable NAME",
+	Short: "Enable the git webhook of the named application",
+	Long:  "Generate a new webhook secret for the named application, replacing any existing one",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return errors.Wrap(err, "error initializing cli")
+		}
+
+		branch, err := cmd.Flags().GetString("branch")
+		if err != nil {
+			return errors.Wrap(err, "error reading option --branch")
+		}
+
+		err = client.AppWebhookEnable(args[0], branch)
+		if err != nil {
+			return errors.Wrap(err, "error enabling webhook")
+		}
+
+		return nil
+	},
+	ValidArgsFunction: matchingAppsFinder,
+}
+
+// CmdAppWebhookDisable implements the epinio `apps webhook disable` command
+var CmdAppWebhookDisable = &cobra.Command{
+	Use:   "disable NAME",
+	Short: "Disable the git webhook of the named application",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return errors.Wrap(err, "error initializing cli")
+		}
+
+		err = client.AppWebhookDisable(args[0])
+		if err != nil {
+			return errors.Wrap(err, "error disabling webhook")
+		}
+
+		return nil
+	},
+	ValidArgsFunction: matchingAppsFinder,
+}
+
+// CmdAppWebhookShow implements the epinio `apps webhook show` command
+var CmdAppWebhookShow = &cobra.Command{
+	Use:   "show NAME",
+	Short: "Show the git webhook of the named application",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return errors.Wrap(err, "error initializing cli")
+		}
+
+		err = client.AppWebhookShow(args[0])
+		if err != nil {
+			return errors.Wrap(err, "error showing webhook")
+		}
+
+		return nil
+	},
+	ValidArgsFunction: matchingAppsFinder,
+}
+
+// CmdAppBuildSecret implements the epinio `apps build-secret` command
+var CmdAppBuildSecret = &cobra.Command{
+	Use:           "build-secret",
+	Short:         "Epinio application build secrets",
+	Long:          `Manage secrets available to the stagings of an application only, e.g. package registry credentials`,
+	Args:          cobra.ExactArgs(0),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+// CmdAppBuildSecretSet implements the epinio `apps build-secret set` command
+var CmdAppBuildSecretSet = &cobra.Command{
+	Use:   "set NAME KEY VALUE",
+	Short: "Set a build secret of the named application",
+	Long:  "Set a build secret of the named application. It becomes an environment variable of the next stagings, and is not part of the application's environment",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return errors.Wrap(err, "error initializing cli")
+		}
+
+		err = client.AppBuildSecretSet(args[0], args[1], args[2])
+		if err != nil {
+			return errors.Wrap(err, "error setting build secret")
+		}
+
+		return nil
+	},
+	ValidArgsFunction: matchingAppsFinder,
+}
+
+// CmdAppBuildSecretUnset implements the epinio `apps build-secret unset` command
+var CmdAppBuildSecretUnset = &cobra.Command{
+	Use:   "unset NAME KEY",
+	Short: "Remove a build secret of the named application",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return errors.Wrap(err, "error initializing cli")
+		}
+
+		err = client.AppBuildSecretUnset(args[0], args[1])
+		if err != nil {
+			return errors.Wrap(err, "error removing build secret")
+		}
+
+		return nil
+	},
+	ValidArgsFunction: matchingAppsFinder,
+}
+
+// CmdAppBuildSecretList implements the epinio `apps build-secret list` command
+var CmdAppBuildSecretList = &cobra.Command{
+	Use:   "list NAME",
+	Short: "List the names of the build secrets of the named application",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return errors.Wrap(err, "error initializing cli")
+		}
+
+		err = client.AppBuildSecrets(args[0])
+		if err != nil {
+			return errors.Wrap(err, "error listing build secrets")
+		}
+
+		return nil
+	},
+	ValidArgsFunction: matchingAppsFinder,
+}
+
+// CmdAppDownload implements the epinio `apps download` command
+var CmdAppDownload = &cobra.Command{
+	Use:   "download NAME",
+	Short: "Download the sources of the named application, as gzipped tarball",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return errors.Wrap(err, "error initializing cli")
+		}
+
+		revision, err := cmd.Flags().GetString("revision")
+		if err != nil {
+			return errors.Wrap(err, "error reading option --revision")
+		}
+
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return errors.Wrap(err, "error reading option --output")
+		}
+
+		err = client.AppDownload(args[0], revision, output)
+		if err != nil {
+			return errors.Wrap(err, "error downloading application sources")
+		}
+
+		return nil
+	},
+	ValidArgsFunction: matchingAppsFinder,
+}
+
+// matchingAppsFinder completes the first argument of a command with the
+// names of the apps in the targeted org
+func matchingAppsFinder(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	app, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	matches := app.AppsMatching(cmd.Context(), toComplete)
+
+	return matches, cobra.ShellCompDirectiveNoFileComp
+}
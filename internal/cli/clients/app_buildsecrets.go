@@ -0,0 +1,94 @@
+package clients
+
+import (
+	"encoding/json"
+
+	api "github.com/epinio/epinio/internal/api/v1"
+	"github.com/epinio/epinio/internal/api/v1/models"
+)
+
+// AppBuildSecretSet sets a build secret of the named app, in the targeted org
+func (c *EpinioClient) AppBuildSecretSet(appName, key, value string) error {
+	log := c.Log.WithName("AppBuildSecretSet").WithValues("Organization", c.Config.Org, "Application", appName, "Key", key)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Application", appName).
+		WithStringValue("Name", key).
+		Msg("Set build secret")
+
+	js, err := json.Marshal(models.BuildSecretSetRequest{Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.post(api.Routes.Path("AppBuildSecretSet", c.Config.Org, appName), string(js))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().Msg("Build secret set. It is used by the next staging.")
+
+	return nil
+}
+
+// AppBuildSecretUnset removes a build secret of the named app, in the
+// targeted org
+func (c *EpinioClient) AppBuildSecretUnset(appName, key string) error {
+	log := c.Log.WithName("AppBuildSecretUnset").WithValues("Organization", c.Config.Org, "Application", appName, "Key", key)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Application", appName).
+		WithStringValue("Name", key).
+		Msg("Remove build secret")
+
+	_, err := c.delete(api.Routes.Path("AppBuildSecretUnset", c.Config.Org, appName, key))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().Msg("Build secret removed.")
+
+	return nil
+}
+
+// AppBuildSecrets lists the names of the build secrets of the named app, in
+// the targeted org
+func (c *EpinioClient) AppBuildSecrets(appName string) error {
+	log := c.Log.WithName("AppBuildSecrets").WithValues("Organization", c.Config.Org, "Application", appName)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Application", appName).
+		Msg("Listing build secrets")
+
+	b, err := c.get(api.Routes.Path("AppBuildSecrets", c.Config.Org, appName))
+	if err != nil {
+		return err
+	}
+
+	secrets := models.BuildSecretsResponse{}
+	if err := json.Unmarshal(b, &secrets); err != nil {
+		return err
+	}
+
+	if len(secrets.Keys) == 0 {
+		c.ui.Exclamation().Msg("No build secrets")
+		return nil
+	}
+
+	msg := c.ui.Success().WithTable("Name")
+	for _, key := range secrets.Keys {
+		msg = msg.WithTableRow(key)
+	}
+	msg.Msg("Build secrets:")
+
+	return nil
+}
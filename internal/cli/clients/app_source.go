@@ -0,0 +1,86 @@
+package clients
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	api "github.com/epinio/epinio/internal/api/v1"
+	"github.com/pkg/errors"
+)
+
+// AppDownload saves the sources of the named app, in the targeted org, as
+// gzipped tarball. An empty revision downloads the latest sources.
+func (c *EpinioClient) AppDownload(appName, revision, output string) error {
+	log := c.Log.WithName("AppDownload").WithValues("Organization", c.Config.Org, "Application", appName, "Revision", revision)
+	log.Info("start")
+	defer log.Info("return")
+
+	if output == "" {
+		output = appName + ".tar.gz"
+	}
+
+	msg := c.ui.Note().
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Application", appName)
+	if revision != "" {
+		msg = msg.WithStringValue("Revision", revision)
+	}
+	msg.WithStringValue("File", output).Msg("Downloading application sources")
+
+	uri := fmt.Sprintf("%s/%s", c.serverURL, api.Routes.Path("AppSource", c.Config.Org, appName))
+	if revision != "" {
+		uri += "?revision=" + url.QueryEscape(revision)
+	}
+	c.Log.Info(fmt.Sprintf("GET %s", uri))
+
+	request, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return err
+	}
+	request.SetBasicAuth(c.Config.User, c.Config.Password)
+
+	response, err := (&http.Client{}).Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		bodyBytes, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		return errors.New(fmt.Sprintf("%s: %s", http.StatusText(response.StatusCode), string(bodyBytes)))
+	}
+
+	// Written next to the output first, so that a failed download does
+	// not leave a truncated file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(output), ".epinio-download-*")
+	if err != nil {
+		return errors.Wrap(err, "can't create output file")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, response.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to download sources")
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), output)
+	if err != nil {
+		return errors.Wrap(err, "can't write output file")
+	}
+
+	c.ui.Success().WithStringValue("Size", formatSize(size)).Msg("Sources downloaded.")
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	api "github.com/epinio/epinio/internal/api/v1"
+	"github.com/epinio/epinio/internal/api/v1/models"
+)
+
+// AppWebhookEnable generates a new webhook secret for the named app, in the
+// targeted org, and sets the branch to deploy from
+func (c *EpinioClient) AppWebhookEnable(appName, branch string) error {
+	log := c.Log.WithName("AppWebhookEnable").WithValues("Organization", c.Config.Org, "Application", appName)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Application", appName).
+		WithStringValue("Branch", branch).
+		Msg("Enable application webhook")
+
+	js, err := json.Marshal(models.WebhookEnableRequest{Branch: branch})
+	if err != nil {
+		return err
+	}
+
+	b, err := c.post(api.Routes.Path("AppWebhookEnable", c.Config.Org, appName), string(js))
+	if err != nil {
+		return err
+	}
+
+	return c.showWebhook(appName, b, "Webhook enabled")
+}
+
+// AppWebhookDisable removes the webhook of the named app, in the targeted org
+func (c *EpinioClient) AppWebhookDisable(appName string) error {
+	log := c.Log.WithName("AppWebhookDisable").WithValues("Organization", c.Config.Org, "Application", appName)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Application", appName).
+		Msg("Disable application webhook")
+
+	_, err := c.delete(api.Routes.Path("AppWebhookDisable", c.Config.Org, appName))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().Msg("Webhook disabled")
+
+	return nil
+}
+
+// AppWebhookShow displays the webhook of the named app, in the targeted org
+func (c *EpinioClient) AppWebhookShow(appName string) error {
+	log := c.Log.WithName("AppWebhookShow").WithValues("Organization", c.Config.Org, "Application", appName)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Application", appName).
+		Msg("Show application webhook")
+
+	b, err := c.get(api.Routes.Path("AppWebhookShow", c.Config.Org, appName))
+	if err != nil {
+		return err
+	}
+
+	return c.showWebhook(appName, b, "Webhook:")
+}
+
+func (c *EpinioClient) showWebhook(appName string, response []byte, title string) error {
+	webhook := models.WebhookResponse{}
+	if err := json.Unmarshal(response, &webhook); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(fmt.Sprintf("%s/%s", c.serverURL, api.Routes.Path("AppWebhook", c.Config.Org, appName)))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().
+		WithTable("Key", "Value").
+		WithTableRow("URL", u.String()).
+		WithTableRow("Content Type", "application/json").
+		WithTableRow("Secret", webhook.Secret).
+		WithTableRow("Branch", webhook.Branch).
+		Msg(title)
+
+	return nil
+}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,6 +32,7 @@ import (
 	"github.com/epinio/epinio/internal/cli/logprinter"
 	"github.com/epinio/epinio/internal/domain"
 	"github.com/epinio/epinio/internal/duration"
+	"github.com/epinio/epinio/internal/operations"
 	"github.com/epinio/epinio/internal/services"
 
 	"github.com/go-logr/logr"
@@ -41,6 +43,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
 )
 
 // EpinioClient provides functionality for talking to a
@@ -57,6 +60,10 @@ type EpinioClient struct {
 type PushParams struct {
 	Instances *int32
 	Services  []string
+	GitIgnore bool
+	DryRun    bool
+	// Compression of the uploaded sources, one of Compressions
+	Compression string
 }
 
 func NewEpinioClient(ctx context.Context, flags *pflag.FlagSet) (*EpinioClient, error) {
@@ -130,8 +137,8 @@ func (c *EpinioClient) ConfigUpdate(ctx context.Context) error {
 }
 
 // ServicePlans gets all service classes in the cluster, for the
-// specified class
-func (c *EpinioClient) ServicePlans(serviceClassName string) error {
+// specified class. withSchema adds the parameter schema of each plan.
+func (c *EpinioClient) ServicePlans(serviceClassName string, withSchema bool) error {
 	log := c.Log.WithName("ServicePlans").WithValues("ServiceClass", serviceClassName)
 	log.Info("start")
 	defer log.Info("return")
@@ -164,6 +171,22 @@ func (c *EpinioClient) ServicePlans(serviceClassName string) error {
 	}
 	msg.Msg("Epinio Service Plans:")
 
+	if !withSchema {
+		return nil
+	}
+
+	for _, sp := range servicePlans {
+		if len(sp.Schema) == 0 {
+			c.ui.Normal().Msgf("Plan %s: no parameter schema", sp.Name)
+			continue
+		}
+		schema, err := json.MarshalIndent(sp.Schema, "", "  ")
+		if err != nil {
+			return err
+		}
+		c.ui.Normal().Msgf("Plan %s, parameter schema:\n%s", sp.Name, string(schema))
+	}
+
 	return nil
 }
 
@@ -198,6 +221,176 @@ func (c *EpinioClient) ServicePlanMatching(ctx context.Context, serviceClassName
 	return result
 }
 
+// Brokers lists the service brokers registered with Epinio
+func (c *EpinioClient) Brokers() error {
+	log := c.Log.WithName("Brokers")
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		Msg("Listing service brokers")
+
+	jsonResponse, err := c.get(api.Routes.Path("ServiceBrokers"))
+	if err != nil {
+		return err
+	}
+	var brokers models.BrokersResponse
+	if err := json.Unmarshal(jsonResponse, &brokers); err != nil {
+		return err
+	}
+
+	msg := c.ui.Success().WithTable("Name", "URL")
+	for _, broker := range brokers {
+		msg = msg.WithTableRow(broker.Name, broker.URL)
+	}
+	msg.Msg("Epinio Service Brokers:")
+
+	return nil
+}
+
+// BrokerAdd registers a service broker with Epinio. Its services become
+// available as service classes.
+func (c *EpinioClient) BrokerAdd(name, url, user, password string) error {
+	log := c.Log.WithName("BrokerAdd").WithValues("Name", name, "URL", url)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Name", name).
+		WithStringValue("URL", url).
+		Msg("Registering service broker...")
+
+	request := models.BrokerCreateRequest{
+		Name:     name,
+		URL:      url,
+		Username: user,
+		Password: password,
+	}
+
+	js, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.post(api.Routes.Path("ServiceBrokerCreate"), string(js))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().Msg("Service broker registered.")
+
+	return nil
+}
+
+// BrokerRemove removes the registration of a service broker
+func (c *EpinioClient) BrokerRemove(name string) error {
+	log := c.Log.WithName("BrokerRemove").WithValues("Name", name)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Name", name).
+		Msg("Removing service broker...")
+
+	_, err := c.delete(api.Routes.Path("ServiceBrokerDelete", name))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().Msg("Service broker removed.")
+
+	return nil
+}
+
+// Charts lists the Helm charts registered as service classes
+func (c *EpinioClient) Charts() error {
+	log := c.Log.WithName("Charts")
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		Msg("Listing service charts")
+
+	jsonResponse, err := c.get(api.Routes.Path("ServiceCharts"))
+	if err != nil {
+		return err
+	}
+	var charts []services.Chart
+	if err := json.Unmarshal(jsonResponse, &charts); err != nil {
+		return err
+	}
+
+	msg := c.ui.Success().WithTable("Name", "Chart", "Version", "Plans", "Description")
+	for _, chart := range charts {
+		plans := []string{}
+		for _, plan := range chart.Plans {
+			plans = append(plans, plan.Name)
+		}
+		msg = msg.WithTableRow(chart.Name, chart.Chart, chart.Version, strings.Join(plans, ", "), chart.Description)
+	}
+	msg.Msg("Epinio Service Charts:")
+
+	return nil
+}
+
+// ChartAdd registers the Helm chart defined in the file, YAML or JSON, as
+// service class
+func (c *EpinioClient) ChartAdd(path string) error {
+	log := c.Log.WithName("ChartAdd").WithValues("Path", path)
+	log.Info("start")
+	defer log.Info("return")
+
+	definition, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read chart definition")
+	}
+
+	var chart services.Chart
+	err = yaml.Unmarshal(definition, &chart)
+	if err != nil {
+		return errors.Wrap(err, "bad chart definition")
+	}
+
+	c.ui.Note().
+		WithStringValue("Name", chart.Name).
+		WithStringValue("Chart", chart.Chart).
+		Msg("Registering service chart...")
+
+	js, err := json.Marshal(chart)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.post(api.Routes.Path("ServiceChartCreate"), string(js))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().Msg("Service chart registered.")
+
+	return nil
+}
+
+// ChartRemove removes the registration of a Helm chart
+func (c *EpinioClient) ChartRemove(name string) error {
+	log := c.Log.WithName("ChartRemove").WithValues("Name", name)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Name", name).
+		Msg("Removing service chart...")
+
+	_, err := c.delete(api.Routes.Path("ServiceChartDelete", name))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().Msg("Service chart removed.")
+
+	return nil
+}
+
 // ServiceClassMatching returns all service classes in the cluster which have the specified prefix in their name
 func (c *EpinioClient) ServiceClassMatching(ctx context.Context, prefix string) []string {
 	log := c.Log.WithName("ServiceClasses").WithValues("PrefixToMatch", prefix)
@@ -281,11 +474,16 @@ func (c *EpinioClient) Services() error {
 	details.Info("list services")
 
 	sort.Sort(response)
-	msg := c.ui.Success().WithTable("Name", "Applications")
+	msg := c.ui.Success().WithTable("Name", "Type", "Class", "Plan", "Status", "Created", "Applications", "Last Error")
 
 	details.Info("list services")
 	for _, service := range response {
-		msg = msg.WithTableRow(service.Name, strings.Join(service.BoundApps, ", "))
+		created := ""
+		if !service.Created.IsZero() {
+			created = service.Created.Format(time.RFC3339)
+		}
+		msg = msg.WithTableRow(service.Name, service.Type, service.Class, service.Plan,
+			service.Status, created, strings.Join(service.BoundApps, ", "), service.LastError)
 	}
 	msg.Msg("Epinio Services:")
 
@@ -320,8 +518,9 @@ func (c *EpinioClient) ServiceMatching(ctx context.Context, prefix string) []str
 }
 
 // BindService attaches a service specified by name to the named application,
-// both in the targeted organization.
-func (c *EpinioClient) BindService(serviceName, appName string) error {
+// both in the targeted organization. The service data is seen by the
+// application as files, env, or both, as given by as.
+func (c *EpinioClient) BindService(serviceName, appName, as string) error {
 	log := c.Log.WithName("Bind Service To Application").
 		WithValues("Name", serviceName, "Application", appName, "Organization", c.Config.Org)
 	log.Info("start")
@@ -329,182 +528,730 @@ func (c *EpinioClient) BindService(serviceName, appName string) error {
 
 	c.ui.Note().
 		WithStringValue("Service", serviceName).
-		WithStringValue("Application", appName).
+		WithStringValue("Application", appName).
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("As", as).
+		Msg("Bind Service")
+
+	request := models.BindRequest{
+		Names: []string{serviceName},
+		As:    as,
+	}
+
+	js, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	b, err := c.post(api.Routes.Path("ServiceBindingCreate", c.Config.Org, appName), string(js))
+	if err != nil {
+		return err
+	}
+
+	br := &models.BindResponse{}
+	if err := json.Unmarshal(b, br); err != nil {
+		return err
+	}
+
+	if len(br.WasBound) > 0 {
+		c.ui.Success().
+			WithStringValue("Service", serviceName).
+			WithStringValue("Application", appName).
+			WithStringValue("Organization", c.Config.Org).
+			Msg("Service Already Bound to Application.")
+
+		return nil
+	}
+
+	c.ui.Success().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Application", appName).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Service Bound to Application.")
+	return nil
+}
+
+// UnbindService detaches the service specified by name from the named
+// application, both in the targeted organization.
+func (c *EpinioClient) UnbindService(serviceName, appName string) error {
+	log := c.Log.WithName("Unbind Service").
+		WithValues("Name", serviceName, "Application", appName, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Application", appName).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Unbind Service from Application")
+
+	_, err := c.delete(api.Routes.Path("ServiceBindingDelete",
+		c.Config.Org, appName, serviceName))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Application", appName).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Service Detached From Application.")
+	return nil
+}
+
+// RotateCredentials replaces the credentials of the service's binding to the
+// application. The application is restarted with the new credentials before
+// the old ones are revoked.
+func (c *EpinioClient) RotateCredentials(serviceName, appName string) error {
+	log := c.Log.WithName("Rotate Credentials").
+		WithValues("Name", serviceName, "Application", appName, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Application", appName).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Rotate Credentials of Service Binding")
+
+	c.ui.Note().KeeplineUnder(1).Msg("Restarting application with new credentials...")
+	s := c.ui.Progressf("Restarting %s", appName)
+	defer s.Stop()
+
+	_, err := c.post(api.Routes.Path("ServiceBindingRotate",
+		c.Config.Org, appName, serviceName), "")
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Application", appName).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Credentials Rotated.")
+	return nil
+}
+
+// DeleteService deletes a service specified by name
+func (c *EpinioClient) DeleteService(name string, unbind, force, wait bool) error {
+	log := c.Log.WithName("Delete Service").
+		WithValues("Name", name, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Name", name).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Delete Service")
+
+	request := models.DeleteRequest{
+		Unbind: unbind,
+		Force:  force,
+	}
+
+	js, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	jsonResponse, err := c.curlWithCustomErrorHandling(
+		api.Routes.Path("ServiceDelete", c.Config.Org, name),
+		"DELETE", string(js),
+		func(response *http.Response, bodyBytes []byte, err error) error {
+			// nothing special for internal errors and the like
+			if response.StatusCode != http.StatusBadRequest {
+				return err
+			}
+
+			// A bad request happens when the service is
+			// still bound to one or more applications, or
+			// shared with other organizations, and the
+			// response contains an array of their names.
+
+			var apiError api.ErrorResponse
+			if err := json.Unmarshal(bodyBytes, &apiError); err != nil {
+				return err
+			}
+
+			if apiError.Errors[0].Title == "service is shared with other organizations" {
+				shares := strings.Split(apiError.Errors[0].Details, ",")
+
+				msg := c.ui.Exclamation().WithTable("Shared With")
+				for _, org := range shares {
+					msg = msg.WithTableRow(org)
+				}
+
+				msg.Msg("Unable to delete service. It is shared with")
+				c.ui.Exclamation().Compact().Msg("Use --force to remove the shares, unbinding the applications of these organizations")
+
+				return errors.New(http.StatusText(response.StatusCode))
+			}
+
+			bound := strings.Split(apiError.Errors[0].Details, ",")
+
+			sort.Strings(bound)
+			msg := c.ui.Exclamation().WithTable("Bound Applications")
+
+			for _, app := range bound {
+				msg = msg.WithTableRow(app)
+			}
+
+			msg.Msg("Unable to delete service. It is still used by")
+			c.ui.Exclamation().Compact().Msg("Use --unbind to force the issue")
+
+			return errors.New(http.StatusText(response.StatusCode))
+		})
+	if err != nil {
+		if err.Error() != "Bad Request" {
+			return err
+		}
+		return nil
+	}
+
+	if len(jsonResponse) > 0 {
+		var deleteResponse models.DeleteResponse
+		if err := json.Unmarshal(jsonResponse, &deleteResponse); err != nil {
+			return err
+		}
+		if len(deleteResponse.BoundApps) > 0 {
+			sort.Strings(deleteResponse.BoundApps)
+			msg := c.ui.Note().WithTable("Previously Bound To")
+
+			for _, app := range deleteResponse.BoundApps {
+				msg = msg.WithTableRow(app)
+			}
+
+			msg.Msg("")
+		}
+
+		if wait {
+			c.ui.Note().KeeplineUnder(1).Msg("Deleting...")
+			err = c.waitForOperation(deleteResponse.Operation, "Deleting")
+			if err != nil {
+				return err
+			}
+		} else {
+			c.ui.Success().
+				WithStringValue("Name", name).
+				WithStringValue("Organization", c.Config.Org).
+				WithStringValue("Operation", deleteResponse.Operation).
+				Msg("Service Removal Started.")
+			return nil
+		}
+	}
+
+	c.ui.Success().
+		WithStringValue("Name", name).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Service Removed.")
+	return nil
+}
+
+// ServiceKeys lists the keys of the named service
+func (c *EpinioClient) ServiceKeys(serviceName string) error {
+	log := c.Log.WithName("ServiceKeys").
+		WithValues("Service", serviceName, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Listing service keys")
+
+	jsonResponse, err := c.get(api.Routes.Path("ServiceKeys", c.Config.Org, serviceName))
+	if err != nil {
+		return err
+	}
+	var keys models.ServiceKeysResponse
+	if err := json.Unmarshal(jsonResponse, &keys); err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		c.ui.Normal().Msg("No keys found")
+		return nil
+	}
+
+	msg := c.ui.Success().WithTable("Name")
+	for _, key := range keys {
+		msg = msg.WithTableRow(key)
+	}
+	msg.Msg("Service Keys:")
+
+	return nil
+}
+
+// ServiceKeyCreate creates a named key of the service, with credentials for
+// consumers outside of Epinio
+func (c *EpinioClient) ServiceKeyCreate(serviceName, keyName string) error {
+	log := c.Log.WithName("ServiceKeyCreate").
+		WithValues("Service", serviceName, "Key", keyName, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Key", keyName).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Creating service key...")
+
+	request := models.ServiceKeyCreateRequest{
+		Name: keyName,
+	}
+
+	js, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.post(api.Routes.Path("ServiceKeyCreate", c.Config.Org, serviceName), string(js))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Key", keyName).
+		Msg("Service key created.")
+
+	c.ui.Exclamation().Msgf("Show its credentials with `epinio service key show %s %s`", serviceName, keyName)
+
+	return nil
+}
+
+// ServiceKeyShow shows the credentials of the named key of the service
+func (c *EpinioClient) ServiceKeyShow(serviceName, keyName string) error {
+	log := c.Log.WithName("ServiceKeyShow").
+		WithValues("Service", serviceName, "Key", keyName, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Key", keyName).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Service Key Details")
+
+	jsonResponse, err := c.get(api.Routes.Path("ServiceKeyShow", c.Config.Org, serviceName, keyName))
+	if err != nil {
+		return err
+	}
+	var key models.ServiceKeyResponse
+	if err := json.Unmarshal(jsonResponse, &key); err != nil {
+		return err
+	}
+
+	msg := c.ui.Success().WithTable("Key", "Value")
+	keys := make([]string, 0, len(key.Data))
+	for k := range key.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		msg = msg.WithTableRow(k, key.Data[k])
+	}
+
+	msg.Msg("")
+	return nil
+}
+
+// ServiceKeyDelete removes the named key of the service, revoking its
+// credentials
+func (c *EpinioClient) ServiceKeyDelete(serviceName, keyName string) error {
+	log := c.Log.WithName("ServiceKeyDelete").
+		WithValues("Service", serviceName, "Key", keyName, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Key", keyName).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Deleting service key...")
+
+	_, err := c.delete(api.Routes.Path("ServiceKeyDelete", c.Config.Org, serviceName, keyName))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Key", keyName).
+		Msg("Service key deleted.")
+
+	return nil
+}
+
+// ShareService makes the named service of the current org bindable by the
+// applications of the other org
+func (c *EpinioClient) ShareService(name, org string) error {
+	log := c.Log.WithName("ShareService").
+		WithValues("Name", name, "Organization", c.Config.Org, "Target", org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Name", name).
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Shared With", org).
+		Msg("Sharing Service...")
+
+	request := models.ServiceShareRequest{
+		Org: org,
+	}
+
+	js, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.post(api.Routes.Path("ServiceShare", c.Config.Org, name), string(js))
+	if err != nil {
+		return err
+	}
+
+	c.ui.Success().
+		WithStringValue("Name", name).
+		WithStringValue("Organization", org).
+		Msg("Service shared.")
+
+	return nil
+}
+
+// UnshareService removes the share of the named service of the current org
+// with the other org. The applications of that org bound to the service are
+// unbound when requested, else an error.
+func (c *EpinioClient) UnshareService(name, org string, unbind bool) error {
+	log := c.Log.WithName("UnshareService").
+		WithValues("Name", name, "Organization", c.Config.Org, "Target", org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Name", name).
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Shared With", org).
+		Msg("Unsharing Service...")
+
+	request := models.ServiceUnshareRequest{
+		Unbind: unbind,
+	}
+
+	js, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	jsonResponse, err := c.curl(api.Routes.Path("ServiceUnshare", c.Config.Org, name, org), "DELETE", string(js))
+	if err != nil {
+		return err
+	}
+
+	var unshareResponse models.ServiceUnshareResponse
+	if err := json.Unmarshal(jsonResponse, &unshareResponse); err != nil {
+		return err
+	}
+	if len(unshareResponse.BoundApps) > 0 {
+		sort.Strings(unshareResponse.BoundApps)
+		msg := c.ui.Note().WithTable("Previously Bound To")
+
+		for _, app := range unshareResponse.BoundApps {
+			msg = msg.WithTableRow(app)
+		}
+
+		msg.Msg("")
+	}
+
+	c.ui.Success().
+		WithStringValue("Name", name).
+		WithStringValue("Organization", org).
+		Msg("Service unshared.")
+
+	return nil
+}
+
+// CreateService creates a service specified by name, class, plan, and optional key/value dictionary
+// TODO: Allow underscores in service names (right now they fail because of kubernetes naming rules for secrets)
+func (c *EpinioClient) CreateService(name, class, plan string, data string, waitForProvision bool) error {
+	log := c.Log.WithName("Create Service").
+		WithValues("Name", name, "Class", class, "Plan", plan, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	msg := c.ui.Note().
+		WithStringValue("Name", name).
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Class", class).
+		WithStringValue("Plan", plan).
+		WithTable("Parameter", "Value")
+	msg.Msg("Create Service")
+
+	request := models.CatalogCreateRequest{
+		Name:  name,
+		Class: class,
+		Plan:  plan,
+		Data:  data,
+	}
+
+	js, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	jsonResponse, err := c.post(api.Routes.Path("ServiceCreate", c.Config.Org), string(js))
+	if err != nil {
+		return err
+	}
+	var createResponse models.ServiceOperationResponse
+	if err := json.Unmarshal(jsonResponse, &createResponse); err != nil {
+		return err
+	}
+
+	c.ui.Success().
+		WithStringValue("Name", name).
+		WithStringValue("Organization", c.Config.Org).
+		WithStringValue("Class", class).
+		WithStringValue("Plan", plan).
+		WithStringValue("Operation", createResponse.Operation).
+		Msg("Service Saved.")
+
+	if waitForProvision {
+		c.ui.Note().KeeplineUnder(1).Msg("Provisioning...")
+		err = c.waitForOperation(createResponse.Operation, "Provisioning")
+		if err != nil {
+			return err
+		}
+		c.ui.Success().Msg("Service Provisioned.")
+	} else {
+		c.ui.Note().Msg(fmt.Sprintf("Use `epinio service show %s` to watch when it is provisioned", name))
+	}
+
+	return nil
+}
+
+// ServiceBackups lists the backups of the service, and its backup schedule
+func (c *EpinioClient) ServiceBackups(serviceName string) error {
+	log := c.Log.WithName("ServiceBackups").
+		WithValues("Service", serviceName, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Organization", c.Config.Org).
+		Msg("Listing service backups")
+
+	jsonResponse, err := c.get(api.Routes.Path("ServiceBackups", c.Config.Org, serviceName))
+	if err != nil {
+		return err
+	}
+	var response models.ServiceBackupsResponse
+	if err := json.Unmarshal(jsonResponse, &response); err != nil {
+		return err
+	}
+
+	if response.Schedule != nil {
+		c.ui.Note().
+			WithStringValue("Every", response.Schedule.Every).
+			WithStringValue("Next", response.Schedule.Next.Format(time.RFC3339)).
+			WithStringValue("Keep", strconv.Itoa(response.Schedule.Keep)).
+			Msg("Backup Schedule:")
+	}
+
+	if len(response.Backups) == 0 {
+		c.ui.Normal().Msg("No backups found")
+		return nil
+	}
+
+	msg := c.ui.Success().WithTable("ID", "Created", "Scheduled", "State", "Error")
+	for _, b := range response.Backups {
+		msg = msg.WithTableRow(b.ID, b.Created.Format(time.RFC3339), strconv.FormatBool(b.Scheduled), b.State, b.Error)
+	}
+	msg.Msg("Service Backups:")
+
+	return nil
+}
+
+// ServiceBackupCreate takes a backup of the service, and waits for it if
+// asked to
+func (c *EpinioClient) ServiceBackupCreate(serviceName string, wait bool) error {
+	log := c.Log.WithName("ServiceBackupCreate").
+		WithValues("Service", serviceName, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	c.ui.Note().
+		WithStringValue("Service", serviceName).
 		WithStringValue("Organization", c.Config.Org).
-		Msg("Bind Service")
-
-	request := models.BindRequest{
-		Names: []string{serviceName},
-	}
-
-	js, err := json.Marshal(request)
-	if err != nil {
-		return err
-	}
+		Msg("Backing up service...")
 
-	b, err := c.post(api.Routes.Path("ServiceBindingCreate", c.Config.Org, appName), string(js))
+	jsonResponse, err := c.post(api.Routes.Path("ServiceBackupCreate", c.Config.Org, serviceName), "")
 	if err != nil {
 		return err
 	}
-
-	br := &models.BindResponse{}
-	if err := json.Unmarshal(b, br); err != nil {
+	var response models.ServiceBackupResponse
+	if err := json.Unmarshal(jsonResponse, &response); err != nil {
 		return err
 	}
 
-	if len(br.WasBound) > 0 {
-		c.ui.Success().
-			WithStringValue("Service", serviceName).
-			WithStringValue("Application", appName).
-			WithStringValue("Organization", c.Config.Org).
-			Msg("Service Already Bound to Application.")
+	c.ui.Success().
+		WithStringValue("Service", serviceName).
+		WithStringValue("Backup", response.ID).
+		WithStringValue("Operation", response.Operation).
+		Msg("Backup Started.")
 
+	if !wait {
+		c.ui.Note().Msg(fmt.Sprintf("Use `epinio service backup list %s` to watch when it is done", serviceName))
 		return nil
 	}
 
-	c.ui.Success().
-		WithStringValue("Service", serviceName).
-		WithStringValue("Application", appName).
-		WithStringValue("Organization", c.Config.Org).
-		Msg("Service Bound to Application.")
+	err = c.waitForOperation(response.Operation, "Backing up")
+	if err != nil {
+		return err
+	}
+	c.ui.Success().Msg("Backup Done.")
+
 	return nil
 }
 
-// UnbindService detaches the service specified by name from the named
-// application, both in the targeted organization.
-func (c *EpinioClient) UnbindService(serviceName, appName string) error {
-	log := c.Log.WithName("Unbind Service").
-		WithValues("Name", serviceName, "Application", appName, "Organization", c.Config.Org)
+// ServiceBackupRestore replaces the data of the service with the backup,
+// and waits for it if asked to
+func (c *EpinioClient) ServiceBackupRestore(serviceName, backupID string, wait bool) error {
+	log := c.Log.WithName("ServiceBackupRestore").
+		WithValues("Service", serviceName, "Backup", backupID, "Organization", c.Config.Org)
 	log.Info("start")
 	defer log.Info("return")
 
 	c.ui.Note().
 		WithStringValue("Service", serviceName).
-		WithStringValue("Application", appName).
+		WithStringValue("Backup", backupID).
 		WithStringValue("Organization", c.Config.Org).
-		Msg("Unbind Service from Application")
+		Msg("Restoring service backup...")
 
-	_, err := c.delete(api.Routes.Path("ServiceBindingDelete",
-		c.Config.Org, appName, serviceName))
+	jsonResponse, err := c.post(api.Routes.Path("ServiceBackupRestore", c.Config.Org, serviceName, backupID), "")
 	if err != nil {
 		return err
 	}
+	var response models.ServiceOperationResponse
+	if err := json.Unmarshal(jsonResponse, &response); err != nil {
+		return err
+	}
 
 	c.ui.Success().
 		WithStringValue("Service", serviceName).
-		WithStringValue("Application", appName).
-		WithStringValue("Organization", c.Config.Org).
-		Msg("Service Detached From Application.")
+		WithStringValue("Backup", backupID).
+		WithStringValue("Operation", response.Operation).
+		Msg("Restore Started.")
+
+	if !wait {
+		return nil
+	}
+
+	err = c.waitForOperation(response.Operation, "Restoring")
+	if err != nil {
+		return err
+	}
+	c.ui.Success().Msg("Backup Restored.")
+
 	return nil
 }
 
-// DeleteService deletes a service specified by name
-func (c *EpinioClient) DeleteService(name string, unbind bool) error {
-	log := c.Log.WithName("Delete Service").
-		WithValues("Name", name, "Organization", c.Config.Org)
+// ServiceBackupSchedule makes the server back up the service every
+// interval. A zero interval removes the schedule.
+func (c *EpinioClient) ServiceBackupSchedule(serviceName string, every time.Duration, keep int) error {
+	log := c.Log.WithName("ServiceBackupSchedule").
+		WithValues("Service", serviceName, "Every", every, "Organization", c.Config.Org)
 	log.Info("start")
 	defer log.Info("return")
 
 	c.ui.Note().
-		WithStringValue("Name", name).
+		WithStringValue("Service", serviceName).
+		WithStringValue("Every", every.String()).
 		WithStringValue("Organization", c.Config.Org).
-		Msg("Delete Service")
+		Msg("Scheduling service backups...")
 
-	request := models.DeleteRequest{
-		Unbind: unbind,
+	js, err := json.Marshal(models.ServiceBackupScheduleRequest{Every: every.String(), Keep: keep})
+	if err != nil {
+		return err
 	}
 
-	js, err := json.Marshal(request)
+	_, err = c.put(api.Routes.Path("ServiceBackupSchedule", c.Config.Org, serviceName), string(js))
 	if err != nil {
 		return err
 	}
 
-	jsonResponse, err := c.curlWithCustomErrorHandling(
-		api.Routes.Path("ServiceDelete", c.Config.Org, name),
-		"DELETE", string(js),
-		func(response *http.Response, bodyBytes []byte, err error) error {
-			// nothing special for internal errors and the like
-			if response.StatusCode != http.StatusBadRequest {
-				return err
-			}
-
-			// A bad request happens when the service is
-			// still bound to one or more applications,
-			// and the response contains an array of their
-			// names.
-
-			var apiError api.ErrorResponse
-			if err := json.Unmarshal(bodyBytes, &apiError); err != nil {
-				return err
-			}
-
-			bound := strings.Split(apiError.Errors[0].Details, ",")
+	if every == 0 {
+		c.ui.Success().WithStringValue("Service", serviceName).Msg("Backup Schedule Removed.")
+	} else {
+		c.ui.Success().WithStringValue("Service", serviceName).Msg("Backups Scheduled.")
+	}
 
-			sort.Strings(bound)
-			msg := c.ui.Exclamation().WithTable("Bound Applications")
+	return nil
+}
 
-			for _, app := range bound {
-				msg = msg.WithTableRow(app)
-			}
+// waitForOperation polls the server's operation until it is done, showing
+// its progress messages. A failed operation is an error, as is one which
+// does not finish in time.
+func (c *EpinioClient) waitForOperation(id, action string) error {
+	s := c.ui.Progressf(action)
+	defer s.Stop()
 
-			msg.Msg("Unable to delete service. It is still used by")
-			c.ui.Exclamation().Compact().Msg("Use --unbind to force the issue")
+	// The server gives up on the operation by then, with some slack for
+	// recording that
+	deadline := time.Now().Add(duration.ToOperation() + time.Minute)
+	for {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for operation %s", id)
+		}
 
-			return errors.New(http.StatusText(response.StatusCode))
-		})
-	if err != nil {
-		if err.Error() != "Bad Request" {
+		jsonResponse, err := c.get(api.Routes.Path("Operation", c.Config.Org, id))
+		if err != nil {
 			return err
 		}
-		return nil
-	}
-
-	if len(jsonResponse) > 0 {
-		var deleteResponse models.DeleteResponse
-		if err := json.Unmarshal(jsonResponse, &deleteResponse); err != nil {
+		var op operations.Operation
+		if err := json.Unmarshal(jsonResponse, &op); err != nil {
 			return err
 		}
-		if len(deleteResponse.BoundApps) > 0 {
-			sort.Strings(deleteResponse.BoundApps)
-			msg := c.ui.Note().WithTable("Previously Bound To")
 
-			for _, app := range deleteResponse.BoundApps {
-				msg = msg.WithTableRow(app)
-			}
-
-			msg.Msg("")
+		if len(op.Messages) > 0 {
+			s.ChangeMessage(op.Messages[len(op.Messages)-1])
+		}
+		if op.State == operations.StateFailed {
+			return errors.New(op.Error)
+		}
+		if op.Done() {
+			return nil
 		}
-	}
 
-	c.ui.Success().
-		WithStringValue("Name", name).
-		WithStringValue("Organization", c.Config.Org).
-		Msg("Service Removed.")
-	return nil
+		time.Sleep(duration.PollInterval())
+	}
 }
 
-// CreateService creates a service specified by name, class, plan, and optional key/value dictionary
+// CreateCustomService creates a service specified by name and key/value dictionary
 // TODO: Allow underscores in service names (right now they fail because of kubernetes naming rules for secrets)
-func (c *EpinioClient) CreateService(name, class, plan string, data string, waitForProvision bool) error {
-	log := c.Log.WithName("Create Service").
-		WithValues("Name", name, "Class", class, "Plan", plan, "Organization", c.Config.Org)
+func (c *EpinioClient) CreateCustomService(name string, dict []string) error {
+	log := c.Log.WithName("Create Custom Service").
+		WithValues("Name", name, "Organization", c.Config.Org)
 	log.Info("start")
 	defer log.Info("return")
 
+	data := make(map[string]string)
 	msg := c.ui.Note().
 		WithStringValue("Name", name).
 		WithStringValue("Organization", c.Config.Org).
-		WithStringValue("Class", class).
-		WithStringValue("Plan", plan).
 		WithTable("Parameter", "Value")
-	msg.Msg("Create Service")
+	for i := 0; i < len(dict); i += 2 {
+		key := dict[i]
+		value := dict[i+1]
+		msg = msg.WithTableRow(key, value)
+		data[key] = value
+	}
+	msg.Msg("Create Custom Service")
 
-	request := models.CatalogCreateRequest{
-		Name:             name,
-		Class:            class,
-		Plan:             plan,
-		Data:             data,
-		WaitForProvision: waitForProvision,
+	request := models.CustomCreateRequest{
+		Name: name,
+		Data: data,
 	}
 
 	js, err := json.Marshal(request)
@@ -512,13 +1259,8 @@ func (c *EpinioClient) CreateService(name, class, plan string, data string, wait
 		return err
 	}
 
-	if waitForProvision {
-		c.ui.Note().KeeplineUnder(1).Msg("Provisioning...")
-		s := c.ui.Progressf("Provisioning")
-		defer s.Stop()
-	}
-
-	_, err = c.post(api.Routes.Path("ServiceCreate", c.Config.Org), string(js))
+	_, err = c.post(api.Routes.Path("ServiceCreateCustom", c.Config.Org),
+		string(js))
 	if err != nil {
 		return err
 	}
@@ -526,60 +1268,120 @@ func (c *EpinioClient) CreateService(name, class, plan string, data string, wait
 	c.ui.Success().
 		WithStringValue("Name", name).
 		WithStringValue("Organization", c.Config.Org).
-		WithStringValue("Class", class).
-		WithStringValue("Plan", plan).
 		Msg("Service Saved.")
-
-	if waitForProvision {
-		c.ui.Success().Msg("Service Provisioned.")
-	} else {
-		c.ui.Note().Msg(fmt.Sprintf("Use `epinio service %s` to watch when it is provisioned", name))
-	}
-
 	return nil
 }
 
-// CreateCustomService creates a service specified by name and key/value dictionary
-// TODO: Allow underscores in service names (right now they fail because of kubernetes naming rules for secrets)
-func (c *EpinioClient) CreateCustomService(name string, dict []string) error {
-	log := c.Log.WithName("Create Custom Service").
+// UpdateCustomService changes the data of a custom service. Unless
+// noRestart is set the server restarts the applications bound to it.
+func (c *EpinioClient) UpdateCustomService(name string, set map[string]string, unset []string, noRestart bool) error {
+	log := c.Log.WithName("Update Custom Service").
 		WithValues("Name", name, "Organization", c.Config.Org)
 	log.Info("start")
 	defer log.Info("return")
 
-	data := make(map[string]string)
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 	msg := c.ui.Note().
 		WithStringValue("Name", name).
 		WithStringValue("Organization", c.Config.Org).
 		WithTable("Parameter", "Value")
-	for i := 0; i < len(dict); i += 2 {
-		key := dict[i]
-		value := dict[i+1]
-		msg = msg.WithTableRow(key, value)
-		data[key] = value
+	for _, k := range keys {
+		msg = msg.WithTableRow(k, set[k])
 	}
-	msg.Msg("Create Custom Service")
-
-	request := models.CustomCreateRequest{
-		Name: name,
-		Data: data,
+	for _, k := range unset {
+		msg = msg.WithTableRow(k, "(removed)")
 	}
+	msg.Msg("Update Custom Service")
 
-	js, err := json.Marshal(request)
+	js, err := json.Marshal(models.CustomUpdateRequest{
+		Set:       set,
+		Unset:     unset,
+		NoRestart: noRestart,
+	})
 	if err != nil {
 		return err
 	}
 
-	_, err = c.post(api.Routes.Path("ServiceCreateCustom", c.Config.Org),
+	response, err := c.patch(api.Routes.Path("ServiceUpdateCustom", c.Config.Org, name),
 		string(js))
 	if err != nil {
 		return err
 	}
 
-	c.ui.Success().
+	var updateResponse models.CustomUpdateResponse
+	if err := json.Unmarshal(response, &updateResponse); err != nil {
+		return err
+	}
+
+	success := c.ui.Success().
 		WithStringValue("Name", name).
-		WithStringValue("Organization", c.Config.Org).
-		Msg("Service Saved.")
+		WithStringValue("Organization", c.Config.Org)
+	if len(updateResponse.RestartedApps) > 0 {
+		success = success.WithStringValue("Restarted Applications",
+			strings.Join(updateResponse.RestartedApps, ", "))
+	}
+	success.Msg("Service Updated.")
+
+	for _, message := range updateResponse.RestartErrors {
+		c.ui.Exclamation().Msg(message)
+	}
+	return nil
+}
+
+// UpdateService changes the plan and the parameters of a catalog service
+func (c *EpinioClient) UpdateService(name, plan, data string, waitForCompletion bool) error {
+	log := c.Log.WithName("Update Service").
+		WithValues("Name", name, "Plan", plan, "Organization", c.Config.Org)
+	log.Info("start")
+	defer log.Info("return")
+
+	msg := c.ui.Note().
+		WithStringValue("Name", name).
+		WithStringValue("Organization", c.Config.Org)
+	if plan != "" {
+		msg = msg.WithStringValue("Plan", plan)
+	}
+	if data != "" {
+		msg = msg.WithStringValue("Parameters", data)
+	}
+	msg.Msg("Update Service")
+
+	js, err := json.Marshal(models.ServiceUpdateRequest{
+		Plan: plan,
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	jsonResponse, err := c.patch(api.Routes.Path("ServiceUpdate", c.Config.Org, name), string(js))
+	if err != nil {
+		return err
+	}
+	var updateResponse models.ServiceOperationResponse
+	if err := json.Unmarshal(jsonResponse, &updateResponse); err != nil {
+		return err
+	}
+
+	if waitForCompletion {
+		c.ui.Note().KeeplineUnder(1).Msg("Updating...")
+		err = c.waitForOperation(updateResponse.Operation, "Updating")
+		if err != nil {
+			return err
+		}
+
+		c.ui.Success().
+			WithStringValue("Name", name).
+			WithStringValue("Organization", c.Config.Org).
+			Msg("Service Updated.")
+	} else {
+		c.ui.Note().Msg(fmt.Sprintf("Use `epinio service show %s` to watch when it is updated", name))
+	}
+
 	return nil
 }
 
@@ -750,6 +1552,15 @@ func (c *EpinioClient) AppShow(appName string) error {
 		WithTableRow("Services", strings.Join(app.BoundServices, ", ")).
 		Msg("Details:")
 
+	if len(app.History) > 0 {
+		msg := c.ui.Note().WithTable("Stage Id", "Revision", "Trigger", "Time")
+		for _, record := range app.History {
+			msg = msg.WithTableRow(record.ID, record.Revision, record.Trigger,
+				record.Time.Format(time.RFC3339))
+		}
+		msg.Msg("Staging History:")
+	}
+
 	return nil
 }
 
@@ -1118,6 +1929,22 @@ func (c *EpinioClient) Push(ctx context.Context, name, rev, source string, param
 		sourceToShow = fmt.Sprintf("%s @ %s", sourceToShow, rev)
 	}
 
+	// Archives are pushed as their content
+	if rev == "" {
+		dir, tmpDir, err := unpackSource(source)
+		if tmpDir != "" {
+			defer os.RemoveAll(tmpDir)
+		}
+		if err != nil {
+			return err
+		}
+		source = dir
+	}
+
+	if params.DryRun {
+		return c.pushDryRun(source, params.GitIgnore)
+	}
+
 	msg := c.ui.Note().
 		WithStringValue("Name", appRef.Name).
 		WithStringValue("Sources", sourceToShow).
@@ -1164,11 +1991,12 @@ func (c *EpinioClient) Push(ctx context.Context, name, rev, source string, param
 	}
 
 	var gitRef *models.GitRef
+	var archive *models.ArchiveRef
 
 	if rev == "" {
 		c.ui.Normal().Msg("Collecting the application sources ...")
 
-		tmpDir, tarball, err := collectSources(log, source)
+		tmpDir, tarball, manifest, err := c.collectSources(log, appRef, source, params.GitIgnore, params.Compression)
 		defer func() {
 			if tmpDir != "" {
 				_ = os.RemoveAll(tmpDir)
@@ -1181,13 +2009,14 @@ func (c *EpinioClient) Push(ctx context.Context, name, rev, source string, param
 		c.ui.Normal().Msg("Uploading application code ...")
 
 		details.Info("upload code")
-		upload, err := c.uploadCode(appRef, tarball)
+		upload, err := c.uploadCode(appRef, tarball, manifest)
 		if err != nil {
 			return err
 		}
 		log.V(3).Info("upload response", "response", upload)
 
 		gitRef = upload.Git
+		archive = upload.Archive
 	} else {
 		gitRef = &models.GitRef{
 			URL:      source,
@@ -1205,9 +2034,10 @@ func (c *EpinioClient) Push(ctx context.Context, name, rev, source string, param
 		App:       appRef,
 		Instances: params.Instances,
 		Git:       gitRef,
+		Archive:   archive,
 		Route:     route,
 	}
-	details.Info("staging code", "Git", gitRef.Revision)
+	details.Info("staging code", "Git", gitRef, "Archive", archive)
 	stage, err := c.stageCode(req)
 	if err != nil {
 		return err
@@ -1235,6 +2065,8 @@ func (c *EpinioClient) Push(ctx context.Context, name, rev, source string, param
 	err = c.waitForPipelineRun(ctx, appRef, stage.Stage.ID)
 	if err != nil {
 		stopChan <- true // Stop the printing go routine
+		wg.Wait()        // Keep the diagnostics below the logs
+		c.showStagingFailure(appRef, stage.Stage.ID)
 		return errors.Wrap(err, "waiting for staging failed")
 	}
 	stopChan <- true // Stop the printing go routine
@@ -1365,37 +2197,6 @@ func (c *EpinioClient) deleteCertificate(ctx context.Context, appName string) er
 	return nil
 }
 
-func (c *EpinioClient) ServicesToApps(ctx context.Context, org string) (map[string]models.AppList, error) {
-	// Determine apps bound to services
-	// (inversion of services bound to apps)
-	// Literally query apps in the org for their services and invert.
-
-	var appsOf = map[string]models.AppList{}
-
-	apps, err := application.List(ctx, c.Cluster, c.Config.Org)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, app := range apps {
-		w := application.NewWorkload(c.Cluster, app.AppRef())
-		bound, err := w.Services(ctx)
-		if err != nil {
-			return nil, err
-		}
-		for _, bonded := range bound {
-			bname := bonded.Name()
-			if theapps, found := appsOf[bname]; found {
-				appsOf[bname] = append(theapps, app)
-			} else {
-				appsOf[bname] = models.AppList{app}
-			}
-		}
-	}
-
-	return appsOf, nil
-}
-
 func (c *EpinioClient) get(endpoint string) ([]byte, error) {
 	return c.curl(endpoint, "GET", "")
 }
@@ -1404,6 +2205,10 @@ func (c *EpinioClient) post(endpoint string, data string) ([]byte, error) {
 	return c.curl(endpoint, "POST", data)
 }
 
+func (c *EpinioClient) put(endpoint string, data string) ([]byte, error) {
+	return c.curl(endpoint, "PUT", data)
+}
+
 func (c *EpinioClient) patch(endpoint string, data string) ([]byte, error) {
 	return c.curl(endpoint, "PATCH", data)
 }
@@ -0,0 +1,20 @@
+package gitea_test
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestGitea(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gitea Suite")
+}
+
+var _ = BeforeSuite(func() {
+	// Serve local repositories in-process, without the git binaries
+	client.InstallProtocol("file", server.DefaultServer)
+})
@@ -0,0 +1,229 @@
+package gitea
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/errors"
+)
+
+const (
+	// pushRemote is the name of the remote the sources are pushed to
+	pushRemote = "epinio"
+	// pushBranch is the branch of the application repositories
+	pushBranch = plumbing.ReferenceName("refs/heads/main")
+)
+
+var (
+	authorMu    sync.Mutex
+	authorName  = "Epinio"
+	authorEmail = "ci@epinio"
+)
+
+// SetCommitAuthor sets the author and committer of the commits made for
+// pushed sources
+func SetCommitAuthor(name, email string) {
+	authorMu.Lock()
+	defer authorMu.Unlock()
+	authorName = name
+	authorEmail = email
+}
+
+func commitSignature() *object.Signature {
+	authorMu.Lock()
+	defer authorMu.Unlock()
+	return &object.Signature{
+		Name:  authorName,
+		Email: authorEmail,
+		When:  time.Now(),
+	}
+}
+
+// Push commits the sources in dir on top of the main branch of the remote
+// repository, and pushes the commit. It returns the revision of the
+// commit. A repository in dir is reused, else one is created. The remote
+// has to exist, it may be empty.
+func Push(dir, remote string, auth transport.AuthMethod) (string, error) {
+	repo, err := git.PlainOpen(dir)
+	if err == git.ErrRepositoryNotExists {
+		repo, err = git.PlainInit(dir, false)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open repository")
+	}
+
+	err = repo.DeleteRemote(pushRemote)
+	if err != nil && err != git.ErrRemoteNotFound {
+		return "", errors.Wrap(err, "failed to remove stale remote")
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: pushRemote,
+		URLs: []string{remote},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to add remote")
+	}
+
+	// Like `git fetch && git reset --soft`: the branch moves to the
+	// remote's head, the work tree stays as it is
+	parent, err := fetchHead(repo, auth)
+	if err != nil {
+		return "", err
+	}
+	err = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, pushBranch))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to switch branch")
+	}
+	if !parent.IsZero() {
+		err = repo.Storer.SetReference(plumbing.NewHashReference(pushBranch, parent))
+		if err != nil {
+			return "", errors.Wrap(err, "failed to reset branch")
+		}
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open work tree")
+	}
+	err = wt.AddWithOptions(&git.AddOptions{All: true})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to add sources")
+	}
+	// Adding does not stage the files removed from a checked out work
+	// tree
+	status, err := wt.Status()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get status of sources")
+	}
+	for path, s := range status {
+		if s.Worktree == git.Deleted {
+			_, err = wt.Remove(path)
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to remove '%s' from sources", path)
+			}
+		}
+	}
+
+	signature := commitSignature()
+	rev, err := wt.Commit(fmt.Sprintf("pushed at %s", signature.When.Format("20060102150405")), &git.CommitOptions{
+		Author:    signature,
+		Committer: signature,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to commit sources")
+	}
+
+	// The progress receives the messages of the remote, for the error
+	progress := &bytes.Buffer{}
+	err = repo.Push(&git.PushOptions{
+		RemoteName: pushRemote,
+		RefSpecs:   []config.RefSpec{config.RefSpec(pushBranch + ":" + pushBranch)},
+		Auth:       auth,
+		Progress:   progress,
+	})
+	if err != nil {
+		return "", remoteError(err, "failed to push sources", progress)
+	}
+
+	return rev.String(), nil
+}
+
+// fetchHead fetches the main branch of the remote and returns its head. The
+// hash is zero for a remote without main branch.
+func fetchHead(repo *git.Repository, auth transport.AuthMethod) (plumbing.Hash, error) {
+	tracking := plumbing.NewRemoteReferenceName(pushRemote, pushBranch.Short())
+
+	progress := &bytes.Buffer{}
+	err := repo.Fetch(&git.FetchOptions{
+		RemoteName: pushRemote,
+		RefSpecs:   []config.RefSpec{config.RefSpec("+" + pushBranch + ":" + tracking)},
+		Auth:       auth,
+		Progress:   progress,
+	})
+	switch {
+	case err == nil, err == git.NoErrAlreadyUpToDate:
+	case err == transport.ErrEmptyRemoteRepository, errors.Is(err, git.NoMatchingRefSpecError{}):
+		return plumbing.ZeroHash, nil
+	default:
+		return plumbing.ZeroHash, remoteError(err, "failed to fetch previous sources", progress)
+	}
+
+	ref, err := repo.Reference(tracking, true)
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrap(err, "failed to resolve previous sources")
+	}
+	return ref.Hash(), nil
+}
+
+// clone checks the main branch of the remote out into dir
+func clone(dir, remote string, auth transport.AuthMethod) error {
+	progress := &bytes.Buffer{}
+	_, err := git.PlainClone(dir, false, &git.CloneOptions{
+		URL:           remote,
+		Auth:          auth,
+		ReferenceName: pushBranch,
+		SingleBranch:  true,
+		Progress:      progress,
+	})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return remoteError(err, "failed to clone previous sources", progress)
+	}
+	return nil
+}
+
+// commitPattern matches the full or abbreviated hash of a commit
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+
+// checkoutRevision updates the work tree of the repository in dir to the
+// revision. It returns false for an unknown revision. Revisions are commit
+// hashes, anything else is unknown. The revision parser of go-git fails on
+// ranges and reflog syntax, or does not return at all.
+func checkoutRevision(dir, revision string) (bool, error) {
+	if !commitPattern.MatchString(revision) {
+		return false, nil
+	}
+
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open repository")
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err == plumbing.ErrReferenceNotFound || err == plumbing.ErrObjectNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to resolve revision '%s'", revision)
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open work tree")
+	}
+	err = wt.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to check out revision '%s'", revision)
+	}
+
+	return true, nil
+}
+
+// remoteError adds the messages of the remote to the error
+func remoteError(err error, message string, progress *bytes.Buffer) error {
+	output := strings.TrimSpace(progress.String())
+	if output == "" {
+		return errors.Wrap(err, message)
+	}
+	return errors.Wrapf(err, "%s, remote said: %s", message, output)
+}
@@ -0,0 +1,146 @@
+package gitea_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/epinio/epinio/internal/cli/clients/gitea"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Push", func() {
+	var (
+		tmpDir string
+		remote string
+	)
+
+	writeFile := func(dir, name, content string) {
+		p := filepath.Join(dir, name)
+		Expect(os.MkdirAll(filepath.Dir(p), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(p, []byte(content), 0644)).To(Succeed())
+	}
+
+	// pushed returns the commit at the head of the remote's main branch
+	pushed := func() *object.Commit {
+		repo, err := git.PlainOpen(remote)
+		Expect(err).ToNot(HaveOccurred())
+		ref, err := repo.Reference(plumbing.ReferenceName("refs/heads/main"), true)
+		Expect(err).ToNot(HaveOccurred())
+		commit, err := repo.CommitObject(ref.Hash())
+		Expect(err).ToNot(HaveOccurred())
+		return commit
+	}
+
+	files := func(commit *object.Commit) []string {
+		names := []string{}
+		iter, err := commit.Files()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(iter.ForEach(func(f *object.File) error {
+			names = append(names, f.Name)
+			return nil
+		})).To(Succeed())
+		return names
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "epinio-push-test")
+		Expect(err).ToNot(HaveOccurred())
+
+		remote = filepath.Join(tmpDir, "remote.git")
+		_, err = git.PlainInit(remote, true)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		SetCommitAuthor("Epinio", "ci@epinio")
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("pushes the sources to an empty repository", func() {
+		// Quotes in the path broke the former shell script
+		src := filepath.Join(tmpDir, `it's "src"`)
+		writeFile(src, "main.go", "package main")
+		writeFile(src, "pkg/lib.go", "package pkg")
+
+		rev, err := Push(src, remote, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		commit := pushed()
+		Expect(commit.Hash.String()).To(Equal(rev))
+		Expect(commit.NumParents()).To(Equal(0))
+		Expect(files(commit)).To(ConsistOf("main.go", "pkg/lib.go"))
+		Expect(commit.Author.Name).To(Equal("Epinio"))
+	})
+
+	It("commits on top of the previous sources", func() {
+		first := filepath.Join(tmpDir, "first")
+		writeFile(first, "main.go", "package main")
+		writeFile(first, "old.go", "package main // old")
+		previous, err := Push(first, remote, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		second := filepath.Join(tmpDir, "second")
+		writeFile(second, "main.go", "package main // changed")
+		writeFile(second, "new.go", "package main // new")
+		_, err = Push(second, remote, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		commit := pushed()
+		Expect(commit.ParentHashes).To(Equal([]plumbing.Hash{plumbing.NewHash(previous)}))
+		Expect(files(commit)).To(ConsistOf("main.go", "new.go"))
+
+		file, err := commit.File("main.go")
+		Expect(err).ToNot(HaveOccurred())
+		content, err := file.Contents()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(content).To(Equal("package main // changed"))
+	})
+
+	It("removes the files deleted from a checked out repository", func() {
+		first := filepath.Join(tmpDir, "first")
+		writeFile(first, "main.go", "package main")
+		writeFile(first, "old.go", "package main // old")
+		_, err := Push(first, remote, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		clone := filepath.Join(tmpDir, "clone")
+		_, err = git.PlainClone(clone, false, &git.CloneOptions{
+			URL:           remote,
+			ReferenceName: plumbing.ReferenceName("refs/heads/main"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Remove(filepath.Join(clone, "old.go"))).To(Succeed())
+
+		_, err = Push(clone, remote, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files(pushed())).To(ConsistOf("main.go"))
+	})
+
+	It("records the configured author", func() {
+		SetCommitAuthor("Builder", "builder@example.com")
+
+		src := filepath.Join(tmpDir, "src")
+		writeFile(src, "main.go", "package main")
+		_, err := Push(src, remote, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		commit := pushed()
+		Expect(commit.Author.Name).To(Equal("Builder"))
+		Expect(commit.Author.Email).To(Equal("builder@example.com"))
+		Expect(commit.Committer.Email).To(Equal("builder@example.com"))
+	})
+
+	It("reports a missing remote", func() {
+		src := filepath.Join(tmpDir, "src")
+		writeFile(src, "main.go", "package main")
+
+		_, err := Push(src, filepath.Join(tmpDir, "missing.git"), nil)
+		Expect(err).To(MatchError(ContainSubstring("failed to fetch previous sources")))
+	})
+})
@@ -0,0 +1,61 @@
+package gitea
+
+import (
+	"net/url"
+	"path"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/pkg/errors"
+)
+
+// Checkout clones the app into dir, and checks the revision out. An empty
+// revision is the latest. It returns false if the app has no repository
+// yet, or the revision is not known.
+func (c *Client) Checkout(app models.AppRef, dir, revision string) (bool, error) {
+	_, resp, err := c.Client.GetRepo(app.Org, app.Name)
+	if resp == nil && err != nil {
+		return false, errors.Wrap(err, "failed to make get repo request")
+	}
+	if resp.StatusCode == 404 {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get repo")
+	}
+
+	remote, err := c.remote(app)
+	if err != nil {
+		return false, err
+	}
+
+	err = clone(dir, remote, c.basicAuth())
+	if err != nil {
+		return false, err
+	}
+
+	if revision == "" {
+		return true, nil
+	}
+	return checkoutRevision(dir, revision)
+}
+
+// remote returns the url of the app's repository
+func (c *Client) remote(app models.AppRef) (string, error) {
+	u, err := url.Parse(deployments.GiteaURL)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse gitea url")
+	}
+	u.Path = path.Join(u.Path, app.Org, app.Name)
+
+	return u.String(), nil
+}
+
+// basicAuth returns the credentials for git operations on the repositories
+func (c *Client) basicAuth() *githttp.BasicAuth {
+	return &githttp.BasicAuth{
+		Username: c.Auth.Username,
+		Password: c.Auth.Password,
+	}
+}
@@ -1,13 +1,6 @@
 package gitea
 
 import (
-	"fmt"
-	"net/url"
-	"os/exec"
-	"path"
-	"strings"
-	"time"
-
 	giteaSDK "code.gitea.io/sdk/gitea"
 	"github.com/epinio/epinio/deployments"
 	"github.com/epinio/epinio/internal/api/v1/models"
@@ -29,16 +22,14 @@ func (c *Client) Upload(app models.AppRef, tmpDir string) (models.GitRef, error)
 		return g, errors.Wrap(err, "failed to create application")
 	}
 
-	u, err := url.Parse(deployments.GiteaURL)
+	remote, err := c.remote(app)
 	if err != nil {
-		return g, errors.Wrap(err, "failed to parse gitea url")
+		return g, err
 	}
-	u.User = url.UserPassword(c.Auth.Username, c.Auth.Password)
-	u.Path = path.Join(u.Path, app.Org, app.Name)
 
-	rev, err := c.gitPush(u.String(), tmpDir)
+	rev, err := Push(tmpDir, remote, c.basicAuth())
 	if err != nil {
-		return g, errors.Wrap(err, "failed to get latest app commit")
+		return g, err
 	}
 
 	g = models.GitRef{
@@ -72,38 +63,3 @@ func (c *Client) createRepo(org string, name string) error {
 
 	return nil
 }
-
-// gitPush the app data
-func (c *Client) gitPush(remote string, tmpDir string) (string, error) {
-	cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf(`
-cd "%s" 
-git init
-git config user.name "Epinio"
-git config user.email ci@epinio
-git remote add epinio "%s"
-git fetch --all
-git reset --soft epinio/main
-git add --all
-git commit -m "pushed at %s"
-git push epinio %s:main
-`, tmpDir, remote, time.Now().Format("20060102150405"), "`git branch --show-current`"))
-
-	_, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", errors.Wrap(err, "push script failed")
-	}
-
-	// extract commit sha
-	cmd = exec.Command("/bin/sh", "-c", fmt.Sprintf(`
-cd "%s"
-git rev-parse HEAD
-`, tmpDir))
-
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", errors.Wrap(err, "failed to determine last commit")
-	}
-
-	rev := strings.TrimSuffix(string(out), "\n")
-	return rev, nil
-}
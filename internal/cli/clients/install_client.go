@@ -95,6 +95,11 @@ func (c *InstallClient) Install(cmd *cobra.Command) error {
 	details.Info("show option configuration")
 	c.showInstallConfiguration(c.options)
 
+	err = c.validateSourceStore()
+	if err != nil {
+		return err
+	}
+
 	// TODO (post MVP): Run a validation phase which perform
 	// additional checks on the values. For example range limits,
 	// proper syntax of the string, etc. do it as pghase, and late
@@ -132,17 +137,26 @@ func (c *InstallClient) Install(cmd *cobra.Command) error {
 
 	c.ui.Success().Msg("Created system_domain: " + domain.Value.(string))
 
-	installationWg := &sync.WaitGroup{}
-	for _, deployment := range []kubernetes.Deployment{
+	sourceStore, err := c.options.GetString("source_store", "")
+	if err != nil {
+		return err
+	}
+	toInstall := []kubernetes.Deployment{
 		&deployments.Kubed{Timeout: duration.ToDeployment()},
 		&deployments.CertManager{Timeout: duration.ToDeployment()},
 		&deployments.Epinio{Timeout: duration.ToDeployment()},
 		&deployments.Quarks{Timeout: duration.ToDeployment()},
-		&deployments.Gitea{Timeout: duration.ToDeployment()},
 		&deployments.Registry{Timeout: duration.ToDeployment()},
 		&deployments.Tekton{Timeout: duration.ToDeployment()},
 		&deployments.ServiceCatalog{Timeout: duration.ToDeployment()},
-	} {
+	}
+	// Sources kept in S3 need no gitea
+	if sourceStore != "s3" {
+		toInstall = append(toInstall, &deployments.Gitea{Timeout: duration.ToDeployment()})
+	}
+
+	installationWg := &sync.WaitGroup{}
+	for _, deployment := range toInstall {
 		installationWg.Add(1)
 		go func(deployment kubernetes.Deployment, wg *sync.WaitGroup) {
 			defer wg.Done()
@@ -409,3 +423,30 @@ func (c *InstallClient) traefikServiceIngressInfo(ctx context.Context) (string,
 
 	return string(traefikServiceIngressInfo), nil
 }
+
+// validateSourceStore checks that a source store in S3 has its bucket
+// configured, as the epinio server would fail to start otherwise
+func (c *InstallClient) validateSourceStore() error {
+	sourceStore, err := c.options.GetString("source_store", "")
+	if err != nil {
+		return err
+	}
+
+	switch sourceStore {
+	case "gitea":
+		return nil
+	case "s3":
+		for _, name := range []string{"s3_endpoint", "s3_bucket"} {
+			value, err := c.options.GetString(name, "")
+			if err != nil {
+				return err
+			}
+			if value == "" {
+				return fmt.Errorf("the s3 source store needs the option %s", name)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown source store '%s', expected 'gitea' or 's3'", sourceStore)
+}
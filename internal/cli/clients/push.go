@@ -1,63 +1,515 @@
 package clients
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"os"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/epinio/epinio/deployments"
+	"github.com/avast/retry-go"
+	"github.com/epinio/epinio/helpers"
 	api "github.com/epinio/epinio/internal/api/v1"
 	"github.com/epinio/epinio/internal/api/v1/models"
 	"github.com/epinio/epinio/internal/duration"
 	"github.com/go-logr/logr"
-	"github.com/mholt/archiver/v3"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pkg/errors"
-	tekton "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
-func collectSources(log logr.Logger, source string) (string, string, error) {
-	files, err := ioutil.ReadDir(source)
-	if err != nil {
-		return "", "", errors.Wrap(err, "cannot read the apps source files")
-	}
-	sources := []string{}
-	for _, f := range files {
-		// The FileInfo entries returned by ReadDir provide
-		// only the base name of the file or directory they
-		// are for. We have to add back the path of the
-		// application directory to get the proper paths to
-		// the files and directories to assemble in the
-		// tarball.
-		// Ignore git config files in the app sources to prevent conflicts with the gitea git repo
-		if f.Name() == ".git" || f.Name() == ".gitignore" || f.Name() == ".gitmodules" || f.Name() == ".gitconfig" || f.Name() == ".git-credentials" {
-			log.V(3).Info(fmt.Sprintf("Skipping upload of file/dir '%s'.", f.Name()))
+// ignoreFile is the name of the files listing the sources not to push
+const ignoreFile = ".epinioignore"
+
+// skippedSources are never pushed. Git config files in the app sources
+// conflict with the gitea git repo.
+var skippedSources = map[string]bool{
+	".git":             true,
+	".gitignore":       true,
+	".gitmodules":      true,
+	".gitconfig":       true,
+	".git-credentials": true,
+	ignoreFile:         true,
+}
+
+// sourceFile is a file of the application sources
+type sourceFile struct {
+	name string // slash separated, relative to the sources directory
+	path string
+	info os.FileInfo
+}
+
+// listSources walks the application sources and returns the files to push.
+// The .epinioignore files found along the way exclude files and directories
+// below them, using the .gitignore syntax. With gitignore set, a .gitignore
+// is used in directories without .epinioignore.
+func listSources(log logr.Logger, source string, gitignore bool) ([]sourceFile, error) {
+	matcher := helpers.NewIgnoreMatcher()
+	sources := []sourceFile{}
+
+	err := filepath.Walk(source, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		if name != "." {
+			if skippedSources[info.Name()] {
+				log.V(3).Info(fmt.Sprintf("Skipping upload of file/dir '%s'.", name))
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if matcher.Ignored(name, info.IsDir()) {
+				log.V(3).Info(fmt.Sprintf("Ignoring file/dir '%s'.", name))
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if !info.IsDir() {
+			sources = append(sources, sourceFile{name: name, path: p, info: info})
+			return nil
+		}
+
+		// Load the ignore file of the directory before walking into it
+		for _, candidate := range ignoreFiles(gitignore) {
+			content, err := ioutil.ReadFile(filepath.Join(p, candidate))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			log.V(3).Info("found ignore file", "dir", name, "file", candidate)
+			matcher.Add(name, content)
+			break
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read the apps source files")
+	}
+
+	return sources, nil
+}
+
+func ignoreFiles(gitignore bool) []string {
+	if gitignore {
+		return []string{ignoreFile, ".gitignore"}
+	}
+	return []string{ignoreFile}
+}
+
+// collectSources writes the application sources into a tarball, in a new
+// temp directory. The caller has to remove the directory. If the server
+// supports incremental uploads the tarball only holds the files it lacks,
+// and the returned manifest describes all files.
+func (c *EpinioClient) collectSources(log logr.Logger, app models.AppRef, source string, gitignore bool, compression string) (string, string, []models.SourceFile, error) {
+	sources, err := listSources(log, source, gitignore)
+	if err != nil {
+		return "", "", nil, err
+	}
+	log.V(3).Info("found app data files", "count", len(sources))
+
+	manifest := []models.SourceFile{}
+	for _, source := range sources {
+		if !source.info.Mode().IsRegular() {
 			continue
 		}
-		sources = append(sources, path.Join(source, f.Name()))
+		sum, err := helpers.FileSHA256(source.path)
+		if err != nil {
+			return "", "", nil, errors.Wrap(err, "failed to checksum the apps source files")
+		}
+		manifest = append(manifest, models.SourceFile{
+			Path:   source.name,
+			SHA256: sum,
+			Mode:   uint32(source.info.Mode().Perm()),
+			Size:   source.info.Size(),
+		})
+	}
+
+	missing, err := c.sourceManifest(app, manifest)
+	switch {
+	case err == errUploadNotSupported:
+		manifest = nil
+	case err != nil:
+		return "", "", nil, err
+	default:
+		// Symlinks are not in the manifest, and always sent
+		needed := map[string]bool{}
+		for _, name := range missing {
+			needed[name] = true
+		}
+		delta := []sourceFile{}
+		for _, source := range sources {
+			if needed[source.name] || !source.info.Mode().IsRegular() {
+				delta = append(delta, source)
+			}
+		}
+		log.V(3).Info("incremental upload", "files", len(delta))
+		c.ui.Normal().Msgf("%d of %d files changed", len(missing), len(manifest))
+		sources = delta
 	}
-	log.V(3).Info("found app data files", "files", sources)
 
 	// create a tmpDir - tarball dir and POST
 	tmpDir, err := ioutil.TempDir("", "epinio-app")
 	if err != nil {
-		return "", "", errors.Wrap(err, "can't create temp directory")
+		return "", "", nil, errors.Wrap(err, "can't create temp directory")
 	}
 
 	tarball := path.Join(tmpDir, "blob.tar")
-	err = archiver.Archive(sources, tarball)
+	err = writeTarball(tarball, sources, compression)
+	if err != nil {
+		return tmpDir, "", nil, errors.Wrap(err, "can't create archive")
+	}
+
+	return tmpDir, tarball, manifest, nil
+}
+
+// sourceManifest asks the server which files of the manifest it lacks
+func (c *EpinioClient) sourceManifest(app models.AppRef, manifest []models.SourceFile) ([]string, error) {
+	js, err := json.Marshal(models.SourceManifestRequest{Files: manifest})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.curlWithCustomErrorHandling(api.Routes.Path("AppSourceManifest", app.Org, app.Name), "POST", string(js),
+		func(response *http.Response, bodyBytes []byte, err error) error {
+			if response.StatusCode == http.StatusNotFound {
+				return errUploadNotSupported
+			}
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := models.SourceManifestResponse{}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Missing, nil
+}
+
+// Compressions of the uploaded tarball. The server detects them by content.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// Compressions lists the supported compressions of uploads
+var Compressions = []string{CompressionGzip, CompressionZstd, CompressionNone}
+
+// writeTarball archives the files under their relative names, compressed
+// as requested. Directories are implied by the file names.
+func writeTarball(tarball string, sources []sourceFile, compression string) error {
+	out, err := os.Create(tarball)
 	if err != nil {
-		return tmpDir, "", errors.Wrap(err, "can't create archive")
+		return err
+	}
+	defer out.Close()
+
+	var compressor io.WriteCloser
+	switch compression {
+	case CompressionGzip:
+		compressor = gzip.NewWriter(out)
+	case CompressionZstd:
+		compressor, err = zstd.NewWriter(out)
+		if err != nil {
+			return err
+		}
+	case CompressionNone, "":
+	default:
+		return fmt.Errorf("unknown compression '%s'", compression)
+	}
+
+	var w io.Writer = out
+	if compressor != nil {
+		w = compressor
+	}
+
+	tw := tar.NewWriter(w)
+	for _, source := range sources {
+		link := ""
+		if source.info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(source.path)
+			if err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(source.info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = source.name
+
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+		if !source.info.Mode().IsRegular() {
+			continue
+		}
+
+		err = copyFile(tw, source.path)
+		if err != nil {
+			return err
+		}
 	}
 
-	return tmpDir, tarball, nil
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	if compressor != nil {
+		err = compressor.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return out.Close()
 }
 
-func (c *EpinioClient) uploadCode(app models.AppRef, tarball string) (*models.UploadResponse, error) {
+// unpackSource extracts application sources given as archive, e.g. a zip,
+// tar.gz, jar or war file, into a new temp directory. It returns the
+// directory to push from, which is the source itself if it is a directory,
+// and the temp directory to remove, if any.
+func unpackSource(source string) (string, string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", "", err
+	}
+	if info.IsDir() {
+		return source, "", nil
+	}
+
+	tmpDir, err := ioutil.TempDir("", "epinio-sources")
+	if err != nil {
+		return "", "", errors.Wrap(err, "can't create temp directory")
+	}
+
+	dir := path.Join(tmpDir, "app")
+	// The archive is the user's own, it is unpacked without limits
+	err = helpers.UnpackArchive(source, dir, helpers.ArchiveLimits{})
+	if err != nil {
+		return "", tmpDir, errors.Wrap(err, "can't unpack application sources")
+	}
+
+	return dir, tmpDir, nil
+}
+
+func copyFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// uploadChunkSize is the amount of application sources sent per request of
+// a chunked upload
+const uploadChunkSize = 4 * 1024 * 1024
+
+// errUploadNotSupported is returned when the server predates chunked and
+// incremental uploads
+var errUploadNotSupported = errors.New("server does not support chunked uploads")
+
+// uploadCode uploads the tarball in chunks. A failed chunk is retried from
+// the offset the server reports, the server verifies the checksum of the
+// whole tarball at the end. Servers without chunked uploads get the tarball
+// in a single request.
+func (c *EpinioClient) uploadCode(app models.AppRef, tarball string, manifest []models.SourceFile) (*models.UploadResponse, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open tarball")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	checksum, err := helpers.FileSHA256(tarball)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to checksum tarball")
+	}
+
+	upload, err := c.uploadStart(app, size)
+	if err == errUploadNotSupported {
+		return c.uploadWhole(app, tarball)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	progress := c.ui.ProgressBar("Uploading", size)
+	offset := upload.Offset
+	chunk := make([]byte, uploadChunkSize)
+
+	for offset < size {
+		err := retry.Do(
+			func() error {
+				n, err := f.ReadAt(chunk, offset)
+				if err != nil && err != io.EOF {
+					return err
+				}
+
+				status, err := c.uploadChunk(app, upload.ID, offset, chunk[:n])
+				if err != nil {
+					// Resume from whatever the server got
+					if current, serr := c.uploadStatus(app, upload.ID); serr == nil {
+						offset = current.Offset
+						progress.Set(offset)
+					}
+					return err
+				}
+
+				offset = status.Offset
+				progress.Set(offset)
+				return nil
+			},
+			retry.RetryIf(func(err error) bool {
+				return !strings.HasPrefix(err.Error(), http.StatusText(http.StatusNotFound)) &&
+					!strings.HasPrefix(err.Error(), http.StatusText(http.StatusBadRequest))
+			}),
+			retry.OnRetry(func(n uint, err error) {
+				c.ui.Note().Msgf("Retrying (%d/%d) after %s", n, duration.RetryMax, err.Error())
+			}),
+			retry.Delay(time.Second),
+			retry.Attempts(duration.RetryMax),
+		)
+		if err != nil {
+			progress.Stop()
+			return nil, errors.Wrap(err, "can't upload archive")
+		}
+	}
+	progress.Stop()
+
+	return c.uploadFinish(app, upload.ID, checksum, manifest)
+}
+
+func (c *EpinioClient) uploadStart(app models.AppRef, size int64) (*models.UploadStatusResponse, error) {
+	js, err := json.Marshal(models.UploadStartRequest{Size: size})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.curlWithCustomErrorHandling(api.Routes.Path("AppUploadStart", app.Org, app.Name), "POST", string(js),
+		func(response *http.Response, bodyBytes []byte, err error) error {
+			if response.StatusCode == http.StatusNotFound {
+				return errUploadNotSupported
+			}
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	status := &models.UploadStatusResponse{}
+	if err := json.Unmarshal(b, status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+func (c *EpinioClient) uploadStatus(app models.AppRef, id string) (*models.UploadStatusResponse, error) {
+	b, err := c.get(api.Routes.Path("AppUploadStatus", app.Org, app.Name, id))
+	if err != nil {
+		return nil, err
+	}
+
+	status := &models.UploadStatusResponse{}
+	if err := json.Unmarshal(b, status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+func (c *EpinioClient) uploadChunk(app models.AppRef, id string, offset int64, chunk []byte) (*models.UploadStatusResponse, error) {
+	uri := fmt.Sprintf("%s/%s?offset=%d", c.serverURL, api.Routes.Path("AppUploadChunk", app.Org, app.Name, id), offset)
+	c.Log.Info(fmt.Sprintf("PUT %s", uri), "bytes", len(chunk))
+
+	request, err := http.NewRequest("PUT", uri, bytes.NewReader(chunk))
+	if err != nil {
+		return nil, err
+	}
+	request.SetBasicAuth(c.Config.User, c.Config.Password)
+	request.Header.Set("Content-Type", "application/octet-stream")
+
+	response, err := (&http.Client{}).Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("%s: %s", http.StatusText(response.StatusCode), string(bodyBytes)))
+	}
+
+	status := &models.UploadStatusResponse{}
+	if err := json.Unmarshal(bodyBytes, status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+func (c *EpinioClient) uploadFinish(app models.AppRef, id, checksum string, manifest []models.SourceFile) (*models.UploadResponse, error) {
+	js, err := json.Marshal(models.UploadFinishRequest{SHA256: checksum, Manifest: manifest})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.post(api.Routes.Path("AppUploadFinish", app.Org, app.Name, id), string(js))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't complete upload")
+	}
+
+	// returns git commit and app route
+	upload := &models.UploadResponse{}
+	if err := json.Unmarshal(b, upload); err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// uploadWhole uploads the tarball in a single request
+func (c *EpinioClient) uploadWhole(app models.AppRef, tarball string) (*models.UploadResponse, error) {
 	b, err := c.upload(api.Routes.Path("AppUpload", app.Org, app.Name), tarball)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't upload archive")
@@ -92,41 +544,101 @@ func (c *EpinioClient) stageCode(req models.StageRequest) (*models.StageResponse
 	return stage, nil
 }
 
+// waitForPipelineRun polls the staging status until the run completes. While
+// the staging is queued its position is shown.
 func (c *EpinioClient) waitForPipelineRun(ctx context.Context, app models.AppRef, id string) error {
 	c.ui.ProgressNote().KeeplineUnder(1).Msg("Running staging")
 
-	cs, err := tekton.NewForConfig(c.Cluster.RestConfig)
-	if err != nil {
-		return err
-	}
-	client := cs.TektonV1beta1().PipelineRuns(deployments.TektonStagingNamespace)
-
-	return wait.PollImmediate(time.Second, duration.ToAppBuilt(),
+	// The time to build counts from the start of the run, the time spent
+	// in the queue does not count
+	position := 0
+	deadline := time.Now().Add(duration.ToAppBuilt())
+	return wait.PollImmediateInfinite(time.Second,
 		func() (bool, error) {
-			l, err := client.List(ctx, metav1.ListOptions{LabelSelector: models.EpinioStageIDLabel + "=" + id})
+			b, err := c.get(api.Routes.Path("AppStageStatus", app.Org, app.Name, id))
 			if err != nil {
 				return false, err
 			}
-			if len(l.Items) == 0 {
-				return false, nil
+
+			status := models.StageStatusResponse{}
+			if err := json.Unmarshal(b, &status); err != nil {
+				return false, err
 			}
-			for _, pr := range l.Items {
-				// any failed conditions, throw an error so we can exit early
-				for _, c := range pr.Status.Conditions {
-					if c.IsFalse() {
-						return false, errors.New(c.Message)
-					}
+
+			switch status.Status {
+			case models.StagingQueued:
+				if status.Position != position {
+					position = status.Position
+					c.ui.ProgressNote().KeeplineUnder(1).Msg(fmt.Sprintf("queued (position %d)", position))
 				}
-				// it worked
-				if pr.Status.CompletionTime != nil {
-					return true, nil
+				deadline = time.Now().Add(duration.ToAppBuilt())
+				return false, nil
+			case models.StagingSucceeded:
+				return true, nil
+			case models.StagingFailed:
+				// throw an error so we can exit early
+				message := "staging failed"
+				if status.Failure != nil && status.Failure.Message != "" {
+					message = status.Failure.Message
 				}
+				return false, errors.New(message)
+			}
+
+			if position != 0 {
+				position = 0
+				c.ui.ProgressNote().KeeplineUnder(1).Msg("Running staging")
+			}
+			if time.Now().After(deadline) {
+				return false, wait.ErrWaitTimeout
 			}
-			// pr exists, but still running
+			// still running
 			return false, nil
 		})
 }
 
+// stagingFailureHints suggest a fix for the known staging failures
+var stagingFailureHints = map[string]string{
+	models.StagingFailureNoBuildpack:  "No buildpack recognized the application sources. Check that the pushed directory contains the application.",
+	models.StagingFailureImagePush:    "The application image could not be pushed to the registry.",
+	models.StagingFailureRegistryAuth: "The registry rejected the credentials of the staging pipeline.",
+	models.StagingFailureOutOfDisk:    "The staging pod ran out of disk space.",
+	models.StagingFailureTimeout:      "Staging did not finish in time.",
+}
+
+// showStagingFailure fetches the diagnostics of the failed staging run from
+// the server and renders them. This is best effort, the push has failed
+// regardless.
+func (c *EpinioClient) showStagingFailure(app models.AppRef, id string) {
+	b, err := c.get(api.Routes.Path("AppStageStatus", app.Org, app.Name, id))
+	if err != nil {
+		c.Log.Info("failed to get staging status", "error", err.Error())
+		return
+	}
+
+	status := models.StageStatusResponse{}
+	if err := json.Unmarshal(b, &status); err != nil || status.Failure == nil {
+		return
+	}
+	failure := status.Failure
+
+	msg := c.ui.Problem().
+		WithTable("Key", "Value").
+		WithTableRow("Reason", failure.Reason).
+		WithTableRow("Task", failure.Task).
+		WithTableRow("Step", failure.Step).
+		WithTableRow("Exit Code", strconv.Itoa(int(failure.ExitCode))).
+		WithTableRow("Message", failure.Message)
+	if hint, ok := stagingFailureHints[failure.Reason]; ok {
+		msg = msg.WithTableRow("Hint", hint)
+	}
+	msg.Msg("Staging failed:")
+
+	if len(failure.Logs) > 0 {
+		c.ui.Normal().Msg(fmt.Sprintf("Last lines of the log of step '%s':\n%s",
+			failure.Step, strings.Join(failure.Logs, "\n")))
+	}
+}
+
 func (c *EpinioClient) waitForApp(ctx context.Context, app models.AppRef, id string) error {
 	c.ui.ProgressNote().KeeplineUnder(1).Msg("Creating application resources")
 
@@ -139,3 +651,41 @@ func (c *EpinioClient) waitForApp(ctx context.Context, app models.AppRef, id str
 
 	return nil
 }
+
+// pushDryRun lists the files a push of the sources would upload, with their
+// total size
+func (c *EpinioClient) pushDryRun(source string, gitignore bool) error {
+	sources, err := listSources(c.Log, source, gitignore)
+	if err != nil {
+		return err
+	}
+
+	if len(sources) == 0 {
+		c.ui.Exclamation().Msg("No files to push")
+		return nil
+	}
+
+	total := int64(0)
+	msg := c.ui.Success().WithTable("File", "Size")
+	for _, source := range sources {
+		total += source.info.Size()
+		msg = msg.WithTableRow(source.name, formatSize(source.info.Size()))
+	}
+	msg.Msg(fmt.Sprintf("Dry run, would push %d files, %s in total:", len(sources), formatSize(total)))
+
+	return nil
+}
+
+// formatSize renders a byte count for humans
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -67,6 +67,83 @@ var NeededOptions = kubernetes.InstallationOptions{
 			return nil
 		},
 	},
+	{
+		Name:        "staging_concurrency",
+		Description: "The number of stagings allowed to run at the same time. Further stagings are queued (0 means no limit)",
+		Type:        kubernetes.IntType,
+		Default:     4,
+		Value:       4,
+	},
+	{
+		Name:        "max_upload_size",
+		Description: "The maximum size of the application sources accepted by a push, e.g. 500Mi (0 means no limit)",
+		Type:        kubernetes.StringType,
+		Default:     "1Gi",
+		Value:       "1Gi",
+	},
+	{
+		Name:        "source_store",
+		Description: "Where pushed application sources are kept, 'gitea' or 's3'. Gitea is not installed for s3",
+		Type:        kubernetes.StringType,
+		Default:     "gitea",
+		Value:       "gitea",
+	},
+	{
+		Name:        "s3_endpoint",
+		Description: "The URL of the S3 compatible store for the application sources",
+		Type:        kubernetes.StringType,
+		Default:     "",
+		Value:       "",
+	},
+	{
+		Name:        "s3_bucket",
+		Description: "The bucket for the application sources. It has to exist",
+		Type:        kubernetes.StringType,
+		Default:     "",
+		Value:       "",
+	},
+	{
+		Name:        "s3_region",
+		Description: "The region of the bucket",
+		Type:        kubernetes.StringType,
+		Default:     "us-east-1",
+		Value:       "us-east-1",
+	},
+	{
+		Name:        "s3_access_key_id",
+		Description: "The access key id for the bucket",
+		Type:        kubernetes.StringType,
+		Default:     "",
+		Value:       "",
+	},
+	{
+		Name:        "s3_secret_access_key",
+		Description: "The secret access key for the bucket",
+		Type:        kubernetes.StringType,
+		Default:     "",
+		Value:       "",
+	},
+	{
+		Name:        "backup_store",
+		Description: "Where the archives of service backups are kept, 'pvc' or 's3'",
+		Type:        kubernetes.StringType,
+		Default:     "pvc",
+		Value:       "pvc",
+	},
+	{
+		Name:        "backup_claim_size",
+		Description: "The size of the volume claim for the pvc backup store",
+		Type:        kubernetes.StringType,
+		Default:     "10Gi",
+		Value:       "10Gi",
+	},
+	{
+		Name:        "backup_s3_bucket",
+		Description: "The bucket for the service backups, at the S3 endpoint and with the credentials of the s3 options. It has to exist",
+		Type:        kubernetes.StringType,
+		Default:     "",
+		Value:       "",
+	},
 }
 
 var TraefikOptions = kubernetes.InstallationOptions{
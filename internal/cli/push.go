@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"fmt"
 	"os"
 	"strings"
 
@@ -17,6 +18,10 @@ func init() {
 	CmdPush.Flags().Int32P("instances", "i", v1.DefaultInstances,
 		"The number of desired instances for the application, default only applies to new deployments")
 	CmdPush.Flags().String("git", "", "git revision of sources. PATH becomes repository location")
+	CmdPush.Flags().Bool("gitignore", false, "use .gitignore files where there is no .epinioignore")
+	CmdPush.Flags().Bool("dry-run", false, "list the files to push, and their total size, without pushing")
+	CmdPush.Flags().String("compression", clients.CompressionGzip,
+		"compression of the uploaded sources, one of "+strings.Join(clients.Compressions, ", "))
 	CmdPush.Flags().StringSliceP("bind", "b", []string{}, "services to bind immediately")
 	CmdPush.RegisterFlagCompletionFunc("bind",
 		func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
@@ -80,8 +85,12 @@ func instances(cmd *cobra.Command) (*int32, error) {
 // CmdPush implements the epinio push command
 var CmdPush = &cobra.Command{
 	Use:   "push NAME [URL|PATH_TO_APPLICATION_SOURCES]",
-	Short: "Push an application from the specified directory, or the current working directory",
-	Args:  cobra.RangeArgs(1, 2),
+	Short: "Push an application from the specified directory or archive, or the current working directory",
+	Long: `Push an application from the specified directory or archive, or the current working directory.
+
+Archives are zip, jar, war, tar, tar.gz and tar.zst files. They are pushed as
+their content.`,
+	Args: cobra.RangeArgs(1, 2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.SilenceUsage = true
 
@@ -138,6 +147,33 @@ var CmdPush = &cobra.Command{
 		}
 		params.Services = services
 
+		params.GitIgnore, err = cmd.Flags().GetBool("gitignore")
+		if err != nil {
+			return errors.Wrap(err, "could not read option --gitignore")
+		}
+		params.DryRun, err = cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return errors.Wrap(err, "could not read option --dry-run")
+		}
+		if params.DryRun && gitRevision != "" {
+			cmd.SilenceUsage = false
+			return errors.New("--dry-run requires local sources, not --git")
+		}
+
+		params.Compression, err = cmd.Flags().GetString("compression")
+		if err != nil {
+			return errors.Wrap(err, "could not read option --compression")
+		}
+		known := false
+		for _, compression := range clients.Compressions {
+			known = known || compression == params.Compression
+		}
+		if !known {
+			cmd.SilenceUsage = false
+			return fmt.Errorf("unknown compression '%s', expected one of %s",
+				params.Compression, strings.Join(clients.Compressions, ", "))
+		}
+
 		err = client.Push(cmd.Context(), args[0], gitRevision, path, params)
 		if err != nil {
 			return errors.Wrap(err, "error pushing app to server")
@@ -14,16 +14,23 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/epinio/epinio/helpers/kubernetes"
 	"github.com/epinio/epinio/helpers/termui"
 	"github.com/epinio/epinio/helpers/tracelog"
 	apiv1 "github.com/epinio/epinio/internal/api/v1"
+	"github.com/epinio/epinio/internal/backups"
+	"github.com/epinio/epinio/internal/cli/clients/gitea"
 	"github.com/epinio/epinio/internal/filesystem"
+	"github.com/epinio/epinio/internal/gc"
+	"github.com/epinio/epinio/internal/sources"
 	"github.com/epinio/epinio/internal/web"
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func init() {
@@ -31,6 +38,74 @@ func init() {
 	flags.Int("port", 0, "(PORT) The port to listen on. Leave empty to auto-assign a random port")
 	viper.BindPFlag("port", flags.Lookup("port"))
 	viper.BindEnv("port", "PORT")
+
+	flags.Int("staging-concurrency", 0, "(STAGING_CONCURRENCY) Number of stagings allowed to run at the same time. 0 means no limit")
+	viper.BindPFlag("staging-concurrency", flags.Lookup("staging-concurrency"))
+	viper.BindEnv("staging-concurrency", "STAGING_CONCURRENCY")
+
+	flags.String("max-upload-size", "1Gi", "(MAX_UPLOAD_SIZE) Maximum size of the application sources accepted by a push. 0 means no limit")
+	viper.BindPFlag("max-upload-size", flags.Lookup("max-upload-size"))
+	viper.BindEnv("max-upload-size", "MAX_UPLOAD_SIZE")
+
+	flags.String("source-store", sources.KindGitea, "(SOURCE_STORE) Where pushed application sources are kept, 'gitea' or 's3'")
+	viper.BindPFlag("source-store", flags.Lookup("source-store"))
+	viper.BindEnv("source-store", "SOURCE_STORE")
+
+	flags.String("s3-endpoint", "", "(S3_ENDPOINT) URL of the S3 compatible source store")
+	viper.BindPFlag("s3-endpoint", flags.Lookup("s3-endpoint"))
+	viper.BindEnv("s3-endpoint", "S3_ENDPOINT")
+
+	flags.String("s3-bucket", "", "(S3_BUCKET) Bucket for the application sources")
+	viper.BindPFlag("s3-bucket", flags.Lookup("s3-bucket"))
+	viper.BindEnv("s3-bucket", "S3_BUCKET")
+
+	flags.String("s3-region", "us-east-1", "(S3_REGION) Region of the bucket")
+	viper.BindPFlag("s3-region", flags.Lookup("s3-region"))
+	viper.BindEnv("s3-region", "S3_REGION")
+
+	flags.String("s3-access-key-id", "", "(S3_ACCESS_KEY_ID) Access key id for the bucket")
+	viper.BindPFlag("s3-access-key-id", flags.Lookup("s3-access-key-id"))
+	viper.BindEnv("s3-access-key-id", "S3_ACCESS_KEY_ID")
+
+	flags.String("s3-secret-access-key", "", "(S3_SECRET_ACCESS_KEY) Secret access key for the bucket")
+	viper.BindPFlag("s3-secret-access-key", flags.Lookup("s3-secret-access-key"))
+	viper.BindEnv("s3-secret-access-key", "S3_SECRET_ACCESS_KEY")
+
+	flags.String("backup-store", backups.KindPVC, "(BACKUP_STORE) Where the archives of service backups are kept, 'pvc' or 's3'")
+	viper.BindPFlag("backup-store", flags.Lookup("backup-store"))
+	viper.BindEnv("backup-store", "BACKUP_STORE")
+
+	flags.String("backup-claim-size", "10Gi", "(BACKUP_CLAIM_SIZE) Size of the volume claim created for the pvc backup store")
+	viper.BindPFlag("backup-claim-size", flags.Lookup("backup-claim-size"))
+	viper.BindEnv("backup-claim-size", "BACKUP_CLAIM_SIZE")
+
+	flags.String("backup-s3-bucket", "", "(BACKUP_S3_BUCKET) Bucket for the service backups, at the S3 endpoint of the source store")
+	viper.BindPFlag("backup-s3-bucket", flags.Lookup("backup-s3-bucket"))
+	viper.BindEnv("backup-s3-bucket", "BACKUP_S3_BUCKET")
+
+	flags.String("git-author-name", "Epinio", "(GIT_AUTHOR_NAME) Author of the commits made for pushed sources, with the gitea source store")
+	viper.BindPFlag("git-author-name", flags.Lookup("git-author-name"))
+	viper.BindEnv("git-author-name", "GIT_AUTHOR_NAME")
+
+	flags.String("git-author-email", "ci@epinio", "(GIT_AUTHOR_EMAIL) Email of the author of the commits made for pushed sources")
+	viper.BindPFlag("git-author-email", flags.Lookup("git-author-email"))
+	viper.BindEnv("git-author-email", "GIT_AUTHOR_EMAIL")
+
+	flags.Int("gc-keep-runs", 3, "(GC_KEEP_RUNS) Number of finished staging runs kept per application")
+	viper.BindPFlag("gc-keep-runs", flags.Lookup("gc-keep-runs"))
+	viper.BindEnv("gc-keep-runs", "GC_KEEP_RUNS")
+
+	flags.Duration("gc-max-age", 7*24*time.Hour, "(GC_MAX_AGE) Age beyond which finished staging runs are removed. 0 keeps them regardless of age")
+	viper.BindPFlag("gc-max-age", flags.Lookup("gc-max-age"))
+	viper.BindEnv("gc-max-age", "GC_MAX_AGE")
+
+	flags.Duration("gc-interval", 10*time.Minute, "(GC_INTERVAL) Time between garbage collections")
+	viper.BindPFlag("gc-interval", flags.Lookup("gc-interval"))
+	viper.BindEnv("gc-interval", "GC_INTERVAL")
+
+	flags.Bool("gc-prune-images", true, "(GC_PRUNE_IMAGES) Remove registry images of deleted and expired stagings")
+	viper.BindPFlag("gc-prune-images", flags.Lookup("gc-prune-images"))
+	viper.BindEnv("gc-prune-images", "GC_PRUNE_IMAGES")
 }
 
 // CmdServer implements the epinio server command
@@ -44,6 +119,62 @@ var CmdServer = &cobra.Command{
 		port := viper.GetInt("port")
 		ui := termui.NewUI()
 		logger := tracelog.NewServerLogger()
+
+		apiv1.SetStagingConcurrency(viper.GetInt("staging-concurrency"))
+
+		maxUploadSize, err := resource.ParseQuantity(viper.GetString("max-upload-size"))
+		if err != nil {
+			return errors.Wrap(err, "bad --max-upload-size")
+		}
+		apiv1.SetMaxUploadSize(maxUploadSize.Value())
+
+		err = sources.Configure(sources.Config{
+			Kind:   viper.GetString("source-store"),
+			Unpack: apiv1.UnpackLimits(),
+			S3: sources.S3Config{
+				Endpoint:        viper.GetString("s3-endpoint"),
+				Bucket:          viper.GetString("s3-bucket"),
+				Region:          viper.GetString("s3-region"),
+				AccessKeyID:     viper.GetString("s3-access-key-id"),
+				SecretAccessKey: viper.GetString("s3-secret-access-key"),
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "bad --source-store")
+		}
+		err = backups.Configure(backups.Config{
+			Kind:      viper.GetString("backup-store"),
+			ClaimSize: viper.GetString("backup-claim-size"),
+			S3: sources.S3Config{
+				Endpoint:        viper.GetString("s3-endpoint"),
+				Bucket:          viper.GetString("backup-s3-bucket"),
+				Region:          viper.GetString("s3-region"),
+				AccessKeyID:     viper.GetString("s3-access-key-id"),
+				SecretAccessKey: viper.GetString("s3-secret-access-key"),
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "bad --backup-store")
+		}
+		gitea.SetCommitAuthor(viper.GetString("git-author-name"), viper.GetString("git-author-email"))
+
+		gcInterval := viper.GetDuration("gc-interval")
+		if gcInterval <= 0 {
+			return errors.New("bad --gc-interval: must be positive")
+		}
+
+		cluster, err := kubernetes.GetCluster(cmd.Context())
+		if err != nil {
+			return errors.Wrap(err, "failed to get access to a kube client")
+		}
+		collector := gc.NewCollector(cluster, logger, gc.Retention{
+			Keep:   viper.GetInt("gc-keep-runs"),
+			MaxAge: viper.GetDuration("gc-max-age"),
+		}, viper.GetBool("gc-prune-images"))
+		collector.Start(cmd.Context(), gcInterval)
+		http.Handle("/metrics", collector)
+		backups.NewScheduler(cluster, logger).Start(cmd.Context(), time.Minute)
+
 		_, listeningPort, err := startEpinioServer(httpServerWg, port, ui, logger)
 		if err != nil {
 			return errors.Wrap(err, "failed to start server")
@@ -113,6 +244,12 @@ func logRequest(r *http.Request, log logr.Logger) {
 	method := r.Method
 	log.V(1).Info("received request", "method", method, "uri", uri)
 
+	// Application sources are streamed to disk, not buffered for logging
+	if isSourceUpload(r) {
+		log.V(2).Info("request", "body", "application sources")
+		return
+	}
+
 	// Read request body for logging
 	bodyBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -129,5 +266,16 @@ func logRequest(r *http.Request, log logr.Logger) {
 		return
 	}
 
+	// Build secret values must not end up in the logs
+	if strings.HasSuffix(r.URL.Path, "/buildsecrets") {
+		log.V(2).Info("request", "body", "redacted")
+		return
+	}
+
 	log.V(2).Info("request", "body", string(bodyBytes))
 }
+
+func isSourceUpload(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/store") ||
+		(r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/uploads/"))
+}
@@ -2,7 +2,9 @@ package cli
 
 import (
 	"encoding/json"
+	"strings"
 
+	"github.com/epinio/epinio/internal/application"
 	"github.com/epinio/epinio/internal/cli/clients"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -11,13 +13,39 @@ import (
 func init() {
 	CmdServiceCreate.Flags().String("data", "", "json data to be passed to the underlying service as parameters")
 	CmdServiceCreate.Flags().Bool("dont-wait", false, "Return immediately, without waiting for the service to be provisioned")
+	CmdServiceUpdate.Flags().StringSlice("set", []string{}, "data to add or change, as KEY=VALUE")
+	CmdServiceUpdate.Flags().StringSlice("unset", []string{}, "keys of the data to remove")
+	CmdServiceUpdate.Flags().Bool("no-restart", false, "do not restart the applications bound to the service")
+	CmdServiceUpdate.Flags().String("plan", "", "new plan of a catalog service")
+	CmdServiceUpdate.Flags().String("data", "", "json data replacing the parameters of a catalog service")
+	CmdServiceUpdate.Flags().Bool("dont-wait", false, "Return immediately, without waiting for a catalog service to be updated")
+	CmdServiceBind.Flags().String("as", application.BindAsFiles,
+		"how the application sees the service data, one of "+strings.Join(application.BindModes, ", "))
 	CmdServiceDelete.Flags().Bool("unbind", false, "Unbind from applications before deleting")
+	CmdServiceDelete.Flags().Bool("dont-wait", false, "Return immediately, without waiting for the service to be deleted")
+	CmdServiceDelete.Flags().Bool("force", false, "Remove the shares with other organizations before deleting, unbinding their applications")
+	CmdServiceShare.Flags().String("to-org", "", "organization to share the service with")
+	err := CmdServiceShare.MarkFlagRequired("to-org")
+	if err != nil {
+		panic(err)
+	}
+	CmdServiceUnshare.Flags().String("from-org", "", "organization to remove the share of the service from")
+	CmdServiceUnshare.Flags().Bool("unbind", false, "Unbind the applications of the organization from the service")
+	err = CmdServiceUnshare.MarkFlagRequired("from-org")
+	if err != nil {
+		panic(err)
+	}
+	CmdServiceListPlans.Flags().Bool("details", false, "show the schema of the parameters of each plan")
 	CmdService.AddCommand(CmdServiceShow)
 	CmdService.AddCommand(CmdServiceCreate)
 	CmdService.AddCommand(CmdServiceCreateCustom)
+	CmdService.AddCommand(CmdServiceUpdate)
 	CmdService.AddCommand(CmdServiceDelete)
 	CmdService.AddCommand(CmdServiceBind)
 	CmdService.AddCommand(CmdServiceUnbind)
+	CmdService.AddCommand(CmdServiceRotateCredentials)
+	CmdService.AddCommand(CmdServiceShare)
+	CmdService.AddCommand(CmdServiceUnshare)
 	CmdService.AddCommand(CmdServiceListClasses)
 	CmdService.AddCommand(CmdServiceListPlans)
 	CmdService.AddCommand(CmdServiceList)
@@ -113,6 +141,33 @@ var CmdServiceCreateCustom = &cobra.Command{
 	RunE: ServiceCreateCustom,
 }
 
+// CmdServiceUpdate implements the epinio service update command
+var CmdServiceUpdate = &cobra.Command{
+	Use:   "update NAME",
+	Short: "Update a service",
+	Long: `Change the data of the named custom service. The applications bound to it
+are restarted to pick up the new data, unless --no-restart is given.
+
+Change the plan or the parameters of the named catalog service, with --plan
+and --data.`,
+	Args: cobra.ExactArgs(1),
+	RunE: ServiceUpdate,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		app, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		matches := app.ServiceMatching(cmd.Context(), toComplete)
+
+		return matches, cobra.ShellCompDirectiveNoFileComp
+	},
+}
+
 // CmdServiceDelete implements the epinio service delete command
 var CmdServiceDelete = &cobra.Command{
 	Use:   "delete NAME",
@@ -140,9 +195,14 @@ var CmdServiceDelete = &cobra.Command{
 var CmdServiceBind = &cobra.Command{
 	Use:   "bind NAME APP",
 	Short: "Bind a service to an application",
-	Long:  `Bind service by name, to named application.`,
-	Args:  cobra.ExactArgs(2),
-	RunE:  ServiceBind,
+	Long: `Bind service by name, to named application.
+
+The service data is mounted as files below /services/NAME, injected as
+environment variables prefixed with the upper-cased service name, or both.
+The EPINIO_SERVICES environment variable of the application describes all
+its bindings.`,
+	Args: cobra.ExactArgs(2),
+	RunE: ServiceBind,
 	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		if len(args) > 1 {
 			return nil, cobra.ShellCompDirectiveNoFileComp
@@ -197,6 +257,83 @@ var CmdServiceUnbind = &cobra.Command{
 	},
 }
 
+// CmdServiceRotateCredentials implements the epinio service rotate-credentials command
+var CmdServiceRotateCredentials = &cobra.Command{
+	Use:   "rotate-credentials NAME APP",
+	Short: "Rotate the credentials of a service binding",
+	Long: `Replace the credentials of the named service's binding to the named application.
+The application is restarted with the new credentials before the old ones are removed.`,
+	Args: cobra.ExactArgs(2),
+	RunE: ServiceRotateCredentials,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 1 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		app, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		if len(args) == 1 {
+			// #args == 1: app name.
+			matches := app.AppsMatching(cmd.Context(), toComplete)
+			return matches, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		// #args == 0: service name.
+		matches := app.ServiceMatching(cmd.Context(), toComplete)
+
+		return matches, cobra.ShellCompDirectiveNoFileComp
+	},
+}
+
+// CmdServiceShare implements the epinio service share command
+var CmdServiceShare = &cobra.Command{
+	Use:   "share NAME",
+	Short: "Share a service with another organization",
+	Long:  `Make the named service bindable by the applications of another organization.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  ServiceShare,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		app, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		matches := app.ServiceMatching(cmd.Context(), toComplete)
+
+		return matches, cobra.ShellCompDirectiveNoFileComp
+	},
+}
+
+// CmdServiceUnshare implements the epinio service unshare command
+var CmdServiceUnshare = &cobra.Command{
+	Use:   "unshare NAME",
+	Short: "Remove the share of a service with another organization",
+	Long:  `Remove the share of the named service with another organization.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  ServiceUnshare,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		app, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		matches := app.ServiceMatching(cmd.Context(), toComplete)
+
+		return matches, cobra.ShellCompDirectiveNoFileComp
+	},
+}
+
 // CmdServiceListClasses implements the epinio service classes command
 var CmdServiceListClasses = &cobra.Command{
 	Use:   "list-classes",
@@ -324,6 +461,91 @@ func ServiceCreateCustom(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// ServiceUpdate implements the epinio service update command
+func ServiceUpdate(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	assignments, err := cmd.Flags().GetStringSlice("set")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --set")
+	}
+	unset, err := cmd.Flags().GetStringSlice("unset")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --unset")
+	}
+	noRestart, err := cmd.Flags().GetBool("no-restart")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --no-restart")
+	}
+
+	plan, err := cmd.Flags().GetString("plan")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --plan")
+	}
+	data, err := cmd.Flags().GetString("data")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --data")
+	}
+	dw, err := cmd.Flags().GetBool("dont-wait")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --dont-wait")
+	}
+
+	if plan != "" || data != "" {
+		if len(assignments) > 0 || len(unset) > 0 {
+			cmd.SilenceUsage = false
+			return errors.New("--set and --unset can not be combined with --plan and --data")
+		}
+		if data != "" {
+			var dataObj map[string]interface{}
+			err = json.Unmarshal([]byte(data), &dataObj)
+			if err != nil {
+				// User error. Show usage for this one.
+				cmd.SilenceUsage = false
+				return errors.Wrap(err, "Invalid json format for data")
+			}
+		}
+
+		client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+		if err != nil {
+			return errors.Wrap(err, "error initializing cli")
+		}
+
+		err = client.UpdateService(args[0], plan, data, !dw)
+		if err != nil {
+			return errors.Wrap(err, "error updating service")
+		}
+
+		return nil
+	}
+
+	set := map[string]string{}
+	for _, assignment := range assignments {
+		pieces := strings.SplitN(assignment, "=", 2)
+		if len(pieces) != 2 || pieces[0] == "" {
+			cmd.SilenceUsage = false
+			return errors.Errorf("bad --set '%s', expected KEY=VALUE", assignment)
+		}
+		set[pieces[0]] = pieces[1]
+	}
+	if len(set) == 0 && len(unset) == 0 {
+		cmd.SilenceUsage = false
+		return errors.New("nothing to update, use --set, --unset, --plan or --data")
+	}
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.UpdateCustomService(args[0], set, unset, noRestart)
+	if err != nil {
+		return errors.Wrap(err, "error updating service")
+	}
+
+	return nil
+}
+
 // ServiceDelete implements the epinio service delete command
 func ServiceDelete(cmd *cobra.Command, args []string) error {
 	cmd.SilenceUsage = true
@@ -333,12 +555,22 @@ func ServiceDelete(cmd *cobra.Command, args []string) error {
 		return errors.Wrap(err, "error reading option --unbind")
 	}
 
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --force")
+	}
+
+	dw, err := cmd.Flags().GetBool("dont-wait")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --dont-wait")
+	}
+
 	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
 	if err != nil {
 		return errors.Wrap(err, "error initializing cli")
 	}
 
-	err = client.DeleteService(args[0], unbind)
+	err = client.DeleteService(args[0], unbind, force, !dw)
 	if err != nil {
 		return errors.Wrap(err, "error deleting service")
 	}
@@ -350,12 +582,22 @@ func ServiceDelete(cmd *cobra.Command, args []string) error {
 func ServiceBind(cmd *cobra.Command, args []string) error {
 	cmd.SilenceUsage = true
 
+	as, err := cmd.Flags().GetString("as")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --as")
+	}
+	if !application.ValidBindMode(as) {
+		cmd.SilenceUsage = false
+		return errors.Errorf("unknown binding mode '%s', expected one of %s",
+			as, strings.Join(application.BindModes, ", "))
+	}
+
 	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
 	if err != nil {
 		return errors.Wrap(err, "error initializing cli")
 	}
 
-	err = client.BindService(args[0], args[1])
+	err = client.BindService(args[0], args[1], as)
 	if err != nil {
 		return errors.Wrap(err, "error binding service")
 	}
@@ -380,6 +622,72 @@ func ServiceUnbind(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// ServiceRotateCredentials implements the epinio service rotate-credentials command
+func ServiceRotateCredentials(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.RotateCredentials(args[0], args[1])
+	if err != nil {
+		return errors.Wrap(err, "error rotating credentials")
+	}
+
+	return nil
+}
+
+// ServiceShare implements the epinio service share command
+func ServiceShare(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	org, err := cmd.Flags().GetString("to-org")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --to-org")
+	}
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ShareService(args[0], org)
+	if err != nil {
+		return errors.Wrap(err, "error sharing service")
+	}
+
+	return nil
+}
+
+// ServiceUnshare implements the epinio service unshare command
+func ServiceUnshare(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	org, err := cmd.Flags().GetString("from-org")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --from-org")
+	}
+
+	unbind, err := cmd.Flags().GetBool("unbind")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --unbind")
+	}
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.UnshareService(args[0], org, unbind)
+	if err != nil {
+		return errors.Wrap(err, "error unsharing service")
+	}
+
+	return nil
+}
+
 // ServiceListClasses implements the epinio service list-classes command
 func ServiceListClasses(cmd *cobra.Command, args []string) error {
 	cmd.SilenceUsage = true
@@ -401,12 +709,17 @@ func ServiceListClasses(cmd *cobra.Command, args []string) error {
 func ServiceListPlans(cmd *cobra.Command, args []string) error {
 	cmd.SilenceUsage = true
 
+	details, err := cmd.Flags().GetBool("details")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --details")
+	}
+
 	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
 	if err != nil {
 		return errors.Wrap(err, "error initializing cli")
 	}
 
-	err = client.ServicePlans(args[0])
+	err = client.ServicePlans(args[0], details)
 	if err != nil {
 		return errors.Wrap(err, "error listing plan")
 	}
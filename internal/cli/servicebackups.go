@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/epinio/epinio/internal/cli/clients"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	CmdServiceBackupCreate.Flags().Bool("wait", false, "Wait for the backup to complete")
+	CmdServiceBackupRestore.Flags().Bool("wait", false, "Wait for the restore to complete")
+	CmdServiceBackupSchedule.Flags().Int("keep", 0, "Number of scheduled backups kept, the server's default if not set")
+
+	CmdServiceBackup.AddCommand(CmdServiceBackupCreate)
+	CmdServiceBackup.AddCommand(CmdServiceBackupList)
+	CmdServiceBackup.AddCommand(CmdServiceBackupRestore)
+	CmdServiceBackup.AddCommand(CmdServiceBackupSchedule)
+	CmdService.AddCommand(CmdServiceBackup)
+}
+
+// CmdServiceBackup implements the epinio service backup command
+var CmdServiceBackup = &cobra.Command{
+	Use:           "backup",
+	Aliases:       []string{"backups"},
+	Short:         "Epinio service backups",
+	Long:          `Back up the data of services, restore the backups, and schedule backups`,
+	Args:          cobra.ExactArgs(0),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+// CmdServiceBackupCreate implements the epinio service backup create command
+var CmdServiceBackupCreate = &cobra.Command{
+	Use:               "create SERVICE",
+	Short:             "Back up a service",
+	Long:              `Back up the data of the service, with the backup hook of its class.`,
+	Args:              cobra.ExactArgs(1),
+	RunE:              ServiceBackupCreate,
+	ValidArgsFunction: completeKeyService,
+}
+
+// CmdServiceBackupList implements the epinio service backup list command
+var CmdServiceBackupList = &cobra.Command{
+	Use:               "list SERVICE",
+	Short:             "Lists the backups of a service",
+	Args:              cobra.ExactArgs(1),
+	RunE:              ServiceBackupList,
+	ValidArgsFunction: completeKeyService,
+}
+
+// CmdServiceBackupRestore implements the epinio service backup restore command
+var CmdServiceBackupRestore = &cobra.Command{
+	Use:               "restore SERVICE BACKUPID",
+	Short:             "Restore a backup of a service",
+	Long:              `Replace the data of the service with the backup.`,
+	Args:              cobra.ExactArgs(2),
+	RunE:              ServiceBackupRestore,
+	ValidArgsFunction: completeKeyService,
+}
+
+// CmdServiceBackupSchedule implements the epinio service backup schedule command
+var CmdServiceBackupSchedule = &cobra.Command{
+	Use:               "schedule SERVICE INTERVAL",
+	Short:             "Schedule backups of a service",
+	Long:              `Back up the service every interval, e.g. 24h. An interval of 0 removes the schedule. Older scheduled backups are removed, keeping the newest ones.`,
+	Args:              cobra.ExactArgs(2),
+	RunE:              ServiceBackupSchedule,
+	ValidArgsFunction: completeKeyService,
+}
+
+// ServiceBackupCreate implements the epinio service backup create command
+func ServiceBackupCreate(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	wait, err := cmd.Flags().GetBool("wait")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --wait")
+	}
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ServiceBackupCreate(args[0], wait)
+	if err != nil {
+		return errors.Wrap(err, "error backing up service")
+	}
+
+	return nil
+}
+
+// ServiceBackupList implements the epinio service backup list command
+func ServiceBackupList(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ServiceBackups(args[0])
+	if err != nil {
+		return errors.Wrap(err, "error listing service backups")
+	}
+
+	return nil
+}
+
+// ServiceBackupRestore implements the epinio service backup restore command
+func ServiceBackupRestore(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	wait, err := cmd.Flags().GetBool("wait")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --wait")
+	}
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ServiceBackupRestore(args[0], args[1], wait)
+	if err != nil {
+		return errors.Wrap(err, "error restoring service backup")
+	}
+
+	return nil
+}
+
+// ServiceBackupSchedule implements the epinio service backup schedule command
+func ServiceBackupSchedule(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	every, err := time.ParseDuration(args[1])
+	if err != nil {
+		return errors.Wrap(err, "bad backup interval")
+	}
+
+	keep, err := cmd.Flags().GetInt("keep")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --keep")
+	}
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ServiceBackupSchedule(args[0], every, keep)
+	if err != nil {
+		return errors.Wrap(err, "error scheduling service backups")
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"github.com/epinio/epinio/internal/cli/clients"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	CmdServiceBrokerAdd.Flags().String("user", "", "user name for basic auth at the broker")
+	CmdServiceBrokerAdd.Flags().String("pass", "", "password for basic auth at the broker")
+	CmdServiceBroker.AddCommand(CmdServiceBrokerAdd)
+	CmdServiceBroker.AddCommand(CmdServiceBrokerList)
+	CmdServiceBroker.AddCommand(CmdServiceBrokerRemove)
+	CmdService.AddCommand(CmdServiceBroker)
+}
+
+// CmdServiceBroker implements the epinio service broker command
+var CmdServiceBroker = &cobra.Command{
+	Use:           "broker",
+	Aliases:       []string{"brokers"},
+	Short:         "Epinio service brokers",
+	Long:          `Manage the service brokers whose services are offered as service classes`,
+	Args:          cobra.ExactArgs(0),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+// CmdServiceBrokerAdd implements the epinio service broker add command
+var CmdServiceBrokerAdd = &cobra.Command{
+	Use:   "add NAME URL",
+	Short: "Register a service broker",
+	Long:  `Register the Open Service Broker API compatible broker at URL, under the given name.`,
+	Args:  cobra.ExactArgs(2),
+	RunE:  ServiceBrokerAdd,
+}
+
+// CmdServiceBrokerList implements the epinio service broker list command
+var CmdServiceBrokerList = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the registered service brokers",
+	Args:  cobra.ExactArgs(0),
+	RunE:  ServiceBrokerList,
+}
+
+// CmdServiceBrokerRemove implements the epinio service broker remove command
+var CmdServiceBrokerRemove = &cobra.Command{
+	Use:   "remove NAME",
+	Short: "Remove a service broker",
+	Long:  `Remove the named service broker. Brokers with service instances can not be removed.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  ServiceBrokerRemove,
+}
+
+// ServiceBrokerAdd implements the epinio service broker add command
+func ServiceBrokerAdd(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	user, err := cmd.Flags().GetString("user")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --user")
+	}
+	pass, err := cmd.Flags().GetString("pass")
+	if err != nil {
+		return errors.Wrap(err, "error reading option --pass")
+	}
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.BrokerAdd(args[0], args[1], user, pass)
+	if err != nil {
+		return errors.Wrap(err, "error registering service broker")
+	}
+
+	return nil
+}
+
+// ServiceBrokerList implements the epinio service broker list command
+func ServiceBrokerList(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.Brokers()
+	if err != nil {
+		return errors.Wrap(err, "error listing service brokers")
+	}
+
+	return nil
+}
+
+// ServiceBrokerRemove implements the epinio service broker remove command
+func ServiceBrokerRemove(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.BrokerRemove(args[0])
+	if err != nil {
+		return errors.Wrap(err, "error removing service broker")
+	}
+
+	return nil
+}
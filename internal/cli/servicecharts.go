@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"github.com/epinio/epinio/internal/cli/clients"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	CmdServiceChart.AddCommand(CmdServiceChartAdd)
+	CmdServiceChart.AddCommand(CmdServiceChartList)
+	CmdServiceChart.AddCommand(CmdServiceChartRemove)
+	CmdService.AddCommand(CmdServiceChart)
+}
+
+// CmdServiceChart implements the epinio service chart command
+var CmdServiceChart = &cobra.Command{
+	Use:           "chart",
+	Aliases:       []string{"charts"},
+	Short:         "Epinio service charts",
+	Long:          `Manage the Helm charts offered as service classes`,
+	Args:          cobra.ExactArgs(0),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+// CmdServiceChartAdd implements the epinio service chart add command
+var CmdServiceChartAdd = &cobra.Command{
+	Use:   "add FILE",
+	Short: "Register a Helm chart as service class",
+	Long:  `Register the Helm chart defined in FILE, YAML or JSON, as service class.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  ServiceChartAdd,
+}
+
+// CmdServiceChartList implements the epinio service chart list command
+var CmdServiceChartList = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the registered Helm charts",
+	Args:  cobra.ExactArgs(0),
+	RunE:  ServiceChartList,
+}
+
+// CmdServiceChartRemove implements the epinio service chart remove command
+var CmdServiceChartRemove = &cobra.Command{
+	Use:   "remove NAME",
+	Short: "Remove a Helm chart",
+	Long:  `Remove the named Helm chart. Charts with services can not be removed.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  ServiceChartRemove,
+}
+
+// ServiceChartAdd implements the epinio service chart add command
+func ServiceChartAdd(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ChartAdd(args[0])
+	if err != nil {
+		return errors.Wrap(err, "error registering service chart")
+	}
+
+	return nil
+}
+
+// ServiceChartList implements the epinio service chart list command
+func ServiceChartList(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.Charts()
+	if err != nil {
+		return errors.Wrap(err, "error listing service charts")
+	}
+
+	return nil
+}
+
+// ServiceChartRemove implements the epinio service chart remove command
+func ServiceChartRemove(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ChartRemove(args[0])
+	if err != nil {
+		return errors.Wrap(err, "error removing service chart")
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"github.com/epinio/epinio/internal/cli/clients"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	CmdServiceKey.AddCommand(CmdServiceKeyCreate)
+	CmdServiceKey.AddCommand(CmdServiceKeyList)
+	CmdServiceKey.AddCommand(CmdServiceKeyShow)
+	CmdServiceKey.AddCommand(CmdServiceKeyDelete)
+	CmdService.AddCommand(CmdServiceKey)
+}
+
+// CmdServiceKey implements the epinio service key command
+var CmdServiceKey = &cobra.Command{
+	Use:           "key",
+	Aliases:       []string{"keys"},
+	Short:         "Epinio service keys",
+	Long:          `Manage the keys of services, credentials for consumers outside of Epinio`,
+	Args:          cobra.ExactArgs(0),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+// CmdServiceKeyCreate implements the epinio service key create command
+var CmdServiceKeyCreate = &cobra.Command{
+	Use:               "create SERVICE KEYNAME",
+	Short:             "Create a service key",
+	Long:              `Create the named key of the service, a binding not attached to an application.`,
+	Args:              cobra.ExactArgs(2),
+	RunE:              ServiceKeyCreate,
+	ValidArgsFunction: completeKeyService,
+}
+
+// CmdServiceKeyList implements the epinio service key list command
+var CmdServiceKeyList = &cobra.Command{
+	Use:               "list SERVICE",
+	Short:             "Lists the keys of a service",
+	Args:              cobra.ExactArgs(1),
+	RunE:              ServiceKeyList,
+	ValidArgsFunction: completeKeyService,
+}
+
+// CmdServiceKeyShow implements the epinio service key show command
+var CmdServiceKeyShow = &cobra.Command{
+	Use:               "show SERVICE KEYNAME",
+	Short:             "Show the credentials of a service key",
+	Args:              cobra.ExactArgs(2),
+	RunE:              ServiceKeyShow,
+	ValidArgsFunction: completeKeyService,
+}
+
+// CmdServiceKeyDelete implements the epinio service key delete command
+var CmdServiceKeyDelete = &cobra.Command{
+	Use:               "delete SERVICE KEYNAME",
+	Short:             "Delete a service key",
+	Long:              `Delete the named key of the service. Its credentials are revoked where the service issues them per binding.`,
+	Args:              cobra.ExactArgs(2),
+	RunE:              ServiceKeyDelete,
+	ValidArgsFunction: completeKeyService,
+}
+
+// completeKeyService completes the service name of the service key
+// commands
+func completeKeyService(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	app, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	matches := app.ServiceMatching(cmd.Context(), toComplete)
+
+	return matches, cobra.ShellCompDirectiveNoFileComp
+}
+
+// ServiceKeyCreate implements the epinio service key create command
+func ServiceKeyCreate(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ServiceKeyCreate(args[0], args[1])
+	if err != nil {
+		return errors.Wrap(err, "error creating service key")
+	}
+
+	return nil
+}
+
+// ServiceKeyList implements the epinio service key list command
+func ServiceKeyList(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ServiceKeys(args[0])
+	if err != nil {
+		return errors.Wrap(err, "error listing service keys")
+	}
+
+	return nil
+}
+
+// ServiceKeyShow implements the epinio service key show command
+func ServiceKeyShow(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ServiceKeyShow(args[0], args[1])
+	if err != nil {
+		return errors.Wrap(err, "error showing service key")
+	}
+
+	return nil
+}
+
+// ServiceKeyDelete implements the epinio service key delete command
+func ServiceKeyDelete(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	client, err := clients.NewEpinioClient(cmd.Context(), cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "error initializing cli")
+	}
+
+	err = client.ServiceKeyDelete(args[0], args[1])
+	if err != nil {
+		return errors.Wrap(err, "error deleting service key")
+	}
+
+	return nil
+}
@@ -16,6 +16,8 @@ const (
 	orgDeletion           = 5 * time.Minute
 	serviceSecret         = 5 * time.Minute
 	serviceProvision      = 5 * time.Minute
+	serviceBackup         = 30 * time.Minute
+	operation             = 45 * time.Minute
 	serviceLoadBalancer   = 5 * time.Minute
 	podReady              = 5 * time.Minute
 	appBuilt              = 10 * time.Minute
@@ -111,6 +113,18 @@ func ToServiceProvision() time.Duration {
 	return Multiplier() * serviceProvision
 }
 
+// ToServiceBackup returns the duration to wait for the job taking or
+// restoring a backup of a service to complete
+func ToServiceBackup() time.Duration {
+	return Multiplier() * serviceBackup
+}
+
+// ToOperation returns the duration after which a background operation of
+// the server is given up
+func ToOperation() time.Duration {
+	return Multiplier() * operation
+}
+
 // ToServiceLoadBalancer
 func ToServiceLoadBalancer() time.Duration {
 	return Multiplier() * serviceLoadBalancer
@@ -0,0 +1,397 @@
+// Package gc implements the garbage collector of the epinio server. It
+// enforces the retention of staging runs, and removes what the deleted runs
+// leave behind: their volumes and the images they built.
+package gc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/go-logr/logr"
+	v1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	tektonv1beta1 "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/typed/pipeline/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Retention configures which staging runs are kept. Runs in progress and the
+// runs of the currently deployed stagings are always kept.
+type Retention struct {
+	// Keep is the number of finished runs kept per application
+	Keep int
+	// MaxAge is the age beyond which finished runs are removed, even if
+	// within Keep. Zero disables the age limit.
+	MaxAge time.Duration
+}
+
+// Stats counts the resources removed by the collector
+type Stats struct {
+	PipelineRuns int
+	Volumes      int
+	Secrets      int
+	Images       int
+}
+
+func (s *Stats) add(o Stats) {
+	s.PipelineRuns += o.PipelineRuns
+	s.Volumes += o.Volumes
+	s.Secrets += o.Secrets
+	s.Images += o.Images
+}
+
+// staleSecretAge is the age beyond which a copy of build secrets not adopted
+// by a staging run is considered left behind, e.g. by a server restart
+// losing the staging queue
+const staleSecretAge = 24 * time.Hour
+
+// Collector periodically removes expired staging runs, their orphaned volumes
+// and the registry images no longer referenced by any deployment or kept run.
+type Collector struct {
+	cluster   *kubernetes.Cluster
+	log       logr.Logger
+	retention Retention
+
+	mu          sync.Mutex
+	pruneImages bool
+	removed     Stats
+	cycles      int
+	errors      int
+}
+
+// NewCollector returns a collector enforcing the retention
+func NewCollector(cluster *kubernetes.Cluster, log logr.Logger, retention Retention, pruneImages bool) *Collector {
+	return &Collector{
+		cluster:     cluster,
+		log:         log.WithName("gc"),
+		retention:   retention,
+		pruneImages: pruneImages,
+	}
+}
+
+// Start runs a collection every interval, until the context is done
+func (c *Collector) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			stats, err := c.Collect(ctx)
+			if err != nil {
+				c.log.Error(err, "collection failed")
+			} else {
+				c.log.Info("collection done", "pipelineruns", stats.PipelineRuns,
+					"volumes", stats.Volumes, "secrets", stats.Secrets, "images", stats.Images)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Collect performs a single collection and returns what it removed
+func (c *Collector) Collect(ctx context.Context) (Stats, error) {
+	stats, err := c.collect(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removed.add(stats)
+	c.cycles++
+	if err != nil {
+		c.errors++
+	}
+
+	return stats, err
+}
+
+func (c *Collector) collect(ctx context.Context) (Stats, error) {
+	stats := Stats{}
+
+	// The registry catalog is listed before the runs. An image missing
+	// from the runs listed afterwards was built by a run which was
+	// already gone, never by a run started in between.
+	var registry *registryClient
+	var repositories []string
+	c.mu.Lock()
+	pruneImages := c.pruneImages
+	c.mu.Unlock()
+	if pruneImages {
+		var err error
+		registry, err = newRegistryClient(ctx, c.cluster)
+		if err != nil {
+			return stats, err
+		}
+		repositories, err = registry.repositories(ctx)
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	cs, err := versioned.NewForConfig(c.cluster.RestConfig)
+	if err != nil {
+		return stats, err
+	}
+	client := cs.TektonV1beta1().PipelineRuns(deployments.TektonStagingNamespace)
+
+	runs, err := client.List(ctx, metav1.ListOptions{LabelSelector: "app.kubernetes.io/managed-by=epinio"})
+	if err != nil {
+		return stats, err
+	}
+
+	apps, current, images, err := c.deployed(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	expired := ExpiredRuns(runs.Items, apps, current, c.retention, time.Now())
+	for _, name := range expired {
+		err := client.Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return stats, err
+		}
+		if err == nil {
+			stats.PipelineRuns++
+		}
+	}
+
+	gone := map[string]bool{}
+	for _, name := range expired {
+		gone[name] = true
+	}
+	for _, run := range runs.Items {
+		if !gone[run.Name] {
+			images[imageRepository(runParam(run, "APP_IMAGE"))] = true
+		}
+	}
+
+	volumes, err := c.collectVolumes(ctx, client)
+	stats.Volumes = volumes
+	if err != nil {
+		return stats, err
+	}
+
+	secrets, err := c.collectSecrets(ctx)
+	stats.Secrets = secrets
+	if err != nil {
+		return stats, err
+	}
+
+	if registry != nil {
+		removed, err := registry.prune(ctx, repositories, images)
+		stats.Images = removed
+		if err == errDeleteDisabled {
+			c.log.Info("registry does not allow deleting images, disabling image pruning")
+			c.mu.Lock()
+			c.pruneImages = false
+			c.mu.Unlock()
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// deployed returns the known applications (as org/name), the stage IDs of the
+// deployed stagings, and the image repositories used by the deployments.
+func (c *Collector) deployed(ctx context.Context) (map[string]bool, map[string]bool, map[string]bool, error) {
+	apps := map[string]bool{}
+	current := map[string]bool{}
+	images := map[string]bool{}
+
+	client, err := c.cluster.ClientApp()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	list, err := client.Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, app := range list.Items {
+		apps[appKey(app.GetNamespace(), app.GetName())] = true
+	}
+
+	deploymentList, err := c.cluster.Kubectl.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/component=application,app.kubernetes.io/managed-by=epinio",
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, deployment := range deploymentList.Items {
+		if id := deployment.Spec.Template.Labels["epinio.suse.org/stage-id"]; id != "" {
+			current[id] = true
+		}
+		for _, container := range deployment.Spec.Template.Spec.Containers {
+			images[imageRepository(container.Image)] = true
+		}
+	}
+
+	return apps, current, images, nil
+}
+
+// collectVolumes removes the volumes created for the workspaces of runs which
+// no longer exist. Usually kubernetes removes them with their owner, this
+// catches what it missed.
+func (c *Collector) collectVolumes(ctx context.Context, runs tektonv1beta1.PipelineRunInterface) (int, error) {
+	client := c.cluster.Kubectl.CoreV1().PersistentVolumeClaims(deployments.TektonStagingNamespace)
+
+	pvcs, err := client.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, pvc := range pvcs.Items {
+		if pvc.DeletionTimestamp != nil {
+			continue
+		}
+		for _, owner := range pvc.OwnerReferences {
+			if owner.Kind != "PipelineRun" {
+				continue
+			}
+			// Check the owner itself, it may have started after the
+			// runs were listed
+			_, err := runs.Get(ctx, owner.Name, metav1.GetOptions{})
+			if err == nil {
+				break
+			}
+			if !apierrors.IsNotFound(err) {
+				return removed, err
+			}
+
+			err = client.Delete(ctx, pvc.Name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return removed, err
+			}
+			if err == nil {
+				removed++
+			}
+			break
+		}
+	}
+
+	return removed, nil
+}
+
+// collectSecrets removes the copies of build secrets which were never adopted
+// by a staging run. Adopted copies go away with their run.
+func (c *Collector) collectSecrets(ctx context.Context) (int, error) {
+	client := c.cluster.Kubectl.CoreV1().Secrets(deployments.TektonStagingNamespace)
+
+	secrets, err := client.List(ctx, metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/component=build-secrets,app.kubernetes.io/managed-by=epinio",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, secret := range secrets.Items {
+		if len(secret.OwnerReferences) > 0 || time.Since(secret.CreationTimestamp.Time) < staleSecretAge {
+			continue
+		}
+		err := client.Delete(ctx, secret.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return removed, err
+		}
+		if err == nil {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// ExpiredRuns returns the names of the runs to remove under the retention.
+// Runs in progress and the runs of the current stagings are kept. Runs of
+// applications which no longer exist are removed.
+func ExpiredRuns(runs []v1beta1.PipelineRun, apps, current map[string]bool, retention Retention, now time.Time) []string {
+	byApp := map[string][]v1beta1.PipelineRun{}
+	for _, run := range runs {
+		key := appKey(run.Labels["app.kubernetes.io/part-of"], run.Labels["app.kubernetes.io/name"])
+		byApp[key] = append(byApp[key], run)
+	}
+
+	expired := []string{}
+	for key, appRuns := range byApp {
+		// Newest first
+		sort.Slice(appRuns, func(i, j int) bool {
+			return appRuns[j].CreationTimestamp.Before(&appRuns[i].CreationTimestamp)
+		})
+
+		kept := 0
+		for _, run := range appRuns {
+			if run.Status.CompletionTime == nil || current[run.Name] {
+				continue
+			}
+			tooOld := retention.MaxAge > 0 && now.Sub(run.CreationTimestamp.Time) > retention.MaxAge
+			if !apps[key] || kept >= retention.Keep || tooOld {
+				expired = append(expired, run.Name)
+				continue
+			}
+			kept++
+		}
+	}
+
+	sort.Strings(expired)
+	return expired
+}
+
+// ServeHTTP exposes what the collector removed in the prometheus text format
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	removed, cycles, errors := c.removed, c.cycles, c.errors
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP epinio_gc_removed_total Resources removed by the garbage collector.\n")
+	fmt.Fprintf(w, "# TYPE epinio_gc_removed_total counter\n")
+	fmt.Fprintf(w, "epinio_gc_removed_total{kind=\"pipelinerun\"} %d\n", removed.PipelineRuns)
+	fmt.Fprintf(w, "epinio_gc_removed_total{kind=\"volume\"} %d\n", removed.Volumes)
+	fmt.Fprintf(w, "epinio_gc_removed_total{kind=\"secret\"} %d\n", removed.Secrets)
+	fmt.Fprintf(w, "epinio_gc_removed_total{kind=\"image\"} %d\n", removed.Images)
+	fmt.Fprintf(w, "# HELP epinio_gc_cycles_total Garbage collections performed.\n")
+	fmt.Fprintf(w, "# TYPE epinio_gc_cycles_total counter\n")
+	fmt.Fprintf(w, "epinio_gc_cycles_total %d\n", cycles)
+	fmt.Fprintf(w, "# HELP epinio_gc_errors_total Garbage collections which failed.\n")
+	fmt.Fprintf(w, "# TYPE epinio_gc_errors_total counter\n")
+	fmt.Fprintf(w, "epinio_gc_errors_total %d\n", errors)
+}
+
+func appKey(org, name string) string {
+	return org + "/" + name
+}
+
+func runParam(run v1beta1.PipelineRun, name string) string {
+	for _, param := range run.Spec.Params {
+		if param.Name == name {
+			return param.Value.StringVal
+		}
+	}
+	return ""
+}
+
+// imageRepository strips the registry host and the tag from the image
+// reference, e.g. "registry.example.com/apps/foo-1234" yields "apps/foo-1234"
+func imageRepository(image string) string {
+	if i := strings.Index(image, "/"); i >= 0 {
+		image = image[i+1:]
+	}
+	if i := strings.LastIndex(image, ":"); i >= 0 {
+		image = image[:i]
+	}
+	return image
+}
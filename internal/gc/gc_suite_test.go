@@ -0,0 +1,13 @@
+package gc_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestGc(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gc Suite")
+}
@@ -0,0 +1,74 @@
+package gc_test
+
+import (
+	"time"
+
+	. "github.com/epinio/epinio/internal/gc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("ExpiredRuns", func() {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	run := func(name, app string, age time.Duration, finished bool) v1beta1.PipelineRun {
+		pr := v1beta1.PipelineRun{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+				Labels: map[string]string{
+					"app.kubernetes.io/name":    app,
+					"app.kubernetes.io/part-of": "workspace",
+				},
+			},
+		}
+		if finished {
+			completion := metav1.NewTime(now.Add(-age).Add(time.Minute))
+			pr.Status.CompletionTime = &completion
+		}
+		return pr
+	}
+
+	apps := map[string]bool{"workspace/foo": true}
+
+	It("keeps the newest finished runs of each app", func() {
+		runs := []v1beta1.PipelineRun{
+			run("r1", "foo", 4*time.Hour, true),
+			run("r2", "foo", 3*time.Hour, true),
+			run("r3", "foo", 2*time.Hour, true),
+			run("r4", "foo", 1*time.Hour, true),
+		}
+		expired := ExpiredRuns(runs, apps, map[string]bool{}, Retention{Keep: 2}, now)
+		Expect(expired).To(Equal([]string{"r1", "r2"}))
+	})
+
+	It("never removes running or current runs", func() {
+		runs := []v1beta1.PipelineRun{
+			run("r1", "foo", 4*time.Hour, true),
+			run("r2", "foo", 3*time.Hour, true),
+			run("r3", "foo", 1*time.Hour, false),
+		}
+		expired := ExpiredRuns(runs, apps, map[string]bool{"r1": true}, Retention{Keep: 0}, now)
+		Expect(expired).To(Equal([]string{"r2"}))
+	})
+
+	It("removes runs beyond the max age", func() {
+		runs := []v1beta1.PipelineRun{
+			run("r1", "foo", 48*time.Hour, true),
+			run("r2", "foo", 1*time.Hour, true),
+		}
+		expired := ExpiredRuns(runs, apps, map[string]bool{}, Retention{Keep: 5, MaxAge: 24 * time.Hour}, now)
+		Expect(expired).To(Equal([]string{"r1"}))
+	})
+
+	It("removes the finished runs of deleted apps", func() {
+		runs := []v1beta1.PipelineRun{
+			run("r1", "gone", 2*time.Hour, true),
+			run("r2", "gone", 1*time.Hour, false),
+		}
+		expired := ExpiredRuns(runs, apps, map[string]bool{}, Retention{Keep: 5}, now)
+		Expect(expired).To(Equal([]string{"r1"}))
+	})
+})
@@ -0,0 +1,204 @@
+package gc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/domain"
+	"github.com/pkg/errors"
+)
+
+// appRepositoryPrefix is the part of the registry holding the images built
+// by staging
+const appRepositoryPrefix = "apps/"
+
+// errDeleteDisabled is returned by the registry when it is not configured to
+// allow deletion
+var errDeleteDisabled = errors.New("registry does not allow deleting images")
+
+// registryClient talks to the epinio registry via the docker registry v2 API,
+// using the credentials and CA of the staging pipeline
+type registryClient struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+}
+
+func newRegistryClient(ctx context.Context, cluster *kubernetes.Cluster) (*registryClient, error) {
+	mainDomain, err := domain.MainDomain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	host := fmt.Sprintf("%s.%s", deployments.RegistryDeploymentID, mainDomain)
+
+	creds, err := cluster.GetSecret(ctx, deployments.TektonStagingNamespace, "registry-creds")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get registry credentials")
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+	err = json.Unmarshal(creds.Data[".dockerconfigjson"], &config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode registry credentials")
+	}
+	auth, ok := config.Auths[host]
+	if !ok {
+		return nil, fmt.Errorf("no registry credentials for '%s'", host)
+	}
+
+	// The registry certificate may be signed by the epinio CA, which
+	// kubed copies into the staging namespace.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	certs, err := cluster.GetSecret(ctx, deployments.TektonStagingNamespace,
+		fmt.Sprintf("%s-tls", deployments.RegistryDeploymentID))
+	if err == nil && len(certs.Data["ca.crt"]) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM(certs.Data["ca.crt"])
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &registryClient{
+		url:      "https://" + host,
+		username: auth.Username,
+		password: auth.Password,
+		client:   &http.Client{Transport: transport},
+	}, nil
+}
+
+// repositories returns the repositories holding application images
+func (r *registryClient) repositories(ctx context.Context) ([]string, error) {
+	var catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+	err := r.getJSON(ctx, "/v2/_catalog?n=10000", &catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, repository := range catalog.Repositories {
+		if strings.HasPrefix(repository, appRepositoryPrefix) {
+			result = append(result, repository)
+		}
+	}
+
+	return result, nil
+}
+
+// prune deletes the images of all repositories not in use. Every staging
+// pushes to a repository of its own, named after app and revision.
+func (r *registryClient) prune(ctx context.Context, repositories []string, inUse map[string]bool) (int, error) {
+	removed := 0
+	for _, repository := range repositories {
+		if inUse[repository] {
+			continue
+		}
+
+		var tags struct {
+			Tags []string `json:"tags"`
+		}
+		err := r.getJSON(ctx, fmt.Sprintf("/v2/%s/tags/list", repository), &tags)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, tag := range tags.Tags {
+			err := r.deleteTag(ctx, repository, tag)
+			if err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// deleteTag deletes the manifest the tag points to. The registry's own
+// garbage collection reclaims the layers.
+func (r *registryClient) deleteTag(ctx context.Context, repository, tag string) error {
+	req, err := r.request(ctx, http.MethodHead, fmt.Sprintf("/v2/%s/manifests/%s", repository, tag))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry: %s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return fmt.Errorf("registry: no digest for %s:%s", repository, tag)
+	}
+
+	req, err = r.request(ctx, http.MethodDelete, fmt.Sprintf("/v2/%s/manifests/%s", repository, digest))
+	if err != nil {
+		return err
+	}
+	resp, err = r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusNotFound:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return errDeleteDisabled
+	}
+	return fmt.Errorf("registry: %s %s: %s", req.Method, req.URL.Path, resp.Status)
+}
+
+func (r *registryClient) getJSON(ctx context.Context, path string, v interface{}) error {
+	req, err := r.request(ctx, http.MethodGet, path)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry: GET %s: %s", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (r *registryClient) request(ctx context.Context, method, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, r.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(r.username, r.password)
+	return req, nil
+}
@@ -4,6 +4,7 @@ package interfaces
 
 import (
 	"context"
+	"errors"
 
 	corev1 "k8s.io/api/core/v1"
 )
@@ -20,3 +21,19 @@ type Service interface {
 }
 
 type ServiceList []Service
+
+// ErrNoCredentials is returned for services which do not issue credentials
+// per application binding, like custom services
+var ErrNoCredentials = errors.New("service has no credentials per application to rotate")
+
+// CredentialRotator is implemented by services which issue credentials per
+// application binding, and are able to replace them
+type CredentialRotator interface {
+	// RotateBinding creates a new binding of the application, with new
+	// credentials, next to the current binding with the named secret. It
+	// returns the secret of the new binding.
+	RotateBinding(ctx context.Context, appName, current string) (*corev1.Secret, error)
+	// DeleteRotatedBinding removes the binding of the application with
+	// the named secret, after it was replaced by RotateBinding
+	DeleteRotatedBinding(ctx context.Context, appName, secretName string) error
+}
@@ -0,0 +1,325 @@
+// Package operations runs the long parts of requests in the background of
+// the epinio server, and tracks their state. Clients poll the state of an
+// operation instead of waiting in a request, so that slow brokers do not
+// run into HTTP timeouts.
+package operations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/randstr"
+	"github.com/epinio/epinio/internal/duration"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// The states of an operation
+const (
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// Retention is the age beyond which finished operations are removed
+const Retention = 24 * time.Hour
+
+// Heartbeat is the interval at which the server running an operation
+// records that it is still at it
+const Heartbeat = time.Minute
+
+// StaleAfter is the time without update after which a running operation is
+// considered abandoned, by a server which stopped, and failed
+const StaleAfter = 5 * Heartbeat
+
+// errAbandoned is the error of abandoned operations
+const errAbandoned = "abandoned, the server running the operation stopped"
+
+// Operation is the state of a long running action of the server, like the
+// provisioning of a service. It is recorded in a config map of the epinio
+// namespace, so that it survives restarts of the server, and is visible to
+// all its replicas.
+type Operation struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Org      string    `json:"org"`
+	Target   string    `json:"target"`
+	State    string    `json:"state"`
+	Messages []string  `json:"messages"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+// Done returns true when the operation is finished, successful or not
+func (o *Operation) Done() bool {
+	return o.State != StateRunning
+}
+
+// abandoned returns true for a running operation which was not updated for
+// longer than StaleAfter, and is not run by this server
+func (o *Operation) abandoned(now time.Time) bool {
+	if o.State != StateRunning || now.Sub(o.Updated) < StaleAfter {
+		return false
+	}
+	_, ok := running.Load(o.ID)
+	return !ok
+}
+
+// Progress records a message about the progress of an operation
+type Progress func(message string)
+
+// Action is the work of an operation. It runs with a context independent of
+// the request starting it.
+type Action func(ctx context.Context, progress Progress) error
+
+// running is the set of the operations of this server which did not finish
+// yet. It guards against pruning them.
+var running sync.Map
+
+// operationResourceName returns the name of the config map recording the
+// operation
+func operationResourceName(id string) string {
+	return fmt.Sprintf("epinio-operation-%s", id)
+}
+
+// Start records a new operation of the kind on the target of the org, and
+// runs the action in the background. The action is cancelled after
+// duration.ToOperation(). Finished operations older than the Retention are
+// removed, abandoned ones are marked as failed.
+func Start(ctx context.Context, cluster *kubernetes.Cluster, kind, org, target string, action Action) (*Operation, error) {
+	prune(ctx, cluster)
+
+	id, err := randstr.Hex16()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	op := &Operation{
+		ID:       id,
+		Kind:     kind,
+		Org:      org,
+		Target:   target,
+		State:    StateRunning,
+		Messages: []string{},
+		Created:  now,
+		Updated:  now,
+	}
+	js, err := json.Marshal(op)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Create(ctx,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: operationResourceName(id),
+				Labels: map[string]string{
+					"app.kubernetes.io/name":       "epinio",
+					"app.kubernetes.io/component":  "operation",
+					"app.kubernetes.io/managed-by": "epinio",
+					"epinio.suse.org/organization": org,
+					"epinio.suse.org/target":       target,
+				},
+			},
+			Data: map[string]string{
+				"operation": string(js),
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := duration.ToOperation()
+	running.Store(id, true)
+	go func() {
+		defer running.Delete(id)
+
+		// The request context ends with the request
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		done := make(chan struct{})
+		defer close(done)
+		go heartbeat(cluster, id, done)
+
+		err := action(ctx, func(message string) {
+			_ = update(ctx, cluster, id, func(op *Operation) {
+				op.Messages = append(op.Messages, message)
+			})
+		})
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s: %w", timeout, err)
+		}
+
+		// The final state is recorded even when the action timed out
+		_ = update(context.Background(), cluster, id, func(op *Operation) {
+			if err != nil {
+				op.State = StateFailed
+				op.Error = err.Error()
+			} else {
+				op.State = StateSucceeded
+			}
+		})
+	}()
+
+	return op, nil
+}
+
+// heartbeat touches the operation until done is closed, so that it does not
+// look abandoned
+func heartbeat(cluster *kubernetes.Cluster, id string, done <-chan struct{}) {
+	ticker := time.NewTicker(Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_ = update(context.Background(), cluster, id, func(op *Operation) {})
+		}
+	}
+}
+
+// Lookup returns the operation with the ID, or nil if there is none
+func Lookup(ctx context.Context, cluster *kubernetes.Cluster, id string) (*Operation, error) {
+	configMap, err := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Get(ctx,
+		operationResourceName(id), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	op, err := operationFromConfigMap(*configMap)
+	if err != nil {
+		return nil, err
+	}
+	return failAbandoned(ctx, cluster, op), nil
+}
+
+// Latest returns the most recent operation of each target of the org, by
+// target name. Bad records are skipped.
+func Latest(ctx context.Context, cluster *kubernetes.Cluster, org string) (map[string]*Operation, error) {
+	configMaps, err := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app.kubernetes.io/component=operation, epinio.suse.org/organization=%s", org),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	latest := map[string]*Operation{}
+	for _, configMap := range configMaps.Items {
+		op, err := operationFromConfigMap(configMap)
+		if err != nil {
+			continue
+		}
+		if current, ok := latest[op.Target]; ok && current.Created.After(op.Created) {
+			continue
+		}
+		latest[op.Target] = op
+	}
+
+	for target, op := range latest {
+		latest[target] = failAbandoned(ctx, cluster, op)
+	}
+
+	return latest, nil
+}
+
+// failAbandoned records an abandoned operation as failed, and returns it
+// as such. Failures to record are ignored, the next look tries again.
+func failAbandoned(ctx context.Context, cluster *kubernetes.Cluster, op *Operation) *Operation {
+	if !op.abandoned(time.Now()) {
+		return op
+	}
+
+	_ = update(ctx, cluster, op.ID, func(recorded *Operation) {
+		if recorded.abandoned(time.Now()) {
+			recorded.State = StateFailed
+			recorded.Error = errAbandoned
+		}
+	})
+
+	failed := *op
+	failed.State = StateFailed
+	failed.Error = errAbandoned
+	return &failed
+}
+
+// update applies the change to the recorded operation, and retries on
+// conflicts with other updates
+func update(ctx context.Context, cluster *kubernetes.Cluster, id string, change func(*Operation)) error {
+	client := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := client.Get(ctx, operationResourceName(id), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		op, err := operationFromConfigMap(*configMap)
+		if err != nil {
+			return err
+		}
+
+		change(op)
+		op.Updated = time.Now().UTC()
+
+		js, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		configMap.Data["operation"] = string(js)
+
+		_, err = client.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// prune removes the finished operations older than the Retention, and marks
+// the abandoned ones as failed. Failures are ignored, the next start tries
+// again.
+func prune(ctx context.Context, cluster *kubernetes.Cluster) {
+	client := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID)
+
+	configMaps, err := client.List(ctx, metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/component=operation",
+	})
+	if err != nil {
+		return
+	}
+
+	for _, configMap := range configMaps.Items {
+		op, err := operationFromConfigMap(configMap)
+		if err != nil {
+			continue
+		}
+		if op.abandoned(time.Now()) {
+			failAbandoned(ctx, cluster, op)
+			continue
+		}
+		if !op.Done() || time.Since(op.Updated) < Retention {
+			continue
+		}
+		_ = client.Delete(ctx, configMap.Name, metav1.DeleteOptions{})
+	}
+}
+
+func operationFromConfigMap(configMap corev1.ConfigMap) (*Operation, error) {
+	var op Operation
+	err := json.Unmarshal([]byte(configMap.Data["operation"]), &op)
+	if err != nil {
+		return nil, fmt.Errorf("bad operation '%s': %w", configMap.Name, err)
+	}
+	return &op, nil
+}
@@ -0,0 +1,19 @@
+package operations_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+func TestOperations(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Operations Suite")
+}
+
+var _ = BeforeSuite(func() {
+	// The timeouts are zero without the flags of the command line
+	viper.Set("timeout-multiplier", 1)
+})
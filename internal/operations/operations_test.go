@@ -0,0 +1,167 @@
+package operations_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	. "github.com/epinio/epinio/internal/operations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Operations", func() {
+	var (
+		ctx     context.Context
+		cluster *kubernetes.Cluster
+	)
+
+	// record stores the operation as a server would have
+	record := func(op Operation) {
+		js, err := json.Marshal(op)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Create(ctx,
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "epinio-operation-" + op.ID,
+					Labels: map[string]string{
+						"app.kubernetes.io/component":  "operation",
+						"epinio.suse.org/organization": op.Org,
+						"epinio.suse.org/target":       op.Target,
+					},
+				},
+				Data: map[string]string{"operation": string(js)},
+			}, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	lookup := func(id string) *Operation {
+		op, err := Lookup(ctx, cluster, id)
+		Expect(err).ToNot(HaveOccurred())
+		return op
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		cluster = &kubernetes.Cluster{Kubectl: fake.NewSimpleClientset()}
+	})
+
+	Describe("Start", func() {
+		It("records the progress and the success of the action", func() {
+			proceed := make(chan struct{})
+			op, err := Start(ctx, cluster, "service-create", "workspace", "mydb",
+				func(ctx context.Context, progress Progress) error {
+					progress("Provisioning")
+					<-proceed
+					progress("Provisioned")
+					return nil
+				})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(op.State).To(Equal(StateRunning))
+
+			Eventually(func() []string { return lookup(op.ID).Messages }).Should(Equal([]string{"Provisioning"}))
+			Expect(lookup(op.ID).Done()).To(BeFalse())
+
+			close(proceed)
+			Eventually(func() string { return lookup(op.ID).State }).Should(Equal(StateSucceeded))
+			Expect(lookup(op.ID).Messages).To(Equal([]string{"Provisioning", "Provisioned"}))
+		})
+
+		It("records the error of a failed action", func() {
+			op, err := Start(ctx, cluster, "service-delete", "workspace", "mydb",
+				func(ctx context.Context, progress Progress) error {
+					return errors.New("broker is down")
+				})
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(func() string { return lookup(op.ID).State }).Should(Equal(StateFailed))
+			Expect(lookup(op.ID).Error).To(Equal("broker is down"))
+		})
+
+		It("removes finished operations older than the retention", func() {
+			old := time.Now().Add(-Retention - time.Hour).UTC()
+			record(Operation{ID: "old", Org: "workspace", Target: "mydb", State: StateSucceeded, Created: old, Updated: old})
+			recent := time.Now().Add(-time.Hour).UTC()
+			record(Operation{ID: "recent", Org: "workspace", Target: "mydb", State: StateFailed, Created: recent, Updated: recent})
+
+			_, err := Start(ctx, cluster, "service-create", "workspace", "other",
+				func(ctx context.Context, progress Progress) error { return nil })
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(lookup("old")).To(BeNil())
+			Expect(lookup("recent")).ToNot(BeNil())
+		})
+
+		It("fails operations abandoned by a stopped server", func() {
+			stale := time.Now().Add(-StaleAfter - time.Minute).UTC()
+			record(Operation{ID: "stale", Org: "workspace", Target: "mydb", State: StateRunning, Created: stale, Updated: stale})
+
+			_, err := Start(ctx, cluster, "service-create", "workspace", "other",
+				func(ctx context.Context, progress Progress) error { return nil })
+			Expect(err).ToNot(HaveOccurred())
+
+			configMap, err := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Get(ctx,
+				"epinio-operation-stale", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configMap.Data["operation"]).To(ContainSubstring(`"state":"failed"`))
+		})
+	})
+
+	Describe("Lookup", func() {
+		It("returns nil for unknown operations", func() {
+			Expect(lookup("missing")).To(BeNil())
+		})
+
+		It("reports abandoned operations as failed", func() {
+			stale := time.Now().Add(-StaleAfter - time.Minute).UTC()
+			record(Operation{ID: "stale", Org: "workspace", Target: "mydb", State: StateRunning, Created: stale, Updated: stale})
+
+			op := lookup("stale")
+			Expect(op.State).To(Equal(StateFailed))
+			Expect(op.Error).To(ContainSubstring("abandoned"))
+		})
+
+		It("leaves running operations with recent updates alone", func() {
+			now := time.Now().UTC()
+			record(Operation{ID: "busy", Org: "workspace", Target: "mydb", State: StateRunning, Created: now, Updated: now})
+
+			Expect(lookup("busy").State).To(Equal(StateRunning))
+		})
+	})
+
+	Describe("Latest", func() {
+		It("returns the newest operation of each target, skipping bad records", func() {
+			now := time.Now().UTC()
+			record(Operation{ID: "first", Org: "workspace", Target: "mydb", State: StateSucceeded, Created: now.Add(-time.Hour), Updated: now})
+			record(Operation{ID: "second", Org: "workspace", Target: "mydb", State: StateFailed, Created: now, Updated: now})
+			record(Operation{ID: "cache", Org: "workspace", Target: "cache", State: StateSucceeded, Created: now, Updated: now})
+			record(Operation{ID: "elsewhere", Org: "other", Target: "mydb", State: StateSucceeded, Created: now, Updated: now})
+
+			_, err := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Create(ctx,
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "epinio-operation-bad",
+						Labels: map[string]string{
+							"app.kubernetes.io/component":  "operation",
+							"epinio.suse.org/organization": "workspace",
+							"epinio.suse.org/target":       "mydb",
+						},
+					},
+					Data: map[string]string{"operation": "{"},
+				}, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			latest, err := Latest(ctx, cluster, "workspace")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(latest).To(HaveLen(2))
+			Expect(latest["mydb"].ID).To(Equal("second"))
+			Expect(latest["cache"].ID).To(Equal("cache"))
+		})
+	})
+})
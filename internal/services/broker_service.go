@@ -0,0 +1,515 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/duration"
+	"github.com/epinio/epinio/internal/interfaces"
+	"github.com/epinio/epinio/internal/services/osb"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// BrokerService is a Service provisioned through a broker registered with
+// Epinio, speaking the Open Service Broker API. The state of the instance
+// is kept in a secret of the org, named like the secret of a custom
+// service. Implements the Service interface.
+type BrokerService struct {
+	SecretName string
+	OrgName    string
+	Service    string
+	Class      string
+	Plan       string
+	Broker     string
+	instanceID string
+	serviceID  string
+	planID     string
+	Created    time.Time
+	cluster    *kubernetes.Cluster
+}
+
+var _ interfaces.Service = &BrokerService{}
+var _ interfaces.CredentialRotator = &BrokerService{}
+
+// The keys of the secret holding the state of a broker service instance
+const (
+	brokerKeyBroker      = "broker"
+	brokerKeyClass       = "class"
+	brokerKeyPlan        = "plan"
+	brokerKeyInstanceID  = "instance-id"
+	brokerKeyServiceID   = "service-id"
+	brokerKeyPlanID      = "plan-id"
+	brokerKeyState       = "state"
+	brokerKeyOperation   = "operation"
+	brokerKeyDescription = "description"
+)
+
+// bindingIDAnnotation holds the broker's ID of the binding, on the binding
+// secret
+const bindingIDAnnotation = "epinio.suse.org/binding-id"
+
+// BrokerServiceList returns a ServiceList of all broker services of the org
+func BrokerServiceList(ctx context.Context, cluster *kubernetes.Cluster, org string) (interfaces.ServiceList, error) {
+	labelSelector := fmt.Sprintf("epinio.suse.org/service-type=broker, epinio.suse.org/organization=%s", org)
+
+	secrets, err := cluster.Kubectl.CoreV1().Secrets(org).List(ctx,
+		metav1.ListOptions{
+			LabelSelector: labelSelector,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	result := interfaces.ServiceList{}
+	for _, secret := range secrets.Items {
+		result = append(result, brokerServiceFromSecret(cluster, secret))
+	}
+
+	return result, nil
+}
+
+// BrokerServiceLookup finds a broker service by looking for its secret
+func BrokerServiceLookup(ctx context.Context, cluster *kubernetes.Cluster, org, service string) (interfaces.Service, error) {
+	secret, err := cluster.GetSecret(ctx, org, serviceResourceName(org, service))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if secret.Labels["epinio.suse.org/service-type"] != "broker" {
+		return nil, nil
+	}
+
+	return brokerServiceFromSecret(cluster, *secret), nil
+}
+
+// CreateBrokerService provisions a new instance of the class and plan
+// through the class' broker, with the parameters. The instance may still
+// be provisioning on return, see WaitForProvision.
+func CreateBrokerService(ctx context.Context, cluster *kubernetes.Cluster, name, org string,
+	class *ServiceClass, plan *ServicePlan, parameters map[string]interface{}) (interfaces.Service, error) {
+
+	if class.broker == nil {
+		return nil, fmt.Errorf("service class '%s' is not offered by a broker", class.Name)
+	}
+
+	instanceID, err := osb.NewGUID()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BrokerService{
+		SecretName: serviceResourceName(org, name),
+		OrgName:    org,
+		Service:    name,
+		Class:      class.Name,
+		Plan:       plan.Name,
+		Broker:     class.broker.Name,
+		instanceID: instanceID,
+		serviceID:  class.Hash,
+		planID:     plan.id,
+		cluster:    cluster,
+	}
+
+	// The state is recorded before provisioning, so that an instance is
+	// never left behind unknown to Epinio
+	err = cluster.CreateSecret(ctx, org, corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: s.SecretName,
+			Labels: map[string]string{
+				"epinio.suse.org/service-type": "broker",
+				"epinio.suse.org/service":      name,
+				"epinio.suse.org/organization": org,
+				"epinio.suse.org/broker":       s.Broker,
+				"app.kubernetes.io/name":       "epinio",
+			},
+		},
+		StringData: map[string]string{
+			brokerKeyBroker:     s.Broker,
+			brokerKeyClass:      s.Class,
+			brokerKeyPlan:       s.Plan,
+			brokerKeyInstanceID: s.instanceID,
+			brokerKeyServiceID:  s.serviceID,
+			brokerKeyPlanID:     s.planID,
+			brokerKeyState:      osb.StateInProgress,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := class.broker.Client()
+	op, err := client.Provision(ctx, instanceID, osb.ProvisionRequest{
+		ServiceID: s.serviceID,
+		PlanID:    s.planID,
+		Context: &osb.PlatformContext{
+			Platform:  "kubernetes",
+			Namespace: org,
+			Instance:  name,
+		},
+		Parameters:       parameters,
+		OrganizationGUID: org,
+		SpaceGUID:        org,
+	})
+	if err != nil {
+		_ = cluster.DeleteSecret(ctx, org, s.SecretName)
+		return nil, err
+	}
+
+	if op.Async {
+		err = s.record(ctx, osb.StateInProgress, op.Operation, "")
+	} else {
+		err = s.record(ctx, osb.StateSucceeded, "", "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func brokerServiceFromSecret(cluster *kubernetes.Cluster, secret corev1.Secret) *BrokerService {
+	return &BrokerService{
+		SecretName: secret.Name,
+		OrgName:    secret.Labels["epinio.suse.org/organization"],
+		Service:    secret.Labels["epinio.suse.org/service"],
+		Class:      string(secret.Data[brokerKeyClass]),
+		Plan:       string(secret.Data[brokerKeyPlan]),
+		Broker:     string(secret.Data[brokerKeyBroker]),
+		instanceID: string(secret.Data[brokerKeyInstanceID]),
+		serviceID:  string(secret.Data[brokerKeyServiceID]),
+		planID:     string(secret.Data[brokerKeyPlanID]),
+		Created:    secret.CreationTimestamp.Time,
+		cluster:    cluster,
+	}
+}
+
+func (s *BrokerService) Name() string {
+	return s.Service
+}
+
+func (s *BrokerService) Org() string {
+	return s.OrgName
+}
+
+// client returns a client for the broker of the service
+func (s *BrokerService) client(ctx context.Context) (*osb.Client, error) {
+	broker, err := LookupBroker(ctx, s.cluster, s.Broker)
+	if err != nil {
+		return nil, err
+	}
+	if broker == nil {
+		return nil, fmt.Errorf("service broker '%s' is not registered", s.Broker)
+	}
+	return broker.Client(), nil
+}
+
+// state returns the state of the last operation of the instance, as
+// recorded, with the operation and its description
+func (s *BrokerService) state(ctx context.Context) (string, string, string, error) {
+	secret, err := s.cluster.GetSecret(ctx, s.OrgName, s.SecretName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", "", "", errors.New("service does not exist")
+		}
+		return "", "", "", err
+	}
+	return string(secret.Data[brokerKeyState]),
+		string(secret.Data[brokerKeyOperation]),
+		string(secret.Data[brokerKeyDescription]), nil
+}
+
+// record stores the state of the last operation of the instance
+func (s *BrokerService) record(ctx context.Context, state, operation, description string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := s.cluster.GetSecret(ctx, s.OrgName, s.SecretName)
+		if err != nil {
+			return err
+		}
+
+		secret.Data[brokerKeyState] = []byte(state)
+		secret.Data[brokerKeyOperation] = []byte(operation)
+		secret.Data[brokerKeyDescription] = []byte(description)
+
+		_, err = s.cluster.Kubectl.CoreV1().Secrets(s.OrgName).Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// refresh asks the broker for the state of an operation in progress, and
+// records it
+func (s *BrokerService) refresh(ctx context.Context) (*osb.LastOperation, error) {
+	state, operation, description, err := s.state(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if state != osb.StateInProgress {
+		return &osb.LastOperation{State: state, Description: description}, nil
+	}
+
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	last, err := client.LastOperation(ctx, s.instanceID, s.serviceID, s.planID, operation)
+	if err != nil {
+		return nil, err
+	}
+	if last.State != osb.StateInProgress {
+		err = s.record(ctx, last.State, "", last.Description)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return last, nil
+}
+
+// GetBinding returns the secret holding the credentials of the application's
+// binding. The binding is created by the broker if there is none yet.
+func (s *BrokerService) GetBinding(ctx context.Context, appName string) (*corev1.Secret, error) {
+	bindingName := bindingResourceName(s.OrgName, s.Service, appName)
+
+	for _, name := range []string{bindingName, rotatedBindingName(bindingName)} {
+		secret, err := s.cluster.GetSecret(ctx, s.OrgName, name)
+		if err == nil {
+			return secret, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	return s.bind(ctx, appName, bindingName)
+}
+
+// bind creates a binding of the application at the broker, and stores the
+// credentials in the named secret
+func (s *BrokerService) bind(ctx context.Context, appName, bindingName string) (*corev1.Secret, error) {
+	last, err := s.refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if last.State != osb.StateSucceeded {
+		return nil, errors.New("service is not provisioned")
+	}
+
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bindingID, err := osb.NewGUID()
+	if err != nil {
+		return nil, err
+	}
+
+	binding, op, err := client.Bind(ctx, s.instanceID, bindingID, osb.BindRequest{
+		ServiceID: s.serviceID,
+		PlanID:    s.planID,
+		Context: &osb.PlatformContext{
+			Platform:  "kubernetes",
+			Namespace: s.OrgName,
+			Instance:  s.Service,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if op.Async {
+		err = client.Wait(ctx, duration.ToServiceSecret(), func(ctx context.Context) (*osb.LastOperation, error) {
+			return client.BindingLastOperation(ctx, s.instanceID, bindingID, s.serviceID, s.planID, op.Operation)
+		})
+		if err != nil {
+			return nil, err
+		}
+		binding, err = client.FetchBinding(ctx, s.instanceID, bindingID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := map[string][]byte{}
+	for key, value := range binding.Credentials {
+		if str, ok := value.(string); ok {
+			data[key] = []byte(str)
+			continue
+		}
+		js, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = js
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: bindingName,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       appName,
+				"app.kubernetes.io/part-of":    s.OrgName,
+				"app.kubernetes.io/component":  "servicebindingsecret",
+				"app.kubernetes.io/managed-by": "epinio",
+			},
+			Annotations: map[string]string{
+				bindingIDAnnotation: bindingID,
+			},
+		},
+		Data: data,
+	}
+	secret, err = s.cluster.Kubectl.CoreV1().Secrets(s.OrgName).Create(ctx, secret, metav1.CreateOptions{})
+	if err != nil {
+		_, _ = client.Unbind(ctx, s.instanceID, bindingID, s.serviceID, s.planID)
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// DeleteBinding removes the application's binding at the broker, and the
+// secret holding its credentials
+func (s *BrokerService) DeleteBinding(ctx context.Context, appName, org string) error {
+	bindingName := bindingResourceName(s.OrgName, s.Service, appName)
+
+	for _, name := range []string{bindingName, rotatedBindingName(bindingName)} {
+		err := s.unbind(ctx, org, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RotateBinding creates a new binding of the application at the broker,
+// next to the current one. A binding left behind by an incomplete rotation
+// is removed first.
+func (s *BrokerService) RotateBinding(ctx context.Context, appName, current string) (*corev1.Secret, error) {
+	err := checkRotation(s.OrgName, s.Service, appName, current)
+	if err != nil {
+		return nil, err
+	}
+
+	next := rotatedBindingName(current)
+	err = s.unbind(ctx, s.OrgName, next)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.bind(ctx, appName, next)
+}
+
+// DeleteRotatedBinding removes the replaced binding of the application at
+// the broker, and its secret
+func (s *BrokerService) DeleteRotatedBinding(ctx context.Context, appName, secretName string) error {
+	err := checkRotation(s.OrgName, s.Service, appName, secretName)
+	if err != nil {
+		return err
+	}
+
+	return s.unbind(ctx, s.OrgName, secretName)
+}
+
+// unbind removes the binding stored in the named secret at the broker, and
+// the secret. A missing secret is not an error.
+func (s *BrokerService) unbind(ctx context.Context, org, bindingName string) error {
+	secret, err := s.cluster.GetSecret(ctx, org, bindingName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	bindingID := secret.Annotations[bindingIDAnnotation]
+	op, err := client.Unbind(ctx, s.instanceID, bindingID, s.serviceID, s.planID)
+	if err != nil {
+		return err
+	}
+	if op.Async {
+		err = client.Wait(ctx, duration.ToServiceSecret(), func(ctx context.Context) (*osb.LastOperation, error) {
+			return client.BindingLastOperation(ctx, s.instanceID, bindingID, s.serviceID, s.planID, op.Operation)
+		})
+		if err != nil && err != osb.ErrGone {
+			return err
+		}
+	}
+
+	return s.cluster.DeleteSecret(ctx, org, bindingName)
+}
+
+// Delete deprovisions the instance, waiting for the broker to complete, and
+// removes its state
+func (s *BrokerService) Delete(ctx context.Context) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	op, err := client.Deprovision(ctx, s.instanceID, s.serviceID, s.planID)
+	if err != nil {
+		return err
+	}
+	if op.Async {
+		err = s.record(ctx, osb.StateInProgress, op.Operation, "")
+		if err != nil {
+			return err
+		}
+		err = client.Wait(ctx, duration.ToServiceProvision(), func(ctx context.Context) (*osb.LastOperation, error) {
+			return client.LastOperation(ctx, s.instanceID, s.serviceID, s.planID, op.Operation)
+		})
+		if err != nil && err != osb.ErrGone {
+			return err
+		}
+	}
+
+	return s.cluster.DeleteSecret(ctx, s.OrgName, s.SecretName)
+}
+
+func (s *BrokerService) Status(ctx context.Context) (string, error) {
+	last, err := s.refresh(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	switch last.State {
+	case osb.StateSucceeded:
+		return "Provisioned", nil
+	case osb.StateInProgress:
+		return "Provisioning", nil
+	}
+	if last.Description != "" {
+		return "Failed: " + last.Description, nil
+	}
+	return "Failed", nil
+}
+
+func (s *BrokerService) WaitForProvision(ctx context.Context) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	return client.Wait(ctx, duration.ToServiceProvision(), s.refresh)
+}
+
+func (s *BrokerService) Details(_ context.Context) (map[string]string, error) {
+	details := map[string]string{}
+
+	details["Class"] = s.Class
+	details["Plan"] = s.Plan
+	details["Broker"] = s.Broker
+
+	return details, nil
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/tracelog"
+	"github.com/epinio/epinio/internal/services/osb"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Broker is a service broker registered with Epinio. Its services are
+// offered as classes next to the ones of the service catalog.
+type Broker struct {
+	Name     string
+	URL      string
+	Username string
+	Password string
+}
+
+// Client returns a client talking to the broker
+func (b *Broker) Client() *osb.Client {
+	return osb.NewClient(b.URL, b.Username, b.Password)
+}
+
+// ErrBrokerExists is returned by AddBroker for a name already in use
+var ErrBrokerExists = errors.New("service broker exists")
+
+// ErrBrokerCatalog is returned by AddBroker when the catalog of the broker
+// can not be fetched
+var ErrBrokerCatalog = errors.New("failed to fetch catalog of service broker")
+
+// ErrBrokerInUse is returned by DeleteBroker for a broker with service
+// instances
+var ErrBrokerInUse = errors.New("service broker has service instances")
+
+func brokerResourceName(name string) string {
+	return fmt.Sprintf("epinio-broker-%s", name)
+}
+
+// AddBroker registers the broker, after checking that its catalog can be
+// fetched. The registration is kept in a secret of the epinio namespace.
+func AddBroker(ctx context.Context, cluster *kubernetes.Cluster, broker Broker) error {
+	_, err := cluster.GetSecret(ctx, deployments.EpinioDeploymentID, brokerResourceName(broker.Name))
+	if err == nil {
+		return ErrBrokerExists
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	_, err = broker.Client().Catalog(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBrokerCatalog, err.Error())
+	}
+
+	return cluster.CreateSecret(ctx, deployments.EpinioDeploymentID, corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: brokerResourceName(broker.Name),
+			Labels: map[string]string{
+				"epinio.suse.org/broker":       broker.Name,
+				"app.kubernetes.io/name":       "epinio",
+				"app.kubernetes.io/component":  "servicebroker",
+				"app.kubernetes.io/managed-by": "epinio",
+			},
+		},
+		StringData: map[string]string{
+			"url":      broker.URL,
+			"username": broker.Username,
+			"password": broker.Password,
+		},
+	})
+}
+
+// ListBrokers returns the registered brokers, sorted by name
+func ListBrokers(ctx context.Context, cluster *kubernetes.Cluster) ([]Broker, error) {
+	secrets, err := cluster.Kubectl.CoreV1().Secrets(deployments.EpinioDeploymentID).List(ctx,
+		metav1.ListOptions{
+			LabelSelector: "app.kubernetes.io/component=servicebroker, epinio.suse.org/broker",
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Broker{}
+	for _, secret := range secrets.Items {
+		result = append(result, brokerFromSecret(secret))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result, nil
+}
+
+// LookupBroker returns the named broker, or nil if it is not registered
+func LookupBroker(ctx context.Context, cluster *kubernetes.Cluster, name string) (*Broker, error) {
+	secret, err := cluster.GetSecret(ctx, deployments.EpinioDeploymentID, brokerResourceName(name))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	broker := brokerFromSecret(*secret)
+	return &broker, nil
+}
+
+// DeleteBroker removes the registration of the broker. Brokers with service
+// instances can not be removed, the error names the instances.
+func DeleteBroker(ctx context.Context, cluster *kubernetes.Cluster, name string) error {
+	instances, err := cluster.Kubectl.CoreV1().Secrets("").List(ctx,
+		metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("epinio.suse.org/service-type=broker, epinio.suse.org/broker=%s", name),
+		})
+	if err != nil {
+		return err
+	}
+	if len(instances.Items) > 0 {
+		names := []string{}
+		for _, instance := range instances.Items {
+			names = append(names, instance.Namespace+"/"+instance.Labels["epinio.suse.org/service"])
+		}
+		return fmt.Errorf("%w: %s", ErrBrokerInUse, strings.Join(names, ", "))
+	}
+
+	return cluster.DeleteSecret(ctx, deployments.EpinioDeploymentID, brokerResourceName(name))
+}
+
+func brokerFromSecret(secret corev1.Secret) Broker {
+	return Broker{
+		Name:     secret.Labels["epinio.suse.org/broker"],
+		URL:      string(secret.Data["url"]),
+		Username: string(secret.Data["username"]),
+		Password: string(secret.Data["password"]),
+	}
+}
+
+// brokerClasses returns the services offered by the registered brokers as
+// service classes. Brokers whose catalog can not be fetched are logged and
+// skipped, so that one broker being down does not hide all the classes.
+func brokerClasses(ctx context.Context, cluster *kubernetes.Cluster) (ServiceClassList, error) {
+	brokers, err := ListBrokers(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	result := ServiceClassList{}
+	for i := range brokers {
+		broker := &brokers[i]
+		catalog, err := broker.Client().Catalog(ctx)
+		if err != nil {
+			tracelog.Logger(ctx).Error(err, "failed to fetch catalog of service broker", "broker", broker.Name)
+			continue
+		}
+
+		for _, service := range catalog.Services {
+			result = append(result, ServiceClass{
+				Name:        service.Name,
+				Broker:      broker.Name,
+				Description: service.Description,
+				Hash:        service.ID,
+				cluster:     cluster,
+				broker:      broker,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// brokerPlans returns the plans of the broker's service of the class
+func (sc *ServiceClass) brokerPlans(ctx context.Context) (ServicePlanList, error) {
+	catalog, err := sc.broker.Client().Catalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := ServicePlanList{}
+	for _, service := range catalog.Services {
+		if service.ID != sc.Hash {
+			continue
+		}
+		for _, plan := range service.Plans {
+			result = append(result, ServicePlan{
+				Name:        plan.Name,
+				Description: plan.Description,
+				Free:        plan.IsFree(),
+				Schema:      plan.CreateParameterSchema(),
+				id:          plan.ID,
+			})
+		}
+	}
+
+	return result, nil
+}
@@ -15,8 +15,13 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
 )
 
+// ErrPlanNotUpdatable is returned by Update when the class of the service
+// does not allow changing the plan
+var ErrPlanNotUpdatable = errors.New("service class does not allow plan changes")
+
 // CatalogService is a Service created using Service Catalog.
 // Implements the Service interface.
 type CatalogService struct {
@@ -25,27 +30,64 @@ type CatalogService struct {
 	Service      string
 	Class        string
 	Plan         string
+	Created      time.Time
 	cluster      *kubernetes.Cluster
 }
 
 var _ interfaces.Service = &CatalogService{}
+var _ interfaces.CredentialRotator = &CatalogService{}
 
-// ServiceClass is a service class managed by Service catalog
+// ServiceClass is a service class managed by Service catalog, offered by a
+// broker registered with Epinio, or a Helm chart registered with Epinio. For
+// brokers the hash is the ID of the broker's service, for charts the name.
 type ServiceClass struct {
 	Hash        string
 	Name        string
 	Broker      string
 	Description string
 	cluster     *kubernetes.Cluster
+	broker      *Broker
+	chart       *Chart
+	// planUpdatable is set for catalog classes whose services can
+	// change their plan
+	planUpdatable bool
 }
 
 type ServiceClassList []ServiceClass
 
+// IsBroker returns whether the class is offered by a broker registered with
+// Epinio, instead of the service catalog
+func (sc *ServiceClass) IsBroker() bool {
+	return sc.broker != nil
+}
+
+// IsChart returns whether the class is a Helm chart registered with Epinio
+func (sc *ServiceClass) IsChart() bool {
+	return sc.chart != nil
+}
+
 // ServicePlan is a service plan managed by Service catalog
 type ServicePlan struct {
 	Name        string
 	Description string
 	Free        bool
+	// Schema is the JSON schema of the parameters of new services, if
+	// the plan has one
+	Schema map[string]interface{} `json:",omitempty"`
+	// UpdateSchema is the JSON schema of the parameters of updated
+	// services, if it differs from Schema
+	UpdateSchema map[string]interface{} `json:",omitempty"`
+	id           string
+}
+
+// UpdateParameterSchema returns the JSON schema of the parameters of an
+// updated service. Plans without a schema of their own for updates check
+// them like the parameters of new services.
+func (sp *ServicePlan) UpdateParameterSchema() map[string]interface{} {
+	if len(sp.UpdateSchema) > 0 {
+		return sp.UpdateSchema
+	}
+	return sp.Schema
 }
 
 type ServicePlanList []ServicePlan
@@ -80,6 +122,19 @@ func (spl ServicePlanList) Less(i, j int) bool {
 
 // LookupPlan returns the named ServicePlan, for the specified class
 func (sc *ServiceClass) LookupPlan(ctx context.Context, plan string) (*ServicePlan, error) {
+	if sc.broker != nil || sc.chart != nil {
+		plans, err := sc.ListPlans(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i := range plans {
+			if plans[i].Name == plan {
+				return &plans[i], nil
+			}
+		}
+		return nil, nil
+	}
+
 	client, err := sc.cluster.ClientServiceCatalog("clusterserviceplans")
 	if err != nil {
 		return nil, err
@@ -104,11 +159,15 @@ func (sc *ServiceClass) LookupPlan(ctx context.Context, plan string) (*ServicePl
 
 		description := spec["description"].(string)
 		isAFreePlan := spec["free"].(bool)
+		schema, _ := spec["instanceCreateParameterSchema"].(map[string]interface{})
+		updateSchema, _ := spec["instanceUpdateParameterSchema"].(map[string]interface{})
 
 		return &ServicePlan{
-			Name:        externalName,
-			Description: description,
-			Free:        isAFreePlan,
+			Name:         externalName,
+			Description:  description,
+			Free:         isAFreePlan,
+			Schema:       schema,
+			UpdateSchema: updateSchema,
 		}, nil
 	}
 
@@ -117,6 +176,13 @@ func (sc *ServiceClass) LookupPlan(ctx context.Context, plan string) (*ServicePl
 
 // ListPlans returns a ServicePlanList of all available catalog service plans, for the named class
 func (sc *ServiceClass) ListPlans(ctx context.Context) (ServicePlanList, error) {
+	if sc.broker != nil {
+		return sc.brokerPlans(ctx)
+	}
+	if sc.chart != nil {
+		return sc.chartPlans(), nil
+	}
+
 	client, err := sc.cluster.ClientServiceCatalog("clusterserviceplans")
 	if err != nil {
 		return nil, err
@@ -138,19 +204,45 @@ func (sc *ServiceClass) ListPlans(ctx context.Context) (ServicePlanList, error)
 		externalName := spec["externalName"].(string)
 		description := spec["description"].(string)
 		isAFreePlan := spec["free"].(bool)
+		schema, _ := spec["instanceCreateParameterSchema"].(map[string]interface{})
+		updateSchema, _ := spec["instanceUpdateParameterSchema"].(map[string]interface{})
 
 		result = append(result, ServicePlan{
-			Name:        externalName,
-			Description: description,
-			Free:        isAFreePlan,
+			Name:         externalName,
+			Description:  description,
+			Free:         isAFreePlan,
+			Schema:       schema,
+			UpdateSchema: updateSchema,
 		})
 	}
 
 	return result, nil
 }
 
-// ListClasses returns a ServiceClassList of all available catalog service classes
+// epinioClasses returns the classes provided by Epinio itself, of the
+// registered charts and brokers
+func epinioClasses(ctx context.Context, cluster *kubernetes.Cluster) (ServiceClassList, error) {
+	charts, err := chartClasses(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	brokers, err := brokerClasses(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(charts, brokers...), nil
+}
+
+// ListClasses returns a ServiceClassList of all available service classes,
+// of the service catalog, the registered charts and brokers
 func ListClasses(ctx context.Context, cluster *kubernetes.Cluster) (ServiceClassList, error) {
+	result, err := epinioClasses(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+
 	client, err := cluster.ClientServiceCatalog("clusterserviceclasses")
 	if err != nil {
 		return nil, err
@@ -158,12 +250,14 @@ func ListClasses(ctx context.Context, cluster *kubernetes.Cluster) (ServiceClass
 
 	serviceClasses, err := client.List(ctx, metav1.ListOptions{})
 
+	// Without service catalog only the charts and brokers provide classes
+	if apierrors.IsNotFound(err) {
+		return result, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	result := ServiceClassList{}
-
 	for _, serviceClass := range serviceClasses.Items {
 		spec := serviceClass.Object["spec"].(map[string]interface{})
 
@@ -187,12 +281,15 @@ func ListClasses(ctx context.Context, cluster *kubernetes.Cluster) (ServiceClass
 		labels := metadata["labels"].(map[string]interface{})
 		hash := labels["servicecatalog.k8s.io/spec.externalID"].(string)
 
+		planUpdatable, _ := spec["planUpdatable"].(bool)
+
 		result = append(result, ServiceClass{
-			Name:        externalName,
-			Broker:      clusterServiceBrokerName,
-			Description: description,
-			Hash:        hash,
-			cluster:     cluster,
+			Name:          externalName,
+			Broker:        clusterServiceBrokerName,
+			Description:   description,
+			Hash:          hash,
+			cluster:       cluster,
+			planUpdatable: planUpdatable,
 		})
 	}
 
@@ -200,6 +297,16 @@ func ListClasses(ctx context.Context, cluster *kubernetes.Cluster) (ServiceClass
 }
 
 func ClassLookup(ctx context.Context, cluster *kubernetes.Cluster, serviceClassName string) (*ServiceClass, error) {
+	classes, err := epinioClasses(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	for i := range classes {
+		if classes[i].Name == serviceClassName {
+			return &classes[i], nil
+		}
+	}
+
 	client, err := cluster.ClientServiceCatalog("clusterserviceclasses")
 	if err != nil {
 		return nil, err
@@ -215,6 +322,9 @@ func ClassLookup(ctx context.Context, cluster *kubernetes.Cluster, serviceClassN
 
 	serviceClasses, err := client.List(ctx, metav1.ListOptions{})
 
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -236,12 +346,15 @@ func ClassLookup(ctx context.Context, cluster *kubernetes.Cluster, serviceClassN
 		labels := metadata["labels"].(map[string]interface{})
 		hash := labels["servicecatalog.k8s.io/spec.externalID"].(string)
 
+		planUpdatable, _ := spec["planUpdatable"].(bool)
+
 		return &ServiceClass{
-			Name:        externalName,
-			Broker:      clusterServiceBrokerName,
-			Description: description,
-			Hash:        hash,
-			cluster:     cluster,
+			Name:          externalName,
+			Broker:        clusterServiceBrokerName,
+			Description:   description,
+			Hash:          hash,
+			cluster:       cluster,
+			planUpdatable: planUpdatable,
 		}, nil
 	}
 
@@ -260,6 +373,10 @@ func CatalogServiceList(ctx context.Context, cluster *kubernetes.Cluster, org st
 
 	serviceInstances, err := client.Namespace(org).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
 
+	// No service catalog, no catalog services
+	if apierrors.IsNotFound(err) {
+		return interfaces.ServiceList{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -283,6 +400,7 @@ func CatalogServiceList(ctx context.Context, cluster *kubernetes.Cluster, org st
 			Service:      service,
 			Class:        className,
 			Plan:         planName,
+			Created:      serviceInstance.GetCreationTimestamp().Time,
 			cluster:      cluster,
 		})
 	}
@@ -317,38 +435,38 @@ func CatalogServiceLookup(ctx context.Context, cluster *kubernetes.Cluster, org,
 		Service:      service,
 		Class:        className,
 		Plan:         planName,
+		Created:      serviceInstance.GetCreationTimestamp().Time,
 		cluster:      cluster,
 	}, nil
 }
 
-func CreateCatalogService(ctx context.Context, cluster *kubernetes.Cluster, name, org, class, plan string, parameters string) (interfaces.Service, error) {
+func CreateCatalogService(ctx context.Context, cluster *kubernetes.Cluster, name, org, class, plan string, parameters map[string]interface{}) (interfaces.Service, error) {
 	resourceName := serviceResourceName(org, name)
 
-	data := fmt.Sprintf(`{
-		"apiVersion": "servicecatalog.k8s.io/v1beta1",
-		"kind": "ServiceInstance",
-		"metadata": {
-			"name": "%s",
-			"namespace": "%s",
-			"labels": {
-				"epinio.suse.org/service-type": "catalog",
-				"epinio.suse.org/service":      "%s",
-				"epinio.suse.org/organization": "%s",
-				"app.kubernetes.io/name":        "epinio"
-			}
+	if parameters == nil {
+		parameters = map[string]interface{}{}
+	}
+
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "servicecatalog.k8s.io/v1beta1",
+			"kind":       "ServiceInstance",
+			"metadata": map[string]interface{}{
+				"name":      resourceName,
+				"namespace": org,
+				"labels": map[string]interface{}{
+					"epinio.suse.org/service-type": "catalog",
+					"epinio.suse.org/service":      name,
+					"epinio.suse.org/organization": org,
+					"app.kubernetes.io/name":       "epinio",
+				},
+			},
+			"spec": map[string]interface{}{
+				"clusterServiceClassExternalName": class,
+				"clusterServicePlanExternalName":  plan,
+				"parameters":                      parameters,
+			},
 		},
-		"spec": {
-			"clusterServiceClassExternalName": "%s",
-			"clusterServicePlanExternalName": "%s",
-			"parameters": %s
-	  }
-	}`, resourceName, org, name, org, class, plan, parameters)
-
-	decoderUnstructured := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
-	obj := &unstructured.Unstructured{}
-	_, _, err := decoderUnstructured.Decode([]byte(data), nil, obj)
-	if err != nil {
-		return nil, err
 	}
 
 	client, err := cluster.ClientServiceCatalog("serviceinstances")
@@ -388,20 +506,66 @@ func (s *CatalogService) GetBinding(ctx context.Context, appName string) (*corev
 
 	bindingName := bindingResourceName(s.OrgName, s.Service, appName)
 
-	binding, err := s.LookupBinding(ctx, bindingName, s.OrgName)
-	if err != nil {
-		return nil, err
-	}
-	if binding == nil {
-		_, err = s.CreateBinding(ctx, bindingName, s.OrgName, s.Service, appName)
+	for _, name := range []string{bindingName, rotatedBindingName(bindingName)} {
+		binding, err := s.LookupBinding(ctx, name, s.OrgName)
 		if err != nil {
 			return nil, err
 		}
+		if binding != nil {
+			return s.GetBindingSecret(ctx, name, s.OrgName)
+		}
+	}
+
+	_, err := s.CreateBinding(ctx, bindingName, s.OrgName, s.Service, appName)
+	if err != nil {
+		return nil, err
 	}
 
 	return s.GetBindingSecret(ctx, bindingName, s.OrgName)
 }
 
+// RotateBinding creates a new ServiceBinding for the application, next to
+// the current one, and returns its secret. The broker issues new
+// credentials for it. A binding left behind by an incomplete rotation is
+// removed first.
+func (s *CatalogService) RotateBinding(ctx context.Context, appName, current string) (*corev1.Secret, error) {
+	err := checkRotation(s.OrgName, s.Service, appName, current)
+	if err != nil {
+		return nil, err
+	}
+
+	next := rotatedBindingName(current)
+	err = s.deleteBinding(ctx, next)
+	if err != nil {
+		return nil, err
+	}
+	err = wait.PollImmediate(time.Second, duration.ToServiceSecret(), func() (bool, error) {
+		binding, err := s.LookupBinding(ctx, next, s.OrgName)
+		return binding == nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.CreateBinding(ctx, next, s.OrgName, s.Service, appName)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetBindingSecret(ctx, next, s.OrgName)
+}
+
+// DeleteRotatedBinding deletes the replaced ServiceBinding of the
+// application. Its secret is deleted automatically.
+func (s *CatalogService) DeleteRotatedBinding(ctx context.Context, appName, secretName string) error {
+	err := checkRotation(s.OrgName, s.Service, appName, secretName)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteBinding(ctx, secretName)
+}
+
 // LookupBinding finds a ServiceBinding object for the application with Name
 // appName if there is one.
 func (s *CatalogService) LookupBinding(ctx context.Context, bindingName, org string) (interface{}, error) {
@@ -503,7 +667,36 @@ func (s *CatalogService) DeleteBinding(ctx context.Context, appName, org string)
 
 	bindingName := bindingResourceName(s.OrgName, s.Service, appName)
 
-	return client.Namespace(org).Delete(ctx, bindingName, metav1.DeleteOptions{})
+	// After a rotation of the credentials the binding has the rotated
+	// name. Not finding either is an error.
+	rotated := true
+	err = client.Namespace(org).Delete(ctx, rotatedBindingName(bindingName), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		rotated = false
+	} else if err != nil {
+		return err
+	}
+
+	err = client.Namespace(org).Delete(ctx, bindingName, metav1.DeleteOptions{})
+	if rotated && apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// deleteBinding deletes the named ServiceBinding of the service, if it
+// exists
+func (s *CatalogService) deleteBinding(ctx context.Context, bindingName string) error {
+	client, err := s.cluster.ClientServiceCatalog("servicebindings")
+	if err != nil {
+		return err
+	}
+
+	err = client.Namespace(s.OrgName).Delete(ctx, bindingName, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
 }
 
 func (s *CatalogService) Delete(ctx context.Context) error {
@@ -567,6 +760,112 @@ func (s *CatalogService) WaitForProvision(ctx context.Context) error {
 	})
 }
 
+// Update changes the plan and the parameters of the service instance. An
+// empty plan keeps the plan, nil parameters keep the parameters. The service
+// catalog then updates the instance at its broker, see WaitForUpdate. The
+// generation of the changed instance is returned.
+func (s *CatalogService) Update(ctx context.Context, plan string, parameters map[string]interface{}) (int64, error) {
+	if plan != "" && plan != s.Plan {
+		class, err := ClassLookup(ctx, s.cluster, s.Class)
+		if err != nil {
+			return 0, err
+		}
+		if class == nil {
+			return 0, fmt.Errorf("service class '%s' does not exist", s.Class)
+		}
+		if !class.planUpdatable {
+			return 0, ErrPlanNotUpdatable
+		}
+	}
+
+	client, err := s.cluster.ClientServiceCatalog("serviceinstances")
+	if err != nil {
+		return 0, err
+	}
+	namespace := client.Namespace(s.OrgName)
+
+	var generation int64
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		serviceInstance, err := namespace.Get(ctx, s.InstanceName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if plan != "" {
+			err = unstructured.SetNestedField(serviceInstance.Object, plan, "spec", "clusterServicePlanExternalName")
+			if err != nil {
+				return err
+			}
+		}
+		if parameters != nil {
+			err = unstructured.SetNestedField(serviceInstance.Object, parameters, "spec", "parameters")
+			if err != nil {
+				return err
+			}
+		}
+
+		updated, err := namespace.Update(ctx, serviceInstance, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		generation = updated.GetGeneration()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if plan != "" {
+		s.Plan = plan
+	}
+	return generation, nil
+}
+
+// WaitForUpdate waits until the service catalog has completed the update of
+// the instance to the generation. An update failed at the broker is an
+// error carrying the message of the catalog.
+func (s *CatalogService) WaitForUpdate(ctx context.Context, generation int64) error {
+	client, err := s.cluster.ClientServiceCatalog("serviceinstances")
+	if err != nil {
+		return err
+	}
+
+	namespace := client.Namespace(s.OrgName)
+
+	return wait.PollImmediate(time.Second, duration.ToServiceProvision(), func() (bool, error) {
+		serviceInstance, err := namespace.Get(ctx, s.InstanceName, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, errors.New("Not Found")
+			}
+			return false, err
+		}
+
+		observed, _, _ := unstructured.NestedInt64(serviceInstance.Object, "status", "observedGeneration")
+		if observed < generation {
+			return false, nil
+		}
+		operation, _, _ := unstructured.NestedString(serviceInstance.Object, "status", "currentOperation")
+		if operation != "" {
+			return false, nil
+		}
+
+		conditions, _, _ := unstructured.NestedSlice(serviceInstance.Object, "status", "conditions")
+		for _, c := range conditions {
+			condition, ok := c.(map[string]interface{})
+			if !ok || condition["type"] != "Ready" {
+				continue
+			}
+			if condition["status"] == "True" {
+				return true, nil
+			}
+			return false, fmt.Errorf("service update failed: %v", condition["message"])
+		}
+
+		return false, nil
+	})
+}
+
 func (s *CatalogService) Details(_ context.Context) (map[string]string, error) {
 	details := map[string]string{}
 
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/duration"
+	"github.com/epinio/epinio/internal/interfaces"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// chartRoleName is the cluster role granting the rights on the resources
+// of chart releases, and the name of its bindings in the org namespaces
+const chartRoleName = "epinio-chart-services"
+
+// ChartService is a Service installed as release of a Helm chart registered
+// with Epinio, in the org namespace. The service is recorded in a secret of
+// the org, named like the secret of a custom service. Implements the Service
+// interface.
+type ChartService struct {
+	SecretName string
+	OrgName    string
+	Service    string
+	Class      string
+	Plan       string
+	Release    string
+	Created    time.Time
+	cluster    *kubernetes.Cluster
+}
+
+var _ interfaces.Service = &ChartService{}
+
+// chartReleaseName returns the name of the release of a service. The
+// service name is unique in the org namespace, and so is the release.
+func chartReleaseName(service string) string {
+	return fmt.Sprintf("epinio-%s", service)
+}
+
+// ChartServiceList returns a ServiceList of all chart services of the org
+func ChartServiceList(ctx context.Context, cluster *kubernetes.Cluster, org string) (interfaces.ServiceList, error) {
+	labelSelector := fmt.Sprintf("epinio.suse.org/service-type=chart, epinio.suse.org/organization=%s", org)
+
+	secrets, err := cluster.Kubectl.CoreV1().Secrets(org).List(ctx,
+		metav1.ListOptions{
+			LabelSelector: labelSelector,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	result := interfaces.ServiceList{}
+	for _, secret := range secrets.Items {
+		result = append(result, chartServiceFromSecret(cluster, secret))
+	}
+
+	return result, nil
+}
+
+// ChartServiceLookup finds a chart service by looking for its secret
+func ChartServiceLookup(ctx context.Context, cluster *kubernetes.Cluster, org, service string) (interfaces.Service, error) {
+	secret, err := cluster.GetSecret(ctx, org, serviceResourceName(org, service))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if secret.Labels["epinio.suse.org/service-type"] != "chart" {
+		return nil, nil
+	}
+
+	return chartServiceFromSecret(cluster, *secret), nil
+}
+
+// CreateChartService installs the chart of the class as release in the org
+// namespace, with the values of the plan. The parameters are values
+// overriding those of the plan. The release may not be ready on
+// return, see WaitForProvision.
+func CreateChartService(ctx context.Context, cluster *kubernetes.Cluster, name, org string,
+	class *ServiceClass, plan *ServicePlan, parameters map[string]interface{}) (interfaces.Service, error) {
+
+	if class.chart == nil {
+		return nil, fmt.Errorf("service class '%s' is not a chart", class.Name)
+	}
+	chartPlan := class.chart.plan(plan.Name)
+	if chartPlan == nil {
+		return nil, fmt.Errorf("chart '%s' has no plan '%s'", class.Name, plan.Name)
+	}
+
+	s := &ChartService{
+		SecretName: serviceResourceName(org, name),
+		OrgName:    org,
+		Service:    name,
+		Class:      class.Name,
+		Plan:       plan.Name,
+		Release:    chartReleaseName(name),
+		cluster:    cluster,
+	}
+
+	// The service is recorded before installing, so that a release is
+	// never left behind unknown to Epinio
+	err := cluster.CreateSecret(ctx, org, corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: s.SecretName,
+			Labels: map[string]string{
+				"epinio.suse.org/service-type": "chart",
+				"epinio.suse.org/service":      name,
+				"epinio.suse.org/organization": org,
+				"epinio.suse.org/chart":        class.Name,
+				"app.kubernetes.io/name":       "epinio",
+			},
+		},
+		StringData: map[string]string{
+			"class":   s.Class,
+			"plan":    s.Plan,
+			"release": s.Release,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ensureChartRoleBinding(ctx, cluster, org)
+	if err != nil {
+		_ = cluster.DeleteSecret(ctx, org, s.SecretName)
+		return nil, err
+	}
+
+	err = helmInstall(ctx, class.chart, s.Release, org, chartPlan.ValuesWith(parameters))
+	if err != nil {
+		_ = helmUninstall(ctx, s.Release, org)
+		_ = cluster.DeleteSecret(ctx, org, s.SecretName)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// ensureChartRoleBinding lets the server manage the resources of chart
+// releases in the org namespace. The server has no such rights cluster
+// wide, the binding goes away with the namespace.
+func ensureChartRoleBinding(ctx context.Context, cluster *kubernetes.Cluster, org string) error {
+	_, err := cluster.Kubectl.RbacV1().RoleBindings(org).Create(ctx, &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: chartRoleName,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "epinio",
+				"app.kubernetes.io/managed-by": "epinio",
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     chartRoleName,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      "epinio-server",
+				Namespace: deployments.EpinioDeploymentID,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+func chartServiceFromSecret(cluster *kubernetes.Cluster, secret corev1.Secret) *ChartService {
+	return &ChartService{
+		SecretName: secret.Name,
+		OrgName:    secret.Labels["epinio.suse.org/organization"],
+		Service:    secret.Labels["epinio.suse.org/service"],
+		Class:      string(secret.Data["class"]),
+		Plan:       string(secret.Data["plan"]),
+		Release:    string(secret.Data["release"]),
+		Created:    secret.CreationTimestamp.Time,
+		cluster:    cluster,
+	}
+}
+
+func (s *ChartService) Name() string {
+	return s.Service
+}
+
+func (s *ChartService) Org() string {
+	return s.OrgName
+}
+
+// chart returns the registered chart of the service
+func (s *ChartService) chart(ctx context.Context) (*Chart, error) {
+	chart, err := LookupChart(ctx, s.cluster, s.Class)
+	if err != nil {
+		return nil, err
+	}
+	if chart == nil {
+		return nil, fmt.Errorf("service chart '%s' is not registered", s.Class)
+	}
+	return chart, nil
+}
+
+// ready returns whether all pods of the release are ready. Pods which ran
+// to completion, e.g. of the hooks of the chart, are ignored. A release
+// without other pods is not ready.
+func (s *ChartService) ready(ctx context.Context) (bool, error) {
+	pods, err := s.cluster.ListPods(ctx, s.OrgName,
+		fmt.Sprintf("app.kubernetes.io/instance=%s", s.Release))
+	if err != nil {
+		return false, err
+	}
+
+	running := 0
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded {
+			continue
+		}
+		running++
+
+		ready := false
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				ready = true
+			}
+		}
+		if !ready {
+			return false, nil
+		}
+	}
+
+	return running > 0, nil
+}
+
+// GetBinding returns the secret holding the data of the application's
+// binding, made from the release's secret as declared by the chart
+func (s *ChartService) GetBinding(ctx context.Context, appName string) (*corev1.Secret, error) {
+	bindingName := bindingResourceName(s.OrgName, s.Service, appName)
+
+	secret, err := s.cluster.GetSecret(ctx, s.OrgName, bindingName)
+	if err == nil {
+		return secret, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	ready, err := s.ready(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !ready {
+		return nil, errors.New("service is not provisioned")
+	}
+
+	chart, err := s.chart(ctx)
+	if err != nil {
+		return nil, err
+	}
+	releaseSecret, err := s.cluster.GetSecret(ctx, s.OrgName, chart.Binding.SecretName(s.Release, s.OrgName))
+	if err != nil {
+		return nil, err
+	}
+	data, err := chart.Binding.BindingData(s.Release, s.OrgName, releaseSecret.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: bindingName,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       appName,
+				"app.kubernetes.io/part-of":    s.OrgName,
+				"app.kubernetes.io/component":  "servicebindingsecret",
+				"app.kubernetes.io/managed-by": "epinio",
+			},
+		},
+		Data: data,
+	}
+
+	return s.cluster.Kubectl.CoreV1().Secrets(s.OrgName).Create(ctx, secret, metav1.CreateOptions{})
+}
+
+// DeleteBinding removes the secret holding the data of the application's
+// binding
+func (s *ChartService) DeleteBinding(ctx context.Context, appName, org string) error {
+	err := s.cluster.DeleteSecret(ctx, org, bindingResourceName(s.OrgName, s.Service, appName))
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Delete uninstalls the release and removes the record of the service
+func (s *ChartService) Delete(ctx context.Context) error {
+	err := helmUninstall(ctx, s.Release, s.OrgName)
+	if err != nil {
+		return err
+	}
+
+	return s.cluster.DeleteSecret(ctx, s.OrgName, s.SecretName)
+}
+
+func (s *ChartService) Status(ctx context.Context) (string, error) {
+	ready, err := s.ready(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ready {
+		return "Provisioned", nil
+	}
+	return "Provisioning", nil
+}
+
+func (s *ChartService) WaitForProvision(ctx context.Context) error {
+	return wait.PollImmediate(time.Second, duration.ToServiceProvision(), func() (bool, error) {
+		return s.ready(ctx)
+	})
+}
+
+func (s *ChartService) Details(_ context.Context) (map[string]string, error) {
+	details := map[string]string{}
+
+	details["Class"] = s.Class
+	details["Plan"] = s.Plan
+	details["Release"] = s.Release
+
+	return details, nil
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/epinio/epinio/deployments"
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/helpers/tracelog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ChartClassBroker is shown as the broker of the service classes backed by
+// Helm charts
+const ChartClassBroker = "helm"
+
+// Chart is a Helm chart registered with Epinio as service class. Services of
+// the class are releases of the chart in the org namespace.
+type Chart struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Chart is the chart reference given to `helm install`, e.g.
+	// `postgresql` with Repo set, or the URL of a chart archive
+	Chart   string       `json:"chart"`
+	Repo    string       `json:"repo,omitempty"`
+	Version string       `json:"version,omitempty"`
+	Plans   []ChartPlan  `json:"plans"`
+	Binding ChartBinding `json:"binding"`
+}
+
+// ChartPlan is a plan of a chart class, overriding values of the chart
+type ChartPlan struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Values      map[string]interface{} `json:"values,omitempty"`
+}
+
+// ChartBinding declares how the data of a binding is made from a release of
+// the chart. In all strings `{{release}}` and `{{namespace}}` are replaced
+// by the name and namespace of the release.
+type ChartBinding struct {
+	// Secret is the name of the secret of the release holding the
+	// credentials
+	Secret string `json:"secret"`
+	// Keys maps binding keys to keys of the secret. Without keys the
+	// whole secret is used.
+	Keys map[string]string `json:"keys,omitempty"`
+	// Values are added to the binding as is, e.g. the host name of the
+	// release's service
+	Values map[string]string `json:"values,omitempty"`
+}
+
+// ErrChartExists is returned by AddChart for a name already in use
+var ErrChartExists = errors.New("service chart exists")
+
+// ErrChartInUse is returned by DeleteChart for a chart with services
+var ErrChartInUse = errors.New("service chart has services")
+
+// Validate checks that the chart is complete
+func (c *Chart) Validate() error {
+	if errs := validation.IsDNS1123Label(c.Name); len(errs) > 0 {
+		return fmt.Errorf("bad chart name '%s': %s", c.Name, strings.Join(errs, ", "))
+	}
+	if c.Chart == "" {
+		return errors.New("chart reference missing")
+	}
+	if len(c.Plans) == 0 {
+		return errors.New("chart without plans")
+	}
+	seen := map[string]bool{}
+	for _, plan := range c.Plans {
+		if plan.Name == "" {
+			return errors.New("chart plan without name")
+		}
+		if seen[plan.Name] {
+			return fmt.Errorf("duplicate chart plan '%s'", plan.Name)
+		}
+		seen[plan.Name] = true
+	}
+	if c.Binding.Secret == "" {
+		return errors.New("chart binding secret missing")
+	}
+	return nil
+}
+
+// plan returns the named plan, or nil
+func (c *Chart) plan(name string) *ChartPlan {
+	for i := range c.Plans {
+		if c.Plans[i].Name == name {
+			return &c.Plans[i]
+		}
+	}
+	return nil
+}
+
+// ValuesWith returns the values for a release of the plan. The parameters of
+// the service override the values of the plan, key by key.
+func (p *ChartPlan) ValuesWith(parameters map[string]interface{}) map[string]interface{} {
+	values := map[string]interface{}{}
+	for key, value := range p.Values {
+		values[key] = value
+	}
+	for key, value := range parameters {
+		values[key] = value
+	}
+	return values
+}
+
+// BindingData returns the data of a binding to the release, from the data
+// of the release's secret
+func (b *ChartBinding) BindingData(release, namespace string, secret map[string][]byte) (map[string][]byte, error) {
+	data := map[string][]byte{}
+	if len(b.Keys) == 0 {
+		for key, value := range secret {
+			data[key] = value
+		}
+	}
+	for key, secretKey := range b.Keys {
+		value, ok := secret[secretKey]
+		if !ok {
+			return nil, fmt.Errorf("secret of release has no key '%s'", secretKey)
+		}
+		data[key] = value
+	}
+	for key, value := range b.Values {
+		data[key] = []byte(expandRelease(value, release, namespace))
+	}
+	return data, nil
+}
+
+// SecretName returns the name of the release's secret holding the
+// credentials
+func (b *ChartBinding) SecretName(release, namespace string) string {
+	return expandRelease(b.Secret, release, namespace)
+}
+
+func expandRelease(s, release, namespace string) string {
+	return strings.NewReplacer("{{release}}", release, "{{namespace}}", namespace).Replace(s)
+}
+
+func chartResourceName(name string) string {
+	return fmt.Sprintf("epinio-chart-%s", name)
+}
+
+// AddChart registers the chart as service class. The registration is kept
+// in a config map of the epinio namespace.
+func AddChart(ctx context.Context, cluster *kubernetes.Cluster, chart Chart) error {
+	err := chart.Validate()
+	if err != nil {
+		return err
+	}
+
+	js, err := json.Marshal(chart)
+	if err != nil {
+		return err
+	}
+
+	_, err = cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Create(ctx,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: chartResourceName(chart.Name),
+				Labels: map[string]string{
+					"epinio.suse.org/chart":        chart.Name,
+					"app.kubernetes.io/name":       "epinio",
+					"app.kubernetes.io/component":  "servicechart",
+					"app.kubernetes.io/managed-by": "epinio",
+				},
+			},
+			Data: map[string]string{
+				"chart": string(js),
+			},
+		}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return ErrChartExists
+	}
+	return err
+}
+
+// ListCharts returns the registered charts, sorted by name
+func ListCharts(ctx context.Context, cluster *kubernetes.Cluster) ([]Chart, error) {
+	configMaps, err := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).List(ctx,
+		metav1.ListOptions{
+			LabelSelector: "app.kubernetes.io/component=servicechart, epinio.suse.org/chart",
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Chart{}
+	for _, configMap := range configMaps.Items {
+		chart, err := chartFromConfigMap(configMap)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *chart)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result, nil
+}
+
+// LookupChart returns the named chart, or nil if it is not registered
+func LookupChart(ctx context.Context, cluster *kubernetes.Cluster, name string) (*Chart, error) {
+	configMap, err := cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Get(ctx,
+		chartResourceName(name), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return chartFromConfigMap(*configMap)
+}
+
+// DeleteChart removes the registration of the chart. Charts with services
+// can not be removed, the error names the services.
+func DeleteChart(ctx context.Context, cluster *kubernetes.Cluster, name string) error {
+	instances, err := cluster.Kubectl.CoreV1().Secrets("").List(ctx,
+		metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("epinio.suse.org/service-type=chart, epinio.suse.org/chart=%s", name),
+		})
+	if err != nil {
+		return err
+	}
+	if len(instances.Items) > 0 {
+		names := []string{}
+		for _, instance := range instances.Items {
+			names = append(names, instance.Namespace+"/"+instance.Labels["epinio.suse.org/service"])
+		}
+		return fmt.Errorf("%w: %s", ErrChartInUse, strings.Join(names, ", "))
+	}
+
+	return cluster.Kubectl.CoreV1().ConfigMaps(deployments.EpinioDeploymentID).Delete(ctx,
+		chartResourceName(name), metav1.DeleteOptions{})
+}
+
+func chartFromConfigMap(configMap corev1.ConfigMap) (*Chart, error) {
+	chart := &Chart{}
+	err := json.Unmarshal([]byte(configMap.Data["chart"]), chart)
+	if err != nil {
+		return nil, fmt.Errorf("bad registration of chart '%s': %w", configMap.Name, err)
+	}
+	return chart, nil
+}
+
+// chartClasses returns the registered charts as service classes
+func chartClasses(ctx context.Context, cluster *kubernetes.Cluster) (ServiceClassList, error) {
+	charts, err := ListCharts(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	result := ServiceClassList{}
+	for i := range charts {
+		chart := &charts[i]
+		result = append(result, ServiceClass{
+			Name:        chart.Name,
+			Broker:      ChartClassBroker,
+			Description: chart.Description,
+			Hash:        chart.Name,
+			cluster:     cluster,
+			chart:       chart,
+		})
+	}
+
+	return result, nil
+}
+
+// chartPlans returns the plans of the chart of the class
+func (sc *ServiceClass) chartPlans() ServicePlanList {
+	result := ServicePlanList{}
+	for _, plan := range sc.chart.Plans {
+		result = append(result, ServicePlan{
+			Name:        plan.Name,
+			Description: plan.Description,
+			Free:        true,
+		})
+	}
+	return result
+}
+
+// helm runs the helm command with the arguments, returning its output. No
+// shell is involved, the arguments are passed as is.
+func helm(ctx context.Context, args ...string) (string, error) {
+	tracelog.Logger(ctx).V(1).Info("executing helm", "args", args)
+
+	out, err := exec.CommandContext(ctx, "helm", args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("helm %s failed: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// helmInstall installs the chart as the named release, with the values
+func helmInstall(ctx context.Context, chart *Chart, release, namespace string, values map[string]interface{}) error {
+	js, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	// Helm reads JSON as it is a subset of YAML
+	valuesFile, err := ioutil.TempFile("", "epinio-values-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(valuesFile.Name())
+
+	_, err = valuesFile.Write(js)
+	if err != nil {
+		valuesFile.Close()
+		return err
+	}
+	err = valuesFile.Close()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"install", release, chart.Chart, "--namespace", namespace, "--values", valuesFile.Name()}
+	if chart.Repo != "" {
+		args = append(args, "--repo", chart.Repo)
+	}
+	if chart.Version != "" {
+		args = append(args, "--version", chart.Version)
+	}
+
+	_, err = helm(ctx, args...)
+	return err
+}
+
+// helmUninstall removes the named release. A missing release is not an
+// error.
+func helmUninstall(ctx context.Context, release, namespace string) error {
+	out, err := helm(ctx, "uninstall", release, "--namespace", namespace)
+	if err != nil && strings.Contains(out, "not found") {
+		return nil
+	}
+	return err
+}
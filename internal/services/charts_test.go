@@ -0,0 +1,67 @@
+package services_test
+
+import (
+	. "github.com/epinio/epinio/internal/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Charts", func() {
+	var chart Chart
+
+	BeforeEach(func() {
+		chart = Chart{
+			Name:  "postgres",
+			Chart: "postgresql",
+			Repo:  "https://charts.bitnami.com/bitnami",
+			Plans: []ChartPlan{{
+				Name:   "small",
+				Values: map[string]interface{}{"persistence": map[string]interface{}{"size": "1Gi"}, "replicas": 1},
+			}},
+			Binding: ChartBinding{
+				Secret: "{{release}}-postgresql",
+				Keys:   map[string]string{"password": "postgresql-password"},
+				Values: map[string]string{"host": "{{release}}-postgresql.{{namespace}}.svc.cluster.local"},
+			},
+		}
+	})
+
+	It("validates chart definitions", func() {
+		Expect(chart.Validate()).To(Succeed())
+
+		chart.Plans = append(chart.Plans, ChartPlan{Name: "small"})
+		Expect(chart.Validate()).To(MatchError("duplicate chart plan 'small'"))
+
+		chart.Plans = nil
+		Expect(chart.Validate()).To(MatchError("chart without plans"))
+
+		chart.Name = "Postgres"
+		Expect(chart.Validate()).To(MatchError(ContainSubstring("bad chart name 'Postgres'")))
+	})
+
+	It("overrides the values of the plan with the parameters", func() {
+		values := chart.Plans[0].ValuesWith(map[string]interface{}{"replicas": 3})
+		Expect(values).To(Equal(map[string]interface{}{
+			"persistence": map[string]interface{}{"size": "1Gi"},
+			"replicas":    3,
+		}))
+		Expect(chart.Plans[0].Values["replicas"]).To(Equal(1))
+	})
+
+	It("makes the binding data from the secret of the release", func() {
+		Expect(chart.Binding.SecretName("epinio-db", "workspace")).To(Equal("epinio-db-postgresql"))
+
+		data, err := chart.Binding.BindingData("epinio-db", "workspace", map[string][]byte{
+			"postgresql-password": []byte("s3cret"),
+			"other":               []byte("unused"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal(map[string][]byte{
+			"password": []byte("s3cret"),
+			"host":     []byte("epinio-db-postgresql.workspace.svc.cluster.local"),
+		}))
+
+		_, err = chart.Binding.BindingData("epinio-db", "workspace", map[string][]byte{})
+		Expect(err).To(MatchError("secret of release has no key 'postgresql-password'"))
+	})
+})
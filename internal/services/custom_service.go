@@ -7,12 +7,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/epinio/epinio/helpers/kubernetes"
 	"github.com/epinio/epinio/internal/interfaces"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
 )
 
 // CustomService is a user defined service.
@@ -21,14 +23,19 @@ type CustomService struct {
 	SecretName string
 	OrgName    string
 	Service    string
+	Created    time.Time
 	kubeClient *kubernetes.Cluster
 }
 
 var _ interfaces.Service = &CustomService{}
 
+// ErrNoData is returned by Update when it would remove all data of the
+// service
+var ErrNoData = errors.New("custom service without data")
+
 // CustomServiceList returns a ServiceList of all available custom Services
 func CustomServiceList(ctx context.Context, kubeClient *kubernetes.Cluster, org string) (interfaces.ServiceList, error) {
-	labelSelector := fmt.Sprintf("app.kubernetes.io/name=epinio, epinio.suse.org/organization=%s", org)
+	labelSelector := fmt.Sprintf("app.kubernetes.io/name=epinio, epinio.suse.org/service-type=custom, epinio.suse.org/organization=%s", org)
 
 	secrets, err := kubeClient.Kubectl.CoreV1().
 		Secrets(org).List(ctx,
@@ -51,6 +58,7 @@ func CustomServiceList(ctx context.Context, kubeClient *kubernetes.Cluster, org
 			SecretName: secretName,
 			OrgName:    org,
 			Service:    service,
+			Created:    s.CreationTimestamp.Time,
 			kubeClient: kubeClient,
 		})
 	}
@@ -62,7 +70,7 @@ func CustomServiceList(ctx context.Context, kubeClient *kubernetes.Cluster, org
 func CustomServiceLookup(ctx context.Context, kubeClient *kubernetes.Cluster, org, service string) (interfaces.Service, error) {
 	secretName := serviceResourceName(org, service)
 
-	_, err := kubeClient.GetSecret(ctx, org, secretName)
+	secret, err := kubeClient.GetSecret(ctx, org, secretName)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			return nil, nil
@@ -70,11 +78,15 @@ func CustomServiceLookup(ctx context.Context, kubeClient *kubernetes.Cluster, or
 			return nil, err
 		}
 	}
+	if serviceType, ok := secret.Labels["epinio.suse.org/service-type"]; ok && serviceType != "custom" {
+		return nil, nil
+	}
 
 	return &CustomService{
 		SecretName: secretName,
 		OrgName:    org,
 		Service:    service,
+		Created:    secret.CreationTimestamp.Time,
 		kubeClient: kubeClient,
 	}, nil
 }
@@ -120,6 +132,38 @@ func CreateCustomService(ctx context.Context, kubeClient *kubernetes.Cluster, na
 	}, nil
 }
 
+// Update changes the binding data of the custom service. The keys of set
+// are added or replaced, the keys of unset are removed. Removing all data
+// is an error, like creating a service without data.
+func (s *CustomService) Update(ctx context.Context, set map[string]string, unset []string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		serviceSecret, err := s.kubeClient.GetSecret(ctx, s.OrgName, s.SecretName)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return errors.New("service does not exist")
+			}
+			return err
+		}
+
+		if serviceSecret.Data == nil {
+			serviceSecret.Data = map[string][]byte{}
+		}
+		for _, k := range unset {
+			delete(serviceSecret.Data, k)
+		}
+		for k, v := range set {
+			serviceSecret.Data[k] = []byte(v)
+		}
+		if len(serviceSecret.Data) < 1 {
+			return ErrNoData
+		}
+
+		_, err = s.kubeClient.Kubectl.CoreV1().Secrets(s.OrgName).Update(
+			ctx, serviceSecret, metav1.UpdateOptions{})
+		return err
+	})
+}
+
 func (s *CustomService) Name() string {
 	return s.Service
 }
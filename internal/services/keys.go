@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/interfaces"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ErrKeyExists is returned by CreateKey for a key name already in use by
+// the service
+var ErrKeyExists = errors.New("service key already exists")
+
+// ServiceKey is a named binding of a service which is not attached to an
+// application. It provides credentials to consumers outside of Epinio. The
+// key is recorded in a secret of the org, holding a copy of the binding
+// data.
+type ServiceKey struct {
+	Name    string
+	Service string
+	Org     string
+	Data    map[string]string
+}
+
+// keyResourceName returns the name of the secret recording the key
+func keyResourceName(org, service, key string) string {
+	return fmt.Sprintf("service.org-%s.svc-%s.key-%s", org, service, key)
+}
+
+// keyConsumer returns the name the key is bound under, in place of an
+// application name. Application names can not contain dots, so it does not
+// clash with the bindings of applications.
+func keyConsumer(key string) string {
+	return fmt.Sprintf("key.%s", key)
+}
+
+// ValidateKeyName checks that the name is usable for a service key
+func ValidateKeyName(key string) error {
+	if errs := validation.IsDNS1123Label(key); len(errs) > 0 {
+		return fmt.Errorf("bad service key name '%s': %s", key, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// CreateKey makes a new binding of the service for the named key, and
+// records its data
+func CreateKey(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service, key string) (*ServiceKey, error) {
+	err := ValidateKeyName(key)
+	if err != nil {
+		return nil, err
+	}
+
+	secretName := keyResourceName(service.Org(), service.Name(), key)
+	_, err = cluster.GetSecret(ctx, service.Org(), secretName)
+	if err == nil {
+		return nil, ErrKeyExists
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	binding, err := service.GetBinding(ctx, keyConsumer(key))
+	if err != nil {
+		return nil, err
+	}
+
+	err = cluster.CreateSecret(ctx, service.Org(), corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName,
+			Labels: map[string]string{
+				"epinio.suse.org/service":      service.Name(),
+				"epinio.suse.org/service-key":  key,
+				"epinio.suse.org/organization": service.Org(),
+				"app.kubernetes.io/component":  "servicekey",
+				"app.kubernetes.io/managed-by": "epinio",
+			},
+		},
+		Data: binding.Data,
+	})
+	if err != nil {
+		_ = service.DeleteBinding(ctx, keyConsumer(key), service.Org())
+		return nil, err
+	}
+
+	return keyFromSecret(service, key, binding.Data), nil
+}
+
+// ListKeys returns the sorted names of the keys of the service
+func ListKeys(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service) ([]string, error) {
+	labelSelector := fmt.Sprintf("app.kubernetes.io/component=servicekey, epinio.suse.org/service=%s, epinio.suse.org/organization=%s",
+		service.Name(), service.Org())
+
+	secrets, err := cluster.Kubectl.CoreV1().Secrets(service.Org()).List(ctx,
+		metav1.ListOptions{
+			LabelSelector: labelSelector,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, secret := range secrets.Items {
+		keys = append(keys, secret.Labels["epinio.suse.org/service-key"])
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// LookupKey returns the named key of the service, or nil if there is none
+func LookupKey(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service, key string) (*ServiceKey, error) {
+	secret, err := cluster.GetSecret(ctx, service.Org(), keyResourceName(service.Org(), service.Name(), key))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return keyFromSecret(service, key, secret.Data), nil
+}
+
+// DeleteKey removes the binding of the named key, and its record. The
+// credentials of the key are revoked, where the service issues credentials
+// per binding.
+func DeleteKey(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service, key string) error {
+	err := service.DeleteBinding(ctx, keyConsumer(key), service.Org())
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	return cluster.DeleteSecret(ctx, service.Org(), keyResourceName(service.Org(), service.Name(), key))
+}
+
+func keyFromSecret(service interfaces.Service, key string, data map[string][]byte) *ServiceKey {
+	serviceKey := &ServiceKey{
+		Name:    key,
+		Service: service.Name(),
+		Org:     service.Org(),
+		Data:    map[string]string{},
+	}
+	for k, v := range data {
+		serviceKey.Data[k] = string(v)
+	}
+	return serviceKey
+}
@@ -0,0 +1,380 @@
+// Package osb is a client of the Open Service Broker API, version 2. See
+// https://github.com/openservicebrokerapi/servicebroker/blob/v2.16/spec.md
+package osb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// APIVersion is the version of the broker API spoken by the client
+const APIVersion = "2.16"
+
+// The states of an asynchronous operation
+const (
+	StateInProgress = "in progress"
+	StateSucceeded  = "succeeded"
+	StateFailed     = "failed"
+)
+
+// ErrGone is returned when the broker reports that the instance or binding
+// does not exist (anymore)
+var ErrGone = errors.New("gone")
+
+// Client talks to a single service broker
+type Client struct {
+	URL      string
+	User     string
+	Password string
+	HTTP     *http.Client
+	// PollInterval is the time between two requests for the state of an
+	// asynchronous operation
+	PollInterval time.Duration
+}
+
+// NewClient returns a client for the broker at the URL, authenticating with
+// basic auth
+func NewClient(url, user, password string) *Client {
+	return &Client{
+		URL:          strings.TrimSuffix(url, "/"),
+		User:         user,
+		Password:     password,
+		HTTP:         &http.Client{Timeout: 60 * time.Second},
+		PollInterval: 2 * time.Second,
+	}
+}
+
+// Error is an error response of the broker
+type Error struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"description"`
+}
+
+func (e *Error) Error() string {
+	message := http.StatusText(e.StatusCode)
+	if e.Code != "" {
+		message += ": " + e.Code
+	}
+	if e.Description != "" {
+		message += ": " + e.Description
+	}
+	return "service broker: " + message
+}
+
+// Catalog lists the services offered by a broker
+type Catalog struct {
+	Services []Service `json:"services"`
+}
+
+// Service is a service offering of a broker
+type Service struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Bindable    bool   `json:"bindable"`
+	Plans       []Plan `json:"plans"`
+}
+
+// Plan is a plan of a service offering
+type Plan struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Free        *bool                  `json:"free,omitempty"`
+	Schemas     map[string]interface{} `json:"schemas,omitempty"`
+}
+
+// IsFree returns whether the plan is free. Plans are free unless the broker
+// says otherwise.
+func (p Plan) IsFree() bool {
+	return p.Free == nil || *p.Free
+}
+
+// CreateParameterSchema returns the JSON schema of the parameters for
+// provisioning an instance of the plan, or nil
+func (p Plan) CreateParameterSchema() map[string]interface{} {
+	instance, _ := p.Schemas["service_instance"].(map[string]interface{})
+	create, _ := instance["create"].(map[string]interface{})
+	schema, _ := create["parameters"].(map[string]interface{})
+	return schema
+}
+
+// PlatformContext is the platform specific context sent with provision
+// and bind requests
+type PlatformContext struct {
+	Platform  string `json:"platform"`
+	Namespace string `json:"namespace,omitempty"`
+	Instance  string `json:"instance_name,omitempty"`
+}
+
+// ProvisionRequest is the body of a provision request
+type ProvisionRequest struct {
+	ServiceID  string                 `json:"service_id"`
+	PlanID     string                 `json:"plan_id"`
+	Context    *PlatformContext       `json:"context,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	// Deprecated in favour of the context, but still required by
+	// brokers for older platforms
+	OrganizationGUID string `json:"organization_guid"`
+	SpaceGUID        string `json:"space_guid"`
+}
+
+// BindRequest is the body of a bind request
+type BindRequest struct {
+	ServiceID  string                 `json:"service_id"`
+	PlanID     string                 `json:"plan_id"`
+	Context    *PlatformContext       `json:"context,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Operation is the result of a request which the broker may complete
+// asynchronously. Async is false when the request is complete.
+type Operation struct {
+	Async     bool   `json:"-"`
+	Operation string `json:"operation,omitempty"`
+}
+
+// LastOperation is the state of an asynchronous operation
+type LastOperation struct {
+	State       string `json:"state"`
+	Description string `json:"description,omitempty"`
+}
+
+// Binding is a binding of a service instance
+type Binding struct {
+	Credentials map[string]interface{} `json:"credentials"`
+}
+
+// NewGUID returns a random version 4 UUID, for use as instance or binding
+// ID
+func NewGUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// Catalog fetches the catalog of the broker
+func (c *Client) Catalog(ctx context.Context) (*Catalog, error) {
+	catalog := &Catalog{}
+	_, err := c.do(ctx, http.MethodGet, "/v2/catalog", nil, nil, catalog, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// Provision creates a service instance
+func (c *Client) Provision(ctx context.Context, instanceID string, req ProvisionRequest) (*Operation, error) {
+	op := &Operation{}
+	status, err := c.do(ctx, http.MethodPut, instancePath(instanceID), acceptsIncomplete(), req, op,
+		http.StatusOK, http.StatusCreated, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	op.Async = status == http.StatusAccepted
+	return op, nil
+}
+
+// Deprovision removes a service instance. An instance the broker does not
+// know is not an error.
+func (c *Client) Deprovision(ctx context.Context, instanceID, serviceID, planID string) (*Operation, error) {
+	query := acceptsIncomplete()
+	query.Set("service_id", serviceID)
+	query.Set("plan_id", planID)
+
+	op := &Operation{}
+	status, err := c.do(ctx, http.MethodDelete, instancePath(instanceID), query, nil, op,
+		http.StatusOK, http.StatusAccepted, http.StatusGone)
+	if err != nil {
+		return nil, err
+	}
+	op.Async = status == http.StatusAccepted
+	return op, nil
+}
+
+// LastOperation returns the state of the last asynchronous operation on the
+// instance. It returns ErrGone when an instance being deprovisioned is gone.
+func (c *Client) LastOperation(ctx context.Context, instanceID, serviceID, planID, operation string) (*LastOperation, error) {
+	return c.lastOperation(ctx, instancePath(instanceID), serviceID, planID, operation)
+}
+
+// Bind creates a binding of the service instance
+func (c *Client) Bind(ctx context.Context, instanceID, bindingID string, req BindRequest) (*Binding, *Operation, error) {
+	response := &struct {
+		Binding
+		Operation
+	}{}
+	status, err := c.do(ctx, http.MethodPut, bindingPath(instanceID, bindingID), acceptsIncomplete(), req, response,
+		http.StatusOK, http.StatusCreated, http.StatusAccepted)
+	if err != nil {
+		return nil, nil, err
+	}
+	response.Operation.Async = status == http.StatusAccepted
+	return &response.Binding, &response.Operation, nil
+}
+
+// FetchBinding returns a binding, used after an asynchronous bind
+func (c *Client) FetchBinding(ctx context.Context, instanceID, bindingID string) (*Binding, error) {
+	binding := &Binding{}
+	_, err := c.do(ctx, http.MethodGet, bindingPath(instanceID, bindingID), nil, nil, binding, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return binding, nil
+}
+
+// BindingLastOperation returns the state of the last asynchronous
+// operation on the binding
+func (c *Client) BindingLastOperation(ctx context.Context, instanceID, bindingID, serviceID, planID, operation string) (*LastOperation, error) {
+	return c.lastOperation(ctx, bindingPath(instanceID, bindingID), serviceID, planID, operation)
+}
+
+// Unbind removes a binding. A binding the broker does not know is not an
+// error.
+func (c *Client) Unbind(ctx context.Context, instanceID, bindingID, serviceID, planID string) (*Operation, error) {
+	query := acceptsIncomplete()
+	query.Set("service_id", serviceID)
+	query.Set("plan_id", planID)
+
+	op := &Operation{}
+	status, err := c.do(ctx, http.MethodDelete, bindingPath(instanceID, bindingID), query, nil, op,
+		http.StatusOK, http.StatusAccepted, http.StatusGone)
+	if err != nil {
+		return nil, err
+	}
+	op.Async = status == http.StatusAccepted
+	return op, nil
+}
+
+// Wait polls the state of an asynchronous operation until it is done, or
+// the timeout expires. A failed operation is an error. ErrGone is passed
+// through, for the caller to decide.
+func (c *Client) Wait(ctx context.Context, timeout time.Duration, poll func(context.Context) (*LastOperation, error)) error {
+	var last *LastOperation
+	err := wait.PollImmediate(c.PollInterval, timeout, func() (bool, error) {
+		var err error
+		last, err = poll(ctx)
+		if err != nil {
+			return false, err
+		}
+		return last.State != StateInProgress, nil
+	})
+	if err != nil {
+		return err
+	}
+	if last.State == StateFailed {
+		return fmt.Errorf("service broker operation failed: %s", last.Description)
+	}
+	return nil
+}
+
+func (c *Client) lastOperation(ctx context.Context, path, serviceID, planID, operation string) (*LastOperation, error) {
+	query := url.Values{}
+	query.Set("service_id", serviceID)
+	query.Set("plan_id", planID)
+	if operation != "" {
+		query.Set("operation", operation)
+	}
+
+	last := &LastOperation{}
+	status, err := c.do(ctx, http.MethodGet, path+"/last_operation", query, nil, last,
+		http.StatusOK, http.StatusGone)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusGone {
+		return nil, ErrGone
+	}
+	return last, nil
+}
+
+// do sends the request and decodes the response into result. A status not
+// in expected is returned as Error. The status is returned.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, result interface{}, expected ...int) (int, error) {
+	uri := c.URL + path
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, uri, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Broker-API-Version", APIVersion)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to reach service broker")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read service broker response")
+	}
+
+	for _, status := range expected {
+		if resp.StatusCode != status {
+			continue
+		}
+		if result != nil && len(bytes.TrimSpace(respBody)) > 0 {
+			err = json.Unmarshal(respBody, result)
+			if err != nil {
+				return 0, errors.Wrap(err, "bad service broker response")
+			}
+		}
+		return resp.StatusCode, nil
+	}
+
+	brokerErr := &Error{StatusCode: resp.StatusCode}
+	_ = json.Unmarshal(respBody, brokerErr)
+	return resp.StatusCode, brokerErr
+}
+
+func acceptsIncomplete() url.Values {
+	query := url.Values{}
+	query.Set("accepts_incomplete", "true")
+	return query
+}
+
+func instancePath(instanceID string) string {
+	return "/v2/service_instances/" + url.PathEscape(instanceID)
+}
+
+func bindingPath(instanceID, bindingID string) string {
+	return instancePath(instanceID) + "/service_bindings/" + url.PathEscape(bindingID)
+}
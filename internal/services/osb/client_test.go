@@ -0,0 +1,247 @@
+package osb_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/epinio/epinio/internal/services/osb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeBroker is a service broker keeping its instances and bindings in
+// memory. With async set, provisioning and deprovisioning take one poll of
+// the last operation to complete.
+type fakeBroker struct {
+	mu        sync.Mutex
+	async     bool
+	fail      bool
+	instances map[string]string
+	pending   map[string]string
+	bindings  map[string]bool
+	requests  []*http.Request
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		instances: map[string]string{},
+		pending:   map[string]string{},
+		bindings:  map[string]bool{},
+	}
+}
+
+func (b *fakeBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests = append(b.requests, r)
+
+	reply := func(status int, body string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok || user != "admin" || password != "secret" {
+		reply(http.StatusUnauthorized, `{}`)
+		return
+	}
+	if r.Header.Get("X-Broker-API-Version") == "" {
+		reply(http.StatusPreconditionFailed, `{"description":"missing version"}`)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/v2/catalog":
+		reply(http.StatusOK, `{"services":[{"id":"svc-1","name":"postgres","description":"A database","bindable":true,
+			"plans":[{"id":"plan-1","name":"small","description":"Small","free":false},{"id":"plan-2","name":"tiny","description":"Tiny"}]}]}`)
+
+	case len(parts) == 4 && parts[3] == "last_operation":
+		id := parts[2]
+		operation, pending := b.pending[id]
+		if !pending {
+			if _, ok := b.instances[id]; !ok {
+				reply(http.StatusGone, `{}`)
+				return
+			}
+			reply(http.StatusOK, `{"state":"succeeded"}`)
+			return
+		}
+		if r.URL.Query().Get("operation") != operation {
+			reply(http.StatusBadRequest, `{"description":"unknown operation"}`)
+			return
+		}
+		delete(b.pending, id)
+		if b.fail {
+			reply(http.StatusOK, `{"state":"failed","description":"out of disks"}`)
+			return
+		}
+		if operation == "deprovision" {
+			delete(b.instances, id)
+		}
+		reply(http.StatusOK, `{"state":"in progress"}`)
+
+	case len(parts) == 3 && r.Method == http.MethodPut:
+		id := parts[2]
+		if _, ok := b.instances[id]; ok {
+			reply(http.StatusConflict, `{"error":"InstanceExists","description":"instance exists"}`)
+			return
+		}
+		var req ProvisionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			reply(http.StatusBadRequest, `{"description":"bad request"}`)
+			return
+		}
+		b.instances[id] = req.PlanID
+		if b.async {
+			b.pending[id] = "provision"
+			reply(http.StatusAccepted, `{"operation":"provision"}`)
+			return
+		}
+		reply(http.StatusCreated, `{}`)
+
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		id := parts[2]
+		if _, ok := b.instances[id]; !ok {
+			reply(http.StatusGone, `{}`)
+			return
+		}
+		if b.async {
+			b.pending[id] = "deprovision"
+			reply(http.StatusAccepted, `{"operation":"deprovision"}`)
+			return
+		}
+		delete(b.instances, id)
+		reply(http.StatusOK, `{}`)
+
+	case len(parts) == 5 && r.Method == http.MethodPut:
+		b.bindings[parts[4]] = true
+		reply(http.StatusCreated, `{"credentials":{"username":"user","port":5432}}`)
+
+	case len(parts) == 5 && r.Method == http.MethodDelete:
+		if !b.bindings[parts[4]] {
+			reply(http.StatusGone, `{}`)
+			return
+		}
+		delete(b.bindings, parts[4])
+		reply(http.StatusOK, `{}`)
+
+	default:
+		reply(http.StatusNotFound, `{}`)
+	}
+}
+
+var _ = Describe("Client", func() {
+	var (
+		broker *fakeBroker
+		server *httptest.Server
+		client *Client
+		ctx    context.Context
+	)
+
+	BeforeEach(func() {
+		broker = newFakeBroker()
+		server = httptest.NewServer(broker)
+		client = NewClient(server.URL+"/", "admin", "secret")
+		client.PollInterval = time.Millisecond
+		ctx = context.Background()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	provision := func(id string) *Operation {
+		op, err := client.Provision(ctx, id, ProvisionRequest{ServiceID: "svc-1", PlanID: "plan-1"})
+		Expect(err).ToNot(HaveOccurred())
+		return op
+	}
+
+	pollInstance := func(id, operation string) func(context.Context) (*LastOperation, error) {
+		return func(ctx context.Context) (*LastOperation, error) {
+			return client.LastOperation(ctx, id, "svc-1", "plan-1", operation)
+		}
+	}
+
+	It("fetches the catalog with version header and credentials", func() {
+		catalog, err := client.Catalog(ctx)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(catalog.Services).To(HaveLen(1))
+		Expect(catalog.Services[0].Name).To(Equal("postgres"))
+		Expect(catalog.Services[0].Plans[0].IsFree()).To(BeFalse())
+		Expect(catalog.Services[0].Plans[1].IsFree()).To(BeTrue())
+		Expect(broker.requests[0].Header.Get("X-Broker-API-Version")).To(Equal(APIVersion))
+	})
+
+	It("reports broker errors", func() {
+		client.Password = "wrong"
+		_, err := client.Catalog(ctx)
+		Expect(err).To(BeAssignableToTypeOf(&Error{}))
+		Expect(err.(*Error).StatusCode).To(Equal(http.StatusUnauthorized))
+
+		client.Password = "secret"
+		provision("inst-1")
+		_, err = client.Provision(ctx, "inst-1", ProvisionRequest{ServiceID: "svc-1", PlanID: "plan-1"})
+		Expect(err).To(MatchError("service broker: Conflict: InstanceExists: instance exists"))
+	})
+
+	It("provisions, binds, unbinds and deprovisions synchronously", func() {
+		op := provision("inst-1")
+		Expect(op.Async).To(BeFalse())
+		Expect(broker.requests[0].URL.Query().Get("accepts_incomplete")).To(Equal("true"))
+
+		binding, op, err := client.Bind(ctx, "inst-1", "bind-1", BindRequest{ServiceID: "svc-1", PlanID: "plan-1"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(op.Async).To(BeFalse())
+		Expect(binding.Credentials).To(Equal(map[string]interface{}{"username": "user", "port": float64(5432)}))
+
+		_, err = client.Unbind(ctx, "inst-1", "bind-1", "svc-1", "plan-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(broker.bindings).To(BeEmpty())
+
+		op, err = client.Deprovision(ctx, "inst-1", "svc-1", "plan-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(op.Async).To(BeFalse())
+		Expect(broker.instances).To(BeEmpty())
+
+		// Gone is fine
+		_, err = client.Deprovision(ctx, "inst-1", "svc-1", "plan-1")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("polls asynchronous operations until they are done", func() {
+		broker.async = true
+
+		op := provision("inst-1")
+		Expect(op.Async).To(BeTrue())
+		Expect(op.Operation).To(Equal("provision"))
+		Expect(client.Wait(ctx, time.Second, pollInstance("inst-1", op.Operation))).To(Succeed())
+
+		op, err := client.Deprovision(ctx, "inst-1", "svc-1", "plan-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(op.Async).To(BeTrue())
+		Expect(client.Wait(ctx, time.Second, pollInstance("inst-1", op.Operation))).To(MatchError(ErrGone))
+	})
+
+	It("reports failed asynchronous operations", func() {
+		broker.async = true
+		broker.fail = true
+
+		op := provision("inst-1")
+		err := client.Wait(ctx, time.Second, pollInstance("inst-1", op.Operation))
+		Expect(err).To(MatchError("service broker operation failed: out of disks"))
+	})
+
+	It("generates version 4 UUIDs", func() {
+		id, err := NewGUID()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+	})
+})
@@ -0,0 +1,13 @@
+package osb_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOSB(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OSB Suite")
+}
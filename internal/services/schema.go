@@ -0,0 +1,50 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ParameterError is a violation of the parameter schema of a plan, by the
+// parameters of a service
+type ParameterError struct {
+	// Field is the path of the offending parameter, `(root)` for the
+	// parameters as a whole
+	Field       string
+	Description string
+}
+
+func (e ParameterError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Description)
+}
+
+// ValidateParameters checks the parameters against the JSON schema and
+// returns the violations, sorted by field. Without schema all parameters are
+// accepted. An error is returned for a broken schema.
+func ValidateParameters(schema, parameters map[string]interface{}) ([]ParameterError, error) {
+	if len(schema) == 0 {
+		return nil, nil
+	}
+	if parameters == nil {
+		parameters = map[string]interface{}{}
+	}
+
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema),
+		gojsonschema.NewGoLoader(parameters))
+	if err != nil {
+		return nil, fmt.Errorf("bad parameter schema: %w", err)
+	}
+
+	violations := []ParameterError{}
+	for _, violation := range result.Errors() {
+		violations = append(violations, ParameterError{
+			Field:       violation.Field(),
+			Description: violation.Description(),
+		})
+	}
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+
+	return violations, nil
+}
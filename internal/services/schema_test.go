@@ -0,0 +1,88 @@
+package services_test
+
+import (
+	. "github.com/epinio/epinio/internal/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidateParameters", func() {
+	schema := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-04/schema#",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"db": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{"type": "string"},
+				},
+			},
+			"replicas": map[string]interface{}{"type": "integer", "minimum": 1},
+		},
+		"required": []interface{}{"db"},
+	}
+
+	It("accepts everything without schema", func() {
+		violations, err := ValidateParameters(nil, map[string]interface{}{"any": "thing"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(violations).To(BeEmpty())
+	})
+
+	It("accepts valid parameters", func() {
+		violations, err := ValidateParameters(schema, map[string]interface{}{
+			"db":       map[string]interface{}{"name": "wordpress"},
+			"replicas": float64(2),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(violations).To(BeEmpty())
+	})
+
+	It("reports each violating field", func() {
+		violations, err := ValidateParameters(schema, map[string]interface{}{
+			"replicas": float64(0),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(violations).To(HaveLen(2))
+		Expect(violations[0].Field).To(Equal("(root)"))
+		Expect(violations[0].Description).To(ContainSubstring("db is required"))
+		Expect(violations[1].Field).To(Equal("replicas"))
+		Expect(violations[1].Error()).To(ContainSubstring("replicas: Must be greater than or equal to 1"))
+	})
+})
+
+var _ = Describe("ServicePlan", func() {
+	create := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"db"},
+	}
+	update := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"replicas": map[string]interface{}{"type": "integer"},
+		},
+	}
+
+	It("checks updates against the schema of new services by default", func() {
+		plan := ServicePlan{Name: "small", Schema: create}
+		Expect(plan.UpdateParameterSchema()).To(Equal(create))
+
+		// The parameters of an update replace the current ones
+		violations, err := ValidateParameters(plan.UpdateParameterSchema(), map[string]interface{}{
+			"replicas": float64(2),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(violations).To(HaveLen(1))
+		Expect(violations[0].Description).To(ContainSubstring("db is required"))
+	})
+
+	It("checks updates against the update schema of the plan", func() {
+		plan := ServicePlan{Name: "small", Schema: create, UpdateSchema: update}
+
+		violations, err := ValidateParameters(plan.UpdateParameterSchema(), map[string]interface{}{
+			"replicas": "many",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(violations).To(HaveLen(1))
+		Expect(violations[0].Field).To(Equal("replicas"))
+	})
+})
@@ -5,6 +5,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/epinio/epinio/helpers/kubernetes"
 	"github.com/epinio/epinio/internal/interfaces"
@@ -20,6 +22,30 @@ func Lookup(ctx context.Context, kubeClient *kubernetes.Cluster, org, service st
 		return serviceInstance, nil
 	}
 
+	serviceInstance, err = SharedServiceLookup(ctx, kubeClient, org, service)
+	if err != nil {
+		return nil, err
+	}
+	if serviceInstance != nil {
+		return serviceInstance, nil
+	}
+
+	serviceInstance, err = ChartServiceLookup(ctx, kubeClient, org, service)
+	if err != nil {
+		return nil, err
+	}
+	if serviceInstance != nil {
+		return serviceInstance, nil
+	}
+
+	serviceInstance, err = BrokerServiceLookup(ctx, kubeClient, org, service)
+	if err != nil {
+		return nil, err
+	}
+	if serviceInstance != nil {
+		return serviceInstance, nil
+	}
+
 	serviceInstance, err = CatalogServiceLookup(ctx, kubeClient, org, service)
 	if err != nil {
 		return nil, err
@@ -39,12 +65,65 @@ func List(ctx context.Context, kubeClient *kubernetes.Cluster, org string) (inte
 		return nil, err
 	}
 
+	sharedServices, err := SharedServiceList(ctx, kubeClient, org)
+	if err != nil {
+		return nil, err
+	}
+
+	chartServices, err := ChartServiceList(ctx, kubeClient, org)
+	if err != nil {
+		return nil, err
+	}
+
+	brokerServices, err := BrokerServiceList(ctx, kubeClient, org)
+	if err != nil {
+		return nil, err
+	}
+
 	catalogServices, err := CatalogServiceList(ctx, kubeClient, org)
 	if err != nil {
 		return nil, err
 	}
 
-	return append(customServices, catalogServices...), nil
+	result := append(customServices, sharedServices...)
+	result = append(result, chartServices...)
+	result = append(result, brokerServices...)
+	return append(result, catalogServices...), nil
+}
+
+// Overview is the summary of a service shown in listings
+type Overview struct {
+	// Type is one of custom, catalog, broker, chart and shared
+	Type  string
+	Class string
+	Plan  string
+	// Created is the creation time of the service, or of the share for
+	// shared services
+	Created time.Time
+}
+
+// OverviewOf returns the summary of the service. The class and plan of a
+// shared service are those of its source, and empty if the source is gone.
+func OverviewOf(ctx context.Context, service interfaces.Service) Overview {
+	switch s := service.(type) {
+	case *CustomService:
+		return Overview{Type: "custom", Created: s.Created}
+	case *CatalogService:
+		return Overview{Type: "catalog", Class: s.Class, Plan: s.Plan, Created: s.Created}
+	case *BrokerService:
+		return Overview{Type: "broker", Class: s.Class, Plan: s.Plan, Created: s.Created}
+	case *ChartService:
+		return Overview{Type: "chart", Class: s.Class, Plan: s.Plan, Created: s.Created}
+	case *SharedService:
+		overview := Overview{Type: "shared", Created: s.Created}
+		if source, err := s.source(ctx); err == nil {
+			sourceOverview := OverviewOf(ctx, source)
+			overview.Class = sourceOverview.Class
+			overview.Plan = sourceOverview.Plan
+		}
+		return overview
+	}
+	return Overview{}
 }
 
 func serviceResourceName(org, service string) string {
@@ -54,3 +133,26 @@ func serviceResourceName(org, service string) string {
 func bindingResourceName(org, service, app string) string {
 	return fmt.Sprintf("service.org-%s.svc-%s.app-%s", org, service, app)
 }
+
+// rotatedBindingSuffix marks the bindings made by a rotation of credentials
+const rotatedBindingSuffix = ".rotated"
+
+// rotatedBindingName returns the name of the binding replacing the named
+// binding on a rotation of the credentials. Rotations alternate between the
+// name made by bindingResourceName and the same name with a suffix.
+func rotatedBindingName(name string) string {
+	if strings.HasSuffix(name, rotatedBindingSuffix) {
+		return strings.TrimSuffix(name, rotatedBindingSuffix)
+	}
+	return name + rotatedBindingSuffix
+}
+
+// checkRotation returns an error when the named binding is not a binding of
+// the service to the application
+func checkRotation(org, service, app, current string) error {
+	bindingName := bindingResourceName(org, service, app)
+	if current != bindingName && current != rotatedBindingName(bindingName) {
+		return fmt.Errorf("secret '%s' is not a binding of service '%s'", current, service)
+	}
+	return nil
+}
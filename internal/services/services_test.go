@@ -1,11 +1,31 @@
 package services_test
 
 import (
+	"context"
+	"time"
+
+	. "github.com/epinio/epinio/internal/services"
 	. "github.com/onsi/ginkgo"
-	//. "github.com/onsi/gomega"
-	//. "github.com/epinio/epinio/internal/services"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("Services", func() {
+	Describe("OverviewOf", func() {
+		created := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		It("reports custom services without class and plan", func() {
+			overview := OverviewOf(context.Background(), &CustomService{Service: "db", Created: created})
+			Expect(overview).To(Equal(Overview{Type: "custom", Created: created}))
+		})
 
+		It("reports the class and plan of catalog services", func() {
+			overview := OverviewOf(context.Background(), &CatalogService{
+				Service: "db",
+				Class:   "mariadb",
+				Plan:    "10-3-22",
+				Created: created,
+			})
+			Expect(overview).To(Equal(Overview{Type: "catalog", Class: "mariadb", Plan: "10-3-22", Created: created}))
+		})
+	})
 })
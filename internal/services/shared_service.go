@@ -0,0 +1,406 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	"github.com/epinio/epinio/internal/interfaces"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrShareOfShare is returned by Share for a service which is itself shared
+// from another org
+var ErrShareOfShare = errors.New("a shared service can not be shared again")
+
+// ErrShareExists is returned by Share when the target org has a service of
+// the same name
+var ErrShareExists = errors.New("service of this name already exists in the org")
+
+// ErrShareToSelf is returned by Share for the org of the service
+var ErrShareToSelf = errors.New("a service can not be shared with its own org")
+
+// SharedService is a Service of another org, made bindable in the org. The
+// share is recorded in a secret of the org, named like the secret of a
+// custom service, and labeled with the org of the service. The binding data
+// is copied from a binding of the service in its org, RefreshShares copies
+// it again after the service changed.
+// Implements the Service and CredentialRotator interfaces.
+type SharedService struct {
+	SecretName string
+	OrgName    string
+	Service    string
+	SourceOrg  string
+	// Created is the creation time of the share, as found by List and
+	// Lookup
+	Created time.Time
+	cluster *kubernetes.Cluster
+}
+
+var _ interfaces.Service = &SharedService{}
+var _ interfaces.CredentialRotator = &SharedService{}
+
+// SharedServiceList returns a ServiceList of all services shared with the org
+func SharedServiceList(ctx context.Context, cluster *kubernetes.Cluster, org string) (interfaces.ServiceList, error) {
+	labelSelector := fmt.Sprintf("epinio.suse.org/service-type=shared, epinio.suse.org/organization=%s", org)
+
+	secrets, err := cluster.Kubectl.CoreV1().Secrets(org).List(ctx,
+		metav1.ListOptions{
+			LabelSelector: labelSelector,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	result := interfaces.ServiceList{}
+	for _, secret := range secrets.Items {
+		result = append(result, sharedServiceFromSecret(cluster, secret))
+	}
+
+	return result, nil
+}
+
+// SharedServiceLookup finds a service shared with the org by looking for the
+// secret recording the share
+func SharedServiceLookup(ctx context.Context, cluster *kubernetes.Cluster, org, service string) (interfaces.Service, error) {
+	secret, err := cluster.GetSecret(ctx, org, serviceResourceName(org, service))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if secret.Labels["epinio.suse.org/service-type"] != "shared" {
+		return nil, nil
+	}
+
+	return sharedServiceFromSecret(cluster, *secret), nil
+}
+
+// Share makes the service bindable in the target org, under the same name.
+// It is an error when the target org already has a service of that name.
+func Share(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service, targetOrg string) (interfaces.Service, error) {
+	if _, ok := service.(*SharedService); ok {
+		return nil, ErrShareOfShare
+	}
+	if service.Org() == targetOrg {
+		return nil, ErrShareToSelf
+	}
+
+	_, err := Lookup(ctx, cluster, targetOrg, service.Name())
+	if err == nil {
+		return nil, ErrShareExists
+	}
+	if err.Error() != "service not found" {
+		return nil, err
+	}
+
+	s := &SharedService{
+		SecretName: serviceResourceName(targetOrg, service.Name()),
+		OrgName:    targetOrg,
+		Service:    service.Name(),
+		SourceOrg:  service.Org(),
+		cluster:    cluster,
+	}
+
+	err = cluster.CreateSecret(ctx, targetOrg, corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: s.SecretName,
+			Labels: map[string]string{
+				"epinio.suse.org/service-type":    "shared",
+				"epinio.suse.org/service":         s.Service,
+				"epinio.suse.org/organization":    targetOrg,
+				"epinio.suse.org/shared-from-org": s.SourceOrg,
+				"app.kubernetes.io/name":          "epinio",
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Shares returns the sorted names of the orgs the service is shared with
+func Shares(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service) ([]string, error) {
+	labelSelector := fmt.Sprintf("epinio.suse.org/service-type=shared, epinio.suse.org/service=%s, epinio.suse.org/shared-from-org=%s",
+		service.Name(), service.Org())
+
+	secrets, err := cluster.Kubectl.CoreV1().Secrets("").List(ctx,
+		metav1.ListOptions{
+			LabelSelector: labelSelector,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	orgs := []string{}
+	for _, secret := range secrets.Items {
+		orgs = append(orgs, secret.Labels["epinio.suse.org/organization"])
+	}
+	sort.Strings(orgs)
+
+	return orgs, nil
+}
+
+func sharedServiceFromSecret(cluster *kubernetes.Cluster, secret corev1.Secret) *SharedService {
+	return &SharedService{
+		SecretName: secret.Name,
+		OrgName:    secret.Labels["epinio.suse.org/organization"],
+		Service:    secret.Labels["epinio.suse.org/service"],
+		SourceOrg:  secret.Labels["epinio.suse.org/shared-from-org"],
+		Created:    secret.CreationTimestamp.Time,
+		cluster:    cluster,
+	}
+}
+
+// sourceAppName returns the name under which the application of the org is
+// bound to the service in its own org. Application names are unique per org
+// only, hence the org prefix.
+func (s *SharedService) sourceAppName(appName string) string {
+	return fmt.Sprintf("%s.%s", s.OrgName, appName)
+}
+
+// source returns the service in its own org
+func (s *SharedService) source(ctx context.Context) (interfaces.Service, error) {
+	service, err := Lookup(ctx, s.cluster, s.SourceOrg, s.Service)
+	if err != nil {
+		if err.Error() == "service not found" {
+			return nil, fmt.Errorf("shared service '%s' no longer exists in org '%s'", s.Service, s.SourceOrg)
+		}
+		return nil, err
+	}
+	return service, nil
+}
+
+func (s *SharedService) Name() string {
+	return s.Service
+}
+
+func (s *SharedService) Org() string {
+	return s.OrgName
+}
+
+// GetBinding returns the secret holding the data of the application's
+// binding, a copy of a binding of the service in its own org. During a
+// rotation the copy may have the name of the rotated binding.
+func (s *SharedService) GetBinding(ctx context.Context, appName string) (*corev1.Secret, error) {
+	bindingName := bindingResourceName(s.OrgName, s.Service, appName)
+
+	for _, name := range []string{bindingName, rotatedBindingName(bindingName)} {
+		secret, err := s.cluster.GetSecret(ctx, s.OrgName, name)
+		if err == nil {
+			return secret, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	source, err := s.source(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sourceSecret, err := source.GetBinding(ctx, s.sourceAppName(appName))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createCopy(ctx, bindingName, appName, sourceSecret)
+}
+
+// RotateBinding rotates the binding of the service in its own org, and
+// copies the new binding next to the current copy
+func (s *SharedService) RotateBinding(ctx context.Context, appName, current string) (*corev1.Secret, error) {
+	source, err := s.source(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rotator, ok := source.(interfaces.CredentialRotator)
+	if !ok {
+		return nil, interfaces.ErrNoCredentials
+	}
+
+	sourceApp := s.sourceAppName(appName)
+	sourceSecret, err := source.GetBinding(ctx, sourceApp)
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := rotator.RotateBinding(ctx, sourceApp, sourceSecret.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := s.createCopy(ctx, rotatedBindingName(current), appName, rotated)
+	if err != nil {
+		if err := rotator.DeleteRotatedBinding(ctx, sourceApp, rotated.Name); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+	return secret, nil
+}
+
+// DeleteRotatedBinding removes the named copy, and the binding of the
+// service in its own org it was copied from. Copies and the bindings they
+// were copied from are rotated together, the names of both are either
+// rotated or not.
+func (s *SharedService) DeleteRotatedBinding(ctx context.Context, appName, secretName string) error {
+	source, err := s.source(ctx)
+	if err != nil {
+		return err
+	}
+	rotator, ok := source.(interfaces.CredentialRotator)
+	if !ok {
+		return interfaces.ErrNoCredentials
+	}
+
+	err = s.cluster.DeleteSecret(ctx, s.OrgName, secretName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	sourceApp := s.sourceAppName(appName)
+	sourceName := bindingResourceName(s.SourceOrg, s.Service, sourceApp)
+	if strings.HasSuffix(secretName, rotatedBindingSuffix) {
+		sourceName = rotatedBindingName(sourceName)
+	}
+	return rotator.DeleteRotatedBinding(ctx, sourceApp, sourceName)
+}
+
+// createCopy stores the data of the binding of the service in its own org
+// as the named binding secret of the application
+func (s *SharedService) createCopy(ctx context.Context, name, appName string, sourceSecret *corev1.Secret) (*corev1.Secret, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":          appName,
+				"app.kubernetes.io/part-of":       s.OrgName,
+				"app.kubernetes.io/component":     "servicebindingsecret",
+				"app.kubernetes.io/managed-by":    "epinio",
+				"epinio.suse.org/service":         s.Service,
+				"epinio.suse.org/shared-from-org": s.SourceOrg,
+			},
+		},
+		Data: sourceSecret.Data,
+	}
+
+	return s.cluster.Kubectl.CoreV1().Secrets(s.OrgName).Create(ctx, secret, metav1.CreateOptions{})
+}
+
+// RefreshShares copies the binding data of the service again into the orgs
+// it is shared with, after the service changed. Applications bound as files
+// see the new data after a while, applications bound as environment
+// variables after a restart.
+func RefreshShares(ctx context.Context, cluster *kubernetes.Cluster, service interfaces.Service) error {
+	orgs, err := Shares(ctx, cluster, service)
+	if err != nil {
+		return err
+	}
+
+	for _, org := range orgs {
+		shared := &SharedService{
+			SecretName: serviceResourceName(org, service.Name()),
+			OrgName:    org,
+			Service:    service.Name(),
+			SourceOrg:  service.Org(),
+			cluster:    cluster,
+		}
+
+		// Copies made before they were labeled with the service are
+		// found by their name
+		secrets, err := cluster.Kubectl.CoreV1().Secrets(org).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("app.kubernetes.io/component=servicebindingsecret, epinio.suse.org/shared-from-org=%s",
+				service.Org()),
+		})
+		if err != nil {
+			return err
+		}
+		prefix := bindingResourceName(org, service.Name(), "")
+		for i := range secrets.Items {
+			secret := &secrets.Items[i]
+			if !strings.HasPrefix(secret.Name, prefix) {
+				continue
+			}
+
+			sourceSecret, err := service.GetBinding(ctx, shared.sourceAppName(secret.Labels["app.kubernetes.io/name"]))
+			if err != nil {
+				return err
+			}
+			secret.Data = sourceSecret.Data
+			_, err = cluster.Kubectl.CoreV1().Secrets(org).Update(ctx, secret, metav1.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DeleteBinding removes the copies of the binding data, and the binding of
+// the service in its own org. The latter is skipped when that service is
+// gone.
+func (s *SharedService) DeleteBinding(ctx context.Context, appName, org string) error {
+	bindingName := bindingResourceName(s.OrgName, s.Service, appName)
+	for _, name := range []string{bindingName, rotatedBindingName(bindingName)} {
+		err := s.cluster.DeleteSecret(ctx, org, name)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	source, err := Lookup(ctx, s.cluster, s.SourceOrg, s.Service)
+	if err != nil {
+		if err.Error() == "service not found" {
+			return nil
+		}
+		return err
+	}
+
+	return source.DeleteBinding(ctx, s.sourceAppName(appName), s.SourceOrg)
+}
+
+// Delete removes the share. The service in its own org is not touched.
+func (s *SharedService) Delete(ctx context.Context) error {
+	return s.cluster.DeleteSecret(ctx, s.OrgName, s.SecretName)
+}
+
+func (s *SharedService) Status(ctx context.Context) (string, error) {
+	source, err := s.source(ctx)
+	if err != nil {
+		return "", err
+	}
+	return source.Status(ctx)
+}
+
+func (s *SharedService) WaitForProvision(ctx context.Context) error {
+	source, err := s.source(ctx)
+	if err != nil {
+		return err
+	}
+	return source.WaitForProvision(ctx)
+}
+
+func (s *SharedService) Details(ctx context.Context) (map[string]string, error) {
+	source, err := s.source(ctx)
+	if err != nil {
+		return nil, err
+	}
+	details, err := source.Details(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details["Shared From"] = s.SourceOrg
+
+	return details, nil
+}
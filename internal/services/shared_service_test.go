@@ -0,0 +1,61 @@
+package services_test
+
+import (
+	"context"
+
+	"github.com/epinio/epinio/helpers/kubernetes"
+	. "github.com/epinio/epinio/internal/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("RefreshShares", func() {
+	ctx := context.Background()
+
+	secret := func(org, name string, labels map[string]string, data string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: org, Labels: labels},
+			Data:       map[string][]byte{"password": []byte(data)},
+		}
+	}
+
+	copyLabels := func(app, service string) map[string]string {
+		return map[string]string{
+			"app.kubernetes.io/name":          app,
+			"app.kubernetes.io/component":     "servicebindingsecret",
+			"epinio.suse.org/service":         service,
+			"epinio.suse.org/shared-from-org": "source",
+		}
+	}
+
+	It("copies the binding data into the orgs the service is shared with", func() {
+		cluster := &kubernetes.Cluster{Kubectl: fake.NewSimpleClientset(
+			secret("source", "service.org-source.svc-db", map[string]string{
+				"epinio.suse.org/service-type": "custom",
+			}, "new"),
+			secret("other", "service.org-other.svc-db", map[string]string{
+				"epinio.suse.org/service-type":    "shared",
+				"epinio.suse.org/service":         "db",
+				"epinio.suse.org/organization":    "other",
+				"epinio.suse.org/shared-from-org": "source",
+			}, ""),
+			secret("other", "service.org-other.svc-db.app-web", copyLabels("web", "db"), "old"),
+			secret("other", "service.org-other.svc-db2.app-web", copyLabels("web", "db2"), "old"),
+		)}
+
+		service, err := CustomServiceLookup(ctx, cluster, "source", "db")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(RefreshShares(ctx, cluster, service)).To(Succeed())
+
+		password := func(name string) string {
+			s, err := cluster.GetSecret(ctx, "other", name)
+			Expect(err).ToNot(HaveOccurred())
+			return string(s.Data["password"])
+		}
+		Expect(password("service.org-other.svc-db.app-web")).To(Equal("new"))
+		Expect(password("service.org-other.svc-db2.app-web")).To(Equal("old"))
+	})
+})
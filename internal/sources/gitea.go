@@ -0,0 +1,121 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/cli/clients/gitea"
+	"github.com/pkg/errors"
+)
+
+// GiteaStore keeps the sources of each app as commits of a gitea
+// repository. Staging clones the repository.
+type GiteaStore struct {
+	client *gitea.Client
+}
+
+var _ Store = &GiteaStore{}
+
+func (s *GiteaStore) CreateOrg(org string) error {
+	return s.client.CreateOrg(org)
+}
+
+func (s *GiteaStore) DeleteOrg(org string) error {
+	return s.client.DeleteOrg(org)
+}
+
+func (s *GiteaStore) DeleteRepo(org, repo string) (int, error) {
+	return s.client.DeleteRepo(org, repo)
+}
+
+func (s *GiteaStore) Upload(ctx context.Context, app models.AppRef, dir string) (*models.UploadResponse, error) {
+	g, err := s.client.Upload(app, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extend url to contain the full repo path
+	g.URL = fmt.Sprintf("%s/%s/%s", g.URL, app.Org, app.Name)
+
+	return &models.UploadResponse{Git: &g}, nil
+}
+
+func (s *GiteaStore) UploadDelta(ctx context.Context, app models.AppRef, manifest []models.SourceFile, deltaDir string) (*models.UploadResponse, error) {
+	tmpDir, err := ioutil.TempDir("", "epinio-sources")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create temp directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The clone is pushed as is, with the new sources as the next commit
+	dir := path.Join(tmpDir, "app")
+	found, err := s.client.Checkout(app, dir, "")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = assemble(dir, manifest, deltaDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Upload(ctx, app, dir)
+}
+
+func (s *GiteaStore) SourceHashes(ctx context.Context, app models.AppRef) (map[string]string, error) {
+	tmpDir, err := ioutil.TempDir("", "epinio-sources")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create temp directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dir := path.Join(tmpDir, "app")
+	found, err := s.client.Checkout(app, dir, "")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return map[string]string{}, nil
+	}
+
+	return hashTree(dir)
+}
+
+func (s *GiteaStore) ArchiveURL(ctx context.Context, app models.AppRef, archive models.ArchiveRef) (string, error) {
+	return "", errors.New("the gitea source store has no archives")
+}
+
+// Retain does nothing, the repository keeps all revisions
+func (s *GiteaStore) Retain(ctx context.Context, app models.AppRef, revisions []string) error {
+	return nil
+}
+
+func (s *GiteaStore) Download(ctx context.Context, app models.AppRef, revision string, w io.Writer) error {
+	tmpDir, err := ioutil.TempDir("", "epinio-sources")
+	if err != nil {
+		return errors.Wrap(err, "can't create temp directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dir := path.Join(tmpDir, "app")
+	found, err := s.client.Checkout(app, dir, revision)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+
+	return writeArchive(dir, w)
+}
@@ -0,0 +1,481 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/epinio/epinio/helpers"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/pkg/errors"
+)
+
+// archiveURLExpiry is how long the staging pipeline can download an archive
+// with the URL handed to it
+const archiveURLExpiry = 24 * time.Hour
+
+// latestObject is the name of the object describing the latest revision of
+// an app's sources
+const latestObject = "latest.json"
+
+// errObjectNotFound is returned for objects missing from the bucket
+var errObjectNotFound = errors.New("object not found")
+
+// digestRegex matches the revisions of the store, the SHA-256 of archives
+var digestRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// S3Config locates the bucket and holds the credentials for it. The
+// endpoint is the URL of the store, buckets are addressed path-style.
+type S3Config struct {
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// S3Store keeps the sources of each app as gzipped tarballs, below the
+// prefix `org/app/` of the bucket. The latest revision is described by the
+// `latest.json` object of the app. Staging downloads the tarball via a
+// presigned URL.
+type S3Store struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+var _ Store = &S3Store{}
+
+// latestRevision is the content of the latest.json object of an app
+type latestRevision struct {
+	Archive models.ArchiveRef `json:"archive"`
+	Files   map[string]string `json:"files"`
+}
+
+// NewS3Store returns a store for the configured bucket. The bucket has to
+// exist.
+func NewS3Store(config S3Config) (*S3Store, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("the s3 source store needs an endpoint and a bucket")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad s3 endpoint")
+	}
+
+	return &S3Store{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{},
+	}, nil
+}
+
+// CreateOrg does nothing, prefixes come into existence with their objects
+func (s *S3Store) CreateOrg(org string) error {
+	return nil
+}
+
+func (s *S3Store) DeleteOrg(org string) error {
+	_, err := s.deletePrefix(context.Background(), org+"/")
+	return err
+}
+
+func (s *S3Store) DeleteRepo(org, repo string) (int, error) {
+	removed, err := s.deletePrefix(context.Background(), org+"/"+repo+"/")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if removed == 0 {
+		return http.StatusNotFound, nil
+	}
+	return http.StatusOK, nil
+}
+
+// Upload archives the sources and makes them the latest revision. Older
+// archives are kept, until Retain removes them.
+func (s *S3Store) Upload(ctx context.Context, app models.AppRef, dir string) (*models.UploadResponse, error) {
+	hashes, err := hashTree(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile("", "epinio-sources-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = writeArchive(dir, tmp)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to archive sources")
+	}
+
+	digest, err := helpers.FileSHA256(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	archive := models.ArchiveRef{
+		Key:    appPrefix(app) + digest + ".tar.gz",
+		Digest: digest,
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	err = s.put(ctx, archive.Key, tmp, digest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store archive")
+	}
+
+	latest, err := json.Marshal(latestRevision{Archive: archive, Files: hashes})
+	if err != nil {
+		return nil, err
+	}
+	err = s.putBytes(ctx, appPrefix(app)+latestObject, latest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store latest revision")
+	}
+
+	return &models.UploadResponse{Archive: &archive}, nil
+}
+
+func (s *S3Store) UploadDelta(ctx context.Context, app models.AppRef, manifest []models.SourceFile, deltaDir string) (*models.UploadResponse, error) {
+	tmpDir, err := ioutil.TempDir("", "epinio-sources")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create temp directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dir := path.Join(tmpDir, "app")
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := s.latest(ctx, app)
+	if err != nil && err != errObjectNotFound {
+		return nil, err
+	}
+	if previous != nil {
+		blob := path.Join(tmpDir, "previous.tar.gz")
+		err = s.getFile(ctx, previous.Archive.Key, blob)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch previous sources")
+		}
+		err = helpers.UnpackArchive(blob, dir, unpackLimits())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to unpack previous sources")
+		}
+	}
+
+	err = assemble(dir, manifest, deltaDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Upload(ctx, app, dir)
+}
+
+func (s *S3Store) SourceHashes(ctx context.Context, app models.AppRef) (map[string]string, error) {
+	latest, err := s.latest(ctx, app)
+	if err == errObjectNotFound {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return latest.Files, nil
+}
+
+func (s *S3Store) ArchiveURL(ctx context.Context, app models.AppRef, archive models.ArchiveRef) (string, error) {
+	if !strings.HasPrefix(archive.Key, appPrefix(app)) || strings.Contains(archive.Key, "..") {
+		return "", fmt.Errorf("archive '%s' does not belong to app '%s'", archive.Key, app.Name)
+	}
+	return PresignV4(s.objectURL(archive.Key), s.config, archiveURLExpiry, time.Now()), nil
+}
+
+func (s *S3Store) Download(ctx context.Context, app models.AppRef, revision string, w io.Writer) error {
+	key := appPrefix(app) + revision + ".tar.gz"
+	if revision == "" {
+		latest, err := s.latest(ctx, app)
+		if err == errObjectNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		key = latest.Archive.Key
+	} else if !digestRegex.MatchString(revision) {
+		return ErrNotFound
+	}
+
+	err := s.get(ctx, key, w)
+	if err == errObjectNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Retain removes the archives of the app other than the latest one and the
+// ones of the revisions
+func (s *S3Store) Retain(ctx context.Context, app models.AppRef, revisions []string) error {
+	keep := map[string]bool{appPrefix(app) + latestObject: true}
+	latest, err := s.latest(ctx, app)
+	if err != nil && err != errObjectNotFound {
+		return err
+	}
+	if latest != nil {
+		keep[latest.Archive.Key] = true
+	}
+	for _, revision := range revisions {
+		if digestRegex.MatchString(revision) {
+			keep[appPrefix(app)+revision+".tar.gz"] = true
+		}
+	}
+
+	keys, err := s.list(ctx, appPrefix(app))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !keep[key] {
+			err = s.delete(ctx, key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func appPrefix(app models.AppRef) string {
+	return app.Org + "/" + app.Name + "/"
+}
+
+func (s *S3Store) latest(ctx context.Context, app models.AppRef) (*latestRevision, error) {
+	body, err := s.getBytes(ctx, appPrefix(app)+latestObject)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := &latestRevision{}
+	err = json.Unmarshal(body, latest)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad latest revision")
+	}
+	return latest, nil
+}
+
+func (s *S3Store) deletePrefix(ctx context.Context, prefix string) (int, error) {
+	keys, err := s.list(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		err = s.delete(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// PresignedURL returns a URL allowing requests of the method on the object
+// of the key, without further credentials, until it expires
+func (s *S3Store) PresignedURL(method, key string, expires time.Duration) string {
+	return PresignV4Method(method, s.objectURL(key), s.config, expires, time.Now())
+}
+
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = path.Join("/", u.Path, s.config.Bucket, key)
+	u.RawPath = ""
+	return &u
+}
+
+func (s *S3Store) put(ctx context.Context, key string, body *os.File, digest string) error {
+	info, err := body.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+
+	_, err = s.do(req, digest)
+	return err
+}
+
+func (s *S3Store) putBytes(ctx context.Context, key string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	hash := sha256Hex(body)
+	_, err = s.do(req, hash)
+	return err
+}
+
+func (s *S3Store) getBytes(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(req, emptyPayloadHash)
+}
+
+func (s *S3Store) getFile(ctx context.Context, key, dst string) error {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = s.get(ctx, key, f)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// get copies the object to w
+func (s *S3Store) get(ctx context.Context, key string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.send(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// DeleteObject removes the object of the key. A missing object is not an
+// error.
+func (s *S3Store) DeleteObject(ctx context.Context, key string) error {
+	return s.delete(ctx, key)
+}
+
+func (s *S3Store) delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(req, emptyPayloadHash)
+	if err == errObjectNotFound {
+		return nil
+	}
+	return err
+}
+
+// list returns the keys of the objects below the prefix
+func (s *S3Store) list(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	token := ""
+
+	for {
+		u := s.objectURL("")
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = canonicalQuery(query)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := s.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.Unmarshal(body, &result)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad object listing")
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do signs and sends the request, and returns the response body
+func (s *S3Store) do(req *http.Request, payloadHash string) ([]byte, error) {
+	resp, err := s.send(req, payloadHash)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// send signs and sends the request. The body of a successful response is
+// left to the caller to read and close.
+func (s *S3Store) send(req *http.Request, payloadHash string) (*http.Response, error) {
+	SignV4(req, s.config, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errObjectNotFound
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return nil, fmt.Errorf("s3: %s %s: %s: %s", req.Method, req.URL.Path, resp.Status,
+		strings.TrimSpace(string(body)))
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,276 @@
+package sources_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/epinio/epinio/helpers"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	. "github.com/epinio/epinio/internal/sources"
+	"github.com/mholt/archiver/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeS3 is a minimal stand-in for an S3 compatible store, serving a
+// single bucket path-style
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	signed := r.URL.Query().Get("X-Amz-Signature") != "" ||
+		strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !signed {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/"+f.bucket)
+	key = strings.TrimPrefix(key, "/")
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		type object struct {
+			Key string `xml:"Key"`
+		}
+		result := struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []object `xml:"Contents"`
+		}{}
+		prefix := r.URL.Query().Get("prefix")
+		for k := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, object{Key: k})
+			}
+		}
+		body, _ := xml.Marshal(result)
+		_, _ = w.Write(body)
+	case r.Method == http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = body
+	case r.Method == http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(body)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := []string{}
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var _ = Describe("S3Store", func() {
+	var (
+		fake   *fakeS3
+		server *httptest.Server
+		store  *S3Store
+		tmpDir string
+		app    = models.NewAppRef("myapp", "workspace")
+		ctx    = context.Background()
+	)
+
+	writeFile := func(dir, name, content string) {
+		p := filepath.Join(dir, name)
+		Expect(os.MkdirAll(filepath.Dir(p), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(p, []byte(content), 0644)).To(Succeed())
+	}
+
+	sourceFile := func(dir, name string) models.SourceFile {
+		sum, err := helpers.FileSHA256(filepath.Join(dir, name))
+		Expect(err).ToNot(HaveOccurred())
+		return models.SourceFile{Path: name, SHA256: sum, Mode: 0644}
+	}
+
+	BeforeEach(func() {
+		var err error
+		fake = &fakeS3{bucket: "sources", objects: map[string][]byte{}}
+		server = httptest.NewServer(fake)
+		store, err = NewS3Store(S3Config{
+			Endpoint:        server.URL,
+			Bucket:          "sources",
+			AccessKeyID:     "key",
+			SecretAccessKey: "secret",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		tmpDir, err = ioutil.TempDir("", "epinio-sources-test")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("stores the sources as archive and remembers their checksums", func() {
+		src := filepath.Join(tmpDir, "src")
+		writeFile(src, "main.go", "package main")
+		writeFile(src, "pkg/lib.go", "package pkg")
+
+		resp, err := store.Upload(ctx, app, src)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Git).To(BeNil())
+		Expect(resp.Archive.Key).To(Equal("workspace/myapp/" + resp.Archive.Digest + ".tar.gz"))
+		Expect(fake.keys()).To(ConsistOf(resp.Archive.Key, "workspace/myapp/latest.json"))
+
+		hashes, err := store.SourceHashes(ctx, app)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hashes).To(HaveLen(2))
+		Expect(hashes["pkg/lib.go"]).To(Equal(sourceFile(src, "pkg/lib.go").SHA256))
+	})
+
+	It("reports no checksums for an app without sources", func() {
+		hashes, err := store.SourceHashes(ctx, app)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hashes).To(BeEmpty())
+	})
+
+	It("combines a delta with the previous revision", func() {
+		src := filepath.Join(tmpDir, "src")
+		writeFile(src, "main.go", "package main")
+		writeFile(src, "old.go", "package main // old")
+		first, err := store.Upload(ctx, app, src)
+		Expect(err).ToNot(HaveOccurred())
+
+		delta := filepath.Join(tmpDir, "delta")
+		writeFile(delta, "new.go", "package main // new")
+		manifest := []models.SourceFile{sourceFile(src, "main.go"), sourceFile(delta, "new.go")}
+
+		second, err := store.UploadDelta(ctx, app, manifest, delta)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second.Archive.Digest).ToNot(Equal(first.Archive.Digest))
+
+		hashes, err := store.SourceHashes(ctx, app)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hashes).To(HaveKey("main.go"))
+		Expect(hashes).To(HaveKey("new.go"))
+		Expect(hashes).ToNot(HaveKey("old.go"))
+
+		// The previous archive is kept for stagings still queued
+		Expect(fake.keys()).To(ConsistOf(first.Archive.Key, second.Archive.Key, "workspace/myapp/latest.json"))
+	})
+
+	It("hands out a download url for archives of the app only", func() {
+		src := filepath.Join(tmpDir, "src")
+		writeFile(src, "main.go", "package main")
+		resp, err := store.Upload(ctx, app, src)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = store.ArchiveURL(ctx, models.NewAppRef("other", "workspace"), *resp.Archive)
+		Expect(err).To(HaveOccurred())
+
+		u, err := store.ArchiveURL(ctx, app, *resp.Archive)
+		Expect(err).ToNot(HaveOccurred())
+		download, err := http.Get(u)
+		Expect(err).ToNot(HaveOccurred())
+		defer download.Body.Close()
+		Expect(download.StatusCode).To(Equal(http.StatusOK))
+
+		blob := filepath.Join(tmpDir, "download.tar.gz")
+		body, err := ioutil.ReadAll(download.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.WriteFile(blob, body, 0600)).To(Succeed())
+
+		out := filepath.Join(tmpDir, "out")
+		Expect(archiver.Unarchive(blob, out)).To(Succeed())
+		content, err := ioutil.ReadFile(filepath.Join(out, "main.go"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal("package main"))
+	})
+
+	It("downloads the latest or a given revision", func() {
+		src := filepath.Join(tmpDir, "src")
+		writeFile(src, "main.go", "package main // first")
+		first, err := store.Upload(ctx, app, src)
+		Expect(err).ToNot(HaveOccurred())
+		writeFile(src, "main.go", "package main // second")
+		_, err = store.Upload(ctx, app, src)
+		Expect(err).ToNot(HaveOccurred())
+
+		content := func(revision string) string {
+			blob := filepath.Join(tmpDir, "download"+revision+".tar.gz")
+			f, err := os.Create(blob)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.Download(ctx, app, revision, f)).To(Succeed())
+			Expect(f.Close()).To(Succeed())
+
+			out := filepath.Join(tmpDir, "out"+revision)
+			Expect(archiver.Unarchive(blob, out)).To(Succeed())
+			body, err := ioutil.ReadFile(filepath.Join(out, "main.go"))
+			Expect(err).ToNot(HaveOccurred())
+			return string(body)
+		}
+
+		Expect(content("")).To(Equal("package main // second"))
+		Expect(content(first.Archive.Digest)).To(Equal("package main // first"))
+
+		err = store.Download(ctx, app, strings.Repeat("0", 64), ioutil.Discard)
+		Expect(err).To(Equal(ErrNotFound))
+		err = store.Download(ctx, app, "../other/latest.json", ioutil.Discard)
+		Expect(err).To(Equal(ErrNotFound))
+	})
+
+	It("keeps the archives of the latest and the retained revisions", func() {
+		src := filepath.Join(tmpDir, "src")
+		upload := func(content string) string {
+			writeFile(src, "main.go", content)
+			resp, err := store.Upload(ctx, app, src)
+			Expect(err).ToNot(HaveOccurred())
+			return resp.Archive.Key
+		}
+		first := upload("package main // first")
+		second := upload("package main // second")
+		third := upload("package main // third")
+		Expect(fake.keys()).To(ContainElements(first, second, third))
+
+		firstDigest := strings.TrimSuffix(filepath.Base(first), ".tar.gz")
+		Expect(store.Retain(ctx, app, []string{firstDigest, "not-a-digest"})).To(Succeed())
+
+		Expect(fake.keys()).To(ConsistOf(first, third, "workspace/myapp/latest.json"))
+	})
+
+	It("deletes the sources of an app", func() {
+		src := filepath.Join(tmpDir, "src")
+		writeFile(src, "main.go", "package main")
+		_, err := store.Upload(ctx, app, src)
+		Expect(err).ToNot(HaveOccurred())
+
+		status, err := store.DeleteRepo("workspace", "myapp")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(fake.keys()).To(BeEmpty())
+
+		status, err = store.DeleteRepo("workspace", "myapp")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+})
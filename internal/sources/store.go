@@ -0,0 +1,106 @@
+// Package sources keeps the application sources pushed to epinio, for the
+// staging pipeline to fetch. The store is either the gitea installed with
+// epinio, or an S3 compatible object store.
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/epinio/epinio/helpers"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/epinio/epinio/internal/cli/clients/gitea"
+)
+
+const (
+	// KindGitea stores the sources as commits in gitea repositories
+	KindGitea = "gitea"
+	// KindS3 stores the sources as tarballs in an S3 bucket
+	KindS3 = "s3"
+)
+
+// Store is where pushed sources are kept. Organizations and apps map to
+// gitea organizations and repositories, or prefixes of the bucket.
+type Store interface {
+	CreateOrg(org string) error
+	DeleteOrg(org string) error
+	// DeleteRepo removes the sources of the app. The status is 404 for an
+	// app without sources.
+	DeleteRepo(org, repo string) (int, error)
+
+	// Upload stores the sources in dir as the latest revision of the app
+	Upload(ctx context.Context, app models.AppRef, dir string) (*models.UploadResponse, error)
+	// UploadDelta stores the files of the manifest as the latest revision
+	// of the app. Files are taken from the delta directory if there, else
+	// from the previous revision.
+	UploadDelta(ctx context.Context, app models.AppRef, manifest []models.SourceFile, deltaDir string) (*models.UploadResponse, error)
+	// SourceHashes returns the SHA-256 checksums of the regular files in
+	// the latest revision of the app, by slash separated path
+	SourceHashes(ctx context.Context, app models.AppRef) (map[string]string, error)
+	// ArchiveURL returns a URL the staging pipeline can download the
+	// archive of the app from, without credentials
+	ArchiveURL(ctx context.Context, app models.AppRef, archive models.ArchiveRef) (string, error)
+	// Download writes the sources of the revision of the app as gzipped
+	// tarball. An empty revision is the latest. ErrNotFound is returned
+	// for an unknown revision.
+	Download(ctx context.Context, app models.AppRef, revision string, w io.Writer) error
+	// Retain removes the sources of the app which are neither the latest
+	// revision nor one of the given revisions. Stores which keep all
+	// revisions do nothing.
+	Retain(ctx context.Context, app models.AppRef, revisions []string) error
+}
+
+// ErrNotFound is returned for sources which are not in the store
+var ErrNotFound = errors.New("sources not found")
+
+// Config selects and configures the store. Unpack bounds the previous
+// revision unpacked for a delta upload.
+type Config struct {
+	Kind   string
+	S3     S3Config
+	Unpack helpers.ArchiveLimits
+}
+
+var (
+	configMu sync.Mutex
+	config   = Config{Kind: KindGitea}
+)
+
+// Configure sets the store used by New
+func Configure(c Config) error {
+	if c.Kind != KindGitea && c.Kind != KindS3 {
+		return fmt.Errorf("unknown source store '%s', expected '%s' or '%s'", c.Kind, KindGitea, KindS3)
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+	return nil
+}
+
+// unpackLimits returns the configured limits of unpacking sources
+func unpackLimits() helpers.ArchiveLimits {
+	configMu.Lock()
+	defer configMu.Unlock()
+	return config.Unpack
+}
+
+// New returns the configured store
+func New(ctx context.Context) (Store, error) {
+	configMu.Lock()
+	c := config
+	configMu.Unlock()
+
+	if c.Kind == KindS3 {
+		return NewS3Store(c.S3)
+	}
+
+	client, err := gitea.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GiteaStore{client: client}, nil
+}
@@ -0,0 +1,200 @@
+package sources
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/epinio/epinio/helpers"
+	"github.com/epinio/epinio/internal/api/v1/models"
+	"github.com/pkg/errors"
+)
+
+// walkTree calls fn for the files and directories below dir, with their
+// slash separated path relative to dir. The git metadata is skipped.
+func walkTree(dir string, fn func(name, p string, info os.FileInfo) error) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if rel == ".git" {
+			return filepath.SkipDir
+		}
+		return fn(filepath.ToSlash(rel), p, info)
+	})
+}
+
+// hashTree returns the SHA-256 checksums of the regular files below dir
+func hashTree(dir string) (map[string]string, error) {
+	hashes := map[string]string{}
+	err := walkTree(dir, func(name, p string, info os.FileInfo) error {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		sum, err := helpers.FileSHA256(p)
+		if err != nil {
+			return err
+		}
+		hashes[name] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to checksum sources")
+	}
+
+	return hashes, nil
+}
+
+// assemble turns the previous revision of the sources in dir into the new
+// one, as described by the manifest. Files not in the manifest are removed,
+// the files of the delta directory are added. The result is verified
+// against the checksums of the manifest.
+func assemble(dir string, manifest []models.SourceFile, deltaDir string) error {
+	wanted := map[string]bool{}
+	for _, file := range manifest {
+		wanted[file.Path] = true
+	}
+
+	err := walkTree(dir, func(name, p string, info os.FileInfo) error {
+		if info.IsDir() || wanted[name] {
+			return nil
+		}
+		return os.Remove(p)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to remove old sources")
+	}
+
+	err = copyTree(deltaDir, dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to add new sources")
+	}
+
+	for _, file := range manifest {
+		p := filepath.Join(dir, filepath.FromSlash(file.Path))
+		sum, err := helpers.FileSHA256(p)
+		if err != nil {
+			return errors.Wrapf(err, "source file '%s' is missing", file.Path)
+		}
+		if sum != file.SHA256 {
+			return fmt.Errorf("source file '%s' does not match its checksum", file.Path)
+		}
+		err = os.Chmod(p, os.FileMode(file.Mode).Perm())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyTree copies the files, directories and symlinks below src into dst,
+// replacing what is there
+func copyTree(src, dst string) error {
+	return walkTree(src, func(name, p string, info os.FileInfo) error {
+		target := filepath.Join(dst, filepath.FromSlash(name))
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			_ = os.Remove(target)
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(p, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(dst)
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// writeArchive writes the tree below dir as gzipped tarball
+func writeArchive(dir string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := walkTree(dir, func(name, p string, info os.FileInfo) error {
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			var err error
+			link, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
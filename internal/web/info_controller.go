@@ -18,20 +18,20 @@ func (hc InfoController) Index(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	giteaClient, err := gitea.New(ctx)
-	if handleError(w, err, 500) {
-		return
-	}
-
 	platform := client.Cluster.GetPlatform()
 	kubeVersion, err := client.Cluster.GetVersion()
 	if handleError(w, err, 500) {
 		return
 	}
+
+	// Gitea is not installed when the sources are kept in S3
 	giteaVersion := "unavailable"
-	giteaFetchedVersion, resp, err := giteaClient.Client.ServerVersion()
-	if err == nil && resp != nil && resp.StatusCode == 200 {
-		giteaVersion = giteaFetchedVersion
+	giteaClient, err := gitea.New(ctx)
+	if err == nil {
+		giteaFetchedVersion, resp, err := giteaClient.Client.ServerVersion()
+		if err == nil && resp != nil && resp.StatusCode == 200 {
+			giteaVersion = giteaFetchedVersion
+		}
 	}
 
 	data := map[string]interface{}{